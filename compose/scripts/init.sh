@@ -147,11 +147,17 @@ initializeNacos() {
   else
     # Even the namespace is just created, there might be some dangling config items in it if the namespace itself was delete before without cleaning all the configs first.
     echo "  Checking existed configs in namespace ${NACOS_NS}..."
-    if [ "$nacosApiVersion" == "1" ]; then
-      statusCode="$(curl -s -o /dev/null -w "%{http_code}" "${NACOS_SERVER_URL}/v2/cs/config?accessToken=${NACOS_ACCESS_TOKEN}&namespaceId=${NACOS_NS}&dataId=secrets.__names__&group=DEFAULT_GROUP")"
-    elif [ "$nacosApiVersion" == "3" ]; then
-      statusCode="$(curl -s -o /dev/null -w "%{http_code}" "${NACOS_SERVER_URL}/v3/admin/cs/config?accessToken=${NACOS_ACCESS_TOKEN}&namespaceId=${NACOS_NS}&dataId=secrets.__names__&groupName=DEFAULT_GROUP")"
-    fi
+    # secrets.__changes__ is the change log of secrets, and secrets.__names__ is the name list used by previous versions.
+    for dataId in secrets.__changes__ secrets.__names__; do
+      if [ "$nacosApiVersion" == "1" ]; then
+        statusCode="$(curl -s -o /dev/null -w "%{http_code}" "${NACOS_SERVER_URL}/v2/cs/config?accessToken=${NACOS_ACCESS_TOKEN}&namespaceId=${NACOS_NS}&dataId=${dataId}&group=DEFAULT_GROUP")"
+      elif [ "$nacosApiVersion" == "3" ]; then
+        statusCode="$(curl -s -o /dev/null -w "%{http_code}" "${NACOS_SERVER_URL}/v3/admin/cs/config?accessToken=${NACOS_ACCESS_TOKEN}&namespaceId=${NACOS_NS}&dataId=${dataId}&groupName=DEFAULT_GROUP")"
+      fi
+      if [ $statusCode -ne 404 ]; then
+        break
+      fi
+    done
     if [ $statusCode -eq 200 ]; then
       echo "  ERROR: Higress configs are found in nacos namespace ${NACOS_NS}."
       echo
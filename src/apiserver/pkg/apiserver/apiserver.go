@@ -17,6 +17,7 @@ limitations under the License.
 package apiserver
 
 import (
+	"context"
 	"fmt"
 
 	istiov1alpha3 "istio.io/client-go/pkg/apis/networking/v1alpha3"
@@ -25,6 +26,7 @@ import (
 	corev1 "k8s.io/api/core/v1"
 	discoveryv1 "k8s.io/api/discovery/v1"
 	networkingv1 "k8s.io/api/networking/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
 	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/fields"
@@ -32,6 +34,7 @@ import (
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/runtime/serializer"
+	"k8s.io/apiserver/pkg/authorization/authorizer"
 	"k8s.io/apiserver/pkg/registry/rest"
 	genericapiserver "k8s.io/apiserver/pkg/server"
 	genericserverstorage "k8s.io/apiserver/pkg/server/storage"
@@ -43,7 +46,9 @@ import (
 	gwapiv1 "github.com/alibaba/higress/api-server/pkg/apis/gatewayapi/v1"
 	"github.com/alibaba/higress/api-server/pkg/codec"
 	"github.com/alibaba/higress/api-server/pkg/converter"
+	"github.com/alibaba/higress/api-server/pkg/encryption"
 	"github.com/alibaba/higress/api-server/pkg/options"
+	"github.com/alibaba/higress/api-server/pkg/rbac"
 	"github.com/alibaba/higress/api-server/pkg/registry"
 	"github.com/alibaba/higress/api-server/pkg/storage"
 	hiextensionsv1alpha1 "github.com/alibaba/higress/v2/client/pkg/apis/extensions/v1alpha1"
@@ -59,7 +64,6 @@ type storageCreator func(
 	newFunc func() runtime.Object,
 	newListFunc func() runtime.Object,
 	attrFunc genericstorage.AttrFunc,
-	sensitive bool,
 ) (rest.Storage, error)
 
 const (
@@ -92,6 +96,7 @@ func init() {
 	_ = authzv1.AddToScheme(Scheme)
 	_ = networkingv1.AddToScheme(Scheme)
 	_ = discoveryv1.AddToScheme(Scheme)
+	_ = rbacv1.AddToScheme(Scheme)
 	_ = hiextensionsv1alpha1.AddToScheme(Scheme)
 	_ = hinetworkingv1.AddToScheme(Scheme)
 	_ = gwapiv1beta1.AddToScheme(Scheme)
@@ -114,6 +119,8 @@ func init() {
 type ExtraConfig struct {
 	AuthOptions    *options.AuthOptions
 	StorageOptions *options.StorageOptions
+	// RBACAuthorizer is the authorizer backed by the stored RBAC resources, which is nil if RBAC is disabled.
+	RBACAuthorizer *rbac.Authorizer
 }
 
 // Config defines the config for the apiserver
@@ -159,17 +166,59 @@ func (c completedConfig) New() (*HigressServer, error) {
 	}
 
 	storageOptions := c.ExtraConfig.StorageOptions
-	storageMode := storageOptions.Mode
 	var nacosConfigClient config_client.IConfigClient
-	if storageMode == options.Storage_Nacos {
-		nacosOptions := c.ExtraConfig.StorageOptions.NacosOptions
-		nacosConfigClient, err = nacosOptions.CreateConfigClient()
+	if storageOptions.Mode == options.Storage_Nacos || storageOptions.MirrorMode == options.Storage_Nacos {
+		nacosConfigClient, err = storageOptions.NacosOptions.CreateConfigClient()
 		if err != nil {
 			return nil, err
 		}
 	}
+	transformer, err := storageOptions.EncryptionOptions.CreateTransformer(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	storageCreateFunc := newStorageCreator(storageOptions, nacosConfigClient, transformer)
+
+	converter.RegisterConverters(Scheme)
+
+	var authz authorizer.Authorizer
+	if c.ExtraConfig.AuthOptions != nil && c.ExtraConfig.AuthOptions.Enabled {
+		authz = c.GenericConfig.Authorization.Authorizer
+	}
+	legacyApiGroupInfo, apiGroupInfos, err := newAPIGroupInfos(storageCreateFunc, authz)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.GenericAPIServer.InstallLegacyAPIGroup("/api", legacyApiGroupInfo); err != nil {
+		return nil, err
+	}
+	for _, apiGroupInfo := range apiGroupInfos {
+		if err := s.GenericAPIServer.InstallAPIGroup(apiGroupInfo); err != nil {
+			return nil, err
+		}
+		if apiGroupInfo.PrioritizedVersions[0].Group == rbacv1.GroupName && c.ExtraConfig.RBACAuthorizer != nil {
+			rbacStorages := apiGroupInfo.VersionedResourcesStorageMap[rbacv1.SchemeGroupVersion.Version]
+			rbacAuthorizer := c.ExtraConfig.RBACAuthorizer
+			s.GenericAPIServer.AddPostStartHookOrDie("start-rbac-authorizer", func(ctx genericapiserver.PostStartHookContext) error {
+				rbacAuthorizer.Start(ctx,
+					rbacStorages["roles"].(rbac.Storage),
+					rbacStorages["rolebindings"].(rbac.Storage),
+					rbacStorages["clusterroles"].(rbac.Storage),
+					rbacStorages["clusterrolebindings"].(rbac.Storage))
+				return nil
+			})
+		}
+	}
+
+	return s, nil
+}
 
-	storageCreateFunc := func(
+// newStorageCreator returns a storageCreator creating the backends set by storageOptions, which encrypt the resources
+// selected by the encryption options with transformer. nacosConfigClient is only used by the nacos backend.
+func newStorageCreator(storageOptions *options.StorageOptions, nacosConfigClient config_client.IConfigClient, transformer encryption.Transformer) storageCreator {
+	storageMode := storageOptions.Mode
+	mirrorMode := storageOptions.MirrorMode
+	return func(
 		groupResource schema.GroupResource,
 		runtimeCodec runtime.Codec,
 		isNamespaced bool,
@@ -177,27 +226,47 @@ func (c completedConfig) New() (*HigressServer, error) {
 		newFunc func() runtime.Object,
 		newListFunc func() runtime.Object,
 		attrFunc genericstorage.AttrFunc,
-		sensitive bool,
 	) (rest.Storage, error) {
 		if groupResource == apiextensionsv1.Resource("customresourcedefinitions") {
 			return storage.CreateCustomResourceDefinitionStorage(runtimeCodec)
 		}
-		switch storageMode {
-		case options.Storage_File:
-			runtimeCodec = codec.NewFlatAwareCodec(groupResource, runtimeCodec)
-			return registry.NewFileREST(groupResource, runtimeCodec, storageOptions.FileOptions.RootDir, extension, isNamespaced, singularName, newFunc, newListFunc, attrFunc)
-		case options.Storage_Nacos:
-			var encryptionKey []byte = nil
-			if sensitive {
-				encryptionKey = storageOptions.NacosOptions.EncryptionKey
+		runtimeCodec = codec.NewUIDDefaultingCodec(groupResource, runtimeCodec)
+		policy := storageOptions.EncryptionOptions.CreatePolicy(groupResource, transformer)
+		createBackend := func(mode string) (registry.REST, error) {
+			switch mode {
+			case options.Storage_File:
+				fileCodec := codec.NewFlatAwareCodec(groupResource, runtimeCodec)
+				return registry.NewFileREST(groupResource, fileCodec, storageOptions.FileOptions, extension, isNamespaced, singularName, newFunc, newListFunc, attrFunc, policy)
+			case options.Storage_Nacos:
+				return registry.NewNacosREST(groupResource, runtimeCodec, nacosConfigClient, isNamespaced, singularName, newFunc, newListFunc, attrFunc, policy), nil
+			case options.Storage_Bolt:
+				return registry.NewBoltREST(groupResource, runtimeCodec, storageOptions.BoltOptions, isNamespaced, singularName, newFunc, newListFunc, attrFunc, policy)
+			case options.Storage_Sql:
+				return registry.NewSqlREST(groupResource, runtimeCodec, storageOptions.SqlOptions, isNamespaced, singularName, newFunc, newListFunc, attrFunc, policy)
+			case options.Storage_Redis:
+				return registry.NewRedisREST(groupResource, runtimeCodec, storageOptions.RedisOptions, isNamespaced, singularName, newFunc, newListFunc, attrFunc, policy)
+			default:
+				panic(fmt.Errorf("invalid storage mode: %s", mode))
 			}
-			return registry.NewNacosREST(groupResource, runtimeCodec, nacosConfigClient, isNamespaced, singularName, newFunc, newListFunc, attrFunc, encryptionKey), nil
-		default:
-			panic(fmt.Errorf("invalid storage mode: %s", storageMode))
 		}
+		primary, err := createBackend(storageMode)
+		if err != nil || mirrorMode == "" {
+			return primary, err
+		}
+		secondary, err := createBackend(mirrorMode)
+		if err != nil {
+			primary.Destroy()
+			return nil, err
+		}
+		return registry.NewMirrorREST(groupResource, primary, secondary), nil
 	}
+}
 
-	converter.RegisterConverters(Scheme)
+// newAPIGroupInfos creates the storages of all the resources served, and returns the legacy API group along with
+// the other API groups to install. SubjectAccessReviews are answered by authz, which is nil if authorization is disabled.
+func newAPIGroupInfos(storageCreateFunc storageCreator, authz authorizer.Authorizer) (*genericapiserver.APIGroupInfo, []*genericapiserver.APIGroupInfo, error) {
+	var legacyApiGroupInfo *genericapiserver.APIGroupInfo
+	var apiGroupInfos []*genericapiserver.APIGroupInfo
 
 	{
 		coreApiGroupInfo := genericapiserver.NewDefaultAPIGroupInfo(corev1.SchemeGroupVersion.Group, Scheme, metav1.ParameterCodec, Codecs)
@@ -205,7 +274,7 @@ func (c completedConfig) New() (*HigressServer, error) {
 		appendStorage(corev1Storages, storageCreateFunc, corev1.SchemeGroupVersion, true, "configmap", "configmaps",
 			func() runtime.Object { return &corev1.ConfigMap{} },
 			func() runtime.Object { return &corev1.ConfigMapList{} },
-			nil, false)
+			nil)
 		appendStorage(corev1Storages, storageCreateFunc, corev1.SchemeGroupVersion, true, "secret", "secrets",
 			func() runtime.Object { return &corev1.Secret{} },
 			func() runtime.Object { return &corev1.SecretList{} },
@@ -220,31 +289,32 @@ func (c completedConfig) New() (*HigressServer, error) {
 				}
 				fields["type"] = string(secret.Type)
 				return labels, fields, err
-			}, true)
+			})
 		appendStorage(corev1Storages, storageCreateFunc, corev1.SchemeGroupVersion, true, "service", "services",
 			func() runtime.Object { return &corev1.Service{} },
 			func() runtime.Object { return &corev1.ServiceList{} },
-			nil, false)
+			nil)
+		if err := appendStatusStorage(corev1Storages, corev1.SchemeGroupVersion, "services"); err != nil {
+			return nil, nil, err
+		}
 		appendStorage(corev1Storages, storageCreateFunc, corev1.SchemeGroupVersion, true, "endpoints", "endpoints",
 			func() runtime.Object { return &corev1.Endpoints{} },
 			func() runtime.Object { return &corev1.EndpointsList{} },
-			nil, false)
+			nil)
 		appendStorage(corev1Storages, storageCreateFunc, corev1.SchemeGroupVersion, true, "pod", "pods",
 			func() runtime.Object { return &corev1.Pod{} },
 			func() runtime.Object { return &corev1.PodList{} },
-			nil, false)
+			nil)
 		appendStorage(corev1Storages, storageCreateFunc, corev1.SchemeGroupVersion, true, "node", "nodes",
 			func() runtime.Object { return &corev1.Node{} },
 			func() runtime.Object { return &corev1.NodeList{} },
-			nil, false)
+			nil)
 		appendStorage(corev1Storages, storageCreateFunc, corev1.SchemeGroupVersion, true, "namespace", "namespaces",
 			func() runtime.Object { return &corev1.Namespace{} },
 			func() runtime.Object { return &corev1.NamespaceList{} },
-			nil, false)
+			nil)
 		coreApiGroupInfo.VersionedResourcesStorageMap[corev1.SchemeGroupVersion.Version] = corev1Storages
-		if err := s.GenericAPIServer.InstallLegacyAPIGroup("/api", &coreApiGroupInfo); err != nil {
-			return nil, err
-		}
+		legacyApiGroupInfo = &coreApiGroupInfo
 	}
 
 	{
@@ -253,11 +323,9 @@ func (c completedConfig) New() (*HigressServer, error) {
 		appendStorage(apiExtensionsStorages, storageCreateFunc, apiextensionsv1.SchemeGroupVersion, false, "customresourcedefinition", "customresourcedefinitions",
 			func() runtime.Object { return &apiextensionsv1.CustomResourceDefinition{} },
 			func() runtime.Object { return &apiextensionsv1.CustomResourceDefinitionList{} },
-			nil, false)
+			nil)
 		apiExtensionsApiGroupInfo.VersionedResourcesStorageMap[apiextensionsv1.SchemeGroupVersion.Version] = apiExtensionsStorages
-		if err := s.GenericAPIServer.InstallAPIGroup(&apiExtensionsApiGroupInfo); err != nil {
-			return nil, err
-		}
+		apiGroupInfos = append(apiGroupInfos, &apiExtensionsApiGroupInfo)
 	}
 
 	{
@@ -266,25 +334,44 @@ func (c completedConfig) New() (*HigressServer, error) {
 		appendStorage(admRegv1Storages, storageCreateFunc, admregv1.SchemeGroupVersion, true, "mutatingwebhookconfiguration", "mutatingwebhookconfigurations",
 			func() runtime.Object { return &admregv1.MutatingWebhookConfiguration{} },
 			func() runtime.Object { return &admregv1.MutatingWebhookConfigurationList{} },
-			nil, false)
+			nil)
 		appendStorage(admRegv1Storages, storageCreateFunc, admregv1.SchemeGroupVersion, true, "validatingwebhookconfiguration", "validatingwebhookconfigurations",
 			func() runtime.Object { return &admregv1.ValidatingWebhookConfiguration{} },
 			func() runtime.Object { return &admregv1.ValidatingWebhookConfigurationList{} },
-			nil, false)
+			nil)
 		admRegApiGroupInfo.VersionedResourcesStorageMap[admregv1.SchemeGroupVersion.Version] = admRegv1Storages
-		if err := s.GenericAPIServer.InstallAPIGroup(&admRegApiGroupInfo); err != nil {
-			return nil, err
-		}
+		apiGroupInfos = append(apiGroupInfos, &admRegApiGroupInfo)
 	}
 
 	{
 		authzApiGroupInfo := genericapiserver.NewDefaultAPIGroupInfo(authzv1.SchemeGroupVersion.Group, Scheme, metav1.ParameterCodec, Codecs)
 		authzv1Storages := map[string]rest.Storage{}
-		authzv1Storages["subjectaccessreviews"] = &storage.SubjectAccessReviewStorage{}
+		authzv1Storages["subjectaccessreviews"] = storage.NewSubjectAccessReviewStorage(authz)
 		authzApiGroupInfo.VersionedResourcesStorageMap[authzv1.SchemeGroupVersion.Version] = authzv1Storages
-		if err := s.GenericAPIServer.InstallAPIGroup(&authzApiGroupInfo); err != nil {
-			return nil, err
-		}
+		apiGroupInfos = append(apiGroupInfos, &authzApiGroupInfo)
+	}
+
+	{
+		rbacApiGroupInfo := genericapiserver.NewDefaultAPIGroupInfo(rbacv1.SchemeGroupVersion.Group, Scheme, metav1.ParameterCodec, Codecs)
+		rbacv1Storages := map[string]rest.Storage{}
+		appendStorage(rbacv1Storages, storageCreateFunc, rbacv1.SchemeGroupVersion, true, "role", "roles",
+			func() runtime.Object { return &rbacv1.Role{} },
+			func() runtime.Object { return &rbacv1.RoleList{} },
+			nil)
+		appendStorage(rbacv1Storages, storageCreateFunc, rbacv1.SchemeGroupVersion, true, "rolebinding", "rolebindings",
+			func() runtime.Object { return &rbacv1.RoleBinding{} },
+			func() runtime.Object { return &rbacv1.RoleBindingList{} },
+			nil)
+		appendStorage(rbacv1Storages, storageCreateFunc, rbacv1.SchemeGroupVersion, false, "clusterrole", "clusterroles",
+			func() runtime.Object { return &rbacv1.ClusterRole{} },
+			func() runtime.Object { return &rbacv1.ClusterRoleList{} },
+			nil)
+		appendStorage(rbacv1Storages, storageCreateFunc, rbacv1.SchemeGroupVersion, false, "clusterrolebinding", "clusterrolebindings",
+			func() runtime.Object { return &rbacv1.ClusterRoleBinding{} },
+			func() runtime.Object { return &rbacv1.ClusterRoleBindingList{} },
+			nil)
+		rbacApiGroupInfo.VersionedResourcesStorageMap[rbacv1.SchemeGroupVersion.Version] = rbacv1Storages
+		apiGroupInfos = append(apiGroupInfos, &rbacApiGroupInfo)
 	}
 
 	{
@@ -293,11 +380,9 @@ func (c completedConfig) New() (*HigressServer, error) {
 		appendStorage(discoveryv1Storages, storageCreateFunc, discoveryv1.SchemeGroupVersion, true, "endpointslice", "endpointslices",
 			func() runtime.Object { return &discoveryv1.EndpointSlice{} },
 			func() runtime.Object { return &discoveryv1.EndpointSliceList{} },
-			nil, false)
+			nil)
 		discoveryApiGroupInfo.VersionedResourcesStorageMap[discoveryv1.SchemeGroupVersion.Version] = discoveryv1Storages
-		if err := s.GenericAPIServer.InstallAPIGroup(&discoveryApiGroupInfo); err != nil {
-			return nil, err
-		}
+		apiGroupInfos = append(apiGroupInfos, &discoveryApiGroupInfo)
 	}
 
 	{
@@ -306,15 +391,16 @@ func (c completedConfig) New() (*HigressServer, error) {
 		appendStorage(networkingv1Storages, storageCreateFunc, networkingv1.SchemeGroupVersion, true, "ingress", "ingresses",
 			func() runtime.Object { return &networkingv1.Ingress{} },
 			func() runtime.Object { return &networkingv1.IngressList{} },
-			nil, false)
+			nil)
+		if err := appendStatusStorage(networkingv1Storages, networkingv1.SchemeGroupVersion, "ingresses"); err != nil {
+			return nil, nil, err
+		}
 		appendStorage(networkingv1Storages, storageCreateFunc, networkingv1.SchemeGroupVersion, true, "ingressclass", "ingressclasses",
 			func() runtime.Object { return &networkingv1.IngressClass{} },
 			func() runtime.Object { return &networkingv1.IngressClassList{} },
-			nil, false)
+			nil)
 		networkingApiGroupInfo.VersionedResourcesStorageMap[networkingv1.SchemeGroupVersion.Version] = networkingv1Storages
-		if err := s.GenericAPIServer.InstallAPIGroup(&networkingApiGroupInfo); err != nil {
-			return nil, err
-		}
+		apiGroupInfos = append(apiGroupInfos, &networkingApiGroupInfo)
 	}
 
 	{
@@ -323,11 +409,12 @@ func (c completedConfig) New() (*HigressServer, error) {
 		appendStorage(hiextensionv1alphaStorages, storageCreateFunc, hiextensionsv1alpha1.SchemeGroupVersion, true, "wasmplugin", "wasmplugins",
 			func() runtime.Object { return &hiextensionsv1alpha1.WasmPlugin{} },
 			func() runtime.Object { return &hiextensionsv1alpha1.WasmPluginList{} },
-			nil, false)
-		hiextensionApiGroupInfo.VersionedResourcesStorageMap[hiextensionsv1alpha1.SchemeGroupVersion.Version] = hiextensionv1alphaStorages
-		if err := s.GenericAPIServer.InstallAPIGroup(&hiextensionApiGroupInfo); err != nil {
-			return nil, err
+			nil)
+		if err := appendStatusStorage(hiextensionv1alphaStorages, hiextensionsv1alpha1.SchemeGroupVersion, "wasmplugins"); err != nil {
+			return nil, nil, err
 		}
+		hiextensionApiGroupInfo.VersionedResourcesStorageMap[hiextensionsv1alpha1.SchemeGroupVersion.Version] = hiextensionv1alphaStorages
+		apiGroupInfos = append(apiGroupInfos, &hiextensionApiGroupInfo)
 	}
 
 	{
@@ -336,15 +423,16 @@ func (c completedConfig) New() (*HigressServer, error) {
 		appendStorage(hinetworkingv1Storages, storageCreateFunc, hinetworkingv1.SchemeGroupVersion, true, "mcpbridge", "mcpbridges",
 			func() runtime.Object { return &hinetworkingv1.McpBridge{} },
 			func() runtime.Object { return &hinetworkingv1.McpBridgeList{} },
-			nil, false)
+			nil)
+		if err := appendStatusStorage(hinetworkingv1Storages, hinetworkingv1.SchemeGroupVersion, "mcpbridges"); err != nil {
+			return nil, nil, err
+		}
 		appendStorage(hinetworkingv1Storages, storageCreateFunc, hinetworkingv1.SchemeGroupVersion, true, "http2rpc", "http2rpcs",
 			func() runtime.Object { return &hinetworkingv1.Http2Rpc{} },
 			func() runtime.Object { return &hinetworkingv1.Http2RpcList{} },
-			nil, false)
+			nil)
 		hinetworkingApiGroupInfo.VersionedResourcesStorageMap[hinetworkingv1.SchemeGroupVersion.Version] = hinetworkingv1Storages
-		if err := s.GenericAPIServer.InstallAPIGroup(&hinetworkingApiGroupInfo); err != nil {
-			return nil, err
-		}
+		apiGroupInfos = append(apiGroupInfos, &hinetworkingApiGroupInfo)
 	}
 
 	{
@@ -353,25 +441,33 @@ func (c completedConfig) New() (*HigressServer, error) {
 		appendStorage(gwapiv1beta1Storages, storageCreateFunc, gwapiv1beta1.SchemeGroupVersion, false, "gatewayclass", "gatewayclasses",
 			func() runtime.Object { return &gwapiv1beta1.GatewayClass{} },
 			func() runtime.Object { return &gwapiv1beta1.GatewayClassList{} },
-			nil, false)
+			nil)
+		if err := appendStatusStorage(gwapiv1beta1Storages, gwapiv1beta1.SchemeGroupVersion, "gatewayclasses"); err != nil {
+			return nil, nil, err
+		}
 		appendStorage(gwapiv1beta1Storages, storageCreateFunc, gwapiv1beta1.SchemeGroupVersion, true, "gateway", "gateways",
 			func() runtime.Object { return &gwapiv1beta1.Gateway{} },
 			func() runtime.Object { return &gwapiv1beta1.GatewayList{} },
-			nil, false)
+			nil)
+		if err := appendStatusStorage(gwapiv1beta1Storages, gwapiv1beta1.SchemeGroupVersion, "gateways"); err != nil {
+			return nil, nil, err
+		}
 		appendStorage(gwapiv1beta1Storages, storageCreateFunc, gwapiv1beta1.SchemeGroupVersion, true, "httproute", "httproutes",
 			func() runtime.Object { return &gwapiv1beta1.HTTPRoute{} },
 			func() runtime.Object { return &gwapiv1beta1.HTTPRouteList{} },
-			nil, false)
+			nil)
+		if err := appendStatusStorage(gwapiv1beta1Storages, gwapiv1beta1.SchemeGroupVersion, "httproutes"); err != nil {
+			return nil, nil, err
+		}
 		appendStorage(gwapiv1beta1Storages, storageCreateFunc, gwapiv1beta1.SchemeGroupVersion, true, "referencegrant", "referencegrants",
 			func() runtime.Object { return &gwapiv1beta1.ReferenceGrant{} },
 			func() runtime.Object { return &gwapiv1beta1.ReferenceGrantList{} },
-			nil, false)
+			nil)
+		// All the versions are served by the same storages, the status subresources included.
 		gwapiApiGroupInfo.VersionedResourcesStorageMap[gwapiv1beta1.SchemeGroupVersion.Version] = gwapiv1beta1Storages
 		gwapiApiGroupInfo.VersionedResourcesStorageMap[gwapiv1alpha2.SchemeGroupVersion.Version] = gwapiv1beta1Storages
 		gwapiApiGroupInfo.VersionedResourcesStorageMap[gwapiv1.SchemeGroupVersion.Version] = gwapiv1beta1Storages
-		if err := s.GenericAPIServer.InstallAPIGroup(&gwapiApiGroupInfo); err != nil {
-			return nil, err
-		}
+		apiGroupInfos = append(apiGroupInfos, &gwapiApiGroupInfo)
 	}
 
 	{
@@ -380,14 +476,12 @@ func (c completedConfig) New() (*HigressServer, error) {
 		appendStorage(istioApiv1alpha3Storages, storageCreateFunc, istiov1alpha3.SchemeGroupVersion, true, "envoyfilter", "envoyfilters",
 			func() runtime.Object { return &istiov1alpha3.EnvoyFilter{} },
 			func() runtime.Object { return &istiov1alpha3.EnvoyFilterList{} },
-			nil, false)
+			nil)
 		istioApiGroupInfo.VersionedResourcesStorageMap[istiov1alpha3.SchemeGroupVersion.Version] = istioApiv1alpha3Storages
-		if err := s.GenericAPIServer.InstallAPIGroup(&istioApiGroupInfo); err != nil {
-			return nil, err
-		}
+		apiGroupInfos = append(apiGroupInfos, &istioApiGroupInfo)
 	}
 
-	return s, nil
+	return legacyApiGroupInfo, apiGroupInfos, nil
 }
 
 func newLegacyAPIGroupInfo(group string, scheme *runtime.Scheme, parameterCodec runtime.ParameterCodec) genericapiserver.APIGroupInfo {
@@ -411,9 +505,36 @@ func appendStorage(storages map[string]rest.Storage,
 	newFunc func() runtime.Object,
 	newListFunc func() runtime.Object,
 	attrFunc genericstorage.AttrFunc,
-	sensitive bool,
 ) {
 	groupResource := groupVersion.WithResource(pluralName).GroupResource()
+	if groupVersion == Scheme.PrioritizedVersionsForGroup(groupVersion.Group)[0] {
+		// There are no internal types, so the objects are kept in memory in the preferred version of their group instead,
+		// which the field managers of server-side apply take as the internal version.
+		Scheme.AddKnownTypes(schema.GroupVersion{Group: groupVersion.Group, Version: runtime.APIVersionInternal}, newFunc(), newListFunc())
+	}
+	storageCodec := newStorageCodec(groupResource)
+	storage, err := storageCreatorFunc(groupResource, storageCodec, isNamespaced, singularName, newFunc, newListFunc, attrFunc)
+	if err != nil {
+		err = fmt.Errorf("unable to create REST storage for a resource due to %v, will die", err)
+		panic(err)
+	}
+	storages[pluralName] = storage
+}
+
+// appendStatusStorage adds the status subresource of a resource added by appendStorage. The status is then only
+// updated through the subresource, and left alone by the updates of the resource.
+func appendStatusStorage(storages map[string]rest.Storage, groupVersion schema.GroupVersion, pluralName string) error {
+	resourceStorage, ok := storages[pluralName].(registry.REST)
+	if !ok {
+		return fmt.Errorf("unable to add status subresource of %s: %T is not a registry storage", groupVersion.WithResource(pluralName), storages[pluralName])
+	}
+	storage, statusStorage := registry.NewStatusREST(resourceStorage, Scheme.PrioritizedVersionsForGroup(groupVersion.Group))
+	storages[pluralName] = storage
+	storages[pluralName+"/status"] = statusStorage
+	return nil
+}
+
+func newStorageCodec(groupResource schema.GroupResource) runtime.Codec {
 	storageCodec, _, err := genericserverstorage.NewStorageCodec(genericserverstorage.StorageCodecConfig{
 		StorageMediaType:  contentType,
 		StorageSerializer: serializer.NewCodecFactory(Scheme),
@@ -425,10 +546,5 @@ func appendStorage(storages map[string]rest.Storage,
 		err = fmt.Errorf("unable to create storage codec for a resource due to %v, will die", err)
 		panic(err)
 	}
-	storage, err := storageCreatorFunc(groupResource, storageCodec, isNamespaced, singularName, newFunc, newListFunc, attrFunc, sensitive)
-	if err != nil {
-		err = fmt.Errorf("unable to create REST storage for a resource due to %v, will die", err)
-		panic(err)
-	}
-	storages[pluralName] = storage
+	return storageCodec
 }
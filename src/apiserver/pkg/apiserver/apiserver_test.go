@@ -0,0 +1,220 @@
+package apiserver
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/managedfields"
+	endpointsopenapi "k8s.io/apiserver/pkg/endpoints/openapi"
+	genericapirequest "k8s.io/apiserver/pkg/endpoints/request"
+	"k8s.io/apiserver/pkg/registry/rest"
+	genericapiserver "k8s.io/apiserver/pkg/server"
+	genericstorage "k8s.io/apiserver/pkg/storage"
+	"k8s.io/kube-openapi/pkg/builder3"
+	"k8s.io/kube-openapi/pkg/validation/spec"
+
+	higressopenapi "github.com/alibaba/higress/api-server/pkg/openapi"
+	"github.com/alibaba/higress/api-server/pkg/options"
+	"github.com/alibaba/higress/api-server/pkg/registry"
+)
+
+func newTestAPIGroupInfos(t *testing.T) (*genericapiserver.APIGroupInfo, []*genericapiserver.APIGroupInfo) {
+	t.Helper()
+	fileOptions := &options.FileOptions{RootDir: t.TempDir()}
+	var storages []registry.REST
+	t.Cleanup(func() {
+		for _, storage := range storages {
+			storage.Destroy()
+		}
+	})
+	legacyApiGroupInfo, apiGroupInfos, err := newAPIGroupInfos(func(
+		groupResource schema.GroupResource,
+		codec runtime.Codec,
+		isNamespaced bool,
+		singularName string,
+		newFunc func() runtime.Object,
+		newListFunc func() runtime.Object,
+		attrFunc genericstorage.AttrFunc,
+	) (rest.Storage, error) {
+		storage, err := registry.NewFileREST(groupResource, codec, fileOptions, extension, isNamespaced, singularName, newFunc, newListFunc, attrFunc, nil)
+		if err == nil {
+			storages = append(storages, storage)
+		}
+		return storage, err
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return legacyApiGroupInfo, apiGroupInfos
+}
+
+func TestStatusSubresources(t *testing.T) {
+	legacyApiGroupInfo, apiGroupInfos := newTestAPIGroupInfos(t)
+	want := map[schema.GroupVersion][]string{
+		{Version: "v1"}: {"services"},
+		{Group: "networking.k8s.io", Version: "v1"}:               {"ingresses"},
+		{Group: "extensions.higress.io", Version: "v1alpha1"}:     {"wasmplugins"},
+		{Group: "networking.higress.io", Version: "v1"}:           {"mcpbridges"},
+		{Group: "gateway.networking.k8s.io", Version: "v1"}:       {"gatewayclasses", "gateways", "httproutes"},
+		{Group: "gateway.networking.k8s.io", Version: "v1beta1"}:  {"gatewayclasses", "gateways", "httproutes"},
+		{Group: "gateway.networking.k8s.io", Version: "v1alpha2"}: {"gatewayclasses", "gateways", "httproutes"},
+	}
+	served := map[schema.GroupVersion]map[string]rest.Storage{}
+	for _, apiGroupInfo := range append(apiGroupInfos, legacyApiGroupInfo) {
+		group := apiGroupInfo.PrioritizedVersions[0].Group
+		for version, storages := range apiGroupInfo.VersionedResourcesStorageMap {
+			served[schema.GroupVersion{Group: group, Version: version}] = storages
+		}
+	}
+	for groupVersion, resources := range want {
+		storages, ok := served[groupVersion]
+		if !ok {
+			t.Errorf("%s isn't served", groupVersion)
+			continue
+		}
+		for _, resource := range resources {
+			if _, ok := storages[resource+"/status"].(rest.Updater); !ok {
+				t.Errorf("%s/status isn't served in %s", resource, groupVersion)
+			}
+			if _, ok := storages[resource].(rest.ResetFieldsStrategy); !ok {
+				t.Errorf("%s in %s doesn't leave the status alone", resource, groupVersion)
+			}
+		}
+	}
+}
+
+func TestAppendStatusStorageOfUnknownStorage(t *testing.T) {
+	storages := map[string]rest.Storage{"services": nil}
+	if err := appendStatusStorage(storages, schema.GroupVersion{Version: "v1"}, "services"); err == nil {
+		t.Fatal("status subresource is added to a storage not from the registry")
+	}
+	if _, ok := storages["services/status"]; ok {
+		t.Fatal("status subresource is served after the failure")
+	}
+}
+
+func TestOpenAPIDefinitionsOfServedTypes(t *testing.T) {
+	legacyApiGroupInfo, apiGroupInfos := newTestAPIGroupInfos(t)
+	ref := func(name string) spec.Ref {
+		return spec.MustCreateRef("#/definitions/" + name)
+	}
+	v2Definitions, v3Definitions := higressopenapi.GetOpenAPIV2Definitions(ref), higressopenapi.GetOpenAPIDefinitions(ref)
+	for _, apiGroupInfo := range append(apiGroupInfos, legacyApiGroupInfo) {
+		for version, storages := range apiGroupInfo.VersionedResourcesStorageMap {
+			for resource, storage := range storages {
+				objType := reflect.TypeOf(storage.New()).Elem()
+				name := objType.PkgPath() + "." + objType.Name()
+				v2, ok := v2Definitions[name]
+				if !ok || v2.Schema.Type == nil && v2.Schema.Properties == nil {
+					t.Errorf("%s of %s/%s has no OpenAPI v2 definition", name, apiGroupInfo.PrioritizedVersions[0].Group, resource)
+				}
+				v3, ok := v3Definitions[name]
+				if !ok || len(v3.Schema.Properties) == 0 {
+					t.Errorf("%s of %s/%s in %s has no OpenAPI v3 definition", name, apiGroupInfo.PrioritizedVersions[0].Group, resource, version)
+				}
+			}
+		}
+	}
+}
+
+// applyInfo applies a configuration to the object stored with a field manager, like the handler of server-side apply.
+type applyInfo struct {
+	fieldManager *managedfields.FieldManager
+	patch        *unstructured.Unstructured
+	manager      string
+	force        bool
+}
+
+func (a *applyInfo) Preconditions() *metav1.Preconditions {
+	return nil
+}
+
+func (a *applyInfo) UpdatedObject(ctx context.Context, oldObj runtime.Object) (runtime.Object, error) {
+	// An object without a UID is taken as a new one.
+	if accessor, err := meta.Accessor(oldObj); oldObj == nil || err == nil && accessor.GetUID() == "" {
+		oldObj = &corev1.ConfigMap{}
+	}
+	return a.fieldManager.Apply(oldObj, a.patch, a.manager, a.force)
+}
+
+func TestServerSideApply(t *testing.T) {
+	legacyApiGroupInfo, _ := newTestAPIGroupInfos(t)
+	storage := legacyApiGroupInfo.VersionedResourcesStorageMap["v1"]["configmaps"].(rest.Updater)
+	namer := endpointsopenapi.NewDefinitionNamer(Scheme)
+	schemas, err := builder3.BuildOpenAPIDefinitionsForResources(genericapiserver.DefaultOpenAPIV3Config(higressopenapi.GetOpenAPIDefinitions, namer),
+		"k8s.io/api/core/v1.ConfigMap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	typeConverter, err := managedfields.NewTypeConverter(schemas, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The objects are kept in memory in the preferred version, which is taken as the internal one.
+	fieldManager, err := managedfields.NewDefaultFieldManager(typeConverter, Scheme, Scheme, Scheme,
+		corev1.SchemeGroupVersion.WithKind("ConfigMap"), schema.GroupVersion{Version: runtime.APIVersionInternal}, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := genericapirequest.WithNamespace(context.Background(), "higress-system")
+	apply := func(manager string, force bool, data map[string]interface{}) (*corev1.ConfigMap, error) {
+		t.Helper()
+		patch := &unstructured.Unstructured{Object: map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "ConfigMap",
+			"metadata":   map[string]interface{}{"name": "a", "namespace": "higress-system"},
+			"data":       data,
+		}}
+		obj, _, err := storage.Update(ctx, "a", &applyInfo{fieldManager: fieldManager, patch: patch, manager: manager, force: force},
+			nil, nil, true, &metav1.UpdateOptions{})
+		if err != nil {
+			return nil, err
+		}
+		return obj.(*corev1.ConfigMap), nil
+	}
+
+	created, err := apply("console", false, map[string]interface{}{"a": "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created.UID == "" || len(created.ManagedFields) != 1 || created.ManagedFields[0].Manager != "console" {
+		t.Fatalf("applied object has UID %q and managed fields %+v", created.UID, created.ManagedFields)
+	}
+
+	// Another manager can't change the fields owned by the console, unless it forces to.
+	_, err = apply("gitops", false, map[string]interface{}{"a": "2", "b": "1"})
+	if !apierrors.IsConflict(err) {
+		t.Fatalf("conflicting apply returned %v, want a conflict", err)
+	}
+	updated, err := apply("gitops", false, map[string]interface{}{"b": "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.UID != created.UID || updated.Data["a"] != "1" || updated.Data["b"] != "1" || len(updated.ManagedFields) != 2 {
+		t.Fatalf("applied object = %+v, want the fields of both managers", updated)
+	}
+	forced, err := apply("gitops", true, map[string]interface{}{"a": "2", "b": "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if forced.Data["a"] != "2" || len(forced.ManagedFields) != 1 || forced.ManagedFields[0].Manager != "gitops" {
+		t.Fatalf("forced apply = %+v, want all the fields taken over by gitops", forced)
+	}
+
+	// The managed fields are stored along with the object.
+	stored, err := storage.(rest.Getter).Get(ctx, "a", &metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if managedFields := stored.(*corev1.ConfigMap).ManagedFields; len(managedFields) != 1 || managedFields[0].Manager != "gitops" {
+		t.Fatalf("stored managed fields = %+v", managedFields)
+	}
+}
@@ -0,0 +1,80 @@
+package apiserver
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/nacos-group/nacos-sdk-go/v2/clients/config_client"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apiserver/pkg/registry/rest"
+
+	"github.com/alibaba/higress/api-server/pkg/options"
+	"github.com/alibaba/higress/api-server/pkg/registry"
+)
+
+// Reencrypt rewrites the objects stored in the primary backend which aren't encrypted as the encryption options
+// require, e.g. not with the newest key after a new one is added, or not at all after a resource is made sensitive.
+// The API servers keep serving meanwhile.
+func Reencrypt(ctx context.Context, storageOptions *options.StorageOptions, out io.Writer) error {
+	transformer, err := storageOptions.EncryptionOptions.CreateTransformer(ctx)
+	if err != nil {
+		return err
+	}
+	if transformer == nil {
+		return fmt.Errorf("data encryption is not enabled")
+	}
+
+	// Changes made to the primary backend are mirrored by the API servers as usual.
+	primaryOptions := *storageOptions
+	primaryOptions.MirrorMode = ""
+	var nacosConfigClient config_client.IConfigClient
+	if primaryOptions.Mode == options.Storage_Nacos {
+		nacosConfigClient, err = primaryOptions.NacosOptions.CreateConfigClient()
+		if err != nil {
+			return err
+		}
+		defer nacosConfigClient.CloseClient()
+	}
+
+	legacyApiGroupInfo, apiGroupInfos, err := newAPIGroupInfos(newStorageCreator(&primaryOptions, nacosConfigClient, transformer), nil)
+	if err != nil {
+		return err
+	}
+	visited := map[rest.Storage]bool{}
+	var errs []error
+	for _, apiGroupInfo := range append(apiGroupInfos, legacyApiGroupInfo) {
+		group := apiGroupInfo.PrioritizedVersions[0].Group
+		for _, storages := range apiGroupInfo.VersionedResourcesStorageMap {
+			resources := make([]string, 0, len(storages))
+			for resource := range storages {
+				resources = append(resources, resource)
+			}
+			sort.Strings(resources)
+			for _, resource := range resources {
+				storage := storages[resource]
+				if visited[storage] {
+					continue
+				}
+				visited[storage] = true
+				defer storage.Destroy()
+
+				reencrypter, ok := storage.(registry.Reencrypter)
+				if !ok {
+					continue
+				}
+				groupResource := schema.GroupResource{Group: group, Resource: resource}
+				count, err := reencrypter.Reencrypt(ctx)
+				if err != nil {
+					errs = append(errs, fmt.Errorf("%s: %v", groupResource, err))
+				}
+				if count > 0 {
+					_, _ = fmt.Fprintf(out, "%s: %d re-encrypted with key %s\n", groupResource, count, transformer.NewestKeyId())
+				}
+			}
+		}
+	}
+	return utilerrors.NewAggregate(errs)
+}
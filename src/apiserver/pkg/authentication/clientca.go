@@ -0,0 +1,162 @@
+package authentication
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math"
+	"math/big"
+	"os"
+	"path/filepath"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apiserver/pkg/authentication/authenticator"
+	x509request "k8s.io/apiserver/pkg/authentication/request/x509"
+	"k8s.io/apiserver/pkg/server/dynamiccertificates"
+	"k8s.io/client-go/util/cert"
+	"k8s.io/client-go/util/keyutil"
+)
+
+const (
+	caCertFile = "ca.crt"
+	caKeyFile  = "ca.key"
+
+	clientCertValidity = 365 * 24 * time.Hour
+	// Client certificates are renewed at startup if they expire within it.
+	clientCertRenewBefore = 30 * 24 * time.Hour
+)
+
+// ClientCA is a CA generated at the first startup and kept in a directory, which issues client certificates to the
+// components connecting to the API server, so each of them has its own identity without a Kubernetes cluster.
+// The common name of a client certificate is the user name, and the organizations are the groups.
+type ClientCA struct {
+	dir     string
+	cert    *x509.Certificate
+	key     crypto.Signer
+	content dynamiccertificates.CAContentProvider
+}
+
+// LoadOrCreateClientCA loads the CA kept in dir, or generates a new one if there isn't any.
+func LoadOrCreateClientCA(dir string) (*ClientCA, error) {
+	certPath, keyPath := filepath.Join(dir, caCertFile), filepath.Join(dir, caKeyFile)
+	keyData, generated, err := keyutil.LoadOrGenerateKeyFile(keyPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load or generate client CA key: %v", err)
+	}
+	privateKey, err := keyutil.ParsePrivateKeyPEM(keyData)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse client CA key %s: %v", keyPath, err)
+	}
+	key, ok := privateKey.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("client CA key %s can't be used to sign certificates", keyPath)
+	}
+
+	var caCert *x509.Certificate
+	if !generated {
+		if certs, err := cert.CertsFromFile(certPath); err == nil {
+			caCert = certs[0]
+		} else if !os.IsNotExist(err) {
+			return nil, fmt.Errorf("failed to load client CA certificate: %v", err)
+		}
+	}
+	if caCert == nil {
+		if caCert, err = cert.NewSelfSignedCACert(cert.Config{CommonName: "higress-api-server-client-ca"}, key); err != nil {
+			return nil, fmt.Errorf("failed to generate client CA certificate: %v", err)
+		}
+		if err := cert.WriteCert(certPath, encodeCertPEM(caCert.Raw)); err != nil {
+			return nil, fmt.Errorf("failed to write client CA certificate: %v", err)
+		}
+	}
+
+	content, err := dynamiccertificates.NewStaticCAContent("higress-client-ca", encodeCertPEM(caCert.Raw))
+	if err != nil {
+		return nil, err
+	}
+	return &ClientCA{dir: dir, cert: caCert, key: key, content: content}, nil
+}
+
+// CAContentProvider returns the CA bundle, which the API server requests client certificates with.
+func (c *ClientCA) CAContentProvider() dynamiccertificates.CAContentProvider {
+	return c.content
+}
+
+// Authenticator returns an authenticator of the requests with a client certificate issued by the CA.
+func (c *ClientCA) Authenticator() authenticator.Request {
+	return x509request.NewDynamic(c.content.VerifyOptions, x509request.CommonNameUserConversion)
+}
+
+// EnsureClientCert makes sure there is a valid client certificate of the user and groups in the directory of the CA,
+// named "<user>.crt" and "<user>.key", and issues a new one if not. It returns the paths of the certificate and key.
+func (c *ClientCA) EnsureClientCert(user string, groups []string) (string, string, error) {
+	certPath, keyPath := filepath.Join(c.dir, user+".crt"), filepath.Join(c.dir, user+".key")
+	if c.isClientCertValid(certPath, keyPath, user, groups) {
+		return certPath, keyPath, nil
+	}
+
+	keyData, err := keyutil.MakeEllipticPrivateKeyPEM()
+	if err != nil {
+		return "", "", err
+	}
+	privateKey, err := keyutil.ParsePrivateKeyPEM(keyData)
+	if err != nil {
+		return "", "", err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).SetInt64(math.MaxInt64))
+	if err != nil {
+		return "", "", err
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: user, Organization: groups},
+		NotBefore:    now.Add(-5 * time.Minute).UTC(),
+		NotAfter:     now.Add(clientCertValidity).UTC(),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, template, c.cert, privateKey.(crypto.Signer).Public(), c.key)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to issue client certificate of %s: %v", user, err)
+	}
+	if err := keyutil.WriteKey(keyPath, keyData); err != nil {
+		return "", "", fmt.Errorf("failed to write client key of %s: %v", user, err)
+	}
+	if err := cert.WriteCert(certPath, encodeCertPEM(certDER)); err != nil {
+		return "", "", fmt.Errorf("failed to write client certificate of %s: %v", user, err)
+	}
+	return certPath, keyPath, nil
+}
+
+// isClientCertValid tells whether the client certificate at certPath is issued by the CA to the user and groups,
+// and doesn't expire soon.
+func (c *ClientCA) isClientCertValid(certPath, keyPath, user string, groups []string) bool {
+	if _, err := os.Stat(keyPath); err != nil {
+		return false
+	}
+	certs, err := cert.CertsFromFile(certPath)
+	if err != nil {
+		return false
+	}
+	clientCert := certs[0]
+	// The order of the organizations isn't kept in the certificate.
+	if clientCert.Subject.CommonName != user || !sets.New(clientCert.Subject.Organization...).Equal(sets.New(groups...)) {
+		return false
+	}
+	if time.Now().Add(clientCertRenewBefore).After(clientCert.NotAfter) {
+		return false
+	}
+	if !bytes.Equal(clientCert.RawIssuer, c.cert.RawSubject) {
+		return false
+	}
+	return clientCert.CheckSignatureFrom(c.cert) == nil
+}
+
+func encodeCertPEM(der []byte) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: cert.CertificateBlockType, Bytes: der})
+}
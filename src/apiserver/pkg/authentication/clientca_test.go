@@ -0,0 +1,141 @@
+package authentication
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"os"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"k8s.io/client-go/util/cert"
+)
+
+// clientCertRequest returns a request over TLS with the client certificate at certPath.
+func clientCertRequest(t *testing.T, certPath string) *http.Request {
+	t.Helper()
+	certs, err := cert.CertsFromFile(certPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := http.NewRequest(http.MethodGet, "https://localhost/api", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.TLS = &tls.ConnectionState{PeerCertificates: certs}
+	return req
+}
+
+func TestClientCA(t *testing.T) {
+	dir := t.TempDir()
+	ca, err := LoadOrCreateClientCA(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPath, keyPath, err := ca.EnsureClientCert("higress-console", []string{"system:masters", "higress:console"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tls.LoadX509KeyPair(certPath, keyPath); err != nil {
+		t.Fatalf("issued certificate doesn't match its key: %v", err)
+	}
+
+	// The common name is the user, and the organizations are the groups.
+	resp, ok, err := ca.Authenticator().AuthenticateRequest(clientCertRequest(t, certPath))
+	if err != nil || !ok {
+		t.Fatalf("AuthenticateRequest() = %v, %v", ok, err)
+	}
+	groups := resp.User.GetGroups()
+	sort.Strings(groups)
+	if resp.User.GetName() != "higress-console" || !reflect.DeepEqual(groups, []string{"higress:console", "system:masters"}) {
+		t.Fatalf("client certificate is authenticated as %+v", resp.User)
+	}
+
+	// The CA is kept across restarts, so are the certificates it issued.
+	issued, err := os.ReadFile(certPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reloaded, err := LoadOrCreateClientCA(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reloaded.cert.Equal(ca.cert) {
+		t.Fatal("client CA is regenerated at restart")
+	}
+	if _, _, err := reloaded.EnsureClientCert("higress-console", []string{"higress:console", "system:masters"}); err != nil {
+		t.Fatal(err)
+	}
+	if kept, err := os.ReadFile(certPath); err != nil || string(kept) != string(issued) {
+		t.Fatalf("valid client certificate is reissued: %v", err)
+	}
+	if _, ok, err := reloaded.Authenticator().AuthenticateRequest(clientCertRequest(t, certPath)); err != nil || !ok {
+		t.Fatalf("AuthenticateRequest() after restart = %v, %v", ok, err)
+	}
+
+	// The certificate is reissued when the groups change.
+	if _, _, err := reloaded.EnsureClientCert("higress-console", []string{"higress:console"}); err != nil {
+		t.Fatal(err)
+	}
+	resp, ok, err = reloaded.Authenticator().AuthenticateRequest(clientCertRequest(t, certPath))
+	if err != nil || !ok || !reflect.DeepEqual(resp.User.GetGroups(), []string{"higress:console"}) {
+		t.Fatalf("AuthenticateRequest() with the reissued certificate = %+v, %v, %v", resp, ok, err)
+	}
+}
+
+func TestClientCARejectsOtherCAs(t *testing.T) {
+	ca, err := LoadOrCreateClientCA(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := LoadOrCreateClientCA(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPath, keyPath, err := other.EnsureClientCert("eve", []string{"system:masters"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, _ := ca.Authenticator().AuthenticateRequest(clientCertRequest(t, certPath)); ok {
+		t.Fatal("client certificate of another CA is authenticated")
+	}
+	// A certificate issued by another CA is replaced when it's found in the directory.
+	if ca.isClientCertValid(certPath, keyPath, "eve", []string{"system:masters"}) {
+		t.Fatal("client certificate of another CA is valid")
+	}
+}
+
+func TestClientCertRenewal(t *testing.T) {
+	ca, err := LoadOrCreateClientCA(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPath, keyPath, err := ca.EnsureClientCert("higress-controller", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certs, err := cert.CertsFromFile(certPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if usages := certs[0].ExtKeyUsage; len(usages) != 1 || usages[0] != x509.ExtKeyUsageClientAuth {
+		t.Fatalf("client certificate has usages %v", usages)
+	}
+	if validity := time.Until(certs[0].NotAfter); validity < clientCertValidity-time.Hour {
+		t.Fatalf("client certificate is valid for %v", validity)
+	}
+	if !ca.isClientCertValid(certPath, keyPath, "higress-controller", nil) {
+		t.Fatal("issued client certificate isn't valid")
+	}
+	if ca.isClientCertValid(certPath, keyPath, "higress-console", nil) {
+		t.Fatal("client certificate of another user is valid")
+	}
+	if err := os.Remove(keyPath); err != nil {
+		t.Fatal(err)
+	}
+	if ca.isClientCertValid(certPath, keyPath, "higress-controller", nil) {
+		t.Fatal("client certificate without its key is valid")
+	}
+}
@@ -0,0 +1,74 @@
+package authentication
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"k8s.io/apiserver/pkg/authentication/authenticator"
+	"k8s.io/apiserver/pkg/authentication/user"
+)
+
+// TokenAuthenticator authenticates bearer tokens listed in a static token file.
+type TokenAuthenticator struct {
+	tokens map[string]*user.DefaultInfo
+}
+
+// NewTokenAuthenticatorFromFile loads a static token file in the same CSV format as the --token-auth-file of
+// Kubernetes, i.e. one "token,user,uid[,\"group1,group2,...\"]" per line. Lines starting with "#" are ignored.
+func NewTokenAuthenticatorFromFile(path string) (*TokenAuthenticator, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open token file: %v", err)
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+	reader.Comment = '#'
+	reader.TrimLeadingSpace = true
+
+	tokens := map[string]*user.DefaultInfo{}
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid token file %s: %v", path, err)
+		}
+		line, _ := reader.FieldPos(0)
+		if len(record) < 3 {
+			return nil, fmt.Errorf("invalid token file %s: line %d: token, user and uid are required", path, line)
+		}
+		token, name, uid := strings.TrimSpace(record[0]), strings.TrimSpace(record[1]), strings.TrimSpace(record[2])
+		if token == "" || name == "" {
+			return nil, fmt.Errorf("invalid token file %s: line %d: empty token or user", path, line)
+		}
+		if _, exists := tokens[token]; exists {
+			return nil, fmt.Errorf("invalid token file %s: line %d: duplicate token", path, line)
+		}
+		info := &user.DefaultInfo{Name: name, UID: uid}
+		if len(record) >= 4 {
+			for _, group := range strings.Split(record[3], ",") {
+				if group = strings.TrimSpace(group); group != "" {
+					info.Groups = append(info.Groups, group)
+				}
+			}
+		}
+		tokens[token] = info
+	}
+	return &TokenAuthenticator{tokens: tokens}, nil
+}
+
+func (a *TokenAuthenticator) AuthenticateToken(ctx context.Context, value string) (*authenticator.Response, bool, error) {
+	info, ok := a.tokens[value]
+	if !ok {
+		return nil, false, nil
+	}
+	return &authenticator.Response{User: info}, true, nil
+}
@@ -0,0 +1,61 @@
+package authentication
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func writeTestTokenFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "tokens.csv")
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestTokenAuthenticator(t *testing.T) {
+	a, err := NewTokenAuthenticatorFromFile(writeTestTokenFile(t, `# token,user,uid,groups
+console-token,higress-console,1,"system:masters, higress:console"
+controller-token, higress-controller, 2
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, ok, err := a.AuthenticateToken(context.Background(), "console-token")
+	if err != nil || !ok {
+		t.Fatalf("AuthenticateToken() = %v, %v", ok, err)
+	}
+	if resp.User.GetName() != "higress-console" || resp.User.GetUID() != "1" ||
+		!reflect.DeepEqual(resp.User.GetGroups(), []string{"system:masters", "higress:console"}) {
+		t.Fatalf("token is authenticated as %+v", resp.User)
+	}
+	resp, ok, err = a.AuthenticateToken(context.Background(), "controller-token")
+	if err != nil || !ok || resp.User.GetName() != "higress-controller" || len(resp.User.GetGroups()) != 0 {
+		t.Fatalf("AuthenticateToken() = %+v, %v, %v", resp, ok, err)
+	}
+	if _, ok, err := a.AuthenticateToken(context.Background(), "unknown"); ok || err != nil {
+		t.Fatalf("unknown token is authenticated: %v, %v", ok, err)
+	}
+}
+
+func TestTokenFileErrors(t *testing.T) {
+	tests := map[string]string{
+		"no uid":          "token,user\n",
+		"empty token":     ",user,1\n",
+		"empty user":      "token,,1\n",
+		"duplicate token": "token,a,1\ntoken,b,2\n",
+		"unclosed quote":  "token,user,1,\"group\n",
+	}
+	for name, content := range tests {
+		if _, err := NewTokenAuthenticatorFromFile(writeTestTokenFile(t, content)); err == nil {
+			t.Errorf("[%s] invalid token file is loaded", name)
+		}
+	}
+	if _, err := NewTokenAuthenticatorFromFile(filepath.Join(t.TempDir(), "missing.csv")); err == nil {
+		t.Error("missing token file is loaded")
+	}
+}
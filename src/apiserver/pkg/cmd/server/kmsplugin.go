@@ -0,0 +1,37 @@
+package server
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/alibaba/higress/api-server/pkg/encryption"
+)
+
+// NewCommandLocalKMSPlugin provides a CLI handler for 'local-kms-plugin' command, which serves a KMS plugin
+// backed by local key files for testing the KMS encryption.
+func NewCommandLocalKMSPlugin(stopCh <-chan struct{}) *cobra.Command {
+	var endpoint, keyDir string
+	var timeout time.Duration
+	cmd := &cobra.Command{
+		Use:   "local-kms-plugin",
+		Short: "Serve a KMS plugin backed by local key files for testing",
+		Long: "Serve a KMS plugin speaking the Kubernetes KMS v2 gRPC protocol, which wraps data keys with the AES keys " +
+			"in a local directory, one per file named after its key ID. The key whose ID sorts last is used for new data keys. " +
+			"It's meant for testing only, as the keys are no better protected than static encryption keys.",
+		RunE: func(c *cobra.Command, args []string) error {
+			if keyDir == "" {
+				return fmt.Errorf("--key-dir must be set")
+			}
+			return encryption.ServeLocalKMS(endpoint, keyDir, timeout, stopCh)
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.StringVar(&endpoint, "listen", "unix:///tmp/higress-kms.sock", "The Unix socket endpoint to serve the KMS plugin on.")
+	flags.StringVar(&keyDir, "key-dir", "", "The directory containing the AES keys wrapping data keys.")
+	flags.DurationVar(&timeout, "timeout", 3*time.Second, "The timeout of establishing connections.")
+
+	return cmd
+}
@@ -0,0 +1,38 @@
+package server
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+
+	"github.com/alibaba/higress/api-server/pkg/apiserver"
+	"github.com/alibaba/higress/api-server/pkg/options"
+)
+
+// NewCommandReencrypt provides a CLI handler for 'reencrypt' command, which re-encrypts the sensitive data stored
+// in the storage backend as the encryption options require.
+func NewCommandReencrypt() *cobra.Command {
+	storageOptions := options.CreateStorageOptions()
+	cmd := &cobra.Command{
+		Use:   "reencrypt",
+		Short: "Re-encrypt the sensitive data stored in the storage backend with the newest encryption key",
+		Long: "Re-encrypt the sensitive data stored in the storage backend with the newest encryption key. " +
+			"Run it with the same storage options as the API servers after a new key is added to --encryption-key-dir " +
+			"and all the API servers are restarted with it, or the key of the KMS plugin is rotated, " +
+			"or --encryption-resources is changed. Old keys can be removed once it succeeds.",
+		RunE: func(c *cobra.Command, args []string) error {
+			if err := utilerrors.NewAggregate(storageOptions.Validate()); err != nil {
+				return err
+			}
+			if !storageOptions.EncryptionOptions.Enabled() {
+				return fmt.Errorf("--encryption-kms-endpoint, --encryption-key-dir or --encryption-key-file must be set")
+			}
+			return apiserver.Reencrypt(c.Context(), storageOptions, c.OutOrStdout())
+		},
+	}
+
+	storageOptions.AddFlags(cmd.Flags())
+
+	return cmd
+}
@@ -24,17 +24,20 @@ import (
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
 	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apiserver/pkg/authorization/authorizerfactory"
+	"k8s.io/apiserver/pkg/authorization/union"
 	"k8s.io/apiserver/pkg/endpoints/openapi"
 	genericapiserver "k8s.io/apiserver/pkg/server"
 	genericoptions "k8s.io/apiserver/pkg/server/options"
 	utilfeature "k8s.io/apiserver/pkg/util/feature"
 	utilversion "k8s.io/apiserver/pkg/util/version"
 	"k8s.io/client-go/informers"
-	openapicommon "k8s.io/kube-openapi/pkg/common"
 	netutils "k8s.io/utils/net"
 
 	"github.com/alibaba/higress/api-server/pkg/apiserver"
+	higressopenapi "github.com/alibaba/higress/api-server/pkg/openapi"
 	"github.com/alibaba/higress/api-server/pkg/options"
+	"github.com/alibaba/higress/api-server/pkg/rbac"
 )
 
 const (
@@ -97,50 +100,6 @@ func (o *HigressServerOptions) Complete() error {
 	return nil
 }
 
-func getOpenAPIDefinitions(openapicommon.ReferenceCallback) map[string]openapicommon.OpenAPIDefinition {
-	return map[string]openapicommon.OpenAPIDefinition{
-		"k8s.io/api/core/v1.ConfigMap": {},
-		"k8s.io/api/core/v1.Secret":    {},
-		"k8s.io/api/core/v1.Service":   {},
-		"k8s.io/api/core/v1.Endpoints": {},
-		"k8s.io/api/core/v1.Pod":       {},
-		"k8s.io/api/core/v1.Node":      {},
-		"k8s.io/api/core/v1.Namespace": {},
-
-		"k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1.CustomResourceDefinition": {},
-
-		"k8s.io/api/admissionregistration/v1.MutatingWebhookConfiguration":   {},
-		"k8s.io/api/admissionregistration/v1.ValidatingWebhookConfiguration": {},
-
-		"k8s.io/api/authorization/v1.SubjectAccessReview": {},
-
-		"k8s.io/api/discovery/v1.EndpointSlice": {},
-
-		"k8s.io/api/networking/v1.Ingress":      {},
-		"k8s.io/api/networking/v1.IngressClass": {},
-
-		"github.com/alibaba/higress/v2/client/pkg/apis/extensions/v1alpha1.WasmPlugin": {},
-		"github.com/alibaba/higress/v2/client/pkg/apis/networking/v1.McpBridge":        {},
-		"github.com/alibaba/higress/v2/client/pkg/apis/networking/v1.Http2Rpc":         {},
-
-		"istio.io/client-go/pkg/apis/networking/v1alpha3.EnvoyFilter": {},
-
-		"github.com/alibaba/higress/api-server/pkg/apis/gatewayapi/v1.GatewayClass":   {},
-		"github.com/alibaba/higress/api-server/pkg/apis/gatewayapi/v1.Gateway":        {},
-		"github.com/alibaba/higress/api-server/pkg/apis/gatewayapi/v1.HTTPRoute":      {},
-		"github.com/alibaba/higress/api-server/pkg/apis/gatewayapi/v1.ReferenceGrant": {},
-
-		"sigs.k8s.io/gateway-api/apis/v1beta1.GatewayClass":    {},
-		"sigs.k8s.io/gateway-api/apis/v1beta1.Gateway":         {},
-		"sigs.k8s.io/gateway-api/apis/v1beta1.HTTPRoute":       {},
-		"sigs.k8s.io/gateway-api/apis/v1beta1.ReferenceGrant":  {},
-		"sigs.k8s.io/gateway-api/apis/v1alpha2.GatewayClass":   {},
-		"sigs.k8s.io/gateway-api/apis/v1alpha2.Gateway":        {},
-		"sigs.k8s.io/gateway-api/apis/v1alpha2.HTTPRoute":      {},
-		"sigs.k8s.io/gateway-api/apis/v1alpha2.ReferenceGrant": {},
-	}
-}
-
 // Config returns config for the api server given HigressServerOptions
 func (o *HigressServerOptions) Config() (*apiserver.Config, error) {
 	// TODO have a "real" external address
@@ -152,13 +111,11 @@ func (o *HigressServerOptions) Config() (*apiserver.Config, error) {
 
 	serverConfig.MaxRequestBodyBytes = o.MaxRequestBodyBytes
 
-	serverConfig.SkipOpenAPIInstallation = true
-
-	serverConfig.OpenAPIConfig = genericapiserver.DefaultOpenAPIConfig(getOpenAPIDefinitions, openapi.NewDefinitionNamer(apiserver.Scheme))
+	serverConfig.OpenAPIConfig = genericapiserver.DefaultOpenAPIConfig(higressopenapi.GetOpenAPIV2Definitions, openapi.NewDefinitionNamer(apiserver.Scheme))
 	serverConfig.OpenAPIConfig.Info.Title = "Higress"
 	serverConfig.OpenAPIConfig.Info.Version = "0.1"
 
-	serverConfig.OpenAPIV3Config = genericapiserver.DefaultOpenAPIV3Config(getOpenAPIDefinitions, openapi.NewDefinitionNamer(apiserver.Scheme))
+	serverConfig.OpenAPIV3Config = genericapiserver.DefaultOpenAPIV3Config(higressopenapi.GetOpenAPIDefinitions, openapi.NewDefinitionNamer(apiserver.Scheme))
 	serverConfig.OpenAPIV3Config.Info.Title = "Higress"
 	serverConfig.OpenAPIV3Config.Info.Version = "0.1"
 
@@ -173,11 +130,20 @@ func (o *HigressServerOptions) Config() (*apiserver.Config, error) {
 		return nil, err
 	}
 
+	var rbacAuthorizer *rbac.Authorizer
+	if o.AuthOptions.Enabled && o.AuthOptions.RBACEnabled {
+		// The RBAC resources are loaded once the storages are created.
+		rbacAuthorizer = rbac.NewAuthorizer()
+		serverConfig.Authorization.Authorizer = union.New(serverConfig.Authorization.Authorizer, rbacAuthorizer)
+		serverConfig.RuleResolver = rbacAuthorizer
+	}
+
 	config := &apiserver.Config{
 		GenericConfig: serverConfig,
 		ExtraConfig: apiserver.ExtraConfig{
 			AuthOptions:    o.AuthOptions,
 			StorageOptions: o.StorageOptions,
+			RBACAuthorizer: rbacAuthorizer,
 		},
 	}
 	return config, nil
@@ -216,9 +182,15 @@ func applyTo(o *genericoptions.RecommendedOptions, config *genericapiserver.Reco
 		if err := o.Authentication.ApplyTo(&config.Config.Authentication, config.SecureServing, config.OpenAPIConfig); err != nil {
 			return err
 		}
+		if err := authOptions.ApplyTo(&config.Config.Authentication, config.SecureServing); err != nil {
+			return err
+		}
 		if err := o.Authorization.ApplyTo(&config.Config.Authorization); err != nil {
 			return err
 		}
+	} else {
+		// Objects created on update, e.g. by server-side apply, are authorized even if authorization is disabled.
+		config.Config.Authorization.Authorizer = authorizerfactory.NewAlwaysAllowAuthorizer()
 	}
 	if err := o.Audit.ApplyTo(&config.Config); err != nil {
 		return err
@@ -269,5 +241,8 @@ func NewCommandStartHigressServer(defaults *HigressServerOptions, stopCh <-chan
 	o.StorageOptions.AddFlags(flags)
 	utilfeature.DefaultMutableFeatureGate.AddFlag(flags)
 
+	cmd.AddCommand(NewCommandReencrypt())
+	cmd.AddCommand(NewCommandLocalKMSPlugin(stopCh))
+
 	return cmd
 }
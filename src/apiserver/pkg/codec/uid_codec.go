@@ -0,0 +1,47 @@
+package codec
+
+import (
+	"io"
+
+	"github.com/google/uuid"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// NewUIDDefaultingCodec returns a codec giving the objects stored without a UID, e.g. by an older version or by hand,
+// a UID derived from their identity, so it stays the same across reads until the object is written again.
+// Server-side apply takes an object without a UID as a new one.
+func NewUIDDefaultingCodec(groupResource schema.GroupResource, innerCodec runtime.Codec) runtime.Codec {
+	return &uidDefaultingCodec{groupResource: groupResource, innerCodec: innerCodec}
+}
+
+type uidDefaultingCodec struct {
+	groupResource schema.GroupResource
+	innerCodec    runtime.Codec
+}
+
+func (c *uidDefaultingCodec) Encode(obj runtime.Object, w io.Writer) error {
+	return c.innerCodec.Encode(obj, w)
+}
+
+func (c *uidDefaultingCodec) Identifier() runtime.Identifier {
+	return c.innerCodec.Identifier()
+}
+
+func (c *uidDefaultingCodec) Decode(data []byte, defaults *schema.GroupVersionKind, into runtime.Object) (runtime.Object, *schema.GroupVersionKind, error) {
+	obj, gvk, err := c.innerCodec.Decode(data, defaults, into)
+	if err != nil {
+		return obj, gvk, err
+	}
+	accessor, err := meta.Accessor(obj)
+	if err != nil || accessor.GetUID() != "" {
+		return obj, gvk, nil
+	}
+	// The creation timestamp tells apart objects recreated with the same name.
+	identity := c.groupResource.String() + "/" + accessor.GetNamespace() + "/" + accessor.GetName() + "/" +
+		accessor.GetCreationTimestamp().UTC().Format("2006-01-02T15:04:05Z")
+	accessor.SetUID(types.UID(uuid.NewSHA1(uuid.NameSpaceURL, []byte(identity)).String()))
+	return obj, gvk, nil
+}
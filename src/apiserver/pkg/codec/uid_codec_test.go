@@ -0,0 +1,58 @@
+package codec
+
+import (
+	"bytes"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/scheme"
+)
+
+func decodeUID(t *testing.T, c runtime.Codec, obj *corev1.ConfigMap) string {
+	t.Helper()
+	obj.TypeMeta = metav1.TypeMeta{APIVersion: "v1", Kind: "ConfigMap"}
+	buf := &bytes.Buffer{}
+	if err := c.Encode(obj, buf); err != nil {
+		t.Fatal(err)
+	}
+	decoded, _, err := c.Decode(buf.Bytes(), nil, &corev1.ConfigMap{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(decoded.(*corev1.ConfigMap).UID)
+}
+
+func TestUIDDefaultingCodec(t *testing.T) {
+	c := NewUIDDefaultingCodec(corev1.Resource("configmaps"), scheme.Codecs.LegacyCodec(corev1.SchemeGroupVersion))
+	created := metav1.Unix(1700000000, 0)
+	obj := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "a", CreationTimestamp: created}}
+
+	// Objects without a UID get the same one on every read.
+	uid := decodeUID(t, c, obj.DeepCopy())
+	if uid == "" || decodeUID(t, c, obj.DeepCopy()) != uid {
+		t.Fatalf("UID of an object stored without one is %q, want a stable one", uid)
+	}
+
+	// Other objects, including the ones recreated with the same name, get other UIDs.
+	others := []*corev1.ConfigMap{
+		{ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "b", CreationTimestamp: created}},
+		{ObjectMeta: metav1.ObjectMeta{Namespace: "other", Name: "a", CreationTimestamp: created}},
+		{ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "a", CreationTimestamp: metav1.Unix(1700000001, 0)}},
+	}
+	for _, other := range others {
+		if otherUID := decodeUID(t, c, other); otherUID == uid {
+			t.Errorf("%s/%s created at %v has the same UID as ns/a", other.Namespace, other.Name, other.CreationTimestamp)
+		}
+	}
+	if secretUID := decodeUID(t, NewUIDDefaultingCodec(corev1.Resource("secrets"), scheme.Codecs.LegacyCodec(corev1.SchemeGroupVersion)), obj.DeepCopy()); secretUID == uid {
+		t.Error("objects of different resources have the same UID")
+	}
+
+	// The UID stored is kept.
+	obj.UID = "stored"
+	if got := decodeUID(t, c, obj); got != "stored" {
+		t.Fatalf("UID stored is decoded as %q", got)
+	}
+}
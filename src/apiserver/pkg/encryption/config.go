@@ -0,0 +1,72 @@
+package encryption
+
+import (
+	"fmt"
+	"os"
+
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/yaml"
+)
+
+// Config is the declarative encryption config naming the resources to encrypt, in the spirit of the
+// EncryptionConfiguration of Kubernetes, e.g.
+//
+//	resources:
+//	- resources: ["secrets"]
+//	- resources: ["wasmplugins.extensions.higress.io"]
+//	  fields: ["{.spec.defaultConfig.apiKey}", "{.spec.matchRules[*].config.apiTokens}"]
+//	- resources: ["configmaps"]
+//	  labelSelector: higress.io/sensitive=true
+type Config struct {
+	Resources []ResourceConfig `json:"resources"`
+}
+
+// ResourceConfig selects the objects to encrypt of some resources.
+type ResourceConfig struct {
+	// Resources are the resources in the form of "<resource>[.<group>]".
+	Resources []string `json:"resources"`
+	// LabelSelector selects the objects to encrypt. All the objects are encrypted if it's empty.
+	LabelSelector string `json:"labelSelector,omitempty"`
+	// Fields are the JSONPath expressions of the fields to encrypt, see FieldPath.
+	// The whole object is encrypted if it's empty.
+	Fields []string `json:"fields,omitempty"`
+}
+
+// LoadConfig loads the declarative encryption config from path, and returns the rules of the resources to encrypt.
+func LoadConfig(path string) ([]*ResourceRule, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read encryption config: %v", err)
+	}
+	config := &Config{}
+	if err := yaml.UnmarshalStrict(data, config); err != nil {
+		return nil, fmt.Errorf("invalid encryption config %s: %v", path, err)
+	}
+
+	var rules []*ResourceRule
+	for i, resourceConfig := range config.Resources {
+		if len(resourceConfig.Resources) == 0 {
+			return nil, fmt.Errorf("invalid encryption config %s: no resource is given in resources[%d]", path, i)
+		}
+		selector, err := labels.Parse(resourceConfig.LabelSelector)
+		if err != nil {
+			return nil, fmt.Errorf("invalid encryption config %s: resources[%d]: %v", path, i, err)
+		}
+		var fields []*FieldPath
+		for _, field := range resourceConfig.Fields {
+			fieldPath, err := ParseFieldPath(field)
+			if err != nil {
+				return nil, fmt.Errorf("invalid encryption config %s: resources[%d]: %v", path, i, err)
+			}
+			fields = append(fields, fieldPath)
+		}
+		for _, resource := range resourceConfig.Resources {
+			groupResource, err := parseGroupResource(resource)
+			if err != nil {
+				return nil, fmt.Errorf("invalid encryption config %s: resources[%d]: %v", path, i, err)
+			}
+			rules = append(rules, &ResourceRule{GroupResource: groupResource, Selector: selector, Fields: fields})
+		}
+	}
+	return rules, nil
+}
@@ -0,0 +1,70 @@
+package encryption
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+func writeTestConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "encryption.yaml")
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfig(t *testing.T) {
+	rules, err := LoadConfig(writeTestConfig(t, `
+resources:
+- resources: ["secrets"]
+- resources: ["wasmplugins.extensions.higress.io", "mcpbridges.networking.higress.io"]
+  fields: ["{.spec.defaultConfig.apiKey}", ".spec.registries[*].authSecretName"]
+- resources: ["configmaps"]
+  labelSelector: higress.io/sensitive=true
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var resources []string
+	for _, rule := range rules {
+		resources = append(resources, rule.GroupResource.String())
+	}
+	if got := strings.Join(resources, ","); got != "secrets,wasmplugins.extensions.higress.io,mcpbridges.networking.higress.io,configmaps" {
+		t.Fatalf("rules are loaded for %s", got)
+	}
+	if !rules[0].Selector.Empty() || len(rules[0].Fields) != 0 {
+		t.Fatalf("secrets aren't encrypted as a whole: %+v", rules[0])
+	}
+	// The resources of the same entry share its fields.
+	if len(rules[1].Fields) != 2 || len(rules[2].Fields) != 2 {
+		t.Fatalf("fields of the plugins = %v, %v, want 2", rules[1].Fields, rules[2].Fields)
+	}
+	selector := rules[3].Selector
+	if selector.Matches(labels.Set{}) || !selector.Matches(labels.Set{"higress.io/sensitive": "true"}) {
+		t.Fatalf("configmaps are selected by %q", selector)
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	tests := map[string]string{
+		"unknown key":    "resources:\n- resources: [secrets]\n  field: [\"{.data}\"]\n",
+		"no resources":   "resources:\n- labelSelector: a=b\n",
+		"bad selector":   "resources:\n- resources: [configmaps]\n  labelSelector: \"a in (b\"\n",
+		"bad field path": "resources:\n- resources: [configmaps]\n  fields: [\".data..key\"]\n",
+		"bad resource":   "resources:\n- resources: [\"\"]\n",
+		"not YAML":       "resources: [",
+	}
+	for name, content := range tests {
+		if rules, err := LoadConfig(writeTestConfig(t, content)); err == nil {
+			t.Errorf("[%s] invalid config is loaded to %v", name, rules)
+		}
+	}
+	if _, err := LoadConfig(filepath.Join(t.TempDir(), "missing.yaml")); err == nil {
+		t.Error("missing config is loaded")
+	}
+}
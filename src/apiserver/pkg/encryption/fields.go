@@ -0,0 +1,406 @@
+package encryption
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"sigs.k8s.io/yaml"
+)
+
+// FieldPath is a JSONPath expression selecting fields of an object, e.g. "{.spec.defaultConfig.apiKey}" or
+// ".spec.registries[*].authSecretName". Only the child operators are supported: ".<name>", "['<name>']", "[<index>]",
+// and the wildcards ".*" and "[*]" matching all the entries of a map or a list.
+type FieldPath struct {
+	expression string
+	segments   []fieldPathSegment
+}
+
+type fieldPathSegment struct {
+	key      string
+	index    int
+	isIndex  bool
+	wildcard bool
+}
+
+// ParseFieldPath parses a JSONPath expression selecting fields to encrypt.
+func ParseFieldPath(expression string) (*FieldPath, error) {
+	path := strings.TrimSpace(expression)
+	if strings.HasPrefix(path, "{") && strings.HasSuffix(path, "}") {
+		path = strings.TrimSpace(path[1 : len(path)-1])
+	}
+	path = strings.TrimPrefix(path, "$")
+	if path != "" && path[0] != '.' && path[0] != '[' {
+		path = "." + path
+	}
+
+	p := &FieldPath{expression: expression}
+	for path != "" {
+		var segment fieldPathSegment
+		switch {
+		case strings.HasPrefix(path, ".*"):
+			segment.wildcard = true
+			path = path[2:]
+		case path[0] == '.':
+			end := strings.IndexAny(path[1:], ".[") + 1
+			if end == 0 {
+				end = len(path)
+			}
+			segment.key = path[1:end]
+			path = path[end:]
+		case strings.HasPrefix(path, "[*]"):
+			segment.wildcard = true
+			path = path[3:]
+		case strings.HasPrefix(path, "['") || strings.HasPrefix(path, "[\""):
+			end := strings.Index(path[2:], string(path[1])+"]")
+			if end < 0 {
+				return nil, fmt.Errorf("invalid field path %q: unterminated quoted name", expression)
+			}
+			segment.key = path[2 : 2+end]
+			path = path[2+end+2:]
+		case path[0] == '[':
+			end := strings.Index(path, "]")
+			if end < 0 {
+				return nil, fmt.Errorf("invalid field path %q: unterminated index", expression)
+			}
+			index, err := strconv.Atoi(path[1:end])
+			if err != nil || index < 0 {
+				return nil, fmt.Errorf("invalid field path %q: only non-negative indexes, names and wildcards are supported", expression)
+			}
+			segment.index, segment.isIndex = index, true
+			path = path[end+1:]
+		default:
+			return nil, fmt.Errorf("invalid field path %q: unexpected %q", expression, path)
+		}
+		if !segment.wildcard && !segment.isIndex && segment.key == "" {
+			return nil, fmt.Errorf("invalid field path %q: empty field name", expression)
+		}
+		p.segments = append(p.segments, segment)
+	}
+	if len(p.segments) == 0 {
+		return nil, fmt.Errorf("invalid field path %q: no field is selected", expression)
+	}
+	return p, nil
+}
+
+func (p *FieldPath) String() string {
+	return p.expression
+}
+
+// transform replaces the values of the fields selected in node with the ones returned by fn, which is given the JSON
+// pointer of each field as well. Fields missing or set to null are skipped.
+func (p *FieldPath) transform(node interface{}, fn func(pointer string, value interface{}) (interface{}, error)) (interface{}, error) {
+	return transformFieldPath(node, "", p.segments, fn)
+}
+
+func transformFieldPath(
+	node interface{},
+	pointer string,
+	segments []fieldPathSegment,
+	fn func(pointer string, value interface{}) (interface{}, error),
+) (interface{}, error) {
+	if node == nil {
+		return nil, nil
+	}
+	if len(segments) == 0 {
+		return fn(pointer, node)
+	}
+	segment, rest := segments[0], segments[1:]
+	switch n := node.(type) {
+	case map[string]interface{}:
+		// In the order of the keys, so the fields are always encrypted in the same order.
+		keys := make([]string, 0, len(n))
+		for key := range n {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if segment.wildcard || !segment.isIndex && key == segment.key {
+				transformed, err := transformFieldPath(n[key], pointer+"/"+pointerEscaper.Replace(key), rest, fn)
+				if err != nil {
+					return nil, err
+				}
+				n[key] = transformed
+			}
+		}
+	case []interface{}:
+		for i, value := range n {
+			if segment.wildcard || segment.isIndex && i == segment.index {
+				transformed, err := transformFieldPath(value, pointer+"/"+strconv.Itoa(i), rest, fn)
+				if err != nil {
+					return nil, err
+				}
+				n[i] = transformed
+			}
+		}
+	}
+	return node, nil
+}
+
+var (
+	pointerEscaper   = strings.NewReplacer("~", "~0", "/", "~1")
+	pointerUnescaper = strings.NewReplacer("~1", "/", "~0", "~")
+)
+
+// transformPointer replaces the value at the JSON pointer in node with the one returned by fn.
+func transformPointer(node interface{}, pointer string, fn func(interface{}) (interface{}, error)) (interface{}, error) {
+	if pointer == "" {
+		return fn(node)
+	}
+	if pointer[0] != '/' {
+		return nil, fmt.Errorf("invalid JSON pointer %q", pointer)
+	}
+	token, rest, hasRest := strings.Cut(pointer[1:], "/")
+	if hasRest {
+		rest = "/" + rest
+	}
+	switch n := node.(type) {
+	case map[string]interface{}:
+		key := pointerUnescaper.Replace(token)
+		if value, ok := n[key]; ok {
+			transformed, err := transformPointer(value, rest, fn)
+			if err != nil {
+				return nil, err
+			}
+			n[key] = transformed
+			return node, nil
+		}
+	case []interface{}:
+		if i, err := strconv.Atoi(token); err == nil && i >= 0 && i < len(n) {
+			transformed, err := transformPointer(n[i], rest, fn)
+			if err != nil {
+				return nil, err
+			}
+			n[i] = transformed
+			return node, nil
+		}
+	}
+	return nil, fmt.Errorf("no field is found at %s", pointer)
+}
+
+// encryptedFieldsKey is the key of the top level field listing the JSON pointers of the fields encrypted in a document,
+// in the order they are encrypted. Unlike a mark in the values, it can't be forged with the values written by users,
+// as it's never a field of an object, and it's dropped from the data of the objects not encrypted.
+const encryptedFieldsKey = "$encryptedFields"
+
+// mayHaveEncryptedFields tells cheaply whether data may have encrypted fields.
+func mayHaveEncryptedFields(data string) bool {
+	return strings.Contains(data, encryptedFieldsKey)
+}
+
+// parseDocument parses data as a JSON or YAML document, and tells whether it's JSON.
+func parseDocument(data string) (interface{}, bool, error) {
+	isJSON := strings.HasPrefix(strings.TrimSpace(data), "{")
+	jsonData, err := yaml.YAMLToJSON([]byte(data))
+	if err != nil {
+		return nil, false, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(jsonData))
+	// Keep the numbers as they are.
+	decoder.UseNumber()
+	var doc interface{}
+	if err := decoder.Decode(&doc); err != nil {
+		return nil, false, err
+	}
+	return doc, isJSON, nil
+}
+
+func formatDocument(doc interface{}, isJSON bool) (string, error) {
+	jsonData, err := json.Marshal(doc)
+	if err != nil {
+		return "", err
+	}
+	if isJSON {
+		return string(jsonData) + "\n", nil
+	}
+	yamlData, err := yaml.JSONToYAML(jsonData)
+	if err != nil {
+		return "", err
+	}
+	return string(yamlData), nil
+}
+
+// encryptFields encrypts the values of the given fields in data one by one, each of which is replaced by a string
+// holding its JSON encrypted, so the rest of the document remains readable. The fields encrypted are listed under
+// encryptedFieldsKey.
+func encryptFields(transformer Transformer, data string, fields []*FieldPath) (string, error) {
+	doc, isJSON, err := parseDocument(data)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse data to encrypt fields: %v", err)
+	}
+	root, ok := doc.(map[string]interface{})
+	if !ok {
+		return "", errors.New("failed to encrypt fields: data isn't an object")
+	}
+	delete(root, encryptedFieldsKey)
+	var pointers []interface{}
+	encrypted := map[string]bool{}
+	encrypt := func(pointer string, value interface{}) (interface{}, error) {
+		if encrypted[pointer] {
+			// Selected by another field path too.
+			return value, nil
+		}
+		rawValue, err := json.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+		encryptedValue, err := transformer.Encrypt(string(rawValue))
+		if err != nil {
+			return nil, err
+		}
+		encrypted[pointer] = true
+		pointers = append(pointers, pointer)
+		return encryptedValue, nil
+	}
+	for _, field := range fields {
+		if _, err = field.transform(root, encrypt); err != nil {
+			return "", fmt.Errorf("failed to encrypt field %s: %v", field, err)
+		}
+	}
+	if len(pointers) != 0 {
+		root[encryptedFieldsKey] = pointers
+	}
+	return formatDocument(root, isJSON)
+}
+
+// decryptFields decrypts the fields listed as encrypted in data, no matter which fields are selected to be encrypted.
+// If transformer is nil, it fails on any encrypted field.
+func decryptFields(transformer Transformer, data string) (string, error) {
+	if !mayHaveEncryptedFields(data) {
+		return data, nil
+	}
+	doc, isJSON, err := parseDocument(data)
+	if err != nil {
+		// Not a document, so nothing is encrypted in it.
+		return data, nil
+	}
+	root, ok := doc.(map[string]interface{})
+	if !ok {
+		return data, nil
+	}
+	if _, ok := root[encryptedFieldsKey]; !ok {
+		return data, nil
+	}
+	if _, err := decryptDocument(transformer, root, nil); err != nil {
+		return "", err
+	}
+	return formatDocument(root, isJSON)
+}
+
+// decryptDocument decrypts the fields listed as encrypted in root in place, and returns their JSON pointers.
+// visit is called on each encrypted value if it's not nil.
+func decryptDocument(transformer Transformer, root map[string]interface{}, visit func(encryptedValue string)) ([]string, error) {
+	list, ok := root[encryptedFieldsKey].([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("malformed %s: %v", encryptedFieldsKey, root[encryptedFieldsKey])
+	}
+	delete(root, encryptedFieldsKey)
+	pointers := make([]string, 0, len(list))
+	for _, item := range list {
+		pointer, ok := item.(string)
+		if !ok {
+			return nil, fmt.Errorf("malformed %s: %v", encryptedFieldsKey, list)
+		}
+		pointers = append(pointers, pointer)
+	}
+	if len(pointers) != 0 && transformer == nil {
+		return nil, errors.New("data is encrypted, but no data encryption key is provided")
+	}
+	decrypt := func(value interface{}) (interface{}, error) {
+		encryptedValue, ok := value.(string)
+		if !ok || !IsEncrypted(encryptedValue) {
+			return nil, errors.New("malformed encrypted field value")
+		}
+		if visit != nil {
+			visit(encryptedValue)
+		}
+		rawValue, err := transformer.Decrypt(encryptedValue)
+		if err != nil {
+			return nil, err
+		}
+		var decryptedValue interface{}
+		decoder := json.NewDecoder(strings.NewReader(rawValue))
+		decoder.UseNumber()
+		if err := decoder.Decode(&decryptedValue); err != nil {
+			return nil, fmt.Errorf("malformed encrypted field value: %v", err)
+		}
+		return decryptedValue, nil
+	}
+	// The fields encrypted later may hold the ones encrypted earlier.
+	for i := len(pointers) - 1; i >= 0; i-- {
+		if _, err := transformPointer(root, pointers[i], decrypt); err != nil {
+			return nil, fmt.Errorf("failed to decrypt field %s: %v", pointers[i], err)
+		}
+	}
+	return pointers, nil
+}
+
+// stripEncryptedFields drops the list of encrypted fields from data not encrypted, so no field of it is decrypted.
+func stripEncryptedFields(data string) (string, error) {
+	if !mayHaveEncryptedFields(data) {
+		return data, nil
+	}
+	doc, isJSON, err := parseDocument(data)
+	if err != nil {
+		return data, nil
+	}
+	root, ok := doc.(map[string]interface{})
+	if !ok {
+		return data, nil
+	}
+	if _, ok := root[encryptedFieldsKey]; !ok {
+		return data, nil
+	}
+	delete(root, encryptedFieldsKey)
+	return formatDocument(root, isJSON)
+}
+
+// fieldsStale tells whether the encrypted fields in data differ from the ones the given fields require, e.g. any of
+// the fields isn't encrypted with the newest key, or any other field is encrypted.
+func fieldsStale(transformer Transformer, data string, fields []*FieldPath) bool {
+	doc, _, err := parseDocument(data)
+	if err != nil {
+		return false
+	}
+	root, ok := doc.(map[string]interface{})
+	if !ok {
+		return false
+	}
+	var pointers []string
+	if _, ok := root[encryptedFieldsKey]; ok {
+		stale := false
+		pointers, err = decryptDocument(transformer, root, func(encryptedValue string) {
+			if transformer.IsStale(encryptedValue) {
+				stale = true
+			}
+		})
+		if err != nil || stale {
+			return true
+		}
+	}
+	// Encrypt the fields again as placeholders, which must be the same as the ones encrypted.
+	var expected []string
+	encrypted := map[string]bool{}
+	for _, field := range fields {
+		_, _ = field.transform(root, func(pointer string, value interface{}) (interface{}, error) {
+			if !encrypted[pointer] {
+				encrypted[pointer] = true
+				expected = append(expected, pointer)
+			}
+			return "", nil
+		})
+	}
+	if len(expected) != len(pointers) {
+		return true
+	}
+	for i := range expected {
+		if expected[i] != pointers[i] {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,285 @@
+package encryption
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+func newTestKeyring(t *testing.T, keyIds ...string) *Keyring {
+	t.Helper()
+	dir := t.TempDir()
+	for i, keyId := range keyIds {
+		key := strings.Repeat(string(rune('a'+i)), 32)
+		if err := os.WriteFile(filepath.Join(dir, keyId), []byte(key), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	keyring, err := LoadKeyring(dir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keyring
+}
+
+func mustParseFieldPaths(t *testing.T, expressions ...string) []*FieldPath {
+	t.Helper()
+	var fields []*FieldPath
+	for _, expression := range expressions {
+		field, err := ParseFieldPath(expression)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fields = append(fields, field)
+	}
+	return fields
+}
+
+func parseJSON(t *testing.T, data string) map[string]interface{} {
+	t.Helper()
+	var doc map[string]interface{}
+	if err := json.Unmarshal([]byte(data), &doc); err != nil {
+		t.Fatalf("invalid JSON %q: %v", data, err)
+	}
+	return doc
+}
+
+func TestParseFieldPath(t *testing.T) {
+	tests := []struct {
+		expression string
+		want       []fieldPathSegment
+		wantErr    bool
+	}{
+		{expression: "{.spec.defaultConfig.apiKey}", want: []fieldPathSegment{{key: "spec"}, {key: "defaultConfig"}, {key: "apiKey"}}},
+		{expression: ".spec.registries[*].authSecretName", want: []fieldPathSegment{{key: "spec"}, {key: "registries"}, {wildcard: true}, {key: "authSecretName"}}},
+		{expression: "$.data['tls.key']", want: []fieldPathSegment{{key: "data"}, {key: "tls.key"}}},
+		{expression: "data.*", want: []fieldPathSegment{{key: "data"}, {wildcard: true}}},
+		{expression: ".items[2]", want: []fieldPathSegment{{key: "items"}, {index: 2, isIndex: true}}},
+		{expression: "", wantErr: true},
+		{expression: ".items[-1]", wantErr: true},
+		{expression: ".data['key", wantErr: true},
+		{expression: ".spec..name", wantErr: true},
+	}
+	for _, tt := range tests {
+		p, err := ParseFieldPath(tt.expression)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("ParseFieldPath(%q) succeeded, want an error", tt.expression)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseFieldPath(%q) failed: %v", tt.expression, err)
+			continue
+		}
+		if !reflect.DeepEqual(p.segments, tt.want) {
+			t.Errorf("ParseFieldPath(%q) = %+v, want %+v", tt.expression, p.segments, tt.want)
+		}
+	}
+}
+
+func TestEncryptFieldsRoundTrip(t *testing.T) {
+	keyring := newTestKeyring(t, "key-1")
+	fields := mustParseFieldPaths(t, "{.data.password}", "{.data['tls/key']}", "{.spec.rules[*].token}")
+	data := `{"kind":"Test","data":{"password":"secret","tls/key":{"pem":"x"},"user":"admin"},` +
+		`"spec":{"rules":[{"token":"a"},{"name":"no-token"},{"token":["b","c"]}]}}`
+
+	encrypted, err := encryptFields(keyring, data, fields)
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc := parseJSON(t, encrypted)
+	if strings.Contains(encrypted, "secret") || strings.Contains(encrypted, `"pem"`) {
+		t.Errorf("fields aren't encrypted: %s", encrypted)
+	}
+	if user := doc["data"].(map[string]interface{})["user"]; user != "admin" {
+		t.Errorf("field not selected is changed to %v", user)
+	}
+	wantPointers := []interface{}{"/data/password", "/data/tls~1key", "/spec/rules/0/token", "/spec/rules/2/token"}
+	if !reflect.DeepEqual(doc[encryptedFieldsKey], wantPointers) {
+		t.Errorf("encrypted fields = %v, want %v", doc[encryptedFieldsKey], wantPointers)
+	}
+
+	decrypted, err := decryptFields(keyring, encrypted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(parseJSON(t, decrypted), parseJSON(t, data)) {
+		t.Errorf("decrypted %s, want %s", decrypted, data)
+	}
+}
+
+func TestEncryptNestedFields(t *testing.T) {
+	keyring := newTestKeyring(t, "key-1")
+	// The inner field is encrypted first, and then again along with the outer one.
+	fields := mustParseFieldPaths(t, "{.spec.config.apiKey}", "{.spec.config}")
+	data := `{"spec":{"config":{"apiKey":"secret","timeout":3}}}`
+
+	encrypted, err := encryptFields(keyring, data, fields)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decrypted, err := decryptFields(keyring, encrypted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(parseJSON(t, decrypted), parseJSON(t, data)) {
+		t.Errorf("decrypted %s, want %s", decrypted, data)
+	}
+	if fieldsStale(keyring, encrypted, fields) {
+		t.Errorf("fields just encrypted are stale: %s", encrypted)
+	}
+}
+
+func TestEncryptFieldsYAML(t *testing.T) {
+	keyring := newTestKeyring(t, "key-1")
+	data := "kind: Test\ndata:\n  password: secret\n  port: 8080\n"
+
+	encrypted, err := encryptFields(keyring, data, mustParseFieldPaths(t, "{.data.password}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.HasPrefix(encrypted, "{") || strings.Contains(encrypted, "secret") {
+		t.Errorf("unexpected encrypted YAML: %s", encrypted)
+	}
+	decrypted, err := decryptFields(keyring, encrypted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(decrypted, "password: secret") || !strings.Contains(decrypted, "port: 8080") {
+		t.Errorf("unexpected decrypted YAML: %s", decrypted)
+	}
+}
+
+// TestPlaintextWithEncryptionMarks checks the values looking like encrypted ones are never decrypted, as anyone
+// allowed to write an object can write them.
+func TestPlaintextWithEncryptionMarks(t *testing.T) {
+	keyring := newTestKeyring(t, "key-1")
+	fields := mustParseFieldPaths(t, "{.data.password}")
+	selected := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"sensitive": "true"}}}
+	notSelected := &corev1.ConfigMap{}
+	policies := map[string]*Policy{
+		"nil":         nil,
+		"no rule":     NewPolicy(keyring, nil),
+		"whole":       NewPolicy(keyring, &ResourceRule{Selector: labels.Everything()}),
+		"fields":      NewPolicy(keyring, &ResourceRule{Selector: labels.Everything(), Fields: fields}),
+		"by selector": NewPolicy(keyring, &ResourceRule{Selector: labels.SelectorFromSet(labels.Set{"sensitive": "true"}), Fields: fields}),
+	}
+	values := []string{
+		"enc2|key-1|AAAA",
+		"enc2|unknown|garbage",
+		"kms2|provider|garbage",
+		"enc|garbage",
+		"$encryptedFields",
+	}
+
+	for name, policy := range policies {
+		for _, obj := range []*corev1.ConfigMap{selected, notSelected} {
+			for _, value := range values {
+				data := `{"kind":"ConfigMap","metadata":{"annotations":{"note":` + jsonString(value) + `}},` +
+					`"data":{"password":"p","other":` + jsonString(value) + `}}`
+				stored, err := policy.Encrypt(obj, data)
+				if err != nil {
+					t.Errorf("[%s] Encrypt(%q) failed: %v", name, value, err)
+					continue
+				}
+				decrypted, err := policy.Decrypt(stored)
+				if err != nil {
+					t.Errorf("[%s] Decrypt of plaintext %q failed: %v", name, value, err)
+					continue
+				}
+				if !reflect.DeepEqual(parseJSON(t, decrypted), parseJSON(t, data)) {
+					t.Errorf("[%s] decrypted %s, want %s", name, decrypted, data)
+				}
+			}
+		}
+	}
+}
+
+// TestForgedEncryptedFields checks a list of encrypted fields in the data of an object not encrypted is dropped,
+// so it can't make the object undecodable.
+func TestForgedEncryptedFields(t *testing.T) {
+	keyring := newTestKeyring(t, "key-1")
+	data := `{"kind":"ConfigMap","data":{"password":"enc2|key-1|AAAA"},"$encryptedFields":["/data/password"]}`
+	for name, policy := range map[string]*Policy{
+		"nil":     nil,
+		"no rule": NewPolicy(keyring, nil),
+	} {
+		stored, err := policy.Encrypt(&corev1.ConfigMap{}, data)
+		if err != nil {
+			t.Fatalf("[%s] Encrypt failed: %v", name, err)
+		}
+		if strings.Contains(stored, encryptedFieldsKey) {
+			t.Errorf("[%s] the forged list of encrypted fields is kept: %s", name, stored)
+		}
+		decrypted, err := policy.Decrypt(stored)
+		if err != nil {
+			t.Fatalf("[%s] Decrypt failed: %v", name, err)
+		}
+		if got := parseJSON(t, decrypted)["data"]; !reflect.DeepEqual(got, map[string]interface{}{"password": "enc2|key-1|AAAA"}) {
+			t.Errorf("[%s] data = %v", name, got)
+		}
+	}
+}
+
+func TestDecryptWithoutKey(t *testing.T) {
+	keyring := newTestKeyring(t, "key-1")
+	policy := NewPolicy(keyring, &ResourceRule{Selector: labels.Everything(), Fields: mustParseFieldPaths(t, "{.data.password}")})
+	stored, err := policy.Encrypt(&corev1.ConfigMap{}, `{"data":{"password":"secret"}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var nilPolicy *Policy
+	if _, err := nilPolicy.Decrypt(stored); err == nil {
+		t.Error("encrypted fields are decrypted without a key")
+	}
+}
+
+func TestFieldsStale(t *testing.T) {
+	oldKeyring := newTestKeyring(t, "key-1")
+	keyring := newTestKeyring(t, "key-1", "key-2")
+	fields := mustParseFieldPaths(t, "{.data.password}")
+	obj := &corev1.ConfigMap{}
+	data := `{"data":{"password":"secret","other":"enc2|key-1|AAAA"}}`
+
+	oldPolicy := NewPolicy(oldKeyring, &ResourceRule{Selector: labels.Everything(), Fields: fields})
+	policy := NewPolicy(keyring, &ResourceRule{Selector: labels.Everything(), Fields: fields})
+	stored, err := oldPolicy.Encrypt(obj, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if oldPolicy.IsStale(obj, stored) {
+		t.Error("fields encrypted with the newest key are stale")
+	}
+	if !policy.IsStale(obj, stored) {
+		t.Error("fields encrypted with an old key aren't stale")
+	}
+	if !policy.IsStale(obj, data) {
+		t.Error("fields not encrypted aren't stale")
+	}
+	if NewPolicy(keyring, nil).IsStale(obj, data) {
+		t.Error("data of a resource not encrypted is stale")
+	}
+	if !NewPolicy(keyring, nil).IsStale(obj, stored) {
+		t.Error("encrypted fields of a resource no longer encrypted aren't stale")
+	}
+	otherFields := NewPolicy(keyring, &ResourceRule{Selector: labels.Everything(), Fields: mustParseFieldPaths(t, "{.data.other}")})
+	if !otherFields.IsStale(obj, stored) {
+		t.Error("fields no longer selected aren't stale")
+	}
+	if policy.IsStale(obj, `{"data":{"other":"x"}}`) {
+		t.Error("data without the fields selected is stale")
+	}
+}
+
+func jsonString(s string) string {
+	data, _ := json.Marshal(s)
+	return string(data)
+}
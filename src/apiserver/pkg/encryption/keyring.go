@@ -0,0 +1,206 @@
+package encryption
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/alibaba/higress/api-server/pkg/utils"
+)
+
+const (
+	// legacyMark prefixes data encrypted with AES-CBC by previous versions, which is still decrypted transparently.
+	legacyMark = "enc|"
+	// envelopeMark prefixes data encrypted with AES-GCM, in the form of "enc2|<keyId>|<base64 of nonce and ciphertext>".
+	envelopeMark      = "enc2|"
+	envelopeSeparator = "|"
+)
+
+// Keyring holds the AES keys used to encrypt sensitive data at rest, identified by their key IDs.
+// Data is always encrypted with the newest key, while any known key can be used to decrypt data,
+// so keys can be rotated by adding a new one and re-encrypting the data stored.
+//
+// A nil Keyring doesn't encrypt data, and fails to decrypt encrypted data.
+type Keyring struct {
+	keys        map[string][]byte
+	newestKeyId string
+	legacyKey   []byte
+}
+
+var _ Transformer = &Keyring{}
+
+// LoadKeyring loads the keys from keyDir, where each regular file holds a key named after the file. Files whose name
+// starts with a dot are skipped, so the dir can be a mounted Kubernetes secret. The newest key is the one whose
+// ID sorts last, so key IDs are supposed to carry a date or a serial number, e.g. "20240601" or "key-0002".
+//
+// legacyKeyFile holds the key used by previous versions, which decrypts data in the legacy format. If keyDir is empty,
+// it's also the only key of the keyring, named after the file without its extension.
+func LoadKeyring(keyDir, legacyKeyFile string) (*Keyring, error) {
+	k := &Keyring{keys: map[string][]byte{}}
+
+	if legacyKeyFile != "" {
+		key, err := readKey(legacyKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		k.legacyKey = key
+		if keyDir == "" {
+			keyId := strings.TrimSuffix(filepath.Base(legacyKeyFile), filepath.Ext(legacyKeyFile))
+			if err := k.addKey(keyId, key); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if keyDir != "" {
+		entries, err := os.ReadDir(keyDir)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read encryption key dir: %v", err)
+		}
+		for _, entry := range entries {
+			if strings.HasPrefix(entry.Name(), ".") {
+				continue
+			}
+			path := filepath.Join(keyDir, entry.Name())
+			// Stat follows the symlinks created for mounted secrets.
+			if fileInfo, err := os.Stat(path); err != nil || !fileInfo.Mode().IsRegular() {
+				continue
+			}
+			key, err := readKey(path)
+			if err != nil {
+				return nil, err
+			}
+			if err := k.addKey(entry.Name(), key); err != nil {
+				return nil, err
+			}
+		}
+		if len(k.keys) == 0 {
+			return nil, fmt.Errorf("no encryption key is found in %s", keyDir)
+		}
+	}
+
+	if len(k.keys) == 0 {
+		return nil, nil
+	}
+	keyIds := k.KeyIds()
+	k.newestKeyId = keyIds[len(keyIds)-1]
+	return k, nil
+}
+
+func readKey(path string) ([]byte, error) {
+	key, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read encryption key file: %s", err)
+	}
+	switch len(key) {
+	case 16, 24, 32:
+		return key, nil
+	default:
+		return nil, fmt.Errorf("invalid encryption key length of %s: %d", path, len(key))
+	}
+}
+
+func (k *Keyring) addKey(keyId string, key []byte) error {
+	if keyId == "" || strings.Contains(keyId, envelopeSeparator) || strings.ContainsAny(keyId, " \t\r\n") {
+		return fmt.Errorf("invalid encryption key ID: %q", keyId)
+	}
+	k.keys[keyId] = key
+	return nil
+}
+
+// KeyIds returns the IDs of all the keys in ascending order, so the newest one is the last.
+func (k *Keyring) KeyIds() []string {
+	if k == nil {
+		return nil
+	}
+	keyIds := make([]string, 0, len(k.keys))
+	for keyId := range k.keys {
+		keyIds = append(keyIds, keyId)
+	}
+	sort.Strings(keyIds)
+	return keyIds
+}
+
+// NewestKeyId returns the ID of the key used to encrypt data.
+func (k *Keyring) NewestKeyId() string {
+	if k == nil {
+		return ""
+	}
+	return k.newestKeyId
+}
+
+// Encrypt encrypts data with the newest key.
+func (k *Keyring) Encrypt(data string) (string, error) {
+	if k == nil {
+		return data, nil
+	}
+	header := envelopeMark + k.newestKeyId + envelopeSeparator
+	encryptedData, err := utils.AesGcmEncrypt([]byte(data), []byte(header), k.keys[k.newestKeyId])
+	if err != nil {
+		return "", err
+	}
+	return header + base64.URLEncoding.EncodeToString(encryptedData), nil
+}
+
+// Decrypt decrypts data in either the current or the legacy format. Data not encrypted is returned as it is.
+func (k *Keyring) Decrypt(data string) (string, error) {
+	switch {
+	case strings.HasPrefix(data, envelopeMark):
+		return k.decryptEnvelope(data)
+	case strings.HasPrefix(data, legacyMark):
+		return k.decryptLegacy(data)
+	default:
+		return data, nil
+	}
+}
+
+func (k *Keyring) decryptEnvelope(data string) (string, error) {
+	keyId, payload, ok := strings.Cut(strings.TrimPrefix(data, envelopeMark), envelopeSeparator)
+	if !ok {
+		return "", errors.New("malformed encrypted data")
+	}
+	if k == nil {
+		return "", errors.New("data is encrypted, but no data encryption key is provided")
+	}
+	key, ok := k.keys[keyId]
+	if !ok {
+		return "", fmt.Errorf("data is encrypted with an unknown key: %s", keyId)
+	}
+	encryptedData, err := base64.URLEncoding.DecodeString(payload)
+	if err != nil {
+		return "", err
+	}
+	header := envelopeMark + keyId + envelopeSeparator
+	decryptedData, err := utils.AesGcmDecrypt(encryptedData, []byte(header), key)
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt data with key %s: %v", keyId, err)
+	}
+	return string(decryptedData), nil
+}
+
+func (k *Keyring) decryptLegacy(data string) (string, error) {
+	if k == nil || k.legacyKey == nil {
+		return "", errors.New("data is encrypted in the legacy format, but no legacy data encryption key is provided")
+	}
+	encryptedData, err := base64.URLEncoding.DecodeString(strings.TrimPrefix(data, legacyMark))
+	if err != nil {
+		return "", err
+	}
+	decryptedData, err := utils.AesDecrypt(encryptedData, k.legacyKey)
+	if err != nil {
+		return "", err
+	}
+	return string(decryptedData), nil
+}
+
+// IsStale tells whether data should be re-encrypted, as it isn't encrypted with the newest key.
+func (k *Keyring) IsStale(data string) bool {
+	if k == nil {
+		return false
+	}
+	return !strings.HasPrefix(data, envelopeMark+k.newestKeyId+envelopeSeparator)
+}
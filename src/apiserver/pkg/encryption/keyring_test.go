@@ -0,0 +1,177 @@
+package encryption
+
+import (
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/alibaba/higress/api-server/pkg/utils"
+)
+
+func TestKeyringRoundTrip(t *testing.T) {
+	keyring := newTestKeyring(t, "key-1")
+	encrypted, err := keyring.Encrypt("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(encrypted, "enc2|key-1|") || strings.Contains(encrypted, "secret") {
+		t.Fatalf("encrypted data = %q, want an envelope of key-1", encrypted)
+	}
+	if decrypted, err := keyring.Decrypt(encrypted); err != nil || decrypted != "secret" {
+		t.Fatalf("Decrypt() = %q, %v", decrypted, err)
+	}
+
+	// The nonces are random, so the same data is never encrypted the same.
+	again, err := keyring.Encrypt("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again == encrypted {
+		t.Fatal("same data is encrypted deterministically")
+	}
+
+	// Data not encrypted is returned as it is.
+	if decrypted, err := keyring.Decrypt("plain"); err != nil || decrypted != "plain" {
+		t.Fatalf("Decrypt() of plaintext = %q, %v", decrypted, err)
+	}
+}
+
+func TestKeyringTampering(t *testing.T) {
+	keyring := newTestKeyring(t, "key-1", "key-2")
+	encrypted, err := keyring.Encrypt("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload := strings.TrimPrefix(encrypted, "enc2|key-2|")
+	data, err := base64.URLEncoding.DecodeString(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[len(data)-1] ^= 1
+	tests := map[string]string{
+		"ciphertext": "enc2|key-2|" + base64.URLEncoding.EncodeToString(data),
+		// The key ID is authenticated, so the payload can't be moved to another key.
+		"key ID":      "enc2|key-1|" + payload,
+		"unknown key": "enc2|key-3|" + payload,
+		"short":       "enc2|key-2|AAAA",
+		"base64":      "enc2|key-2|!!!!",
+		"malformed":   "enc2|key-2",
+	}
+	for name, data := range tests {
+		if decrypted, err := keyring.Decrypt(data); err == nil {
+			t.Errorf("[%s] tampered data is decrypted to %q", name, decrypted)
+		}
+	}
+}
+
+func TestKeyringRotation(t *testing.T) {
+	oldKeyring := newTestKeyring(t, "key-1")
+	encrypted, err := oldKeyring.Encrypt("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if oldKeyring.IsStale(encrypted) {
+		t.Fatal("data encrypted with the newest key is stale")
+	}
+
+	keyring := newTestKeyring(t, "key-1", "key-2")
+	if keyring.NewestKeyId() != "key-2" || !reflect.DeepEqual(keyring.KeyIds(), []string{"key-1", "key-2"}) {
+		t.Fatalf("keyring has keys %v with the newest %q", keyring.KeyIds(), keyring.NewestKeyId())
+	}
+	if decrypted, err := keyring.Decrypt(encrypted); err != nil || decrypted != "secret" {
+		t.Fatalf("Decrypt() with an old key = %q, %v", decrypted, err)
+	}
+	if !keyring.IsStale(encrypted) || !keyring.IsStale("plain") {
+		t.Fatal("data not encrypted with the newest key isn't stale")
+	}
+	reencrypted, err := keyring.Encrypt(encrypted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keyring.IsStale(reencrypted) {
+		t.Fatal("re-encrypted data is stale")
+	}
+}
+
+func TestKeyringLegacyFormat(t *testing.T) {
+	dir := t.TempDir()
+	legacyKey := []byte(strings.Repeat("l", 16))
+	legacyKeyFile := filepath.Join(dir, "legacy.key")
+	if err := os.WriteFile(legacyKeyFile, legacyKey, 0600); err != nil {
+		t.Fatal(err)
+	}
+	encryptedData, err := utils.AesEncrypt([]byte("secret"), legacyKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacy := "enc|" + base64.URLEncoding.EncodeToString(encryptedData)
+
+	// Without a key dir, the legacy key is also the one encrypting data, named after its file.
+	keyring, err := LoadKeyring("", legacyKeyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keyring.NewestKeyId() != "legacy" {
+		t.Fatalf("legacy key is named %q", keyring.NewestKeyId())
+	}
+	if decrypted, err := keyring.Decrypt(legacy); err != nil || decrypted != "secret" {
+		t.Fatalf("Decrypt() of the legacy format = %q, %v", decrypted, err)
+	}
+	if !keyring.IsStale(legacy) {
+		t.Fatal("data in the legacy format isn't stale")
+	}
+
+	// Corrupted legacy data fails to be decrypted instead of panicking.
+	for _, data := range [][]byte{nil, []byte("short"), make([]byte, 16), make([]byte, 32)} {
+		if _, err := keyring.Decrypt("enc|" + base64.URLEncoding.EncodeToString(data)); err == nil {
+			t.Errorf("corrupted legacy data %v is decrypted", data)
+		}
+	}
+
+	if _, err := newTestKeyring(t, "key-1").Decrypt(legacy); err == nil {
+		t.Fatal("legacy data is decrypted without the legacy key")
+	}
+}
+
+func TestLoadKeyring(t *testing.T) {
+	dir := t.TempDir()
+	writeKey := func(name string, size int) {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(strings.Repeat("k", size)), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := LoadKeyring(dir, ""); err == nil {
+		t.Fatal("keyring is loaded from an empty dir")
+	}
+
+	// Hidden files and dirs, like the ones of mounted secrets, are skipped.
+	writeKey(".hidden", 3)
+	if err := os.Mkdir(filepath.Join(dir, "..data"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	writeKey("20240101", 16)
+	writeKey("20240601", 32)
+	keyring, err := LoadKeyring(dir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(keyring.KeyIds(), []string{"20240101", "20240601"}) || keyring.NewestKeyId() != "20240601" {
+		t.Fatalf("keyring has keys %v with the newest %q", keyring.KeyIds(), keyring.NewestKeyId())
+	}
+
+	writeKey("invalid", 20)
+	if _, err := LoadKeyring(dir, ""); err == nil {
+		t.Fatal("key of an invalid length is loaded")
+	}
+
+	if keyring, err := LoadKeyring("", ""); keyring != nil || err != nil {
+		t.Fatalf("LoadKeyring() without keys = %v, %v, want no keyring", keyring, err)
+	}
+	var nilKeyring *Keyring
+	if encrypted, err := nilKeyring.Encrypt("plain"); err != nil || encrypted != "plain" {
+		t.Fatalf("Encrypt() without keys = %q, %v", encrypted, err)
+	}
+}
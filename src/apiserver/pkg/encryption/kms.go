@@ -0,0 +1,303 @@
+package encryption
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	"k8s.io/apimachinery/pkg/util/cache"
+	"k8s.io/apimachinery/pkg/util/uuid"
+	"k8s.io/apiserver/pkg/storage/value/encrypt/envelope/kmsv2"
+	kmstypes "k8s.io/apiserver/pkg/storage/value/encrypt/envelope/kmsv2/v2"
+	"k8s.io/klog/v2"
+	kmsservice "k8s.io/kms/pkg/service"
+
+	"github.com/alibaba/higress/api-server/pkg/utils"
+)
+
+const (
+	// kmsMark prefixes data encrypted with a DEK wrapped by a KMS plugin, in the form of
+	// "kms2|<provider name>|<base64 of the encrypted object>", where the encrypted object is the one of Kubernetes KMS v2.
+	kmsMark = "kms2|"
+
+	kmsDEKSize = 32
+	// kmsDEKMaxUses limits the number of encryptions with a DEK, keeping the chance of random nonce collisions negligible.
+	kmsDEKMaxUses     = 1 << 20
+	kmsDEKCacheSize   = 1000
+	kmsDEKCacheTTL    = time.Hour
+	kmsStatusInterval = time.Minute
+	kmsStatusHealthy  = "ok"
+)
+
+// kmsTransformer encrypts data with DEKs (data encryption keys) generated locally, which are wrapped by a KMS plugin
+// speaking the Kubernetes KMS v2 gRPC protocol, and stored along with the data and the ID of the KMS key wrapping them.
+// A DEK is reused until the KMS key is rotated, so the plugin is only called for new DEKs and decrypting unknown ones.
+//
+// It fails closed: data is never written unencrypted when the plugin is unreachable or unhealthy.
+type kmsTransformer struct {
+	name    string
+	service kmsservice.Service
+	// keyring decrypts data encrypted before the KMS plugin is in use, which may be nil.
+	keyring *Keyring
+	// dekCache holds the plaintext DEKs keyed by their encrypted form.
+	dekCache *cache.LRUExpireCache
+
+	mutex     sync.Mutex
+	statusErr error
+	keyId     string
+	dek       *kmsDEK
+	// generating is closed once the DEK being generated is in place, which is nil if none is.
+	generating chan struct{}
+}
+
+type kmsDEK struct {
+	key          []byte
+	encryptedDEK []byte
+	keyId        string
+	annotations  map[string][]byte
+	uses         int
+}
+
+var _ Transformer = &kmsTransformer{}
+
+// NewKMSTransformer connects to the KMS plugin listening on endpoint, e.g. unix:///var/run/kms.sock, and checks it's
+// healthy. keyring decrypts the data encrypted before, and may be nil.
+// The connection is closed when ctx is done.
+func NewKMSTransformer(ctx context.Context, endpoint, name string, timeout time.Duration, keyring *Keyring) (Transformer, error) {
+	service, err := kmsv2.NewGRPCService(ctx, endpoint, name, timeout)
+	if err != nil {
+		return nil, err
+	}
+	t := &kmsTransformer{
+		name:     name,
+		service:  service,
+		keyring:  keyring,
+		dekCache: cache.NewLRUExpireCache(kmsDEKCacheSize),
+	}
+	if err := t.checkStatus(ctx); err != nil {
+		return nil, err
+	}
+	go t.watchStatus(ctx)
+	return t, nil
+}
+
+func (t *kmsTransformer) checkStatus(ctx context.Context) error {
+	status, err := t.service.Status(ctx)
+	if err == nil && status.Healthz != kmsStatusHealthy {
+		err = fmt.Errorf("unhealthy status: %s", status.Healthz)
+	}
+	if err == nil && status.KeyID == "" {
+		err = errors.New("no key ID is returned")
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if err != nil {
+		err = fmt.Errorf("KMS plugin %s is unavailable: %v", t.name, err)
+		if t.statusErr == nil {
+			klog.Errorf("%v", err)
+		}
+		t.statusErr = err
+		return err
+	}
+	if t.statusErr != nil {
+		klog.Infof("KMS plugin %s is available again", t.name)
+	}
+	if t.keyId != status.KeyID {
+		klog.Infof("KMS plugin %s is using key %s", t.name, status.KeyID)
+	}
+	t.statusErr = nil
+	t.keyId = status.KeyID
+	return nil
+}
+
+func (t *kmsTransformer) watchStatus(ctx context.Context) {
+	ticker := time.NewTicker(kmsStatusInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			_ = t.checkStatus(ctx)
+		}
+	}
+}
+
+// currentDEK returns the DEK to encrypt data with, which is replaced once the KMS key is rotated or it's used too much.
+// The new DEK is wrapped by the plugin without holding the mutex, and the callers needing it meanwhile wait for it.
+func (t *kmsTransformer) currentDEK() (*kmsDEK, error) {
+	for {
+		t.mutex.Lock()
+		if t.statusErr != nil {
+			err := t.statusErr
+			t.mutex.Unlock()
+			return nil, err
+		}
+		if t.dek != nil && t.dek.keyId == t.keyId && t.dek.uses < kmsDEKMaxUses {
+			t.dek.uses++
+			dek := t.dek
+			t.mutex.Unlock()
+			return dek, nil
+		}
+		if generating := t.generating; generating != nil {
+			t.mutex.Unlock()
+			<-generating
+			continue
+		}
+		generating := make(chan struct{})
+		t.generating = generating
+		keyId := t.keyId
+		t.mutex.Unlock()
+
+		dek, err := t.newDEK()
+
+		t.mutex.Lock()
+		t.generating = nil
+		close(generating)
+		if err == nil {
+			// The key wrapping the DEK is taken as the newest one, unless the status has reported another one since.
+			if dek.keyId != t.keyId && t.keyId == keyId {
+				klog.Infof("KMS plugin %s is using key %s", t.name, dek.keyId)
+				t.keyId = dek.keyId
+			}
+			t.dek = dek
+		}
+		t.mutex.Unlock()
+		return dek, err
+	}
+}
+
+// newDEK generates a DEK and wraps it by the plugin.
+func (t *kmsTransformer) newDEK() (*kmsDEK, error) {
+	key := make([]byte, kmsDEKSize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	resp, err := t.service.Encrypt(context.Background(), string(uuid.NewUUID()), key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt DEK with KMS plugin %s: %v", t.name, err)
+	}
+	if resp.KeyID == "" || len(resp.Ciphertext) == 0 {
+		return nil, fmt.Errorf("invalid response of KMS plugin %s: empty key ID or ciphertext", t.name)
+	}
+	t.dekCache.Add(string(resp.Ciphertext), key, kmsDEKCacheTTL)
+	return &kmsDEK{
+		key:          key,
+		encryptedDEK: resp.Ciphertext,
+		keyId:        resp.KeyID,
+		annotations:  resp.Annotations,
+		uses:         1,
+	}, nil
+}
+
+func (t *kmsTransformer) header() string {
+	return kmsMark + t.name + envelopeSeparator
+}
+
+func (t *kmsTransformer) Encrypt(data string) (string, error) {
+	dek, err := t.currentDEK()
+	if err != nil {
+		return "", err
+	}
+	header := t.header()
+	encryptedData, err := utils.AesGcmEncrypt([]byte(data), []byte(header), dek.key)
+	if err != nil {
+		return "", err
+	}
+	encryptedObject, err := proto.Marshal(&kmstypes.EncryptedObject{
+		EncryptedData:          encryptedData,
+		KeyID:                  dek.keyId,
+		EncryptedDEKSource:     dek.encryptedDEK,
+		Annotations:            dek.annotations,
+		EncryptedDEKSourceType: kmstypes.EncryptedDEKSourceType_AES_GCM_KEY,
+	})
+	if err != nil {
+		return "", err
+	}
+	return header + base64.URLEncoding.EncodeToString(encryptedObject), nil
+}
+
+func (t *kmsTransformer) Decrypt(data string) (string, error) {
+	if !strings.HasPrefix(data, kmsMark) {
+		if t.keyring == nil && IsEncrypted(data) {
+			return "", errors.New("data is encrypted without KMS, but no data encryption key is provided")
+		}
+		return t.keyring.Decrypt(data)
+	}
+	encryptedObject, err := t.decodeEncryptedObject(data)
+	if err != nil {
+		return "", err
+	}
+	key, err := t.decryptDEK(encryptedObject)
+	if err != nil {
+		return "", err
+	}
+	decryptedData, err := utils.AesGcmDecrypt(encryptedObject.EncryptedData, []byte(t.header()), key)
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt data with KMS key %s: %v", encryptedObject.KeyID, err)
+	}
+	return string(decryptedData), nil
+}
+
+func (t *kmsTransformer) decodeEncryptedObject(data string) (*kmstypes.EncryptedObject, error) {
+	name, payload, ok := strings.Cut(strings.TrimPrefix(data, kmsMark), envelopeSeparator)
+	if !ok {
+		return nil, errors.New("malformed encrypted data")
+	}
+	if name != t.name {
+		return nil, fmt.Errorf("data is encrypted by KMS plugin %s instead of %s", name, t.name)
+	}
+	rawObject, err := base64.URLEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, err
+	}
+	encryptedObject := &kmstypes.EncryptedObject{}
+	if err := proto.Unmarshal(rawObject, encryptedObject); err != nil {
+		return nil, fmt.Errorf("malformed encrypted data: %v", err)
+	}
+	if encryptedObject.KeyID == "" || len(encryptedObject.EncryptedDEKSource) == 0 ||
+		encryptedObject.EncryptedDEKSourceType != kmstypes.EncryptedDEKSourceType_AES_GCM_KEY {
+		return nil, errors.New("malformed encrypted data: missing key ID or DEK")
+	}
+	return encryptedObject, nil
+}
+
+func (t *kmsTransformer) decryptDEK(encryptedObject *kmstypes.EncryptedObject) ([]byte, error) {
+	if key, ok := t.dekCache.Get(string(encryptedObject.EncryptedDEKSource)); ok {
+		return key.([]byte), nil
+	}
+	key, err := t.service.Decrypt(context.Background(), string(uuid.NewUUID()), &kmsservice.DecryptRequest{
+		Ciphertext:  encryptedObject.EncryptedDEKSource,
+		KeyID:       encryptedObject.KeyID,
+		Annotations: encryptedObject.Annotations,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt DEK with KMS plugin %s: %v", t.name, err)
+	}
+	if len(key) != kmsDEKSize {
+		return nil, fmt.Errorf("invalid DEK length returned by KMS plugin %s: %d", t.name, len(key))
+	}
+	t.dekCache.Add(string(encryptedObject.EncryptedDEKSource), key, kmsDEKCacheTTL)
+	return key, nil
+}
+
+func (t *kmsTransformer) IsStale(data string) bool {
+	if !strings.HasPrefix(data, kmsMark) {
+		return true
+	}
+	encryptedObject, err := t.decodeEncryptedObject(data)
+	return err != nil || encryptedObject.KeyID != t.NewestKeyId()
+}
+
+func (t *kmsTransformer) NewestKeyId() string {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.keyId
+}
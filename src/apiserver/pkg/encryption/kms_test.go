@@ -0,0 +1,292 @@
+package encryption
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/cache"
+	kmsservice "k8s.io/kms/pkg/service"
+)
+
+// fakeKMS wraps DEKs with a keyring like the local KMS plugin, counting the calls and failing them on demand.
+type fakeKMS struct {
+	localKMS
+	mutex    sync.Mutex
+	err      error
+	encrypts int
+	decrypts int
+	// blocked holds the encryptions back until it's closed, unless it's nil.
+	blocked chan struct{}
+}
+
+func (f *fakeKMS) failure() error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.err
+}
+
+func (f *fakeKMS) Encrypt(ctx context.Context, uid string, data []byte) (*kmsservice.EncryptResponse, error) {
+	f.mutex.Lock()
+	f.encrypts++
+	blocked := f.blocked
+	f.mutex.Unlock()
+	if blocked != nil {
+		<-blocked
+	}
+	if err := f.failure(); err != nil {
+		return nil, err
+	}
+	return f.localKMS.Encrypt(ctx, uid, data)
+}
+
+func (f *fakeKMS) Decrypt(ctx context.Context, uid string, req *kmsservice.DecryptRequest) ([]byte, error) {
+	f.mutex.Lock()
+	f.decrypts++
+	f.mutex.Unlock()
+	if err := f.failure(); err != nil {
+		return nil, err
+	}
+	return f.localKMS.Decrypt(ctx, uid, req)
+}
+
+func (f *fakeKMS) Status(ctx context.Context) (*kmsservice.StatusResponse, error) {
+	if err := f.failure(); err != nil {
+		return nil, err
+	}
+	return f.localKMS.Status(ctx)
+}
+
+func newTestKMSTransformer(t *testing.T, service kmsservice.Service, keyring *Keyring) *kmsTransformer {
+	t.Helper()
+	transformer := &kmsTransformer{
+		name:     "test",
+		service:  service,
+		keyring:  keyring,
+		dekCache: cache.NewLRUExpireCache(kmsDEKCacheSize),
+	}
+	if err := transformer.checkStatus(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return transformer
+}
+
+func TestKMSPlugin(t *testing.T) {
+	keyDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(keyDir, "kek-1"), []byte(strings.Repeat("k", 32)), 0600); err != nil {
+		t.Fatal(err)
+	}
+	// Unix socket paths are short, so the socket isn't put in the test dir.
+	socketDir, err := os.MkdirTemp("", "kms")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = os.RemoveAll(socketDir)
+	})
+	endpoint := "unix://" + filepath.Join(socketDir, "kms.sock")
+	stopCh := make(chan struct{})
+	served := make(chan error, 1)
+	go func() {
+		served <- ServeLocalKMS(endpoint, keyDir, time.Second, stopCh)
+	}()
+	t.Cleanup(func() {
+		close(stopCh)
+		<-served
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	var transformer Transformer
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		if transformer, err = NewKMSTransformer(ctx, endpoint, "local", time.Second, nil); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("failed to connect to the local KMS plugin: %v", err)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if transformer.NewestKeyId() != "kek-1" {
+		t.Fatalf("KMS key is %q, want the one of the plugin", transformer.NewestKeyId())
+	}
+	encrypted, err := transformer.Encrypt("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(encrypted, "kms2|local|") || !IsEncrypted(encrypted) || strings.Contains(encrypted, "secret") {
+		t.Fatalf("encrypted data = %q, want an envelope of the plugin", encrypted)
+	}
+	if decrypted, err := transformer.Decrypt(encrypted); err != nil || decrypted != "secret" {
+		t.Fatalf("Decrypt() = %q, %v", decrypted, err)
+	}
+}
+
+func TestKMSDEKCache(t *testing.T) {
+	service := &fakeKMS{localKMS: localKMS{keyring: newTestKeyring(t, "kek-1")}}
+	transformer := newTestKMSTransformer(t, service, nil)
+	var encrypted []string
+	for i := 0; i < 3; i++ {
+		data, err := transformer.Encrypt("secret")
+		if err != nil {
+			t.Fatal(err)
+		}
+		encrypted = append(encrypted, data)
+	}
+	if service.encrypts != 1 {
+		t.Fatalf("plugin is called %d times to encrypt with the same DEK", service.encrypts)
+	}
+
+	// Another instance asks the plugin for the DEK once, and caches it.
+	other := newTestKMSTransformer(t, service, nil)
+	for _, data := range encrypted {
+		if decrypted, err := other.Decrypt(data); err != nil || decrypted != "secret" {
+			t.Fatalf("Decrypt() = %q, %v", decrypted, err)
+		}
+	}
+	if service.decrypts != 1 {
+		t.Fatalf("plugin is called %d times to decrypt the same DEK", service.decrypts)
+	}
+}
+
+func TestKMSSlowDEKEncryption(t *testing.T) {
+	blocked := make(chan struct{})
+	service := &fakeKMS{localKMS: localKMS{keyring: newTestKeyring(t, "kek-1")}, blocked: blocked}
+	transformer := newTestKMSTransformer(t, service, nil)
+	results := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			_, err := transformer.Encrypt("secret")
+			results <- err
+		}()
+	}
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		service.mutex.Lock()
+		encrypts := service.encrypts
+		service.mutex.Unlock()
+		if encrypts > 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the DEK to be encrypted")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// The key is still told while the plugin is wrapping the DEK.
+	told := make(chan string, 1)
+	go func() {
+		told <- transformer.NewestKeyId()
+	}()
+	select {
+	case keyId := <-told:
+		if keyId != "kek-1" {
+			t.Fatalf("NewestKeyId() = %q", keyId)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("NewestKeyId() is blocked by the encryption of a DEK")
+	}
+
+	close(blocked)
+	for i := 0; i < 2; i++ {
+		if err := <-results; err != nil {
+			t.Fatal(err)
+		}
+	}
+	if service.encrypts != 1 {
+		t.Fatalf("plugin is called %d times to encrypt the DEK needed by concurrent encryptions", service.encrypts)
+	}
+}
+
+func TestKMSFailsClosed(t *testing.T) {
+	service := &fakeKMS{localKMS: localKMS{keyring: newTestKeyring(t, "kek-1")}}
+	transformer := newTestKMSTransformer(t, service, nil)
+	encrypted, err := transformer.Encrypt("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	service.mutex.Lock()
+	service.err = errors.New("connection refused")
+	service.mutex.Unlock()
+	if err := transformer.checkStatus(context.Background()); err == nil {
+		t.Fatal("unreachable plugin is healthy")
+	}
+	if data, err := transformer.Encrypt("secret"); err == nil {
+		t.Fatalf("data is encrypted to %q while the plugin is unreachable", data)
+	}
+	// The DEKs cached are still usable to decrypt.
+	if decrypted, err := transformer.Decrypt(encrypted); err != nil || decrypted != "secret" {
+		t.Fatalf("Decrypt() with a cached DEK = %q, %v", decrypted, err)
+	}
+	other := &kmsTransformer{name: "test", service: service, dekCache: cache.NewLRUExpireCache(kmsDEKCacheSize)}
+	if _, err := other.Decrypt(encrypted); err == nil {
+		t.Fatal("data is decrypted without the plugin")
+	}
+
+	service.mutex.Lock()
+	service.err = nil
+	service.mutex.Unlock()
+	if err := transformer.checkStatus(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := transformer.Encrypt("secret"); err != nil {
+		t.Fatalf("Encrypt() once the plugin is back = %v", err)
+	}
+}
+
+func TestKMSKeyRotation(t *testing.T) {
+	keyring := newTestKeyring(t, "key-1")
+	legacy, err := keyring.Encrypt("legacy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	service := &fakeKMS{localKMS: localKMS{keyring: newTestKeyring(t, "kek-1")}}
+	transformer := newTestKMSTransformer(t, service, keyring)
+	encrypted, err := transformer.Encrypt("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if transformer.IsStale(encrypted) || !transformer.IsStale(legacy) {
+		t.Fatal("only the data encrypted before the plugin is in use should be stale")
+	}
+	// The data encrypted with the keyring before is still decrypted.
+	if decrypted, err := transformer.Decrypt(legacy); err != nil || decrypted != "legacy" {
+		t.Fatalf("Decrypt() of keyring data = %q, %v", decrypted, err)
+	}
+
+	// The plugin rotates its key, and a new DEK is wrapped with it.
+	service.keyring = newTestKeyring(t, "kek-1", "kek-2")
+	if err := transformer.checkStatus(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if transformer.NewestKeyId() != "kek-2" || !transformer.IsStale(encrypted) {
+		t.Fatalf("data encrypted with the old KMS key isn't stale after rotating to %q", transformer.NewestKeyId())
+	}
+	reencrypted, err := transformer.Encrypt("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if transformer.IsStale(reencrypted) || service.encrypts != 2 {
+		t.Fatalf("data isn't re-encrypted with a new DEK, after %d DEKs wrapped", service.encrypts)
+	}
+	if decrypted, err := transformer.Decrypt(encrypted); err != nil || decrypted != "secret" {
+		t.Fatalf("Decrypt() with the old KMS key = %q, %v", decrypted, err)
+	}
+
+	// Data encrypted with a keyring can't be decrypted without it.
+	withoutKeyring := newTestKMSTransformer(t, service, nil)
+	if _, err := withoutKeyring.Decrypt(legacy); err == nil {
+		t.Fatal("keyring data is decrypted without the keyring")
+	}
+	if _, err := withoutKeyring.Decrypt(strings.Replace(encrypted, "kms2|test|", "kms2|other|", 1)); err == nil {
+		t.Fatal("data of another plugin is decrypted")
+	}
+}
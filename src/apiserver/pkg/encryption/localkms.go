@@ -0,0 +1,83 @@
+package encryption
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"time"
+
+	"k8s.io/klog/v2"
+	kmsservice "k8s.io/kms/pkg/service"
+	"k8s.io/kms/pkg/util"
+)
+
+const localKMSVersion = "v2"
+
+// localKMS is a KMS plugin wrapping DEKs with the AES keys in a local key directory. It's meant for testing only,
+// as the keys are exposed to the API server host like static encryption keys.
+type localKMS struct {
+	keyring *Keyring
+}
+
+var _ kmsservice.Service = &localKMS{}
+
+// ServeLocalKMS serves a KMS plugin backed by the keys in keyDir on endpoint, e.g. unix:///var/run/kms.sock,
+// until stopCh is closed. The keys are loaded as by LoadKeyring, and the newest one is used to wrap DEKs.
+func ServeLocalKMS(endpoint, keyDir string, timeout time.Duration, stopCh <-chan struct{}) error {
+	keyring, err := LoadKeyring(keyDir, "")
+	if err != nil {
+		return err
+	}
+	if keyring == nil {
+		return errors.New("no key directory is given")
+	}
+	addr, err := util.ParseEndpoint(endpoint)
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(addr, "@") {
+		// Remove the socket file left by a previous run.
+		if err := os.Remove(addr); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+
+	server := kmsservice.NewGRPCService(addr, timeout, &localKMS{keyring: keyring})
+	go func() {
+		<-stopCh
+		server.Shutdown()
+	}()
+	klog.Infof("Serving local KMS plugin on %s with keys %v, encrypting with %s", endpoint, keyring.KeyIds(), keyring.NewestKeyId())
+	return server.ListenAndServe()
+}
+
+func (l *localKMS) Decrypt(ctx context.Context, uid string, req *kmsservice.DecryptRequest) ([]byte, error) {
+	if !strings.HasPrefix(string(req.Ciphertext), envelopeMark+req.KeyID+envelopeSeparator) {
+		return nil, errors.New("ciphertext is not encrypted with the given key")
+	}
+	data, err := l.keyring.Decrypt(string(req.Ciphertext))
+	if err != nil {
+		return nil, err
+	}
+	return []byte(data), nil
+}
+
+func (l *localKMS) Encrypt(ctx context.Context, uid string, data []byte) (*kmsservice.EncryptResponse, error) {
+	ciphertext, err := l.keyring.Encrypt(string(data))
+	if err != nil {
+		return nil, err
+	}
+	return &kmsservice.EncryptResponse{
+		Ciphertext: []byte(ciphertext),
+		KeyID:      l.keyring.NewestKeyId(),
+	}, nil
+}
+
+func (l *localKMS) Status(ctx context.Context) (*kmsservice.StatusResponse, error) {
+	return &kmsservice.StatusResponse{
+		Version: localKMSVersion,
+		Healthz: kmsStatusHealthy,
+		KeyID:   l.keyring.NewestKeyId(),
+	}, nil
+}
@@ -0,0 +1,135 @@
+package encryption
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// Policy decides which objects of a resource are encrypted at rest, and encrypts them with its transformer, either
+// as a whole or field by field. Data encrypted before is always decrypted, even if it's no longer selected to be
+// encrypted, or selected to be encrypted in another way.
+//
+// A nil Policy means encryption isn't configured at all, so it doesn't encrypt data, and fails to decrypt encrypted data.
+type Policy struct {
+	transformer Transformer
+	// rule selects the objects and fields to encrypt, which is nil if the resource isn't encrypted.
+	rule *ResourceRule
+}
+
+// NewPolicy returns a policy encrypting the objects selected by rule with transformer. Nothing is encrypted if rule is nil.
+func NewPolicy(transformer Transformer, rule *ResourceRule) *Policy {
+	return &Policy{transformer: transformer, rule: rule}
+}
+
+// Encrypts tells whether any object of the resource may be encrypted.
+func (p *Policy) Encrypts() bool {
+	return p != nil && p.rule != nil
+}
+
+func (p *Policy) selects(obj runtime.Object) bool {
+	if p == nil || p.rule == nil {
+		return false
+	}
+	if p.rule.Selector == nil || p.rule.Selector.Empty() {
+		return true
+	}
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return false
+	}
+	return p.rule.Selector.Matches(labels.Set(accessor.GetLabels()))
+}
+
+// Encrypt encrypts data, which is obj encoded, if obj is selected to be encrypted.
+// Only the fields it encrypts are decrypted by Decrypt.
+func (p *Policy) Encrypt(obj runtime.Object, data string) (string, error) {
+	if !p.selects(obj) {
+		return stripEncryptedFields(data)
+	}
+	if len(p.rule.Fields) != 0 {
+		return encryptFields(p.transformer, data, p.rule.Fields)
+	}
+	return p.transformer.Encrypt(data)
+}
+
+// Decrypt decrypts data if it's encrypted as a whole or field by field, no matter whether the object is selected
+// to be encrypted.
+func (p *Policy) Decrypt(data string) (string, error) {
+	if p == nil {
+		if IsEncrypted(data) {
+			return "", errors.New("data is encrypted, but no data encryption key is provided")
+		}
+		return decryptFields(nil, data)
+	}
+	if IsEncrypted(data) {
+		return p.transformer.Decrypt(data)
+	}
+	return decryptFields(p.transformer, data)
+}
+
+// IsStale tells whether data, which is obj encoded, should be rewritten, as it isn't encrypted as the policy requires,
+// e.g. not with the newest key, not in the fields selected, or not at all, or it's encrypted while obj isn't selected.
+func (p *Policy) IsStale(obj runtime.Object, data string) bool {
+	if p == nil {
+		return false
+	}
+	if !p.selects(obj) {
+		return IsEncrypted(data) || mayHaveEncryptedFields(data) && fieldsStale(p.transformer, data, nil)
+	}
+	if len(p.rule.Fields) != 0 {
+		return IsEncrypted(data) || fieldsStale(p.transformer, data, p.rule.Fields)
+	}
+	return p.transformer.IsStale(data)
+}
+
+// NewestKeyId returns the ID of the key used to encrypt data.
+func (p *Policy) NewestKeyId() string {
+	if p == nil {
+		return ""
+	}
+	return p.transformer.NewestKeyId()
+}
+
+// ResourceRule names a resource to encrypt, and optionally selects the objects to encrypt by their labels,
+// and the fields to encrypt instead of the whole objects.
+type ResourceRule struct {
+	GroupResource schema.GroupResource
+	Selector      labels.Selector
+	Fields        []*FieldPath
+}
+
+// ParseResourceRule parses a rule in the form of "<resource>[.<group>][:<label selector>]",
+// e.g. "secrets" or "configmaps:higress.io/sensitive=true".
+func ParseResourceRule(rule string) (*ResourceRule, error) {
+	resource, selector, hasSelector := strings.Cut(rule, ":")
+	groupResource, err := parseGroupResource(resource)
+	if err != nil {
+		return nil, fmt.Errorf("invalid encryption resource rule %q: %v", rule, err)
+	}
+	r := &ResourceRule{
+		GroupResource: groupResource,
+		Selector:      labels.Everything(),
+	}
+	if hasSelector {
+		parsedSelector, err := labels.Parse(selector)
+		if err != nil {
+			return nil, fmt.Errorf("invalid encryption resource rule %q: %v", rule, err)
+		}
+		r.Selector = parsedSelector
+	}
+	return r, nil
+}
+
+func parseGroupResource(resource string) (schema.GroupResource, error) {
+	resource = strings.ToLower(strings.TrimSpace(resource))
+	if resource == "" || strings.ContainsAny(resource, ": \t") {
+		return schema.GroupResource{}, fmt.Errorf("invalid resource %q", resource)
+	}
+	return schema.ParseGroupResource(resource), nil
+}
@@ -0,0 +1,20 @@
+package encryption
+
+import "strings"
+
+// Transformer encrypts sensitive data stored at rest, and decrypts it back.
+type Transformer interface {
+	// Encrypt encrypts data with the newest key.
+	Encrypt(data string) (string, error)
+	// Decrypt decrypts data encrypted with any known key. Data not encrypted is returned as it is.
+	Decrypt(data string) (string, error)
+	// IsStale tells whether data should be re-encrypted, as it isn't encrypted with the newest key.
+	IsStale(data string) bool
+	// NewestKeyId returns the ID of the key used to encrypt data.
+	NewestKeyId() string
+}
+
+// IsEncrypted tells whether data is encrypted in any of the supported formats.
+func IsEncrypted(data string) bool {
+	return strings.HasPrefix(data, envelopeMark) || strings.HasPrefix(data, legacyMark) || strings.HasPrefix(data, kmsMark)
+}
@@ -0,0 +1,198 @@
+package openapi
+
+import (
+	"embed"
+	"fmt"
+	"io/fs"
+	"reflect"
+	"strings"
+	"sync"
+
+	hiextensionsv1alpha1 "github.com/alibaba/higress/v2/client/pkg/apis/extensions/v1alpha1"
+	hinetworkingv1 "github.com/alibaba/higress/v2/client/pkg/apis/networking/v1"
+	istiov1alpha3 "istio.io/client-go/pkg/apis/networking/v1alpha3"
+	apiextensionsinternal "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	structuralschema "k8s.io/apiextensions-apiserver/pkg/apiserver/schema"
+	openapiv2 "k8s.io/apiextensions-apiserver/pkg/controller/openapi/v2"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilopenapi "k8s.io/apiserver/pkg/util/openapi"
+	"k8s.io/klog/v2"
+	"k8s.io/kube-openapi/pkg/common"
+	"k8s.io/kube-openapi/pkg/validation/spec"
+	gwapiv1alpha2 "sigs.k8s.io/gateway-api/apis/v1alpha2"
+	gwapiv1beta1 "sigs.k8s.io/gateway-api/apis/v1beta1"
+	"sigs.k8s.io/yaml"
+
+	gwapiv1 "github.com/alibaba/higress/api-server/pkg/apis/gatewayapi/v1"
+	generatedopenapi "github.com/alibaba/higress/api-server/pkg/generated/openapi"
+	"github.com/alibaba/higress/api-server/pkg/storage"
+)
+
+const (
+	typeMetaType   = "k8s.io/apimachinery/pkg/apis/meta/v1.TypeMeta"
+	objectMetaType = "k8s.io/apimachinery/pkg/apis/meta/v1.ObjectMeta"
+	listMetaType   = "k8s.io/apimachinery/pkg/apis/meta/v1.ListMeta"
+)
+
+var (
+	// Definitions of the Higress custom resources, which aren't listed by the customresourcedefinitions storage.
+	//go:embed crds
+	crds embed.FS
+
+	// customResourceTypes are the Go types of the custom resources, whose Go types can't be walked by openapi-gen
+	// as they're generated from protobuf, so their definitions are converted from the schemas in their CRDs instead.
+	customResourceTypes = []customResourceType{
+		{"wasmplugins.extensions.higress.io", "v1alpha1", &hiextensionsv1alpha1.WasmPlugin{}, &hiextensionsv1alpha1.WasmPluginList{}},
+		{"mcpbridges.networking.higress.io", "v1", &hinetworkingv1.McpBridge{}, &hinetworkingv1.McpBridgeList{}},
+		{"http2rpcs.networking.higress.io", "v1", &hinetworkingv1.Http2Rpc{}, &hinetworkingv1.Http2RpcList{}},
+		{"envoyfilters.networking.istio.io", "v1alpha3", &istiov1alpha3.EnvoyFilter{}, &istiov1alpha3.EnvoyFilterList{}},
+
+		{"gatewayclasses.gateway.networking.k8s.io", "v1beta1", &gwapiv1beta1.GatewayClass{}, &gwapiv1beta1.GatewayClassList{}},
+		{"gateways.gateway.networking.k8s.io", "v1beta1", &gwapiv1beta1.Gateway{}, &gwapiv1beta1.GatewayList{}},
+		{"httproutes.gateway.networking.k8s.io", "v1beta1", &gwapiv1beta1.HTTPRoute{}, &gwapiv1beta1.HTTPRouteList{}},
+		{"referencegrants.gateway.networking.k8s.io", "v1beta1", &gwapiv1beta1.ReferenceGrant{}, &gwapiv1beta1.ReferenceGrantList{}},
+		{"gatewayclasses.gateway.networking.k8s.io", "v1alpha2", &gwapiv1alpha2.GatewayClass{}, &gwapiv1alpha2.GatewayClassList{}},
+		{"gateways.gateway.networking.k8s.io", "v1alpha2", &gwapiv1alpha2.Gateway{}, &gwapiv1alpha2.GatewayList{}},
+		{"httproutes.gateway.networking.k8s.io", "v1alpha2", &gwapiv1alpha2.HTTPRoute{}, &gwapiv1alpha2.HTTPRouteList{}},
+		{"referencegrants.gateway.networking.k8s.io", "v1alpha2", &gwapiv1alpha2.ReferenceGrant{}, &gwapiv1alpha2.ReferenceGrantList{}},
+		// The v1 types are the same as the v1beta1 ones, which the CRDs don't have a version of.
+		{"gatewayclasses.gateway.networking.k8s.io", "v1beta1", &gwapiv1.GatewayClass{}, &gwapiv1.GatewayClassList{}},
+		{"gateways.gateway.networking.k8s.io", "v1beta1", &gwapiv1.Gateway{}, &gwapiv1.GatewayList{}},
+		{"httproutes.gateway.networking.k8s.io", "v1beta1", &gwapiv1.HTTPRoute{}, &gwapiv1.HTTPRouteList{}},
+		{"referencegrants.gateway.networking.k8s.io", "v1beta1", &gwapiv1.ReferenceGrant{}, &gwapiv1.ReferenceGrantList{}},
+	}
+
+	loadCustomResourceDefinitions = sync.OnceValues(func() (map[string]*apiextensionsv1.CustomResourceDefinition, error) {
+		customResourceDefinitions := map[string]*apiextensionsv1.CustomResourceDefinition{}
+		higressFiles, _ := fs.Sub(crds, "crds")
+		for _, files := range []fs.FS{storage.CustomResourceDefinitionFiles(), higressFiles} {
+			if err := readCustomResourceDefinitions(files, customResourceDefinitions); err != nil {
+				return nil, err
+			}
+		}
+		return customResourceDefinitions, nil
+	})
+)
+
+type customResourceType struct {
+	crd     string
+	version string
+	object  runtime.Object
+	list    runtime.Object
+}
+
+// GetOpenAPIDefinitions returns the OpenAPI v3 definitions of all the types served. The ones of the built-in types
+// are generated by openapi-gen, and the ones of the custom resources are converted from the schemas in their CRDs.
+func GetOpenAPIDefinitions(ref common.ReferenceCallback) map[string]common.OpenAPIDefinition {
+	return getOpenAPIDefinitions(ref, false)
+}
+
+// GetOpenAPIV2Definitions returns the OpenAPI v2 definitions of all the types served, where the schemas of the custom
+// resources are stripped of the features OpenAPI v2 doesn't support, in the same way as Kubernetes does.
+func GetOpenAPIV2Definitions(ref common.ReferenceCallback) map[string]common.OpenAPIDefinition {
+	return getOpenAPIDefinitions(ref, true)
+}
+
+func getOpenAPIDefinitions(ref common.ReferenceCallback, v2 bool) map[string]common.OpenAPIDefinition {
+	definitions := utilopenapi.GetOpenAPIDefinitionsWithoutDisabledFeatures(generatedopenapi.GetOpenAPIDefinitions)(ref)
+
+	customResourceDefinitions, err := loadCustomResourceDefinitions()
+	if err != nil {
+		// The embedded CRDs are broken, which is a bug.
+		panic(err)
+	}
+	typeMeta := definitions[typeMetaType].Schema
+	for _, t := range customResourceTypes {
+		crd := customResourceDefinitions[t.crd]
+		if crd == nil {
+			panic(fmt.Sprintf("no custom resource definition of %s", t.crd))
+		}
+		definitions[typeName(t.object)] = common.OpenAPIDefinition{
+			Schema:       *buildObjectSchema(crd, t.version, typeMeta, ref, v2),
+			Dependencies: []string{objectMetaType},
+		}
+		definitions[typeName(t.list)] = common.OpenAPIDefinition{
+			Schema:       *buildListSchema(crd, typeName(t.object), typeMeta, ref),
+			Dependencies: []string{typeName(t.object), listMetaType},
+		}
+	}
+	return definitions
+}
+
+// buildObjectSchema converts the schema of a version of a CRD to the one of its kind, like the CRD handler
+// of Kubernetes does.
+func buildObjectSchema(crd *apiextensionsv1.CustomResourceDefinition, version string, typeMeta spec.Schema, ref common.ReferenceCallback, v2 bool) *spec.Schema {
+	var structural *structuralschema.Structural
+	for _, v := range crd.Spec.Versions {
+		if v.Name != version || v.Schema == nil || v.Schema.OpenAPIV3Schema == nil {
+			continue
+		}
+		internal := &apiextensionsinternal.JSONSchemaProps{}
+		if err := apiextensionsv1.Convert_v1_JSONSchemaProps_To_apiextensions_JSONSchemaProps(v.Schema.OpenAPIV3Schema, internal, nil); err != nil {
+			klog.Warningf("failed to convert the schema of %s/%s: %v", crd.Name, version, err)
+			break
+		}
+		var err error
+		if structural, err = structuralschema.NewStructural(internal); err != nil {
+			klog.Warningf("the schema of %s/%s isn't structural: %v", crd.Name, version, err)
+		}
+		break
+	}
+
+	// The schema is left empty if it's unknown, as kubectl would reject any field not listed in its properties.
+	if structural == nil || v2 && (structural.XPreserveUnknownFields || crd.Spec.PreserveUnknownFields) {
+		return &spec.Schema{SchemaProps: spec.SchemaProps{Type: []string{"object"}}}
+	}
+	if v2 {
+		structural = openapiv2.ToStructuralOpenAPIV2(structural)
+	}
+	schema := structural.ToKubeOpenAPI()
+	schema.SetProperty("apiVersion", typeMeta.Properties["apiVersion"])
+	schema.SetProperty("kind", typeMeta.Properties["kind"])
+	schema.SetProperty("metadata", *refSchema(ref, objectMetaType).WithDescription(
+		"Standard object's metadata. More info: https://git.k8s.io/community/contributors/devel/sig-architecture/api-conventions.md#metadata"))
+	return schema
+}
+
+func buildListSchema(crd *apiextensionsv1.CustomResourceDefinition, itemType string, typeMeta spec.Schema, ref common.ReferenceCallback) *spec.Schema {
+	schema := new(spec.Schema).
+		Typed("object", "").
+		WithDescription(fmt.Sprintf("%s is a list of %s", crd.Spec.Names.ListKind, crd.Spec.Names.Kind)).
+		WithRequired("items").
+		SetProperty("items", *spec.ArrayProperty(refSchema(ref, itemType)).WithDescription(
+			fmt.Sprintf("List of %s. More info: https://git.k8s.io/community/contributors/devel/sig-architecture/api-conventions.md", crd.Spec.Names.Plural))).
+		SetProperty("metadata", *refSchema(ref, listMetaType).WithDescription(
+			"Standard list metadata. More info: https://git.k8s.io/community/contributors/devel/sig-architecture/api-conventions.md#types-kinds"))
+	schema.SetProperty("apiVersion", typeMeta.Properties["apiVersion"])
+	schema.SetProperty("kind", typeMeta.Properties["kind"])
+	return schema
+}
+
+func refSchema(ref common.ReferenceCallback, name string) *spec.Schema {
+	return &spec.Schema{SchemaProps: spec.SchemaProps{Ref: ref(name)}}
+}
+
+// typeName returns the name of the Go type of obj, which the definitions are keyed by.
+func typeName(obj runtime.Object) string {
+	t := reflect.TypeOf(obj).Elem()
+	return t.PkgPath() + "." + t.Name()
+}
+
+func readCustomResourceDefinitions(files fs.FS, customResourceDefinitions map[string]*apiextensionsv1.CustomResourceDefinition) error {
+	return fs.WalkDir(files, ".", func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() || !strings.HasSuffix(path, ".yaml") {
+			return err
+		}
+		content, err := fs.ReadFile(files, path)
+		if err != nil {
+			return err
+		}
+		crd := &apiextensionsv1.CustomResourceDefinition{}
+		if err := yaml.Unmarshal(content, crd); err != nil {
+			return fmt.Errorf("invalid custom resource definition %s: %v", path, err)
+		}
+		customResourceDefinitions[crd.Name] = crd
+		return nil
+	})
+}
@@ -0,0 +1,144 @@
+package openapi
+
+import (
+	"strings"
+	"testing"
+
+	hiextensionsv1alpha1 "github.com/alibaba/higress/v2/client/pkg/apis/extensions/v1alpha1"
+	hinetworkingv1 "github.com/alibaba/higress/v2/client/pkg/apis/networking/v1"
+	istiov1alpha3 "istio.io/client-go/pkg/apis/networking/v1alpha3"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	endpointsopenapi "k8s.io/apiserver/pkg/endpoints/openapi"
+	genericapiserver "k8s.io/apiserver/pkg/server"
+	"k8s.io/kube-openapi/pkg/builder"
+	"k8s.io/kube-openapi/pkg/builder3"
+	"k8s.io/kube-openapi/pkg/common"
+	"k8s.io/kube-openapi/pkg/validation/spec"
+
+	gwapiv1 "github.com/alibaba/higress/api-server/pkg/apis/gatewayapi/v1"
+	"github.com/alibaba/higress/api-server/pkg/apiserver"
+)
+
+func testRef(name string) spec.Ref {
+	return spec.MustCreateRef("#/definitions/" + name)
+}
+
+// property returns the schema of the property at the given dot-separated path of schema, or nil if there isn't any.
+func property(schema spec.Schema, path string) *spec.Schema {
+	for _, name := range strings.Split(path, ".") {
+		p, ok := schema.Properties[name]
+		if !ok {
+			return nil
+		}
+		schema = p
+	}
+	return &schema
+}
+
+func TestCustomResourceDefinitions(t *testing.T) {
+	definitions := GetOpenAPIDefinitions(testRef)
+	wasmPlugin := definitions[typeName(&hiextensionsv1alpha1.WasmPlugin{})].Schema
+	if failStrategy := property(wasmPlugin, "spec.failStrategy"); failStrategy == nil || len(failStrategy.Enum) != 2 {
+		t.Fatalf("failStrategy of WasmPlugin = %+v, want the enum in the CRD", failStrategy)
+	}
+	if defaultConfig := property(wasmPlugin, "spec.defaultConfig"); defaultConfig == nil ||
+		defaultConfig.Extensions["x-kubernetes-preserve-unknown-fields"] != true {
+		t.Fatalf("defaultConfig of WasmPlugin = %+v, want any field preserved", defaultConfig)
+	}
+	if property(wasmPlugin, "apiVersion") == nil || property(wasmPlugin, "kind") == nil {
+		t.Fatal("WasmPlugin has no type meta")
+	}
+	if metadata := property(wasmPlugin, "metadata"); metadata == nil || metadata.Ref.String() != "#/definitions/"+objectMetaType {
+		t.Fatalf("metadata of WasmPlugin = %+v, want a reference to ObjectMeta", metadata)
+	}
+	mcpBridge := definitions[typeName(&hinetworkingv1.McpBridge{})].Schema
+	if registries := property(mcpBridge, "spec.registries"); registries == nil || registries.Items == nil {
+		t.Fatalf("registries of McpBridge = %+v, want an array", registries)
+	}
+	list := definitions[typeName(&hiextensionsv1alpha1.WasmPluginList{})].Schema
+	if items := property(list, "items"); items == nil || items.Items.Schema.Ref.String() != "#/definitions/"+typeName(&hiextensionsv1alpha1.WasmPlugin{}) {
+		t.Fatalf("items of WasmPluginList = %+v, want references to WasmPlugin", items)
+	}
+
+	// The gateway API v1 types share the schemas of v1beta1.
+	if property(definitions[typeName(&gwapiv1.HTTPRoute{})].Schema, "spec.rules") == nil {
+		t.Fatal("HTTPRoute v1 has no rules")
+	}
+}
+
+func TestOpenAPIV2Definitions(t *testing.T) {
+	// Nullable fields aren't supported by OpenAPI v2.
+	wasmPlugin := GetOpenAPIDefinitions(testRef)[typeName(&hiextensionsv1alpha1.WasmPlugin{})].Schema
+	if priority := property(wasmPlugin, "spec.priority"); priority == nil || !priority.Nullable {
+		t.Fatalf("priority of WasmPlugin = %+v in OpenAPI v3, want it nullable", priority)
+	}
+	definitions := GetOpenAPIV2Definitions(testRef)
+	wasmPlugin = definitions[typeName(&hiextensionsv1alpha1.WasmPlugin{})].Schema
+	if priority := property(wasmPlugin, "spec.priority"); priority == nil || priority.Nullable {
+		t.Fatalf("priority of WasmPlugin = %+v in OpenAPI v2, want it not nullable", priority)
+	}
+	if property(definitions[typeName(&hinetworkingv1.McpBridge{})].Schema, "spec.registries") == nil {
+		t.Fatal("McpBridge has no schema in OpenAPI v2")
+	}
+}
+
+// TestDefinitionReferences checks all the references in the definitions are defined.
+func TestDefinitionReferences(t *testing.T) {
+	for version, getDefinitions := range map[string]common.GetOpenAPIDefinitions{"v2": GetOpenAPIV2Definitions, "v3": GetOpenAPIDefinitions} {
+		refs := map[string]bool{}
+		definitions := getDefinitions(func(name string) spec.Ref {
+			refs[name] = true
+			return testRef(name)
+		})
+		for name := range refs {
+			// It's only referred by InternalEvent of meta/v1, which isn't served, as in Kubernetes.
+			if name == "k8s.io/apimachinery/pkg/runtime.Object" {
+				continue
+			}
+			if _, ok := definitions[name]; !ok {
+				t.Errorf("%s definition of %s is referred but undefined", version, name)
+			}
+		}
+	}
+}
+
+// TestBuildOpenAPISpecs checks the specs are built with the definitions of the served types, like /openapi/v2 and
+// /openapi/v3 are.
+func TestBuildOpenAPISpecs(t *testing.T) {
+	namer := endpointsopenapi.NewDefinitionNamer(apiserver.Scheme)
+	var names []string
+	for _, obj := range []runtime.Object{
+		&networkingv1.Ingress{},
+		&hiextensionsv1alpha1.WasmPlugin{},
+		&hinetworkingv1.McpBridgeList{},
+		&hinetworkingv1.Http2Rpc{},
+		&istiov1alpha3.EnvoyFilter{},
+		&gwapiv1.Gateway{},
+	} {
+		names = append(names, typeName(obj))
+	}
+
+	swagger, err := builder.BuildOpenAPIDefinitionsForResources(genericapiserver.DefaultOpenAPIConfig(GetOpenAPIV2Definitions, namer), names...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ingress, ok := swagger.Definitions["io.k8s.api.networking.v1.Ingress"]
+	if spec := property(ingress, "spec"); !ok || spec == nil || spec.Ref.String() != "#/definitions/io.k8s.api.networking.v1.IngressSpec" {
+		t.Fatalf("OpenAPI v2 definition of Ingress = %+v", ingress)
+	}
+	if gvks := ingress.Extensions["x-kubernetes-group-version-kind"]; gvks == nil {
+		t.Fatal("Ingress isn't tagged with its kind, which kubectl finds the definitions by")
+	}
+	if _, ok := swagger.Definitions["com.github.alibaba.higress.v2.client.pkg.apis.extensions.v1alpha1.WasmPlugin"]; !ok {
+		t.Fatalf("OpenAPI v2 definitions %v have no WasmPlugin", len(swagger.Definitions))
+	}
+
+	schemas, err := builder3.BuildOpenAPIDefinitionsForResources(genericapiserver.DefaultOpenAPIV3Config(GetOpenAPIDefinitions, namer), names...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if envoyFilter, ok := schemas["io.istio.client-go.pkg.apis.networking.v1alpha3.EnvoyFilter"]; !ok || property(*envoyFilter, "spec") == nil {
+		t.Fatalf("OpenAPI v3 definition of EnvoyFilter = %+v", envoyFilter)
+	}
+}
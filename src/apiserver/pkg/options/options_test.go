@@ -0,0 +1,268 @@
+package options
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"k8s.io/apiserver/pkg/authentication/authenticator"
+	"k8s.io/apiserver/pkg/authentication/user"
+	genericapiserver "k8s.io/apiserver/pkg/server"
+	"k8s.io/client-go/util/cert"
+)
+
+// writeTestCertKey writes a self-signed certificate and its key into the given dir, and returns their paths.
+func writeTestCertKey(t *testing.T, dir string) (string, string) {
+	t.Helper()
+	certPEM, keyPEM, err := cert.GenerateSelfSignedCertKey("nacos.example.com", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+	if err := os.WriteFile(certFile, certPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, keyPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+func TestGetIntFromEnvWithAlias(t *testing.T) {
+	tests := []struct {
+		name       string
+		value      string
+		deprecated string
+		want       int
+	}{
+		{name: "unset", want: 300},
+		{name: "set", value: "60", want: 60},
+		{name: "deprecated", deprecated: "30", want: 30},
+		{name: "both", value: "60", deprecated: "30", want: 60},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("TEST_INTERVAL_SECS", tt.value)
+			t.Setenv("TEST_DEPRECATED_INTERVAL_SECS", tt.deprecated)
+			if got := getIntFromEnvWithAlias("TEST_INTERVAL_SECS", "TEST_DEPRECATED_INTERVAL_SECS", 300); got != tt.want {
+				t.Fatalf("got %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNacosOptionsValidate(t *testing.T) {
+	certFile, keyFile := writeTestCertKey(t, t.TempDir())
+	tests := []struct {
+		name    string
+		options NacosOptions
+		// err is a part of the only error expected, or empty if the options are valid.
+		err string
+	}{
+		{name: "http", options: NacosOptions{ServerHttpUrls: []string{"http://localhost:8848/nacos"}}},
+		{name: "https with CA", options: NacosOptions{ServerHttpUrls: []string{"https://nacos.example.com/nacos"}, TLSCAFile: certFile}},
+		{name: "https insecure", options: NacosOptions{ServerHttpUrls: []string{"https://nacos.example.com/nacos"}, TLSInsecure: true}},
+		{name: "mutual TLS", options: NacosOptions{ServerHttpUrls: []string{"https://nacos.example.com/nacos"},
+			TLSCAFile: certFile, TLSCertFile: certFile, TLSKeyFile: keyFile, TLSServerName: "nacos"}},
+		{name: "access key", options: NacosOptions{ServerHttpUrls: []string{"http://localhost:8848/nacos"}, AccessKey: "ak", SecretKey: "sk"}},
+		{name: "RAM role", options: NacosOptions{ServerHttpUrls: []string{"http://localhost:8848/nacos"}, RamRoleName: "role", SignatureRegionId: "cn-hangzhou"}},
+		{name: "no server", options: NacosOptions{}, err: "--nacos-server must be set"},
+		{name: "unknown scheme", options: NacosOptions{ServerHttpUrls: []string{"grpc://localhost:9848"}}, err: "only HTTP and HTTPS"},
+		{name: "mixed schemes", options: NacosOptions{ServerHttpUrls: []string{"https://a/nacos", "http://b/nacos"}, TLSInsecure: true}, err: "can't be mixed"},
+		{name: "invalid port", options: NacosOptions{ServerHttpUrls: []string{"http://localhost:70000/nacos"}}, err: "invalid port number"},
+		{name: "https without CA", options: NacosOptions{ServerHttpUrls: []string{"https://nacos.example.com/nacos"}}, err: "--nacos-tls-ca-file must be set"},
+		{name: "CA and insecure", options: NacosOptions{ServerHttpUrls: []string{"https://nacos.example.com/nacos"}, TLSCAFile: certFile, TLSInsecure: true}, err: "can't be used along with"},
+		{name: "missing CA", options: NacosOptions{ServerHttpUrls: []string{"https://nacos.example.com/nacos"}, TLSCAFile: "missing.crt"}, err: "failed to read Nacos CA file"},
+		{name: "cert without key", options: NacosOptions{ServerHttpUrls: []string{"https://nacos.example.com/nacos"}, TLSInsecure: true, TLSCertFile: certFile}, err: "must be set together"},
+		{name: "mismatched key", options: NacosOptions{ServerHttpUrls: []string{"https://nacos.example.com/nacos"}, TLSInsecure: true, TLSCertFile: keyFile, TLSKeyFile: certFile}, err: "failed to load Nacos client certificate"},
+		{name: "TLS over http", options: NacosOptions{ServerHttpUrls: []string{"http://localhost:8848/nacos"}, TLSCAFile: certFile}, err: "can only be used with HTTPS URLs"},
+		{name: "access key without secret", options: NacosOptions{ServerHttpUrls: []string{"http://localhost:8848/nacos"}, AccessKey: "ak"}, err: "must be set together"},
+		{name: "RAM role and access key", options: NacosOptions{ServerHttpUrls: []string{"http://localhost:8848/nacos"}, AccessKey: "ak", SecretKey: "sk", RamRoleName: "role"}, err: "--nacos-ram-role-name can't be used"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			errs := tt.options.Validate()
+			if tt.err == "" {
+				if len(errs) != 0 {
+					t.Fatalf("Validate() = %v, want no error", errs)
+				}
+				return
+			}
+			if len(errs) != 1 || !strings.Contains(errs[0].Error(), tt.err) {
+				t.Fatalf("Validate() = %v, want an error about %q", errs, tt.err)
+			}
+		})
+	}
+}
+
+func TestNacosClientParam(t *testing.T) {
+	certFile, keyFile := writeTestCertKey(t, t.TempDir())
+	o := &NacosOptions{
+		ServerHttpUrls:    []string{"https://a.example.com/nacos/", "https://b.example.com:8443/nacos"},
+		AccessKey:         "ak",
+		SecretKey:         "sk",
+		SignatureRegionId: "cn-hangzhou",
+		TLSCAFile:         certFile,
+		TLSCertFile:       certFile,
+		TLSKeyFile:        keyFile,
+		TLSServerName:     "nacos",
+	}
+	param := o.clientParam()
+	if len(param.ServerConfigs) != 2 {
+		t.Fatalf("server configs = %+v, want 2", param.ServerConfigs)
+	}
+	for i, want := range []struct {
+		host string
+		port uint64
+	}{{"a.example.com", 443}, {"b.example.com", 8443}} {
+		server := param.ServerConfigs[i]
+		if server.IpAddr != want.host || server.Port != want.port || server.Scheme != "https" || server.ContextPath != "/nacos" {
+			t.Errorf("server config %d = %+v, want https://%s:%d/nacos", i, server, want.host, want.port)
+		}
+	}
+
+	client := param.ClientConfig
+	if client.AccessKey != "ak" || client.SecretKey != "sk" {
+		t.Errorf("client is signed with %q/%q, want the access key", client.AccessKey, client.SecretKey)
+	}
+	if client.RamConfig == nil || client.RamConfig.SignatureRegionId != "cn-hangzhou" {
+		t.Errorf("RAM config = %+v, want the signature region", client.RamConfig)
+	}
+	tlsConfig := client.TLSCfg
+	if !tlsConfig.Enable || tlsConfig.TrustAll || tlsConfig.CaFile != certFile || tlsConfig.CertFile != certFile ||
+		tlsConfig.KeyFile != keyFile || tlsConfig.ServerNameOverride != "nacos" {
+		t.Errorf("TLS config = %+v, want the TLS options", tlsConfig)
+	}
+
+	// No TLS is set up for HTTP URLs, which default to port 80.
+	o = &NacosOptions{ServerHttpUrls: []string{"http://localhost/nacos"}}
+	param = o.clientParam()
+	if param.ClientConfig.TLSCfg.Enable || param.ClientConfig.RamConfig != nil {
+		t.Errorf("client config = %+v for an HTTP URL", param.ClientConfig)
+	}
+	if len(param.ServerConfigs) != 1 || param.ServerConfigs[0].Port != 80 {
+		t.Errorf("server configs = %+v, want port 80", param.ServerConfigs)
+	}
+}
+
+func TestEncryptionOptionsValidate(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "encryption.yaml")
+	if err := os.WriteFile(configFile, []byte("resources:\n- resources: [secrets, wasmplugins.extensions.higress.io]\n"+
+		"  fields: [\"{.spec.defaultConfig.apiKey}\"]\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		options EncryptionOptions
+		// resources are the resources encrypted, joined by commas.
+		resources string
+		err       string
+	}{
+		{name: "default", resources: "secrets"},
+		{name: "resources", options: EncryptionOptions{Resources: []string{"secrets", "configmaps:higress.io/sensitive=true"}}, resources: "secrets,configmaps"},
+		{name: "config", options: EncryptionOptions{ConfigFile: configFile}, resources: "secrets,wasmplugins.extensions.higress.io"},
+		{name: "config and resources", options: EncryptionOptions{ConfigFile: configFile, Resources: []string{"secrets"}}, err: "can't be used along with"},
+		{name: "duplicate resource", options: EncryptionOptions{Resources: []string{"configmaps:a=b", "configmaps:c=d"}}, resources: "configmaps", err: "duplicate encryption resource"},
+		{name: "invalid rule", options: EncryptionOptions{Resources: []string{"configmaps:a in (b"}}, err: "invalid encryption resource rule"},
+		{name: "missing config", options: EncryptionOptions{ConfigFile: "missing.yaml"}, err: "failed to read encryption config"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			errs := tt.options.Validate()
+			if tt.err == "" && len(errs) != 0 || tt.err != "" && (len(errs) != 1 || !strings.Contains(errs[0].Error(), tt.err)) {
+				t.Fatalf("Validate() = %v, want an error about %q", errs, tt.err)
+			}
+			var resources []string
+			for _, rule := range tt.options.ResourceRules {
+				resources = append(resources, rule.GroupResource.String())
+			}
+			if got := strings.Join(resources, ","); got != tt.resources {
+				t.Fatalf("encrypted resources = %s, want %s", got, tt.resources)
+			}
+		})
+	}
+}
+
+func TestAuthOptionsValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		options AuthOptions
+		err     string
+	}{
+		{name: "disabled", options: AuthOptions{}},
+		{name: "token file", options: AuthOptions{Enabled: true, TokenAuthFile: "tokens.csv"}},
+		{name: "client certs", options: AuthOptions{Enabled: true, ClientCADir: "ca", ClientCerts: []string{"console=system:masters", "controller"}}},
+		{name: "token file without auth", options: AuthOptions{TokenAuthFile: "tokens.csv"}, err: "--token-auth-file requires --auth-enabled"},
+		{name: "client CA without auth", options: AuthOptions{ClientCADir: "ca"}, err: "--auth-client-ca-dir requires --auth-enabled"},
+		{name: "client cert without CA", options: AuthOptions{Enabled: true, ClientCerts: []string{"console"}}, err: "requires --auth-client-ca-dir"},
+		{name: "client cert of the CA", options: AuthOptions{Enabled: true, ClientCADir: "ca", ClientCerts: []string{"ca"}}, err: "invalid user name"},
+		{name: "client cert path", options: AuthOptions{Enabled: true, ClientCADir: "ca", ClientCerts: []string{"../console"}}, err: "invalid user name"},
+		{name: "duplicate client cert", options: AuthOptions{Enabled: true, ClientCADir: "ca", ClientCerts: []string{"console", "console=a"}}, err: "duplicate client certificate"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			errs := tt.options.Validate()
+			if tt.err == "" && len(errs) != 0 || tt.err != "" && (len(errs) != 1 || !strings.Contains(errs[0].Error(), tt.err)) {
+				t.Fatalf("Validate() = %v, want an error about %q", errs, tt.err)
+			}
+		})
+	}
+}
+
+func TestAuthOptionsApplyTo(t *testing.T) {
+	dir := t.TempDir()
+	tokenFile := filepath.Join(dir, "tokens.csv")
+	if err := os.WriteFile(tokenFile, []byte("console-token,higress-console,1,system:masters\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	caDir := filepath.Join(dir, "ca")
+	o := &AuthOptions{Enabled: true, TokenAuthFile: tokenFile, ClientCADir: caDir, ClientCerts: []string{"higress-controller=higress:controller"}}
+	// The remote authentication is tried after the built-in ones.
+	remote := authenticator.RequestFunc(func(req *http.Request) (*authenticator.Response, bool, error) {
+		return &authenticator.Response{User: &user.DefaultInfo{Name: "remote"}}, true, nil
+	})
+	authenticationInfo := &genericapiserver.AuthenticationInfo{Authenticator: remote}
+	servingInfo := &genericapiserver.SecureServingInfo{}
+	if err := o.ApplyTo(authenticationInfo, servingInfo); err != nil {
+		t.Fatal(err)
+	}
+	if servingInfo.ClientCA == nil {
+		t.Fatal("client certificates aren't requested by the server")
+	}
+
+	authenticate := func(req *http.Request) string {
+		t.Helper()
+		resp, ok, err := authenticationInfo.Authenticator.AuthenticateRequest(req)
+		if err != nil || !ok {
+			t.Fatalf("AuthenticateRequest() = %v, %v", ok, err)
+		}
+		return resp.User.GetName()
+	}
+	req := httptest.NewRequest(http.MethodGet, "/api", nil)
+	req.Header.Set("Authorization", "Bearer console-token")
+	if name := authenticate(req); name != "higress-console" {
+		t.Fatalf("token is authenticated as %s", name)
+	}
+	certs, err := cert.CertsFromFile(filepath.Join(caDir, "higress-controller.crt"))
+	if err != nil {
+		t.Fatalf("client certificate isn't issued: %v", err)
+	}
+	req = httptest.NewRequest(http.MethodGet, "/api", nil)
+	req.TLS = &tls.ConnectionState{PeerCertificates: certs}
+	if name := authenticate(req); name != "higress-controller" {
+		t.Fatalf("client certificate is authenticated as %s", name)
+	}
+	if name := authenticate(httptest.NewRequest(http.MethodGet, "/api", nil)); name != "remote" {
+		t.Fatalf("request without credentials is authenticated as %s", name)
+	}
+
+	// Nothing is applied unless auth is enabled.
+	authenticationInfo = &genericapiserver.AuthenticationInfo{}
+	if err := (&AuthOptions{TokenAuthFile: tokenFile}).ApplyTo(authenticationInfo, nil); err != nil || authenticationInfo.Authenticator != nil {
+		t.Fatalf("ApplyTo() with auth disabled = %v, %v", authenticationInfo.Authenticator, err)
+	}
+}
@@ -0,0 +1,359 @@
+package rbac
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	rbacv1 "k8s.io/api/rbac/v1"
+	metainternalversion "k8s.io/apimachinery/pkg/apis/meta/internalversion"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/apiserver/pkg/authentication/serviceaccount"
+	"k8s.io/apiserver/pkg/authentication/user"
+	"k8s.io/apiserver/pkg/authorization/authorizer"
+	genericapirequest "k8s.io/apiserver/pkg/endpoints/request"
+	"k8s.io/apiserver/pkg/registry/rest"
+	rbacv1listers "k8s.io/client-go/listers/rbac/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// Storage is a storage of RBAC resources the authorizer lists and watches.
+type Storage interface {
+	rest.Lister
+	rest.Watcher
+}
+
+// Authorizer authorizes requests with the Roles, ClusterRoles, RoleBindings and ClusterRoleBindings stored
+// in the storage backends, in the same way as the RBAC authorizer of Kubernetes. It has no opinion on the requests
+// until the RBAC resources are loaded, so they are denied unless another authorizer allows them.
+type Authorizer struct {
+	roleLister               rbacv1listers.RoleLister
+	roleBindingLister        rbacv1listers.RoleBindingLister
+	clusterRoleLister        rbacv1listers.ClusterRoleLister
+	clusterRoleBindingLister rbacv1listers.ClusterRoleBindingLister
+
+	informers []cache.SharedIndexInformer
+	synced    atomic.Bool
+}
+
+var _ authorizer.Authorizer = &Authorizer{}
+var _ authorizer.RuleResolver = &Authorizer{}
+
+// NewAuthorizer creates an authorizer, which doesn't authorize anything until it's started.
+func NewAuthorizer() *Authorizer {
+	return &Authorizer{}
+}
+
+// Start loads the RBAC resources from the given storages, and keeps them up to date until ctx is done.
+func (a *Authorizer) Start(ctx context.Context, roles, roleBindings, clusterRoles, clusterRoleBindings Storage) {
+	roleInformer := newInformer(ctx, roles, &rbacv1.Role{})
+	roleBindingInformer := newInformer(ctx, roleBindings, &rbacv1.RoleBinding{})
+	clusterRoleInformer := newInformer(ctx, clusterRoles, &rbacv1.ClusterRole{})
+	clusterRoleBindingInformer := newInformer(ctx, clusterRoleBindings, &rbacv1.ClusterRoleBinding{})
+	a.roleLister = rbacv1listers.NewRoleLister(roleInformer.GetIndexer())
+	a.roleBindingLister = rbacv1listers.NewRoleBindingLister(roleBindingInformer.GetIndexer())
+	a.clusterRoleLister = rbacv1listers.NewClusterRoleLister(clusterRoleInformer.GetIndexer())
+	a.clusterRoleBindingLister = rbacv1listers.NewClusterRoleBindingLister(clusterRoleBindingInformer.GetIndexer())
+	a.informers = []cache.SharedIndexInformer{roleInformer, roleBindingInformer, clusterRoleInformer, clusterRoleBindingInformer}
+
+	for _, informer := range a.informers {
+		go informer.Run(ctx.Done())
+	}
+	go func() {
+		var hasSynced []cache.InformerSynced
+		for _, informer := range a.informers {
+			hasSynced = append(hasSynced, informer.HasSynced)
+		}
+		if cache.WaitForNamedCacheSync("rbac-authorizer", ctx.Done(), hasSynced...) {
+			a.synced.Store(true)
+		}
+	}()
+}
+
+// newInformer creates an informer of a storage, which lists and watches it in-process.
+func newInformer(ctx context.Context, storage Storage, objType runtime.Object) cache.SharedIndexInformer {
+	listWatch := &cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			internalOptions, err := toInternalListOptions(&options)
+			if err != nil {
+				return nil, err
+			}
+			return storage.List(genericapirequest.WithNamespace(ctx, metav1.NamespaceAll), internalOptions)
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			internalOptions, err := toInternalListOptions(&options)
+			if err != nil {
+				return nil, err
+			}
+			return storage.Watch(genericapirequest.WithNamespace(ctx, metav1.NamespaceAll), internalOptions)
+		},
+	}
+	return cache.NewSharedIndexInformer(listWatch, objType, 10*time.Minute, cache.Indexers{
+		cache.NamespaceIndex: cache.MetaNamespaceIndexFunc,
+	})
+}
+
+func toInternalListOptions(options *metav1.ListOptions) (*metainternalversion.ListOptions, error) {
+	internalOptions := &metainternalversion.ListOptions{}
+	if err := metainternalversion.Convert_v1_ListOptions_To_internalversion_ListOptions(options, internalOptions, nil); err != nil {
+		return nil, err
+	}
+	return internalOptions, nil
+}
+
+// HasSynced tells whether the RBAC resources have been loaded.
+func (a *Authorizer) HasSynced() bool {
+	return a.synced.Load()
+}
+
+func (a *Authorizer) Authorize(ctx context.Context, attrs authorizer.Attributes) (authorizer.Decision, string, error) {
+	if !a.HasSynced() {
+		return authorizer.DecisionNoOpinion, "RBAC resources are not loaded yet", nil
+	}
+	if attrs.GetUser() == nil {
+		return authorizer.DecisionNoOpinion, "no user", nil
+	}
+
+	var reason string
+	var errs []error
+	a.visitRulesFor(attrs.GetUser(), attrs.GetNamespace(), func(source string, rule *rbacv1.PolicyRule, err error) bool {
+		if err != nil {
+			errs = append(errs, err)
+			return true
+		}
+		if ruleAllows(attrs, rule) {
+			reason = "RBAC: allowed by " + source
+			return false
+		}
+		return true
+	})
+	if reason != "" {
+		return authorizer.DecisionAllow, reason, nil
+	}
+	return authorizer.DecisionNoOpinion, "", utilerrors.NewAggregate(errs)
+}
+
+func (a *Authorizer) RulesFor(user user.Info, namespace string) ([]authorizer.ResourceRuleInfo, []authorizer.NonResourceRuleInfo, bool, error) {
+	if !a.HasSynced() {
+		return nil, nil, true, errors.New("RBAC resources are not loaded yet")
+	}
+
+	var resourceRules []authorizer.ResourceRuleInfo
+	var nonResourceRules []authorizer.NonResourceRuleInfo
+	var errs []error
+	a.visitRulesFor(user, namespace, func(_ string, rule *rbacv1.PolicyRule, err error) bool {
+		if err != nil {
+			errs = append(errs, err)
+			return true
+		}
+		if len(rule.Resources) != 0 {
+			resourceRules = append(resourceRules, &authorizer.DefaultResourceRuleInfo{
+				Verbs:         rule.Verbs,
+				APIGroups:     rule.APIGroups,
+				Resources:     rule.Resources,
+				ResourceNames: rule.ResourceNames,
+			})
+		}
+		if len(rule.NonResourceURLs) != 0 {
+			nonResourceRules = append(nonResourceRules, &authorizer.DefaultNonResourceRuleInfo{
+				Verbs:           rule.Verbs,
+				NonResourceURLs: rule.NonResourceURLs,
+			})
+		}
+		return true
+	})
+	return resourceRules, nonResourceRules, len(errs) != 0, utilerrors.NewAggregate(errs)
+}
+
+// visitRulesFor calls visitor with the rules granted to the user in the namespace, along with the binding granting
+// them, until it returns false. Cluster-wide rules are always visited, while namespaced ones only if namespace is set.
+func (a *Authorizer) visitRulesFor(user user.Info, namespace string, visitor func(source string, rule *rbacv1.PolicyRule, err error) bool) {
+	clusterRoleBindings, err := a.clusterRoleBindingLister.List(labels.Everything())
+	if err != nil {
+		visitor("", nil, err)
+		return
+	}
+	for _, binding := range clusterRoleBindings {
+		if !appliesTo(user, binding.Subjects, "") {
+			continue
+		}
+		rules, err := a.rulesOf(binding.RoleRef, "")
+		source := fmt.Sprintf("ClusterRoleBinding %q of %s %q", binding.Name, binding.RoleRef.Kind, binding.RoleRef.Name)
+		if err != nil {
+			if !visitor(source, nil, err) {
+				return
+			}
+			continue
+		}
+		for i := range rules {
+			if !visitor(source, &rules[i], nil) {
+				return
+			}
+		}
+	}
+
+	if namespace == "" {
+		return
+	}
+	roleBindings, err := a.roleBindingLister.RoleBindings(namespace).List(labels.Everything())
+	if err != nil {
+		visitor("", nil, err)
+		return
+	}
+	for _, binding := range roleBindings {
+		if !appliesTo(user, binding.Subjects, namespace) {
+			continue
+		}
+		rules, err := a.rulesOf(binding.RoleRef, namespace)
+		source := fmt.Sprintf("RoleBinding %q of %s %q in namespace %q", binding.Name, binding.RoleRef.Kind, binding.RoleRef.Name, namespace)
+		if err != nil {
+			if !visitor(source, nil, err) {
+				return
+			}
+			continue
+		}
+		for i := range rules {
+			if !visitor(source, &rules[i], nil) {
+				return
+			}
+		}
+	}
+}
+
+// rulesOf returns the rules of the role referred by a binding in namespace, which is empty for ClusterRoleBindings.
+func (a *Authorizer) rulesOf(roleRef rbacv1.RoleRef, namespace string) ([]rbacv1.PolicyRule, error) {
+	switch roleRef.Kind {
+	case "Role":
+		role, err := a.roleLister.Roles(namespace).Get(roleRef.Name)
+		if err != nil {
+			return nil, err
+		}
+		return role.Rules, nil
+	case "ClusterRole":
+		clusterRole, err := a.clusterRoleLister.Get(roleRef.Name)
+		if err != nil {
+			return nil, err
+		}
+		if clusterRole.AggregationRule == nil {
+			return clusterRole.Rules, nil
+		}
+		return a.aggregatedRules(clusterRole)
+	default:
+		return nil, fmt.Errorf("unsupported role reference kind: %q", roleRef.Kind)
+	}
+}
+
+// aggregatedRules returns the rules of the ClusterRoles selected by the aggregation rule of a ClusterRole, which are
+// aggregated on the fly instead of by a controller.
+func (a *Authorizer) aggregatedRules(clusterRole *rbacv1.ClusterRole) ([]rbacv1.PolicyRule, error) {
+	rules := append([]rbacv1.PolicyRule{}, clusterRole.Rules...)
+	seen := map[string]bool{clusterRole.Name: true}
+	for _, labelSelector := range clusterRole.AggregationRule.ClusterRoleSelectors {
+		selector, err := metav1.LabelSelectorAsSelector(&labelSelector)
+		if err != nil {
+			return nil, fmt.Errorf("invalid aggregation rule of ClusterRole %q: %v", clusterRole.Name, err)
+		}
+		clusterRoles, err := a.clusterRoleLister.List(selector)
+		if err != nil {
+			return nil, err
+		}
+		for _, aggregated := range clusterRoles {
+			if seen[aggregated.Name] {
+				continue
+			}
+			seen[aggregated.Name] = true
+			rules = append(rules, aggregated.Rules...)
+		}
+	}
+	return rules, nil
+}
+
+// appliesTo tells whether any of the subjects of a binding in namespace refers to the user.
+func appliesTo(user user.Info, subjects []rbacv1.Subject, namespace string) bool {
+	for _, subject := range subjects {
+		switch subject.Kind {
+		case rbacv1.UserKind:
+			if user.GetName() == subject.Name {
+				return true
+			}
+		case rbacv1.GroupKind:
+			for _, group := range user.GetGroups() {
+				if group == subject.Name {
+					return true
+				}
+			}
+		case rbacv1.ServiceAccountKind:
+			saNamespace := subject.Namespace
+			if saNamespace == "" {
+				saNamespace = namespace
+			}
+			if saNamespace != "" && user.GetName() == serviceaccount.MakeUsername(saNamespace, subject.Name) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func ruleAllows(attrs authorizer.Attributes, rule *rbacv1.PolicyRule) bool {
+	if !matches(rule.Verbs, attrs.GetVerb()) {
+		return false
+	}
+	if !attrs.IsResourceRequest() {
+		return nonResourceURLMatches(rule.NonResourceURLs, attrs.GetPath())
+	}
+	resource := attrs.GetResource()
+	if subresource := attrs.GetSubresource(); subresource != "" {
+		resource += "/" + subresource
+	}
+	return matches(rule.APIGroups, attrs.GetAPIGroup()) &&
+		resourceMatches(rule.Resources, resource, attrs.GetSubresource()) &&
+		(len(rule.ResourceNames) == 0 || resourceNameMatches(rule.ResourceNames, attrs.GetName()))
+}
+
+func matches(values []string, value string) bool {
+	for _, v := range values {
+		if v == rbacv1.VerbAll || v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func resourceNameMatches(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// resourceMatches matches "<resource>[/<subresource>]" against the resources of a rule, where "*" matches everything,
+// and "*/<subresource>" matches the subresource of all the resources.
+func resourceMatches(resources []string, resource, subresource string) bool {
+	for _, r := range resources {
+		if r == rbacv1.ResourceAll || r == resource || subresource != "" && r == "*/"+subresource {
+			return true
+		}
+	}
+	return false
+}
+
+// nonResourceURLMatches matches a path against the non-resource URLs of a rule, where a trailing "*" matches any suffix.
+func nonResourceURLMatches(urls []string, path string) bool {
+	for _, url := range urls {
+		if url == rbacv1.NonResourceAll || url == path ||
+			strings.HasSuffix(url, "*") && strings.HasPrefix(path, strings.TrimSuffix(url, "*")) {
+			return true
+		}
+	}
+	return false
+}
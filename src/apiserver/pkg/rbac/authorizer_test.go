@@ -0,0 +1,224 @@
+package rbac
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apiserver/pkg/authentication/user"
+	"k8s.io/apiserver/pkg/authorization/authorizer"
+	genericapirequest "k8s.io/apiserver/pkg/endpoints/request"
+	"k8s.io/apiserver/pkg/registry/rest"
+	"k8s.io/client-go/kubernetes/scheme"
+
+	"github.com/alibaba/higress/api-server/pkg/options"
+	"github.com/alibaba/higress/api-server/pkg/registry"
+)
+
+// testStorages are the file storages of the RBAC resources.
+type testStorages struct {
+	roles, roleBindings, clusterRoles, clusterRoleBindings registry.REST
+}
+
+func newTestStorages(t *testing.T) *testStorages {
+	t.Helper()
+	fileOptions := &options.FileOptions{RootDir: t.TempDir(), Layout: options.FileLayout_Namespaced}
+	codec := scheme.Codecs.LegacyCodec(rbacv1.SchemeGroupVersion)
+	newStorage := func(resource string, isNamespaced bool, singularName string, newFunc, newListFunc func() runtime.Object) registry.REST {
+		storage, err := registry.NewFileREST(rbacv1.Resource(resource), codec, fileOptions, ".yaml", isNamespaced, singularName,
+			newFunc, newListFunc, nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(storage.Destroy)
+		return storage
+	}
+	return &testStorages{
+		roles: newStorage("roles", true, "role",
+			func() runtime.Object { return &rbacv1.Role{} }, func() runtime.Object { return &rbacv1.RoleList{} }),
+		roleBindings: newStorage("rolebindings", true, "rolebinding",
+			func() runtime.Object { return &rbacv1.RoleBinding{} }, func() runtime.Object { return &rbacv1.RoleBindingList{} }),
+		clusterRoles: newStorage("clusterroles", false, "clusterrole",
+			func() runtime.Object { return &rbacv1.ClusterRole{} }, func() runtime.Object { return &rbacv1.ClusterRoleList{} }),
+		clusterRoleBindings: newStorage("clusterrolebindings", false, "clusterrolebinding",
+			func() runtime.Object { return &rbacv1.ClusterRoleBinding{} }, func() runtime.Object { return &rbacv1.ClusterRoleBindingList{} }),
+	}
+}
+
+func mustCreate(t *testing.T, storage rest.Creater, ns string, obj runtime.Object) {
+	t.Helper()
+	if _, err := storage.Create(genericapirequest.WithNamespace(context.Background(), ns), obj, nil, &metav1.CreateOptions{}); err != nil {
+		t.Fatalf("failed to create %v: %v", obj, err)
+	}
+}
+
+func newTestAuthorizer(t *testing.T, storages *testStorages) *Authorizer {
+	t.Helper()
+	a := NewAuthorizer()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	a.Start(ctx, storages.roles, storages.roleBindings, storages.clusterRoles, storages.clusterRoleBindings)
+	waitFor(t, "RBAC resources to be loaded", a.HasSynced)
+	return a
+}
+
+func waitFor(t *testing.T, what string, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func resourceAttributes(u user.Info, verb, namespace, group, resource, subresource, name string) authorizer.AttributesRecord {
+	return authorizer.AttributesRecord{
+		User:            u,
+		Verb:            verb,
+		Namespace:       namespace,
+		APIGroup:        group,
+		Resource:        resource,
+		Subresource:     subresource,
+		Name:            name,
+		ResourceRequest: true,
+	}
+}
+
+func allowed(t *testing.T, a *Authorizer, attrs authorizer.Attributes) bool {
+	t.Helper()
+	decision, _, _ := a.Authorize(context.Background(), attrs)
+	if decision == authorizer.DecisionDeny {
+		t.Fatalf("RBAC denied %v explicitly", attrs)
+	}
+	return decision == authorizer.DecisionAllow
+}
+
+func TestAuthorizer(t *testing.T) {
+	storages := newTestStorages(t)
+	mustCreate(t, storages.clusterRoles, "", &rbacv1.ClusterRole{
+		ObjectMeta: metav1.ObjectMeta{Name: "viewer"},
+		Rules: []rbacv1.PolicyRule{
+			{Verbs: []string{"get", "list", "watch"}, APIGroups: []string{"networking.k8s.io"}, Resources: []string{"ingresses"}},
+			{Verbs: []string{"get"}, NonResourceURLs: []string{"/healthz", "/metrics/*"}},
+		},
+	})
+	mustCreate(t, storages.clusterRoleBindings, "", &rbacv1.ClusterRoleBinding{
+		ObjectMeta: metav1.ObjectMeta{Name: "console-viewer"},
+		Subjects:   []rbacv1.Subject{{Kind: rbacv1.GroupKind, Name: "higress:console"}},
+		RoleRef:    rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: "viewer"},
+	})
+	mustCreate(t, storages.roles, "higress-system", &rbacv1.Role{
+		ObjectMeta: metav1.ObjectMeta{Name: "secret-reader", Namespace: "higress-system"},
+		Rules: []rbacv1.PolicyRule{
+			{Verbs: []string{"get"}, APIGroups: []string{""}, Resources: []string{"secrets"}, ResourceNames: []string{"tls"}},
+			{Verbs: []string{"update"}, APIGroups: []string{"*"}, Resources: []string{"*/status"}},
+		},
+	})
+	mustCreate(t, storages.roleBindings, "higress-system", &rbacv1.RoleBinding{
+		ObjectMeta: metav1.ObjectMeta{Name: "controller", Namespace: "higress-system"},
+		Subjects: []rbacv1.Subject{
+			{Kind: rbacv1.UserKind, Name: "controller"},
+			{Kind: rbacv1.ServiceAccountKind, Name: "pilot"},
+		},
+		RoleRef: rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "Role", Name: "secret-reader"},
+	})
+	a := newTestAuthorizer(t, storages)
+
+	console := &user.DefaultInfo{Name: "admin", Groups: []string{"higress:console"}}
+	controller := &user.DefaultInfo{Name: "controller"}
+	pilot := &user.DefaultInfo{Name: "system:serviceaccount:higress-system:pilot"}
+	tests := []struct {
+		name  string
+		attrs authorizer.Attributes
+		want  bool
+	}{
+		{"cluster role bound to the group", resourceAttributes(console, "list", "", "networking.k8s.io", "ingresses", "", ""), true},
+		{"cluster role in a namespace", resourceAttributes(console, "get", "default", "networking.k8s.io", "ingresses", "", "a"), true},
+		{"verb not granted", resourceAttributes(console, "delete", "default", "networking.k8s.io", "ingresses", "", "a"), false},
+		{"group not granted", resourceAttributes(console, "get", "default", "extensions", "ingresses", "", "a"), false},
+		{"subresource not granted", resourceAttributes(console, "get", "default", "networking.k8s.io", "ingresses", "status", "a"), false},
+		{"non-resource URL", authorizer.AttributesRecord{User: console, Verb: "get", Path: "/healthz"}, true},
+		{"non-resource URL prefix", authorizer.AttributesRecord{User: console, Verb: "get", Path: "/metrics/slis"}, true},
+		{"non-resource URL not granted", authorizer.AttributesRecord{User: console, Verb: "get", Path: "/debug/pprof"}, false},
+		{"role bound to the user", resourceAttributes(controller, "get", "higress-system", "", "secrets", "", "tls"), true},
+		{"resource name not granted", resourceAttributes(controller, "get", "higress-system", "", "secrets", "", "other"), false},
+		{"role in another namespace", resourceAttributes(controller, "get", "default", "", "secrets", "", "tls"), false},
+		{"role cluster-wide", resourceAttributes(controller, "get", "", "", "secrets", "", "tls"), false},
+		{"status of any resource", resourceAttributes(controller, "update", "higress-system", "networking.k8s.io", "ingresses", "status", "a"), true},
+		{"service account in the namespace of the binding", resourceAttributes(pilot, "get", "higress-system", "", "secrets", "", "tls"), true},
+		{"unknown user", resourceAttributes(&user.DefaultInfo{Name: "eve"}, "list", "", "networking.k8s.io", "ingresses", "", ""), false},
+	}
+	for _, tt := range tests {
+		if got := allowed(t, a, tt.attrs); got != tt.want {
+			t.Errorf("[%s] allowed = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestAuthorizerNotSynced(t *testing.T) {
+	a := NewAuthorizer()
+	attrs := resourceAttributes(&user.DefaultInfo{Name: "admin"}, "get", "", "", "secrets", "", "")
+	if decision, reason, _ := a.Authorize(context.Background(), attrs); decision != authorizer.DecisionNoOpinion {
+		t.Fatalf("authorizer not started decided %v: %s", decision, reason)
+	}
+	if _, _, incomplete, err := a.RulesFor(&user.DefaultInfo{Name: "admin"}, ""); !incomplete || err == nil {
+		t.Fatal("rules are resolved before the RBAC resources are loaded")
+	}
+}
+
+func TestAuthorizerWatchesChanges(t *testing.T) {
+	storages := newTestStorages(t)
+	a := newTestAuthorizer(t, storages)
+	alice := &user.DefaultInfo{Name: "alice"}
+	attrs := resourceAttributes(alice, "create", "default", "extensions.higress.io", "wasmplugins", "", "")
+	if allowed(t, a, attrs) {
+		t.Fatal("allowed without any RBAC resource")
+	}
+
+	// The roles and bindings created later are granted, including the rules aggregated into a ClusterRole.
+	mustCreate(t, storages.clusterRoles, "", &rbacv1.ClusterRole{
+		ObjectMeta:      metav1.ObjectMeta{Name: "editor"},
+		AggregationRule: &rbacv1.AggregationRule{ClusterRoleSelectors: []metav1.LabelSelector{{MatchLabels: map[string]string{"aggregate-to-edit": "true"}}}},
+	})
+	mustCreate(t, storages.clusterRoles, "", &rbacv1.ClusterRole{
+		ObjectMeta: metav1.ObjectMeta{Name: "wasmplugin-editor", Labels: map[string]string{"aggregate-to-edit": "true"}},
+		Rules:      []rbacv1.PolicyRule{{Verbs: []string{"*"}, APIGroups: []string{"extensions.higress.io"}, Resources: []string{"wasmplugins"}}},
+	})
+	mustCreate(t, storages.roleBindings, "default", &rbacv1.RoleBinding{
+		ObjectMeta: metav1.ObjectMeta{Name: "alice", Namespace: "default"},
+		Subjects:   []rbacv1.Subject{{Kind: rbacv1.UserKind, Name: "alice"}},
+		RoleRef:    rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: "editor"},
+	})
+	waitFor(t, "the binding to be granted", func() bool {
+		return allowed(t, a, attrs)
+	})
+	resourceRules, _, incomplete, err := a.RulesFor(alice, "default")
+	if err != nil || incomplete || len(resourceRules) != 1 || resourceRules[0].GetResources()[0] != "wasmplugins" {
+		t.Fatalf("RulesFor() = %v, %v, %v", resourceRules, incomplete, err)
+	}
+
+	// The binding deleted is revoked, and a binding of a missing role grants nothing.
+	if _, _, err := storages.roleBindings.Delete(genericapirequest.WithNamespace(context.Background(), "default"), "alice", nil, &metav1.DeleteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the binding to be revoked", func() bool {
+		return !allowed(t, a, attrs)
+	})
+	mustCreate(t, storages.clusterRoleBindings, "", &rbacv1.ClusterRoleBinding{
+		ObjectMeta: metav1.ObjectMeta{Name: "missing"},
+		Subjects:   []rbacv1.Subject{{Kind: rbacv1.UserKind, Name: "alice"}},
+		RoleRef:    rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: "missing"},
+	})
+	waitFor(t, "the binding of the missing role to be loaded", func() bool {
+		_, _, err := a.Authorize(context.Background(), attrs)
+		return err != nil
+	})
+	if allowed(t, a, attrs) {
+		t.Fatal("binding of a missing role is granted")
+	}
+}
@@ -0,0 +1,206 @@
+package registry
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/klog/v2"
+
+	"github.com/alibaba/higress/api-server/pkg/options"
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	boltMetaBucket      = []byte("meta")
+	boltObjectsBucket   = []byte("objects")
+	boltChangeLogBucket = []byte("changelog")
+
+	boltRevisionKey          = []byte("revision")
+	boltCompactedRevisionKey = []byte("compactedRevision")
+)
+
+var (
+	boltDatabases      = map[string]*boltDatabase{}
+	boltDatabasesMutex sync.Mutex
+)
+
+// boltDatabase is a bbolt database shared by all the resources stored in the bolt backend.
+// It keeps objects in a bucket per resource, a cluster-wide revision counter and a change log
+// ordered by revision, all of which are updated in the same transaction.
+type boltDatabase struct {
+	db            *bolt.DB
+	changeLogSize int
+}
+
+// boltChange is a record in the change log.
+type boltChange struct {
+	Resource   string          `json:"resource"`
+	Type       watch.EventType `json:"type"`
+	Object     []byte          `json:"object"`
+	PrevObject []byte          `json:"prevObject,omitempty"`
+}
+
+func getBoltDatabase(boltOptions *options.BoltOptions) (*boltDatabase, error) {
+	boltDatabasesMutex.Lock()
+	defer boltDatabasesMutex.Unlock()
+
+	path := filepath.Clean(boltOptions.Path)
+	if d, ok := boltDatabases[path]; ok {
+		return d, nil
+	}
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: boltOptions.Timeout})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open bolt database [%s]: %v", path, err)
+	}
+	if err := db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{boltMetaBucket, boltObjectsBucket, boltChangeLogBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("failed to initialize bolt database [%s]: %v", path, err)
+	}
+	d := &boltDatabase{
+		db:            db,
+		changeLogSize: boltOptions.ChangeLogSize,
+	}
+	if boltOptions.BackupPath != "" {
+		d.startBackupTicker(boltOptions.BackupPath, boltOptions.BackupInterval)
+	}
+	boltDatabases[path] = d
+	return d, nil
+}
+
+func (d *boltDatabase) startBackupTicker(path string, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	go func(d *boltDatabase) {
+		for {
+			<-ticker.C
+			if err := d.backup(path); err != nil {
+				klog.Errorf("failed to back up bolt database to [%s]: %v", path, err)
+			} else {
+				klog.Infof("bolt database backed up to [%s]", path)
+			}
+		}
+	}(d)
+}
+
+// backup writes a consistent snapshot of the database into the given file without blocking writes.
+func (d *boltDatabase) backup(path string) error {
+	tmpPath := path + ".tmp"
+	if err := d.db.View(func(tx *bolt.Tx) error {
+		return tx.CopyFile(tmpPath, 0600)
+	}); err != nil {
+		_ = os.Remove(tmpPath)
+		return err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		_ = os.Remove(tmpPath)
+		return err
+	}
+	return nil
+}
+
+// resourceBucket returns the bucket holding the objects of a resource. It's nil in a read-only transaction
+// if nothing of the resource has ever been written.
+func (d *boltDatabase) resourceBucket(tx *bolt.Tx, resource string) (*bolt.Bucket, error) {
+	objects := tx.Bucket(boltObjectsBucket)
+	if tx.Writable() {
+		return objects.CreateBucketIfNotExists([]byte(resource))
+	}
+	return objects.Bucket([]byte(resource)), nil
+}
+
+func (d *boltDatabase) currentRevision(tx *bolt.Tx) uint64 {
+	return decodeBoltRevision(tx.Bucket(boltMetaBucket).Get(boltRevisionKey))
+}
+
+func (d *boltDatabase) compactedRevision(tx *bolt.Tx) uint64 {
+	return decodeBoltRevision(tx.Bucket(boltMetaBucket).Get(boltCompactedRevisionKey))
+}
+
+// nextRevision increases the revision and returns the new value. It's persisted when the transaction commits.
+func (d *boltDatabase) nextRevision(tx *bolt.Tx) (uint64, error) {
+	revision := d.currentRevision(tx) + 1
+	if err := tx.Bucket(boltMetaBucket).Put(boltRevisionKey, encodeBoltRevision(revision)); err != nil {
+		return 0, err
+	}
+	return revision, nil
+}
+
+// appendChange records a change into the change log, and drops the oldest records beyond the size limit.
+func (d *boltDatabase) appendChange(tx *bolt.Tx, revision uint64, change *boltChange) error {
+	data, err := json.Marshal(change)
+	if err != nil {
+		return err
+	}
+	changeLog := tx.Bucket(boltChangeLogBucket)
+	if err := changeLog.Put(encodeBoltRevision(revision), data); err != nil {
+		return err
+	}
+	if revision <= uint64(d.changeLogSize) {
+		return nil
+	}
+	threshold := revision - uint64(d.changeLogSize)
+	var expiredKeys [][]byte
+	c := changeLog.Cursor()
+	for k, _ := c.First(); k != nil && decodeBoltRevision(k) <= threshold; k, _ = c.Next() {
+		expiredKeys = append(expiredKeys, k)
+	}
+	if len(expiredKeys) == 0 {
+		return nil
+	}
+	for _, k := range expiredKeys {
+		if err := changeLog.Delete(k); err != nil {
+			return err
+		}
+	}
+	return tx.Bucket(boltMetaBucket).Put(boltCompactedRevisionKey, expiredKeys[len(expiredKeys)-1])
+}
+
+// changesSince returns the changes of a resource happened after the given revision, along with their revisions.
+// A "410 Gone" error is returned if some of them have already been dropped from the change log.
+func (d *boltDatabase) changesSince(tx *bolt.Tx, resource string, revision uint64) ([]uint64, []*boltChange, error) {
+	if compactedRevision := d.compactedRevision(tx); revision < compactedRevision {
+		return nil, nil, apierrors.NewResourceExpired(
+			fmt.Sprintf("too old resource version: %d (%d)", revision, compactedRevision))
+	}
+	var revisions []uint64
+	var changes []*boltChange
+	c := tx.Bucket(boltChangeLogBucket).Cursor()
+	for k, v := c.Seek(encodeBoltRevision(revision + 1)); k != nil; k, v = c.Next() {
+		change := &boltChange{}
+		if err := json.Unmarshal(v, change); err != nil {
+			return nil, nil, fmt.Errorf("invalid change log record at revision %d: %v", decodeBoltRevision(k), err)
+		}
+		if change.Resource != resource {
+			continue
+		}
+		revisions = append(revisions, decodeBoltRevision(k))
+		changes = append(changes, change)
+	}
+	return revisions, changes, nil
+}
+
+func encodeBoltRevision(revision uint64) []byte {
+	data := make([]byte, 8)
+	binary.BigEndian.PutUint64(data, revision)
+	return data
+}
+
+func decodeBoltRevision(data []byte) uint64 {
+	if len(data) != 8 {
+		return 0
+	}
+	return binary.BigEndian.Uint64(data)
+}
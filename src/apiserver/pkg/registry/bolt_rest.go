@@ -0,0 +1,800 @@
+package registry
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metainternalversion "k8s.io/apimachinery/pkg/apis/meta/internalversion"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/uuid"
+	"k8s.io/apimachinery/pkg/watch"
+	genericapirequest "k8s.io/apiserver/pkg/endpoints/request"
+	"k8s.io/apiserver/pkg/registry/rest"
+	"k8s.io/apiserver/pkg/storage"
+	storeerr "k8s.io/apiserver/pkg/storage/errors"
+	"k8s.io/apiserver/pkg/util/dryrun"
+	"k8s.io/klog/v2"
+
+	"github.com/alibaba/higress/api-server/pkg/encryption"
+	"github.com/alibaba/higress/api-server/pkg/options"
+	bolt "go.etcd.io/bbolt"
+)
+
+const optimisticLockErrorMsg = "the object has been modified; please apply your changes to the latest version and try again"
+
+var _ rest.StandardStorage = &boltREST{}
+var _ Reencrypter = &boltREST{}
+var _ rest.Scoper = &boltREST{}
+var _ rest.Storage = &boltREST{}
+
+// NewBoltREST instantiates a new REST storage backed by an embedded bbolt database.
+func NewBoltREST(
+	groupResource schema.GroupResource,
+	codec runtime.Codec,
+	boltOptions *options.BoltOptions,
+	isNamespaced bool,
+	singularName string,
+	newFunc func() runtime.Object,
+	newListFunc func() runtime.Object,
+	attrFunc storage.AttrFunc,
+	policy *encryption.Policy,
+) (REST, error) {
+	if attrFunc == nil {
+		if isNamespaced {
+			attrFunc = storage.DefaultNamespaceScopedAttr
+		} else {
+			attrFunc = storage.DefaultClusterScopedAttr
+		}
+	}
+	db, err := getBoltDatabase(boltOptions)
+	if err != nil {
+		return nil, err
+	}
+	b := &boltREST{
+		TableConvertor: rest.NewDefaultTableConvertor(groupResource),
+		groupResource:  groupResource,
+		codec:          codec,
+		db:             db,
+		resource:       groupResource.String(),
+		isNamespaced:   isNamespaced,
+		singularName:   singularName,
+		newFunc:        newFunc,
+		newListFunc:    newListFunc,
+		attrFunc:       attrFunc,
+		policy:         policy,
+		watchers:       newWatchBroadcaster(),
+	}
+	b.startBookmarkTicker()
+	return b, nil
+}
+
+type boltREST struct {
+	rest.TableConvertor
+	groupResource schema.GroupResource
+	codec         runtime.Codec
+	db            *boltDatabase
+	// resource is the name of the bucket holding the objects, and the resource recorded in the change log.
+	resource     string
+	isNamespaced bool
+	singularName string
+
+	// mutex serializes writes and watch registrations, so events are delivered in revision order.
+	mutex    sync.Mutex
+	watchers *watchBroadcaster
+
+	newFunc     func() runtime.Object
+	newListFunc func() runtime.Object
+	attrFunc    storage.AttrFunc
+	// policy encrypts the objects selected, along with the change log entries holding them, and is nil if
+	// encryption isn't enabled.
+	policy *encryption.Policy
+}
+
+func (b *boltREST) GetSingularName() string {
+	return b.singularName
+}
+
+func (b *boltREST) Destroy() {
+	// The database is shared by all the resources, so it's left open.
+}
+
+func (b *boltREST) New() runtime.Object {
+	return b.newFunc()
+}
+
+func (b *boltREST) NewList() runtime.Object {
+	return b.newListFunc()
+}
+
+func (b *boltREST) NamespaceScoped() bool {
+	return b.isNamespaced
+}
+
+func (b *boltREST) startBookmarkTicker() {
+	if options.WatchBookmarkIntervalSecs <= 0 {
+		return
+	}
+	ticker := time.NewTicker(time.Duration(options.WatchBookmarkIntervalSecs) * time.Second)
+	go func(b *boltREST) {
+		for {
+			<-ticker.C
+			b.sendBookmarks()
+		}
+	}(b)
+}
+
+func (b *boltREST) sendBookmarks() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	var revision uint64
+	if err := b.db.db.View(func(tx *bolt.Tx) error {
+		revision = b.db.currentRevision(tx)
+		return nil
+	}); err != nil {
+		klog.Errorf("[%s] failed to read revision for bookmarks: %v", b.groupResource, err)
+		return
+	}
+	b.watchers.broadcastBookmark(b.newFunc, revision)
+}
+
+// notifyWatchers sends an event to all the watchers. It must be called with mutex held.
+func (b *boltREST) notifyWatchers(revision uint64, ev watch.Event, prevObj runtime.Object) {
+	accessor, _ := meta.Accessor(ev.Object)
+	klog.Infof("event %s %s %s/%s rv=%d count(watcher)=%d", ev.Type, ev.Object.GetObjectKind(), accessor.GetNamespace(), accessor.GetName(), revision, b.watchers.count())
+	b.watchers.broadcast(watchEvent{Event: ev, prevObject: prevObj})
+}
+
+func (b *boltREST) Get(
+	ctx context.Context,
+	name string,
+	options *metav1.GetOptions,
+) (runtime.Object, error) {
+	var obj runtime.Object
+	err := b.db.db.View(func(tx *bolt.Tx) error {
+		var err error
+		obj, err = b.getInTx(tx, b.objectKey(ctx, name))
+		return err
+	})
+	if err != nil {
+		return nil, toAPIError(err)
+	}
+	if obj == nil {
+		return nil, apierrors.NewNotFound(b.groupResource, name)
+	}
+	klog.Infof("[%s] %s got", b.groupResource, name)
+	return obj, nil
+}
+
+func (b *boltREST) List(
+	ctx context.Context,
+	options *metainternalversion.ListOptions,
+) (runtime.Object, error) {
+	token, err := decodeContinue(options)
+	if err != nil {
+		return nil, err
+	}
+	var list runtime.Object
+	err = b.db.db.View(func(tx *bolt.Tx) error {
+		if token != nil {
+			if err := b.checkContinueInTx(ctx, tx, token); err != nil {
+				return err
+			}
+		}
+		var err error
+		list, err = b.listInTx(ctx, tx, options)
+		return err
+	})
+	if err != nil {
+		return nil, toAPIError(err)
+	}
+	return paginate(list, options, token)
+}
+
+// checkContinueInTx verifies that the chunks following the continue token are still consistent with the first one.
+func (b *boltREST) checkContinueInTx(ctx context.Context, tx *bolt.Tx, token *continueToken) error {
+	_, changes, err := b.db.changesSince(tx, b.resource, token.Revision)
+	if err != nil {
+		return err
+	}
+	events := make([]watchEvent, 0, len(changes))
+	for _, change := range changes {
+		ev, err := b.decodeChange(change)
+		if err != nil {
+			return err
+		}
+		events = append(events, ev)
+	}
+	ns, _ := genericapirequest.NamespaceFrom(ctx)
+	return token.checkEvents(ns, events)
+}
+
+func (b *boltREST) listInTx(
+	ctx context.Context,
+	tx *bolt.Tx,
+	options *metainternalversion.ListOptions,
+) (runtime.Object, error) {
+	revision := b.db.currentRevision(tx)
+	if err := checkListResourceVersion(options, revision, b.groupResource); err != nil {
+		return nil, err
+	}
+
+	predicate := b.buildListPredicate(options)
+
+	newListObj := b.NewList()
+	v, err := getListPrt(newListObj)
+	if err != nil {
+		return nil, err
+	}
+
+	count := 0
+	if err := b.visitObjects(tx, b.listKeyPrefix(ctx), func(_ []byte, obj runtime.Object) error {
+		if ok, err := predicate.Matches(obj); err == nil && ok {
+			count++
+			appendItem(v, obj)
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	setListResourceVersion(newListObj, revision)
+
+	klog.Infof("[%s] list count=%d rv=%d", b.groupResource, count, revision)
+	return newListObj, nil
+}
+
+func (b *boltREST) Create(
+	ctx context.Context,
+	obj runtime.Object,
+	createValidation rest.ValidateObjectFunc,
+	options *metav1.CreateOptions,
+) (runtime.Object, error) {
+	if createValidation != nil {
+		if err := createValidation(ctx, obj); err != nil {
+			return nil, toAPIError(err)
+		}
+	}
+
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return nil, apierrors.NewInternalError(err)
+	}
+	name := accessor.GetName()
+	key := b.objectKey(ctx, name)
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	var revision uint64
+	err = b.db.db.Update(func(tx *bolt.Tx) error {
+		bucket, err := b.db.resourceBucket(tx, b.resource)
+		if err != nil {
+			return err
+		}
+		if bucket.Get(key) != nil {
+			return apierrors.NewAlreadyExists(b.groupResource, name)
+		}
+		b.prepareObjectMeta(ctx, accessor)
+		accessor.SetUID(uuid.NewUUID())
+		accessor.SetCreationTimestamp(metav1.NewTime(time.Now()))
+		if dryrun.IsDryRun(options.DryRun) {
+			return errDryRun
+		}
+		revision, err = b.db.nextRevision(tx)
+		if err != nil {
+			return err
+		}
+		accessor.SetResourceVersion(formatResourceVersion(revision))
+		data, err := b.putInTx(bucket, key, obj)
+		if err != nil {
+			return err
+		}
+		return b.db.appendChange(tx, revision, &boltChange{Resource: b.resource, Type: watch.Added, Object: data})
+	})
+	if errors.Is(err, errDryRun) {
+		return obj, nil
+	}
+	if err != nil {
+		return nil, toAPIError(err)
+	}
+
+	b.notifyWatchers(revision, watch.Event{
+		Type:   watch.Added,
+		Object: obj.DeepCopyObject(),
+	}, nil)
+	return obj, nil
+}
+
+func (b *boltREST) Update(
+	ctx context.Context,
+	name string,
+	objInfo rest.UpdatedObjectInfo,
+	createValidation rest.ValidateObjectFunc,
+	updateValidation rest.ValidateObjectUpdateFunc,
+	forceAllowCreate bool,
+	options *metav1.UpdateOptions,
+) (runtime.Object, bool, error) {
+	key := b.objectKey(ctx, name)
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	var revision uint64
+	var oldObj, updatedObj runtime.Object
+	isCreate := false
+	err := b.db.db.Update(func(tx *bolt.Tx) error {
+		bucket, err := b.db.resourceBucket(tx, b.resource)
+		if err != nil {
+			return err
+		}
+		oldObj, err = b.getInTx(tx, key)
+		if err != nil {
+			return err
+		}
+		if oldObj == nil {
+			if !forceAllowCreate {
+				return apierrors.NewNotFound(b.groupResource, name)
+			}
+			isCreate = true
+		}
+
+		updatedObj, err = objInfo.UpdatedObject(ctx, oldObj)
+		if err != nil {
+			return err
+		}
+		updatedAccessor, err := meta.Accessor(updatedObj)
+		if err != nil {
+			return err
+		}
+
+		change := &boltChange{Resource: b.resource}
+		if isCreate {
+			if createValidation != nil {
+				if err := createValidation(ctx, updatedObj); err != nil {
+					return err
+				}
+			}
+			updatedAccessor.SetUID(uuid.NewUUID())
+			updatedAccessor.SetCreationTimestamp(metav1.NewTime(time.Now()))
+			change.Type = watch.Added
+		} else {
+			if updateValidation != nil {
+				if err := updateValidation(ctx, updatedObj, oldObj); err != nil {
+					return err
+				}
+			}
+			oldAccessor, err := meta.Accessor(oldObj)
+			if err != nil {
+				return err
+			}
+			if updatedAccessor.GetResourceVersion() != "" && updatedAccessor.GetResourceVersion() != oldAccessor.GetResourceVersion() {
+				return apierrors.NewConflict(b.groupResource, name, errors.New(optimisticLockErrorMsg))
+			}
+			updatedAccessor.SetUID(oldAccessor.GetUID())
+			change.Type = watch.Modified
+			// Values read from bbolt are only valid until the next write, so keep a copy.
+			change.PrevObject = append([]byte(nil), bucket.Get(key)...)
+		}
+
+		b.prepareObjectMeta(ctx, updatedAccessor)
+		if dryrun.IsDryRun(options.DryRun) {
+			return errDryRun
+		}
+		revision, err = b.db.nextRevision(tx)
+		if err != nil {
+			return err
+		}
+		updatedAccessor.SetResourceVersion(formatResourceVersion(revision))
+		change.Object, err = b.putInTx(bucket, key, updatedObj)
+		if err != nil {
+			return err
+		}
+		return b.db.appendChange(tx, revision, change)
+	})
+	if errors.Is(err, errDryRun) {
+		return updatedObj, isCreate, nil
+	}
+	if err != nil {
+		return nil, false, toAPIError(err)
+	}
+
+	if isCreate {
+		b.notifyWatchers(revision, watch.Event{
+			Type:   watch.Added,
+			Object: updatedObj.DeepCopyObject(),
+		}, nil)
+	} else {
+		b.notifyWatchers(revision, watch.Event{
+			Type:   watch.Modified,
+			Object: updatedObj.DeepCopyObject(),
+		}, oldObj)
+	}
+	return updatedObj, isCreate, nil
+}
+
+func (b *boltREST) Delete(
+	ctx context.Context,
+	name string,
+	deleteValidation rest.ValidateObjectFunc,
+	options *metav1.DeleteOptions) (runtime.Object, bool, error) {
+	key := b.objectKey(ctx, name)
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	var revision uint64
+	var oldObj runtime.Object
+	err := b.db.db.Update(func(tx *bolt.Tx) error {
+		var err error
+		oldObj, err = b.getInTx(tx, key)
+		if err != nil {
+			return err
+		}
+		if oldObj == nil {
+			return apierrors.NewNotFound(b.groupResource, name)
+		}
+		if deleteValidation != nil {
+			if err := deleteValidation(ctx, oldObj); err != nil {
+				return err
+			}
+		}
+		if dryrun.IsDryRun(options.DryRun) {
+			return errDryRun
+		}
+		revision, err = b.deleteInTx(tx, key, oldObj)
+		return err
+	})
+	if errors.Is(err, errDryRun) {
+		return oldObj, true, nil
+	}
+	if err != nil {
+		return nil, false, toAPIError(err)
+	}
+
+	deletedObj := oldObj.DeepCopyObject()
+	setResourceVersion(deletedObj, revision)
+	b.notifyWatchers(revision, watch.Event{
+		Type:   watch.Deleted,
+		Object: deletedObj,
+	}, nil)
+	return oldObj, true, nil
+}
+
+func (b *boltREST) DeleteCollection(
+	ctx context.Context,
+	deleteValidation rest.ValidateObjectFunc,
+	options *metav1.DeleteOptions,
+	listOptions *metainternalversion.ListOptions,
+) (runtime.Object, error) {
+	predicate := b.buildListPredicate(listOptions)
+
+	newListObj := b.NewList()
+	v, err := getListPrt(newListObj)
+	if err != nil {
+		return nil, apierrors.NewInternalError(err)
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	var revisions []uint64
+	var deletedObjs []runtime.Object
+	err = b.db.db.Update(func(tx *bolt.Tx) error {
+		type entry struct {
+			key []byte
+			obj runtime.Object
+		}
+		var entries []entry
+		if err := b.visitObjects(tx, b.listKeyPrefix(ctx), func(key []byte, obj runtime.Object) error {
+			if ok, err := predicate.Matches(obj); err == nil && ok {
+				entries = append(entries, entry{key: append([]byte(nil), key...), obj: obj})
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+		for _, e := range entries {
+			if deleteValidation != nil {
+				if err := deleteValidation(ctx, e.obj); err != nil {
+					return err
+				}
+			}
+			deletedObjs = append(deletedObjs, e.obj)
+		}
+		if dryrun.IsDryRun(options.DryRun) {
+			return errDryRun
+		}
+		for _, e := range entries {
+			revision, err := b.deleteInTx(tx, e.key, e.obj)
+			if err != nil {
+				return err
+			}
+			revisions = append(revisions, revision)
+		}
+		return nil
+	})
+	if errors.Is(err, errDryRun) {
+		for _, obj := range deletedObjs {
+			appendItem(v, obj)
+		}
+		return newListObj, nil
+	}
+	if err != nil {
+		return nil, toAPIError(err)
+	}
+
+	for i, obj := range deletedObjs {
+		appendItem(v, obj)
+		deletedObj := obj.DeepCopyObject()
+		setResourceVersion(deletedObj, revisions[i])
+		b.notifyWatchers(revisions[i], watch.Event{
+			Type:   watch.Deleted,
+			Object: deletedObj,
+		}, nil)
+	}
+	return newListObj, nil
+}
+
+func (b *boltREST) Watch(ctx context.Context, options *metainternalversion.ListOptions) (watch.Interface, error) {
+	allowBookmarks := options != nil && options.AllowWatchBookmarks
+	ns := ""
+	if b.isNamespaced {
+		ns, _ = genericapirequest.NamespaceFrom(ctx)
+	}
+	filter := newSelectionFilter(ns, b.buildListPredicate(options))
+
+	// Hold the lock until the watcher is registered, so no event is missed or duplicated.
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	var initialEvents []watch.Event
+	err := b.db.db.View(func(tx *bolt.Tx) error {
+		if shouldSendInitialEvents(options) {
+			// On initial watch, send all the existing objects
+			list, err := b.listInTx(ctx, tx, options)
+			if err != nil {
+				return err
+			}
+			items, err := meta.ExtractList(list)
+			if err != nil {
+				return err
+			}
+			for _, item := range items {
+				initialEvents = append(initialEvents, watch.Event{
+					Type:   watch.Added,
+					Object: item,
+				})
+			}
+			if allowBookmarks && options.SendInitialEvents != nil {
+				initialEvents = append(initialEvents, newBookmarkEvent(b.newFunc, b.db.currentRevision(tx), true))
+			}
+			return nil
+		}
+		if options.ResourceVersion == "" || options.ResourceVersion == "0" {
+			return nil
+		}
+		// Resume from the given resource version
+		revision, err := parseResourceVersion(options.ResourceVersion)
+		if err != nil {
+			return err
+		}
+		if current := b.db.currentRevision(tx); revision > current {
+			return storeerr.InterpretListError(storage.NewTooLargeResourceVersionError(revision, current, 1), b.groupResource)
+		}
+		_, changes, err := b.db.changesSince(tx, b.resource, revision)
+		if err != nil {
+			return err
+		}
+		for _, change := range changes {
+			ev, err := b.decodeChange(change)
+			if err != nil {
+				return err
+			}
+			if filtered, ok := filter(ev); ok {
+				initialEvents = append(initialEvents, filtered)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, toAPIError(err)
+	}
+
+	return b.watchers.watch(ctx, initialEvents, filter, allowBookmarks), nil
+}
+
+func (b *boltREST) buildListPredicate(options *metainternalversion.ListOptions) storage.SelectionPredicate {
+	label := labels.Everything()
+	field := fields.Everything()
+	if options != nil {
+		if options.LabelSelector != nil {
+			label = options.LabelSelector
+		}
+		if options.FieldSelector != nil {
+			field = options.FieldSelector
+		}
+	}
+	return storage.SelectionPredicate{
+		Label:    label,
+		Field:    field,
+		GetAttrs: b.attrFunc,
+	}
+}
+
+// objectKey returns the key of an object in the resource bucket, which is "<namespace>/<name>" for namespaced
+// resources and "<name>" for cluster-scoped ones.
+func (b *boltREST) objectKey(ctx context.Context, name string) []byte {
+	if b.isNamespaced {
+		ns, _ := genericapirequest.NamespaceFrom(ctx)
+		return []byte(ns + "/" + name)
+	}
+	return []byte(name)
+}
+
+// listKeyPrefix returns the prefix of the keys of all the objects visible to a list request.
+func (b *boltREST) listKeyPrefix(ctx context.Context) []byte {
+	if b.isNamespaced {
+		if ns, ok := genericapirequest.NamespaceFrom(ctx); ok && ns != "" {
+			return []byte(ns + "/")
+		}
+	}
+	return nil
+}
+
+// prepareObjectMeta makes the namespace of an object consistent with the request it's written by.
+func (b *boltREST) prepareObjectMeta(ctx context.Context, accessor metav1.Object) {
+	if b.isNamespaced {
+		ns, _ := genericapirequest.NamespaceFrom(ctx)
+		accessor.SetNamespace(ns)
+	} else {
+		accessor.SetNamespace("")
+	}
+}
+
+func (b *boltREST) getInTx(tx *bolt.Tx, key []byte) (runtime.Object, error) {
+	bucket, err := b.db.resourceBucket(tx, b.resource)
+	if err != nil || bucket == nil {
+		return nil, err
+	}
+	data := bucket.Get(key)
+	if data == nil {
+		return nil, nil
+	}
+	return b.decode(data)
+}
+
+func (b *boltREST) putInTx(bucket *bolt.Bucket, key []byte, obj runtime.Object) ([]byte, error) {
+	data, err := b.encode(obj)
+	if err != nil {
+		return nil, err
+	}
+	if err := bucket.Put(key, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// deleteInTx removes an object and records its removal with a new revision.
+func (b *boltREST) deleteInTx(tx *bolt.Tx, key []byte, oldObj runtime.Object) (uint64, error) {
+	bucket, err := b.db.resourceBucket(tx, b.resource)
+	if err != nil {
+		return 0, err
+	}
+	revision, err := b.db.nextRevision(tx)
+	if err != nil {
+		return 0, err
+	}
+	if err := bucket.Delete(key); err != nil {
+		return 0, err
+	}
+	deletedObj := oldObj.DeepCopyObject()
+	setResourceVersion(deletedObj, revision)
+	data, err := b.encode(deletedObj)
+	if err != nil {
+		return 0, err
+	}
+	return revision, b.db.appendChange(tx, revision, &boltChange{Resource: b.resource, Type: watch.Deleted, Object: data})
+}
+
+// visitObjects decodes all the objects whose keys start with the given prefix, in key order.
+func (b *boltREST) visitObjects(tx *bolt.Tx, prefix []byte, visitFunc func([]byte, runtime.Object) error) error {
+	bucket, err := b.db.resourceBucket(tx, b.resource)
+	if err != nil || bucket == nil {
+		return err
+	}
+	c := bucket.Cursor()
+	for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+		obj, err := b.decode(v)
+		if err != nil {
+			return fmt.Errorf("failed to decode object [%s]: %v", k, err)
+		}
+		if err := visitFunc(k, obj); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *boltREST) encode(obj runtime.Object) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	if err := b.codec.Encode(obj, buf); err != nil {
+		return nil, err
+	}
+	data, err := b.policy.Encrypt(obj, buf.String())
+	if err != nil {
+		return nil, err
+	}
+	return []byte(data), nil
+}
+
+func (b *boltREST) decode(data []byte) (runtime.Object, error) {
+	decrypted, err := b.policy.Decrypt(string(data))
+	if err != nil {
+		return nil, err
+	}
+	obj, _, err := b.codec.Decode([]byte(decrypted), nil, b.newFunc())
+	return obj, err
+}
+
+// Reencrypt rewrites the objects not encrypted as the policy requires, e.g. not with the newest key, like updates.
+func (b *boltREST) Reencrypt(ctx context.Context) (int, error) {
+	if b.policy == nil {
+		return 0, nil
+	}
+	var staleObjs []runtime.Object
+	if err := b.db.db.View(func(tx *bolt.Tx) error {
+		bucket, err := b.db.resourceBucket(tx, b.resource)
+		if err != nil || bucket == nil {
+			return err
+		}
+		return bucket.ForEach(func(k, v []byte) error {
+			obj, err := b.decode(v)
+			if err != nil {
+				return fmt.Errorf("failed to decode object [%s]: %v", k, err)
+			}
+			if b.policy.IsStale(obj, string(v)) {
+				staleObjs = append(staleObjs, obj)
+			}
+			return nil
+		})
+	}); err != nil {
+		return 0, err
+	}
+	return reencryptObjects(ctx, b, b.groupResource, b.policy, staleObjs)
+}
+
+func (b *boltREST) decodeChange(change *boltChange) (watchEvent, error) {
+	obj, err := b.decode(change.Object)
+	if err != nil {
+		return watchEvent{}, err
+	}
+	ev := watchEvent{Event: watch.Event{Type: change.Type, Object: obj}}
+	if len(change.PrevObject) != 0 {
+		if ev.prevObject, err = b.decode(change.PrevObject); err != nil {
+			return watchEvent{}, err
+		}
+	}
+	return ev, nil
+}
+
+// toAPIError keeps errors already carrying an API status, turns failures to reach the store into
+// "503 Service Unavailable" errors, and the other ones into internal errors.
+func toAPIError(err error) error {
+	var statusErr apierrors.APIStatus
+	if errors.As(err, &statusErr) {
+		return err
+	}
+	if isConnectionError(err) {
+		return apierrors.NewServiceUnavailable(err.Error())
+	}
+	return apierrors.NewInternalError(err)
+}
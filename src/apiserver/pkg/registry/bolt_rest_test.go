@@ -0,0 +1,185 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metainternalversion "k8s.io/apimachinery/pkg/apis/meta/internalversion"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apiserver/pkg/registry/rest"
+
+	"github.com/alibaba/higress/api-server/pkg/encryption"
+	"github.com/alibaba/higress/api-server/pkg/options"
+)
+
+func newTestBoltREST(t *testing.T, path string, policy *encryption.Policy) *boltREST {
+	t.Helper()
+	storage, err := NewBoltREST(testGroupResource, testCodec, &options.BoltOptions{
+		Path:          path,
+		Timeout:       time.Second,
+		ChangeLogSize: 1000,
+	}, true, "configmap", newTestConfigMap, newTestConfigMapList, nil, policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return storage.(*boltREST)
+}
+
+func TestBoltRevisionsAndWatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "higress.db")
+	b := newTestBoltREST(t, path, nil)
+	a1 := mustCreate(t, b, "ns", "a", "v1")
+	w := mustWatch(t, b, "ns", a1.ResourceVersion)
+	b1 := mustCreate(t, b, "ns", "b", "v1")
+	mustUpdate(t, b, a1, "v2")
+	mustDelete(t, b, "ns", "b")
+	expectEvents(t, w, "ADDED b=v1", "MODIFIED a=v2", "DELETED b=v1")
+
+	// A watch resumed from a revision gets all the events after it.
+	resumed := mustWatch(t, b, "ns", b1.ResourceVersion)
+	expectEvents(t, resumed, "MODIFIED a=v2", "DELETED b=v1")
+
+	// The revisions are shared by all the resources stored in the database.
+	other, err := NewBoltREST(corev1.Resource("others"), testCodec, &options.BoltOptions{Path: path}, true, "configmap",
+		newTestConfigMap, newTestConfigMapList, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := mustCreate(t, other, "ns", "c", "v1")
+	if revisionOf(t, c) != revisionOf(t, b1)+3 {
+		t.Fatalf("object of another resource is created at revision %s, want the one following %s", c.ResourceVersion, b1.ResourceVersion)
+	}
+	expectNoEvent(t, w)
+}
+
+func TestBoltConflicts(t *testing.T) {
+	b := newTestBoltREST(t, filepath.Join(t.TempDir(), "higress.db"), nil)
+	a := mustCreate(t, b, "ns", "a", "v1")
+	if _, err := b.Create(nsContext("ns"), testConfigMap("ns", "a", "v2"), nil, &metav1.CreateOptions{}); !apierrors.IsAlreadyExists(err) {
+		t.Fatalf("creating an existing object returned %v, want already exists", err)
+	}
+	mustUpdate(t, b, a, "v2")
+	stale := a.DeepCopy()
+	stale.Data["key"] = "v3"
+	_, _, err := b.Update(nsContext("ns"), "a", rest.DefaultUpdatedObjectInfo(stale), nil, nil, false, &metav1.UpdateOptions{})
+	if !apierrors.IsConflict(err) {
+		t.Fatalf("update with a stale resource version returned %v, want a conflict", err)
+	}
+}
+
+func TestBoltChangeLogCompaction(t *testing.T) {
+	b, err := NewBoltREST(testGroupResource, testCodec, &options.BoltOptions{
+		Path:          filepath.Join(t.TempDir(), "higress.db"),
+		Timeout:       time.Second,
+		ChangeLogSize: 2,
+	}, true, "configmap", newTestConfigMap, newTestConfigMapList, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := mustCreate(t, b, "ns", "a", "v1")
+	for _, value := range []string{"v2", "v3", "v4"} {
+		a = mustUpdate(t, b, a, value)
+	}
+	// The changes dropped from the change log can't be watched any more, while the ones kept can.
+	if _, err := b.Watch(nsContext("ns"), &metainternalversion.ListOptions{ResourceVersion: "1"}); !apierrors.IsResourceExpired(err) {
+		t.Fatalf("watch from a compacted revision returned %v, want 410", err)
+	}
+	w := mustWatch(t, b, "ns", formatResourceVersion(revisionOf(t, a)-2))
+	expectEvents(t, w, "MODIFIED a=v3", "MODIFIED a=v4")
+}
+
+func TestBoltBackup(t *testing.T) {
+	dir := t.TempDir()
+	b := newTestBoltREST(t, filepath.Join(dir, "higress.db"), nil)
+	mustCreate(t, b, "ns", "a", "v1")
+	backupPath := filepath.Join(dir, "backup.db")
+	if err := b.db.backup(backupPath); err != nil {
+		t.Fatal(err)
+	}
+	restored := newTestBoltREST(t, backupPath, nil)
+	if got := mustList(t, restored, "ns", nil); listedNames(got) != "a" || got.Items[0].Data["key"] != "v1" {
+		t.Fatalf("listed %v from the backup", got.Items)
+	}
+}
+
+func TestBoltEncryption(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "higress.db")
+	b := newTestBoltREST(t, path, newTestPolicy(t, "key-1"))
+	a := mustCreate(t, b, "ns", "a", "secret-1")
+	w := mustWatch(t, b, "ns", a.ResourceVersion)
+	mustUpdate(t, b, a, "secret-2")
+	mustDelete(t, b, "ns", "a")
+	mustCreate(t, b, "ns", "b", "secret-3")
+	expectEvents(t, w, "MODIFIED a=secret-2", "DELETED a=secret-2", "ADDED b=secret-3")
+	if got := mustList(t, b, "ns", nil); len(got.Items) != 1 || got.Items[0].Data["key"] != "secret-3" {
+		t.Fatalf("listed %v", got.Items)
+	}
+	expectNoBoltPlaintext(t, b.db.db, "secret")
+
+	// The objects encrypted with an old key are re-encrypted with the newest one.
+	rotated := newTestBoltREST(t, path, newTestPolicy(t, "key-1", "key-2"))
+	count, err := rotated.Reencrypt(context.Background())
+	if err != nil || count != 1 {
+		t.Fatalf("Reencrypt() = %d, %v, want 1 object re-encrypted", count, err)
+	}
+	if count, err := rotated.Reencrypt(context.Background()); err != nil || count != 0 {
+		t.Fatalf("Reencrypt() = %d, %v again, want nothing re-encrypted", count, err)
+	}
+	expectNoBoltPlaintext(t, b.db.db, "secret")
+}
+
+// expectNoBoltPlaintext checks the given text isn't found in the objects or the change log stored in the database.
+func expectNoBoltPlaintext(t *testing.T, db *bolt.DB, text string) {
+	t.Helper()
+	var visit func(name string, bucket *bolt.Bucket) error
+	visit = func(name string, bucket *bolt.Bucket) error {
+		return bucket.ForEach(func(k, v []byte) error {
+			if v == nil {
+				return visit(name+"/"+string(k), bucket.Bucket(k))
+			}
+			values := [][]byte{v}
+			if name == string(boltChangeLogBucket) {
+				change := &boltChange{}
+				if err := json.Unmarshal(v, change); err != nil {
+					return err
+				}
+				values = [][]byte{change.Object, change.PrevObject}
+			}
+			for _, value := range values {
+				if strings.Contains(string(value), text) {
+					t.Errorf("%s/%s is stored in plaintext: %s", name, k, value)
+				}
+			}
+			return nil
+		})
+	}
+	if err := db.View(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{boltObjectsBucket, boltChangeLogBucket} {
+			if err := visit(string(name), tx.Bucket(name)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBoltDryRun(t *testing.T) {
+	testDryRun(t, newTestBoltREST(t, filepath.Join(t.TempDir(), "higress.db"), nil))
+}
+
+func TestBoltListChunks(t *testing.T) {
+	testListChunks(t, newTestBoltREST(t, filepath.Join(t.TempDir(), "higress.db"), nil))
+}
+
+func TestBoltServerSideApply(t *testing.T) {
+	testServerSideApply(t, newTestBoltREST(t, filepath.Join(t.TempDir(), "higress.db"), nil))
+}
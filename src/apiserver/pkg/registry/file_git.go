@@ -0,0 +1,186 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/format/index"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	genericapirequest "k8s.io/apiserver/pkg/endpoints/request"
+
+	"github.com/alibaba/higress/api-server/pkg/options"
+	"github.com/alibaba/higress/api-server/pkg/utils"
+)
+
+// GitCommitAnnotation holds the SHA of the git commit the object content is at, when file changes are committed.
+const GitCommitAnnotation = "higress.io/git-commit"
+
+var (
+	fileGitRepositories      = map[string]*fileGitRepository{}
+	fileGitRepositoriesMutex sync.Mutex
+)
+
+// fileGitRepository is the git repository containing the root dir of the file backend, shared by all the resources
+// stored in it. Every change made through the API server is committed into it, while changes pulled from remotes
+// are picked up by the file watchers like any other external change.
+type fileGitRepository struct {
+	mutex     sync.Mutex
+	repo      *git.Repository
+	worktree  *git.Worktree
+	committer object.Signature
+}
+
+func getFileGitRepository(fileOptions *options.FileOptions) (*fileGitRepository, error) {
+	fileGitRepositoriesMutex.Lock()
+	defer fileGitRepositoriesMutex.Unlock()
+
+	rootDir, err := filepath.Abs(fileOptions.RootDir)
+	if err != nil {
+		return nil, err
+	}
+	if r, ok := fileGitRepositories[rootDir]; ok {
+		return r, nil
+	}
+	repo, err := git.PlainOpenWithOptions(rootDir, &git.PlainOpenOptions{DetectDotGit: true})
+	if errors.Is(err, git.ErrRepositoryNotExists) {
+		if repo, err = git.PlainInit(rootDir, false); err == nil {
+			err = excludeRevisionFile(rootDir)
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open git repository [%s]: %v", rootDir, err)
+	}
+	worktree, err := repo.Worktree()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open git worktree [%s]: %v", rootDir, err)
+	}
+	r := &fileGitRepository{
+		repo:     repo,
+		worktree: worktree,
+		committer: object.Signature{
+			Name:  fileOptions.GitCommitterName,
+			Email: fileOptions.GitCommitterEmail,
+		},
+	}
+	fileGitRepositories[rootDir] = r
+	return r, nil
+}
+
+// excludeRevisionFile keeps the revision file, which changes on every write, out of a repository created by ourselves.
+func excludeRevisionFile(rootDir string) error {
+	infoDir := filepath.Join(rootDir, git.GitDirName, "info")
+	if err := utils.EnsureDir(infoDir); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(filepath.Join(infoDir, "exclude"), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+	_, err = file.WriteString("/" + revisionFileName + "\n")
+	return err
+}
+
+// head returns the SHA of the current HEAD commit, or an empty string if there's no commit yet.
+func (r *fileGitRepository) head() string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	ref, err := r.repo.Head()
+	if err != nil {
+		return ""
+	}
+	return ref.Hash().String()
+}
+
+// commit records the current content of the given files, which may have been removed, and returns the SHA of the commit.
+// The author is the user making the request if known, and the committer otherwise.
+func (r *fileGitRepository) commit(ctx context.Context, message string, paths ...string) (string, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	root := r.worktree.Filesystem.Root()
+	for _, path := range paths {
+		absPath, err := filepath.Abs(path)
+		if err != nil {
+			return "", err
+		}
+		relPath, err := filepath.Rel(root, absPath)
+		if err != nil {
+			return "", err
+		}
+		relPath = filepath.ToSlash(relPath)
+		if _, err := os.Stat(absPath); errors.Is(err, os.ErrNotExist) {
+			// A file never committed is fine to be removed.
+			if _, err := r.worktree.Remove(relPath); err != nil && !errors.Is(err, index.ErrEntryNotFound) {
+				return "", err
+			}
+		} else if _, err := r.worktree.Add(relPath); err != nil {
+			return "", err
+		}
+	}
+
+	now := time.Now()
+	committer := r.committer
+	committer.When = now
+	author := committer
+	if user, ok := genericapirequest.UserFrom(ctx); ok && user.GetName() != "" {
+		author = object.Signature{Name: user.GetName(), When: now}
+		if strings.Contains(user.GetName(), "@") {
+			author.Email = user.GetName()
+		}
+	}
+	// Removing the last file leaves the index empty, which is still a change to commit.
+	idx, err := r.repo.Storer.Index()
+	if err != nil {
+		return "", err
+	}
+	hash, err := r.worktree.Commit(message, &git.CommitOptions{
+		Author:            &author,
+		Committer:         &committer,
+		AllowEmptyCommits: len(idx.Entries) == 0,
+	})
+	if err != nil {
+		return "", err
+	}
+	return hash.String(), nil
+}
+
+func setGitCommit(obj runtime.Object, commit string) {
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return
+	}
+	annotations := accessor.GetAnnotations()
+	if commit == "" {
+		if _, ok := annotations[GitCommitAnnotation]; ok {
+			delete(annotations, GitCommitAnnotation)
+			accessor.SetAnnotations(annotations)
+		}
+		return
+	}
+	if annotations == nil {
+		annotations = make(map[string]string)
+	}
+	annotations[GitCommitAnnotation] = commit
+	accessor.SetAnnotations(annotations)
+}
+
+func getGitCommit(obj runtime.Object) string {
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return ""
+	}
+	return accessor.GetAnnotations()[GitCommitAnnotation]
+}
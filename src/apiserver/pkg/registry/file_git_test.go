@@ -0,0 +1,139 @@
+package registry
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apiserver/pkg/authentication/user"
+	genericapirequest "k8s.io/apiserver/pkg/endpoints/request"
+
+	"github.com/alibaba/higress/api-server/pkg/options"
+)
+
+func newTestGitFileREST(t *testing.T, rootDir string) *fileREST {
+	t.Helper()
+	return newTestFileRESTWithOptions(t, testGroupResource, &options.FileOptions{
+		RootDir:           rootDir,
+		Layout:            options.FileLayout_Namespaced,
+		GitCommit:         true,
+		GitCommitterName:  "Higress API Server",
+		GitCommitterEmail: "api-server@higress.io",
+	}, nil)
+}
+
+// expectHead checks the object is annotated with the HEAD commit of the repository, and returns the commit.
+func expectHead(t *testing.T, repo *git.Repository, obj *corev1.ConfigMap) *object.Commit {
+	t.Helper()
+	head, err := repo.Head()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := getGitCommit(obj); got != head.Hash().String() {
+		t.Fatalf("%s is annotated with commit %q, want HEAD %s", obj.Name, got, head.Hash())
+	}
+	commit, err := repo.CommitObject(head.Hash())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return commit
+}
+
+func TestFileGitCommits(t *testing.T) {
+	rootDir := t.TempDir()
+	f := newTestGitFileREST(t, rootDir)
+	repo, err := git.PlainOpen(rootDir)
+	if err != nil {
+		t.Fatalf("repository isn't created in the root dir: %v", err)
+	}
+
+	// The author of a commit is the user making the request.
+	ctx := genericapirequest.WithUser(nsContext("ns"), &user.DefaultInfo{Name: "alice@example.com"})
+	obj, err := f.Create(ctx, testConfigMap("ns", "a", "v1"), nil, &metav1.CreateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := obj.(*corev1.ConfigMap)
+	commit := expectHead(t, repo, a)
+	if commit.Author.Name != "alice@example.com" || commit.Author.Email != "alice@example.com" {
+		t.Fatalf("commit is authored by %s, want the requesting user", commit.Author)
+	}
+	if commit.Committer.Name != "Higress API Server" || commit.Message != "Create "+testGroupResource.String()+" ns/a" {
+		t.Fatalf("commit %q is committed by %s", commit.Message, commit.Committer)
+	}
+	if _, err := commit.File("configmaps/ns/a.yaml"); err != nil {
+		t.Fatalf("created file isn't committed: %v", err)
+	}
+	if _, err := commit.File(revisionFileName); err == nil {
+		t.Fatal("revision file is committed")
+	}
+	if got, err := f.Get(nsContext("ns"), "a", &metav1.GetOptions{}); err != nil || getGitCommit(got) != commit.Hash.String() {
+		t.Fatalf("got a annotated with commit %q, %v, want %s", getGitCommit(got), err, commit.Hash)
+	}
+
+	// The committer is the author when the user is unknown.
+	updated := mustUpdate(t, f, a, "v2")
+	commit = expectHead(t, repo, updated)
+	if commit.Author.Name != "Higress API Server" || commit.Message != "Update "+testGroupResource.String()+" ns/a" {
+		t.Fatalf("commit %q is authored by %s", commit.Message, commit.Author)
+	}
+	if len(commit.ParentHashes) != 1 || commit.ParentHashes[0].String() != getGitCommit(a) {
+		t.Fatalf("update commit has parents %v, want the create commit", commit.ParentHashes)
+	}
+
+	mustDelete(t, f, "ns", "a")
+	head, err := repo.Head()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if head.Hash() == commit.Hash {
+		t.Fatal("deletion isn't committed")
+	}
+	commit, err = repo.CommitObject(head.Hash())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := commit.File("configmaps/ns/a.yaml"); err != object.ErrFileNotFound {
+		t.Fatalf("deleted file is still committed: %v", err)
+	}
+}
+
+func TestFileGitPulledChanges(t *testing.T) {
+	rootDir := t.TempDir()
+	f := newTestGitFileREST(t, rootDir)
+	a := mustCreate(t, f, "ns", "a", "v1")
+	w := mustWatch(t, f, "ns", a.ResourceVersion)
+
+	// A change committed elsewhere and pulled lands in the worktree and the HEAD at once.
+	repo, err := git.PlainOpen(rootDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	worktree, err := repo.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, filepath.Join(rootDir, "configmaps", "ns", "b.yaml"), testConfigMap("ns", "b", "v1"))
+	if _, err := worktree.Add("configmaps/ns/b.yaml"); err != nil {
+		t.Fatal(err)
+	}
+	pulled, err := worktree.Commit("Add b", &git.CommitOptions{Author: &object.Signature{Name: "ci", Email: "ci@example.com"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ev := receiveEvents(t, w, 1)[0]
+	if got := testEvent(ev); got != "ADDED b=v1" {
+		t.Fatalf("watch received %q, want \"ADDED b=v1\"", got)
+	}
+	if got := getGitCommit(ev.Object); got != pulled.String() {
+		t.Fatalf("pulled object is annotated with commit %q, want %s", got, pulled)
+	}
+	if ref, err := repo.Reference(plumbing.HEAD, true); err != nil || ref.Hash() != pulled {
+		t.Fatalf("HEAD is %v, %v after the pull", ref, err)
+	}
+}
@@ -0,0 +1,102 @@
+package registry
+
+import (
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/selection"
+	"k8s.io/apiserver/pkg/storage"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	namespaceIndex = "namespace"
+	// labelIndex indexes objects by "<key>" and "<key>=<value>" of each label.
+	labelIndex = "label"
+	// fieldIndex indexes objects by "<field>=<value>" of each field returned by the AttrFunc, e.g. "type" of Secrets.
+	fieldIndex = "field"
+)
+
+func newFileCacheIndexers(attrFunc storage.AttrFunc) cache.Indexers {
+	return cache.Indexers{
+		namespaceIndex: func(obj interface{}) ([]string, error) {
+			accessor, err := meta.Accessor(obj.(*fileCacheEntry).obj)
+			if err != nil {
+				return nil, err
+			}
+			return []string{accessor.GetNamespace()}, nil
+		},
+		labelIndex: func(obj interface{}) ([]string, error) {
+			accessor, err := meta.Accessor(obj.(*fileCacheEntry).obj)
+			if err != nil {
+				return nil, err
+			}
+			values := make([]string, 0, 2*len(accessor.GetLabels()))
+			for k, v := range accessor.GetLabels() {
+				values = append(values, k, k+"="+v)
+			}
+			return values, nil
+		},
+		fieldIndex: func(obj interface{}) ([]string, error) {
+			_, fields, err := attrFunc(obj.(*fileCacheEntry).obj)
+			if err != nil {
+				return nil, err
+			}
+			values := make([]string, 0, len(fields))
+			for k, v := range fields {
+				values = append(values, k+"="+v)
+			}
+			return values, nil
+		},
+	}
+}
+
+// listCacheCandidates returns the cached entries which may match the given namespace and predicate,
+// using the most specific index available. Callers still need to check the returned entries against the predicate.
+func listCacheCandidates(store cache.ThreadSafeStore, ns string, predicate storage.SelectionPredicate) []interface{} {
+	if predicate.Field != nil {
+		for _, r := range predicate.Field.Requirements() {
+			if r.Operator == selection.Equals || r.Operator == selection.DoubleEquals {
+				if items, err := store.ByIndex(fieldIndex, r.Field+"="+r.Value); err == nil {
+					return items
+				}
+			}
+		}
+	}
+	if predicate.Label != nil {
+		if requirements, selectable := predicate.Label.Requirements(); selectable {
+			for _, r := range requirements {
+				var indexedValues []string
+				switch r.Operator() {
+				case selection.Equals, selection.DoubleEquals, selection.In:
+					for v := range r.Values() {
+						indexedValues = append(indexedValues, r.Key()+"="+v)
+					}
+				case selection.Exists:
+					indexedValues = append(indexedValues, r.Key())
+				default:
+					continue
+				}
+				if items, ok := listByIndexValues(store, labelIndex, indexedValues); ok {
+					return items
+				}
+			}
+		}
+	}
+	if ns != "" {
+		if items, err := store.ByIndex(namespaceIndex, ns); err == nil {
+			return items
+		}
+	}
+	return store.List()
+}
+
+func listByIndexValues(store cache.ThreadSafeStore, indexName string, indexedValues []string) ([]interface{}, bool) {
+	var items []interface{}
+	for _, v := range indexedValues {
+		matched, err := store.ByIndex(indexName, v)
+		if err != nil {
+			return nil, false
+		}
+		items = append(items, matched...)
+	}
+	return items, true
+}
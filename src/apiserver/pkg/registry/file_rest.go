@@ -9,7 +9,6 @@ import (
 	"os"
 	"path/filepath"
 	"reflect"
-	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -22,15 +21,19 @@ import (
 	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/uuid"
 	"k8s.io/apimachinery/pkg/watch"
 	genericapirequest "k8s.io/apiserver/pkg/endpoints/request"
 	"k8s.io/apiserver/pkg/registry/rest"
 	"k8s.io/apiserver/pkg/storage"
+	"k8s.io/apiserver/pkg/util/dryrun"
 	"k8s.io/klog/v2"
 
+	"github.com/alibaba/higress/api-server/pkg/encryption"
+	"github.com/alibaba/higress/api-server/pkg/options"
 	"github.com/alibaba/higress/api-server/pkg/utils"
 	"github.com/fsnotify/fsnotify"
-	"github.com/google/uuid"
+	"k8s.io/client-go/tools/cache"
 )
 
 const fileChangeProcessInterval = 100 * time.Millisecond
@@ -47,13 +50,14 @@ var _ rest.Storage = &fileREST{}
 func NewFileREST(
 	groupResource schema.GroupResource,
 	codec runtime.Codec,
-	rootPath string,
+	fileOptions *options.FileOptions,
 	extension string,
 	isNamespaced bool,
 	singularName string,
 	newFunc func() runtime.Object,
 	newListFunc func() runtime.Object,
 	attrFunc storage.AttrFunc,
+	policy *encryption.Policy,
 ) (REST, error) {
 	if attrFunc == nil {
 		if isNamespaced {
@@ -71,7 +75,7 @@ func NewFileREST(
 		TableConvertor: rest.NewDefaultTableConvertor(groupResource),
 		groupResource:  groupResource,
 		codec:          codec,
-		objRootPath:    filepath.Join(rootPath, strings.ToLower(groupResource.Resource)),
+		objRootPath:    filepath.Join(fileOptions.RootDir, strings.ToLower(groupResource.Resource)),
 		objExtension:   extension,
 		isNamespaced:   isNamespaced,
 		singularName:   singularName,
@@ -79,11 +83,27 @@ func NewFileREST(
 		newListFunc:    newListFunc,
 		attrFunc:       attrFunc,
 		dirWatcher:     watcher,
-		fileWatchers:   make(map[string]*fileWatch, 10),
+		watchers:       newWatchBroadcaster(),
+		policy:         policy,
+	}
+	// Cluster-scoped objects are always stored in the flat layout.
+	f.namespacedLayout = isNamespaced && fileOptions.Layout == options.FileLayout_Namespaced
+	f.serveFromCache = fileOptions.ServeFromCache
+	f.revision, err = getFileRevisionCounter(fileOptions.RootDir)
+	if err != nil {
+		return nil, err
+	}
+	if fileOptions.GitCommit {
+		f.git, err = getFileGitRepository(fileOptions)
+		if err != nil {
+			return nil, err
+		}
 	}
 	if err := f.startDirWatcher(); err != nil {
 		return nil, err
 	}
+	f.history = newEventHistory(options.WatchHistorySize, f.revision.current())
+	f.startBookmarkTicker()
 	return f, nil
 }
 
@@ -95,20 +115,44 @@ type fileREST struct {
 	objExtension  string
 	isNamespaced  bool
 	singularName  string
-
-	fileContentCache        map[string]runtime.Object
+	// namespacedLayout indicates objects are stored as <root>/<resource>/<namespace>/<name>.<ext>.
+	namespacedLayout bool
+	// serveFromCache indicates Get and List requests are served from fileContentCache instead of reading files.
+	serveFromCache bool
+	// git is the repository changes are committed to, or nil if committing is disabled.
+	git *fileGitRepository
+	// policy encrypts the files of the objects selected, and is nil if encryption isn't enabled.
+	policy *encryption.Policy
+
+	revision                *fileRevisionCounter
+	history                 *eventHistory
+	fileContentCache        cache.ThreadSafeStore
+	watchedNamespaceDirs    map[string]bool
 	pendingFileChanges      map[string]time.Time
 	fileChangeMutex         sync.Mutex
 	fileChangeProcessTicker *time.Ticker
 	dirWatcher              *fsnotify.Watcher
-	fileWatchers            map[string]*fileWatch
-	fileWatchersMutex       sync.RWMutex
+	watchers                *watchBroadcaster
 
 	newFunc     func() runtime.Object
 	newListFunc func() runtime.Object
 	attrFunc    storage.AttrFunc
 }
 
+func (f *fileREST) cachedEntry(path string) *fileCacheEntry {
+	if item, ok := f.fileContentCache.Get(path); ok {
+		return item.(*fileCacheEntry)
+	}
+	return nil
+}
+
+// fileCacheEntry holds the last known state of an object file.
+type fileCacheEntry struct {
+	obj runtime.Object
+	// digest is the MD5 of the file content, used to tell external changes from the ones made by ourselves.
+	digest string
+}
+
 func (f *fileREST) GetSingularName() string {
 	return f.singularName
 }
@@ -123,16 +167,49 @@ func (f *fileREST) startDirWatcher() error {
 	if err := utils.EnsureDir(f.objRootPath); err != nil {
 		return fmt.Errorf("unable to create data dir: %v", err)
 	}
+	if f.namespacedLayout {
+		if err := f.migrateFlatLayout(); err != nil {
+			return fmt.Errorf("failed to migrate data dir [%s] to namespaced layout: %v", f.objRootPath, err)
+		}
+	}
 	f.pendingFileChanges = make(map[string]time.Time)
-	f.fileContentCache = make(map[string]runtime.Object, 100)
-	if err := f.visitDir(f.objRootPath, f.objExtension, f.newFunc, f.codec, func(path string, obj runtime.Object) {
-		f.fileContentCache[path] = obj
+	f.fileContentCache = cache.NewThreadSafeStore(newFileCacheIndexers(f.attrFunc), cache.Indices{})
+	f.watchedNamespaceDirs = make(map[string]bool)
+	var syncErr error
+	gitHead := f.gitHead()
+	if err := f.visitDir(f.objRootPath, f.objExtension, f.newFunc, f.codec, func(path string, obj runtime.Object, digest string) {
+		if err := f.syncResourceVersion(obj); err != nil && syncErr == nil {
+			syncErr = err
+		}
+		if f.git != nil {
+			setGitCommit(obj, gitHead)
+		}
+		f.fileContentCache.Add(path, &fileCacheEntry{obj: obj, digest: digest})
 	}); err != nil {
 		return fmt.Errorf("failed to sync file cache [%s]: %v", f.objRootPath, err)
 	}
+	if syncErr != nil {
+		return fmt.Errorf("failed to sync revision [%s]: %v", f.objRootPath, syncErr)
+	}
 	if err := f.dirWatcher.Add(f.objRootPath); err != nil {
 		return fmt.Errorf("unable to watch data dir: %v", err)
 	}
+	if f.namespacedLayout {
+		entries, err := os.ReadDir(f.objRootPath)
+		if err != nil {
+			return fmt.Errorf("unable to read data dir: %v", err)
+		}
+		for _, entry := range entries {
+			if !entry.IsDir() {
+				continue
+			}
+			dir := filepath.Join(f.objRootPath, entry.Name())
+			if err := f.dirWatcher.Add(dir); err != nil {
+				return fmt.Errorf("unable to watch namespace dir [%s]: %v", entry.Name(), err)
+			}
+			f.watchedNamespaceDirs[dir] = true
+		}
+	}
 
 	if f.fileChangeProcessTicker == nil {
 		f.fileChangeProcessTicker = time.NewTicker(fileChangeProcessInterval)
@@ -164,7 +241,114 @@ func (f *fileREST) startDirWatcher() error {
 	return nil
 }
 
+// syncResourceVersion makes sure the revision counter isn't behind the resource version of a loaded object,
+// and assigns a new revision to the object if it doesn't have a valid one.
+func (f *fileREST) syncResourceVersion(obj runtime.Object) error {
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return err
+	}
+	if revision, err := parseResourceVersion(accessor.GetResourceVersion()); err == nil && revision != 0 {
+		return f.revision.observe(revision)
+	}
+	revision, err := f.revision.next()
+	if err != nil {
+		return err
+	}
+	accessor.SetResourceVersion(formatResourceVersion(revision))
+	return nil
+}
+
+func (f *fileREST) startBookmarkTicker() {
+	if options.WatchBookmarkIntervalSecs <= 0 {
+		return
+	}
+	ticker := time.NewTicker(time.Duration(options.WatchBookmarkIntervalSecs) * time.Second)
+	go func(f *fileREST) {
+		for {
+			<-ticker.C
+			f.sendBookmarks()
+		}
+	}(f)
+}
+
+func (f *fileREST) sendBookmarks() {
+	f.fileChangeMutex.Lock()
+	defer f.fileChangeMutex.Unlock()
+	f.watchers.broadcastBookmark(f.newFunc, f.revision.current())
+}
+
+// migrateFlatLayout moves objects stored in the flat layout into the directory of the default namespace.
+func (f *fileREST) migrateFlatLayout() error {
+	entries, err := os.ReadDir(f.objRootPath)
+	if err != nil {
+		return err
+	}
+	targetDir := filepath.Join(f.objRootPath, defaultNamespace)
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), f.objExtension) {
+			continue
+		}
+		if err := utils.EnsureDir(targetDir); err != nil {
+			return err
+		}
+		sourcePath := filepath.Join(f.objRootPath, entry.Name())
+		targetPath := filepath.Join(targetDir, entry.Name())
+		if utils.Exists(targetPath) {
+			klog.Warningf("[%s] skip migrating %s since %s already exists", f.groupResource, sourcePath, targetPath)
+			continue
+		}
+		if err := os.Rename(sourcePath, targetPath); err != nil {
+			return err
+		}
+		klog.Infof("[%s] migrated %s to %s", f.groupResource, sourcePath, targetPath)
+	}
+	return nil
+}
+
+// watchNamespaceDir starts watching a namespace dir and schedules all the files already in it for processing,
+// since they may be written before the watch is established.
+func (f *fileREST) watchNamespaceDir(dir string) {
+	f.fileChangeMutex.Lock()
+	defer f.fileChangeMutex.Unlock()
+
+	f.watchNamespaceDirLocked(dir)
+}
+
+func (f *fileREST) watchNamespaceDirLocked(dir string) {
+	if f.watchedNamespaceDirs[dir] {
+		return
+	}
+	if err := f.dirWatcher.Add(dir); err != nil {
+		klog.Errorf("unable to watch namespace dir [%s]: %v", dir, err)
+		return
+	}
+	f.watchedNamespaceDirs[dir] = true
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		klog.Errorf("unable to read namespace dir [%s]: %v", dir, err)
+		return
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), f.objExtension) {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		if f.cachedEntry(path) == nil {
+			f.pendingFileChanges[path] = time.Now()
+		}
+	}
+}
+
 func (f *fileREST) processDirWatcherEvents(event fsnotify.Event) {
+	if f.namespacedLayout && event.Has(fsnotify.Create) && filepath.Dir(event.Name) == f.objRootPath {
+		if info, err := os.Stat(event.Name); err == nil && info.IsDir() {
+			f.watchNamespaceDir(event.Name)
+			return
+		}
+	}
+
 	if !strings.HasSuffix(event.Name, f.objExtension) {
 		return
 	}
@@ -182,14 +366,21 @@ func (f *fileREST) processDirWatcherEvents(event fsnotify.Event) {
 			defer f.fileChangeMutex.Unlock()
 
 			delete(f.pendingFileChanges, event.Name)
-			obj := f.fileContentCache[event.Name]
-			delete(f.fileContentCache, event.Name)
+			entry := f.cachedEntry(event.Name)
+			f.fileContentCache.Delete(event.Name)
 
-			if obj != nil {
-				f.notifyWatchers(watch.Event{
+			if entry != nil {
+				revision, err := f.revision.next()
+				if err != nil {
+					klog.Errorf("failed to allocate revision for deleted file [%s]: %v", event.Name, err)
+					return
+				}
+				obj := entry.obj.DeepCopyObject()
+				setResourceVersion(obj, revision)
+				f.notifyWatchers(revision, watch.Event{
 					Type:   watch.Deleted,
 					Object: obj,
-				})
+				}, nil)
 			}
 		})()
 	}
@@ -207,29 +398,51 @@ func (f *fileREST) fileChangeProcessTickerFunc() {
 			pendingChangesToKeep[path] = t
 			continue
 		}
-		if obj, err := f.read(f.codec, path, f.newFunc); err == nil {
-			eventType := watch.Modified
-			if f.fileContentCache[path] == nil {
-				eventType = watch.Added
-			}
-			f.fileContentCache[path] = obj
-			f.notifyWatchers(watch.Event{
-				Type:   eventType,
-				Object: obj,
-			})
+		obj, digest, err := f.read(f.codec, path, f.newFunc)
+		if err != nil || obj == nil {
+			continue
+		}
+		entry := f.cachedEntry(path)
+		if entry != nil && entry.digest == digest {
+			// Written by ourselves or not changed at all.
+			continue
+		}
+		revision, err := f.revision.next()
+		if err != nil {
+			klog.Errorf("failed to allocate revision for changed file [%s]: %v", path, err)
+			continue
+		}
+		setResourceVersion(obj, revision)
+		if f.git != nil {
+			// The change is supposed to be pulled from a remote, so its content is at HEAD.
+			setGitCommit(obj, f.git.head())
+		}
+		eventType := watch.Modified
+		var prevObj runtime.Object
+		if entry == nil {
+			eventType = watch.Added
+		} else {
+			prevObj = entry.obj
 		}
+		f.fileContentCache.Add(path, &fileCacheEntry{obj: obj, digest: digest})
+		f.notifyWatchers(revision, watch.Event{
+			Type:   eventType,
+			Object: obj,
+		}, prevObj)
 	}
 	f.pendingFileChanges = pendingChangesToKeep
 }
 
-func (f *fileREST) notifyWatchers(ev watch.Event) {
-	f.fileWatchersMutex.RLock()
-	defer f.fileWatchersMutex.RUnlock()
+// notifyWatchers records an event into the history and sends it to all the watchers.
+// prevObj is the state of the object before a modification, if known.
+// It must be called with fileChangeMutex held, so events are delivered in revision order.
+func (f *fileREST) notifyWatchers(revision uint64, ev watch.Event, prevObj runtime.Object) {
+	wev := watchEvent{Event: ev, prevObject: prevObj}
+	f.history.add(revision, wev)
+
 	accessor, _ := meta.Accessor(ev.Object)
-	klog.Infof("event %s %s %s/%s count(watcher)=%d", ev.Type, ev.Object.GetObjectKind(), accessor.GetNamespace(), accessor.GetName(), len(f.fileWatchers))
-	for _, w := range f.fileWatchers {
-		w.ch <- ev
-	}
+	klog.Infof("event %s %s %s/%s rv=%d count(watcher)=%d", ev.Type, ev.Object.GetObjectKind(), accessor.GetNamespace(), accessor.GetName(), revision, f.watchers.count())
+	f.watchers.broadcast(wev)
 }
 
 func (f *fileREST) New() runtime.Object {
@@ -249,7 +462,29 @@ func (f *fileREST) Get(
 	name string,
 	options *metav1.GetOptions,
 ) (runtime.Object, error) {
-	obj, err := f.read(f.codec, f.objectFileName(ctx, name), f.newFunc)
+	f.fileChangeMutex.Lock()
+	defer f.fileChangeMutex.Unlock()
+
+	return f.getLocked(ctx, name)
+}
+
+func (f *fileREST) getLocked(ctx context.Context, name string) (runtime.Object, error) {
+	start := time.Now()
+	path := f.objectFileName(ctx, name)
+	var obj runtime.Object
+	var err error
+	if f.serveFromCache {
+		if entry := f.cachedEntry(path); entry != nil {
+			obj = entry.obj.DeepCopyObject()
+		}
+		defer observeFileRead(f.groupResource.String(), "get", readSourceCache, start)
+	} else {
+		obj, _, err = f.read(f.codec, path, f.newFunc)
+		if obj != nil {
+			f.applyCachedResourceVersion(path, obj)
+		}
+		defer observeFileRead(f.groupResource.String(), "get", readSourceDisk, start)
+	}
 	if obj == nil && err == nil {
 		requestInfo, ok := genericapirequest.RequestInfoFrom(ctx)
 		var groupResource = schema.GroupResource{}
@@ -266,6 +501,24 @@ func (f *fileREST) Get(
 	return obj, apierrors.NewInternalError(err)
 }
 
+// applyCachedResourceVersion sets the resource version of an object read from disk to the one assigned
+// when the file change was observed, since files changed externally may carry a stale resource version.
+// The git commit, which is never written into files, is taken from the cache as well.
+func (f *fileREST) applyCachedResourceVersion(path string, obj runtime.Object) {
+	entry := f.cachedEntry(path)
+	if entry == nil {
+		return
+	}
+	if accessor, err := meta.Accessor(entry.obj); err == nil {
+		if revision, err := parseResourceVersion(accessor.GetResourceVersion()); err == nil {
+			setResourceVersion(obj, revision)
+		}
+	}
+	if f.git != nil {
+		setGitCommit(obj, getGitCommit(entry.obj))
+	}
+}
+
 func (f *fileREST) normalizeObject() {
 
 }
@@ -274,16 +527,41 @@ func (f *fileREST) List(
 	ctx context.Context,
 	options *metainternalversion.ListOptions,
 ) (runtime.Object, error) {
-	label := labels.Everything()
-	if options != nil && options.LabelSelector != nil {
-		label = options.LabelSelector
+	token, err := decodeContinue(options)
+	if err != nil {
+		return nil, err
 	}
-	field := fields.Everything()
-	if options != nil && options.FieldSelector != nil {
-		field = options.FieldSelector
+
+	f.fileChangeMutex.Lock()
+	defer f.fileChangeMutex.Unlock()
+
+	if token != nil {
+		events, err := f.history.since(token.Revision)
+		if err == nil {
+			ns, _ := genericapirequest.NamespaceFrom(ctx)
+			err = token.checkEvents(ns, events)
+		}
+		if err != nil {
+			return nil, err
+		}
 	}
+	list, err := f.listLocked(ctx, options)
+	if err != nil {
+		return nil, err
+	}
+	return paginate(list, options, token)
+}
 
-	predicate := f.predicateFunc(label, field)
+func (f *fileREST) listLocked(
+	ctx context.Context,
+	options *metainternalversion.ListOptions,
+) (runtime.Object, error) {
+	revision := f.revision.current()
+	if err := checkListResourceVersion(options, revision, f.groupResource); err != nil {
+		return nil, err
+	}
+
+	predicate := f.buildListPredicate(options)
 
 	newListObj := f.NewList()
 	v, err := getListPrt(newListObj)
@@ -291,17 +569,50 @@ func (f *fileREST) List(
 		return nil, apierrors.NewInternalError(err)
 	}
 
-	count := 0
-	if err := f.visitDir(f.objRootPath, f.objExtension, f.newFunc, f.codec, func(path string, obj runtime.Object) {
-		if ok, err := predicate.Matches(obj); err == nil && ok {
+	start := time.Now()
+	count, scanned := 0, 0
+	if f.serveFromCache {
+		ns := ""
+		if f.namespacedLayout {
+			ns, _ = genericapirequest.NamespaceFrom(ctx)
+		}
+		var objs []runtime.Object
+		for _, item := range listCacheCandidates(f.fileContentCache, ns, predicate) {
+			obj := item.(*fileCacheEntry).obj
+			scanned++
+			if ns != "" {
+				if accessor, err := meta.Accessor(obj); err != nil || accessor.GetNamespace() != ns {
+					continue
+				}
+			}
+			if ok, err := predicate.Matches(obj); err == nil && ok {
+				objs = append(objs, obj)
+			}
+		}
+		sortObjects(objs)
+		for _, obj := range objs {
 			count++
-			appendItem(v, obj)
+			appendItem(v, obj.DeepCopyObject())
 		}
-	}); err != nil {
-		return nil, apierrors.NewInternalError(err)
+		observeFileRead(f.groupResource.String(), "list", readSourceCache, start)
+		observeFileList(f.groupResource.String(), readSourceCache, scanned, count)
+	} else {
+		if err := f.visitDir(f.listDir(ctx), f.objExtension, f.newFunc, f.codec, func(path string, obj runtime.Object, _ string) {
+			scanned++
+			if ok, err := predicate.Matches(obj); err == nil && ok {
+				f.applyCachedResourceVersion(path, obj)
+				count++
+				appendItem(v, obj)
+			}
+		}); err != nil {
+			return nil, apierrors.NewInternalError(err)
+		}
+		observeFileRead(f.groupResource.String(), "list", readSourceDisk, start)
+		observeFileList(f.groupResource.String(), readSourceDisk, scanned, count)
 	}
+	setListResourceVersion(newListObj, revision)
 
-	klog.Infof("[%s] list count=%d", f.groupResource, count)
+	klog.Infof("[%s] list count=%d rv=%d", f.groupResource, count, revision)
 
 	return newListObj, nil
 }
@@ -314,7 +625,7 @@ func (f *fileREST) Create(
 ) (runtime.Object, error) {
 	if createValidation != nil {
 		if err := createValidation(ctx, obj); err != nil {
-			return nil, apierrors.NewInternalError(err)
+			return nil, toAPIError(err)
 		}
 	}
 
@@ -338,27 +649,45 @@ func (f *fileREST) Create(
 
 	filename := f.objectFileName(ctx, name)
 
+	f.fileChangeMutex.Lock()
+	defer f.fileChangeMutex.Unlock()
+
 	if utils.Exists(filename) {
 		return nil, apierrors.NewConflict(f.groupResource, name, ErrItemAlreadyExists)
 	}
 
+	accessor.SetUID(uuid.NewUUID())
 	accessor.SetCreationTimestamp(metav1.NewTime(time.Now()))
-	accessor.SetResourceVersion("1")
+	if dryrun.IsDryRun(options.DryRun) {
+		return obj, nil
+	}
 
-	if err := utils.EnsureDir(f.objRootPath); err != nil {
-		panic(fmt.Sprintf("unable to create data dir: %s", err))
+	if err := utils.EnsureDir(filepath.Dir(filename)); err != nil {
+		return nil, apierrors.NewInternalError(fmt.Errorf("unable to create data dir: %v", err))
 	}
-	if err := f.write(f.codec, filename, obj); err != nil {
+	revision, err := f.revision.next()
+	if err != nil {
+		return nil, apierrors.NewInternalError(err)
+	}
+	accessor.SetResourceVersion(formatResourceVersion(revision))
+
+	if f.namespacedLayout {
+		f.watchNamespaceDirLocked(filepath.Dir(filename))
+	}
+	digest, err := f.write(f.codec, filename, obj)
+	if err != nil {
 		if errors.Is(err, fileBeingProcessedError) {
 			return nil, apierrors.NewConflict(f.groupResource, name, err)
 		}
 		return nil, apierrors.NewInternalError(err)
 	}
+	f.commitChange(ctx, "Create", obj, filename)
 
-	f.notifyWatchers(watch.Event{
+	f.fileContentCache.Add(filename, &fileCacheEntry{obj: obj.DeepCopyObject(), digest: digest})
+	f.notifyWatchers(revision, watch.Event{
 		Type:   watch.Added,
 		Object: obj,
-	})
+	}, nil)
 
 	return obj, nil
 }
@@ -372,10 +701,13 @@ func (f *fileREST) Update(
 	forceAllowCreate bool,
 	options *metav1.UpdateOptions,
 ) (runtime.Object, bool, error) {
+	f.fileChangeMutex.Lock()
+	defer f.fileChangeMutex.Unlock()
+
 	isCreate := false
-	oldObj, err := f.Get(ctx, name, nil)
+	oldObj, err := f.getLocked(ctx, name)
 	if err != nil {
-		if !forceAllowCreate {
+		if !forceAllowCreate || !apierrors.IsNotFound(err) {
 			return nil, false, err
 		}
 		isCreate = true
@@ -383,15 +715,10 @@ func (f *fileREST) Update(
 
 	updatedObj, err := objInfo.UpdatedObject(ctx, oldObj)
 	if err != nil {
-		return nil, false, apierrors.NewInternalError(err)
+		return nil, false, toAPIError(err)
 	}
 	filename := f.objectFileName(ctx, name)
 
-	oldAccessor, err := meta.Accessor(oldObj)
-	if err != nil {
-		return nil, false, apierrors.NewInternalError(err)
-	}
-
 	updatedAccessor, err := meta.Accessor(updatedObj)
 	if err != nil {
 		return nil, false, apierrors.NewInternalError(err)
@@ -400,29 +727,52 @@ func (f *fileREST) Update(
 	if isCreate {
 		if createValidation != nil {
 			if err := createValidation(ctx, updatedObj); err != nil {
-				return nil, false, apierrors.NewInternalError(err)
+				return nil, false, toAPIError(err)
 			}
 		}
 
+		updatedAccessor.SetUID(uuid.NewUUID())
 		updatedAccessor.SetCreationTimestamp(metav1.NewTime(time.Now()))
-		updatedAccessor.SetResourceVersion("1")
+		if dryrun.IsDryRun(options.DryRun) {
+			return updatedObj, true, nil
+		}
+
+		revision, err := f.revision.next()
+		if err != nil {
+			return nil, false, apierrors.NewInternalError(err)
+		}
+		updatedAccessor.SetResourceVersion(formatResourceVersion(revision))
 
-		if err := f.write(f.codec, filename, updatedObj); err != nil {
+		if err := utils.EnsureDir(filepath.Dir(filename)); err != nil {
+			return nil, false, apierrors.NewInternalError(err)
+		}
+		if f.namespacedLayout {
+			f.watchNamespaceDirLocked(filepath.Dir(filename))
+		}
+		digest, err := f.write(f.codec, filename, updatedObj)
+		if err != nil {
 			if errors.Is(err, fileBeingProcessedError) {
 				return nil, false, apierrors.NewConflict(f.groupResource, name, err)
 			}
 			return nil, false, apierrors.NewInternalError(err)
 		}
-		f.notifyWatchers(watch.Event{
+		f.commitChange(ctx, "Create", updatedObj, filename)
+		f.fileContentCache.Add(filename, &fileCacheEntry{obj: updatedObj.DeepCopyObject(), digest: digest})
+		f.notifyWatchers(revision, watch.Event{
 			Type:   watch.Added,
 			Object: updatedObj,
-		})
+		}, nil)
 		return updatedObj, true, nil
 	}
 
+	oldAccessor, err := meta.Accessor(oldObj)
+	if err != nil {
+		return nil, false, apierrors.NewInternalError(err)
+	}
+
 	if updateValidation != nil {
 		if err := updateValidation(ctx, updatedObj, oldObj); err != nil {
-			return nil, false, apierrors.NewInternalError(err)
+			return nil, false, toAPIError(err)
 		}
 	}
 
@@ -437,29 +787,31 @@ func (f *fileREST) Update(
 		return nil, false, apierrors.NewConflict(groupResource, name, nil)
 	}
 
-	var newResourceVersion uint64
-	if currentResourceVersion == "" {
-		newResourceVersion = 1
-	} else {
-		newResourceVersion, err = strconv.ParseUint(currentResourceVersion, 10, 64)
-		if err != nil {
-			return nil, false, apierrors.NewInternalError(err)
-		}
-		newResourceVersion++
+	updatedAccessor.SetUID(oldAccessor.GetUID())
+	if dryrun.IsDryRun(options.DryRun) {
+		return updatedObj, false, nil
+	}
+
+	revision, err := f.revision.next()
+	if err != nil {
+		return nil, false, apierrors.NewInternalError(err)
 	}
-	updatedAccessor.SetResourceVersion(strconv.FormatUint(newResourceVersion, 10))
+	updatedAccessor.SetResourceVersion(formatResourceVersion(revision))
 
-	if err := f.write(f.codec, filename, updatedObj); err != nil {
+	digest, err := f.write(f.codec, filename, updatedObj)
+	if err != nil {
 		if errors.Is(err, fileBeingProcessedError) {
 			return nil, false, apierrors.NewConflict(f.groupResource, name, err)
 		}
 		return nil, false, apierrors.NewInternalError(err)
 	}
+	f.commitChange(ctx, "Update", updatedObj, filename)
 
-	f.notifyWatchers(watch.Event{
+	f.fileContentCache.Add(filename, &fileCacheEntry{obj: updatedObj.DeepCopyObject(), digest: digest})
+	f.notifyWatchers(revision, watch.Event{
 		Type:   watch.Modified,
 		Object: updatedObj,
-	})
+	}, oldObj)
 	return updatedObj, false, nil
 }
 
@@ -469,11 +821,15 @@ func (f *fileREST) Delete(
 	deleteValidation rest.ValidateObjectFunc,
 	options *metav1.DeleteOptions) (runtime.Object, bool, error) {
 	filename := f.objectFileName(ctx, name)
+
+	f.fileChangeMutex.Lock()
+	defer f.fileChangeMutex.Unlock()
+
 	if !utils.Exists(filename) {
 		return nil, false, apierrors.NewNotFound(f.groupResource, name)
 	}
 
-	oldObj, err := f.Get(ctx, name, nil)
+	oldObj, err := f.getLocked(ctx, name)
 	if err != nil {
 		return nil, false, err
 	}
@@ -482,14 +838,25 @@ func (f *fileREST) Delete(
 			return nil, false, apierrors.NewBadRequest(err.Error())
 		}
 	}
+	if dryrun.IsDryRun(options.DryRun) {
+		return oldObj, true, nil
+	}
 
+	revision, err := f.revision.next()
+	if err != nil {
+		return nil, false, apierrors.NewInternalError(err)
+	}
 	if err := os.Remove(filename); err != nil {
 		return nil, false, apierrors.NewInternalError(err)
 	}
-	f.notifyWatchers(watch.Event{
+	f.fileContentCache.Delete(filename)
+	deletedObj := oldObj.DeepCopyObject()
+	setResourceVersion(deletedObj, revision)
+	f.commitChange(ctx, "Delete", deletedObj, filename)
+	f.notifyWatchers(revision, watch.Event{
 		Type:   watch.Deleted,
-		Object: oldObj,
-	})
+		Object: deletedObj,
+	}, nil)
 	return oldObj, true, nil
 }
 
@@ -499,36 +866,132 @@ func (f *fileREST) DeleteCollection(
 	options *metav1.DeleteOptions,
 	listOptions *metainternalversion.ListOptions,
 ) (runtime.Object, error) {
+	listObj, err := f.List(ctx, listOptions)
+	if err != nil {
+		return nil, err
+	}
+	items, err := getListPrt(listObj)
+	if err != nil {
+		return nil, apierrors.NewInternalError(err)
+	}
 	newListObj := f.NewList()
 	v, err := getListPrt(newListObj)
 	if err != nil {
 		return nil, apierrors.NewInternalError(err)
 	}
-	if err := f.visitDir(f.objRootPath, f.objExtension, f.newFunc, f.codec, func(path string, obj runtime.Object) {
-		_ = os.Remove(path)
-		appendItem(v, obj)
-	}); err != nil {
-		return nil, apierrors.NewInternalError(fmt.Errorf("failed walking filepath %v: %v", f.objRootPath, err))
+	// The objects are deleted one by one like single deletions, so each of them is validated, recorded with a revision
+	// and notified to the watchers.
+	for i := 0; i < items.Len(); i++ {
+		accessor, err := meta.Accessor(listItemToRuntimeObject(items.Index(i)))
+		if err != nil {
+			return nil, apierrors.NewInternalError(err)
+		}
+		objCtx := genericapirequest.WithNamespace(ctx, accessor.GetNamespace())
+		deletedObj, _, err := f.Delete(objCtx, accessor.GetName(), deleteValidation, options)
+		if apierrors.IsNotFound(err) {
+			// Deleted concurrently.
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		appendItem(v, deletedObj)
 	}
 	return newListObj, nil
 }
 
+// Reencrypt rewrites the files not encrypted as the policy requires, e.g. not with the newest key, like updates,
+// so watchers are notified of the new resource versions.
+func (f *fileREST) Reencrypt(ctx context.Context) (int, error) {
+	if f.policy == nil {
+		return 0, nil
+	}
+	var staleObjs []runtime.Object
+	if err := f.visitDir(f.objRootPath, f.objExtension, f.newFunc, f.codec, func(path string, obj runtime.Object, _ string) {
+		content, err := os.ReadFile(path)
+		if err == nil && f.policy.IsStale(obj, string(content)) {
+			staleObjs = append(staleObjs, obj)
+		}
+	}); err != nil {
+		return 0, fmt.Errorf("failed walking filepath %v: %v", f.objRootPath, err)
+	}
+	return reencryptObjects(ctx, f, f.groupResource, f.policy, staleObjs)
+}
+
 func (f *fileREST) objectFileName(ctx context.Context, name string) string {
+	if f.namespacedLayout {
+		ns, ok := genericapirequest.NamespaceFrom(ctx)
+		if !ok || ns == "" {
+			ns = defaultNamespace
+		}
+		return filepath.Join(f.objRootPath, ns, name+f.objExtension)
+	}
 	// Namespace is ignored here. The filepath is not namespaced.
-	//if f.isNamespaced {
-	//	// FIXME: return error if namespace is not found
-	//	ns, _ := genericapirequest.NamespaceFrom(ctx)
-	//	return filepath.Join(f.objRootPath, ns, name+f.objExtension)
-	//}
 	return filepath.Join(f.objRootPath, name+f.objExtension)
 }
 
-func (f *fileREST) write(encoder runtime.Encoder, filepath string, obj runtime.Object) error {
+// listDir returns the dir containing all the objects visible to a list request.
+func (f *fileREST) listDir(ctx context.Context) string {
+	if f.namespacedLayout {
+		if ns, ok := genericapirequest.NamespaceFrom(ctx); ok && ns != "" {
+			return filepath.Join(f.objRootPath, ns)
+		}
+	}
+	return f.objRootPath
+}
+
+// isNamespaceDir tells whether the given path is a namespace dir under the namespaced layout.
+func (f *fileREST) isNamespaceDir(path string) bool {
+	return f.namespacedLayout && filepath.Dir(path) == f.objRootPath
+}
+
+// gitHead returns the SHA of the HEAD commit if changes are committed to git, or an empty string.
+func (f *fileREST) gitHead() string {
+	if f.git == nil {
+		return ""
+	}
+	return f.git.head()
+}
+
+// commitChange commits the files changed by a request if enabled, and sets the commit on the changed object if any.
+// A failure is only logged, since the change has already been made.
+func (f *fileREST) commitChange(ctx context.Context, action string, obj runtime.Object, paths ...string) {
+	if f.git == nil {
+		return
+	}
+	message := fmt.Sprintf("%s %s", action, f.groupResource)
+	if obj != nil {
+		if key, err := cache.MetaNamespaceKeyFunc(obj); err == nil {
+			message += " " + key
+		}
+	}
+	commit, err := f.git.commit(ctx, message, paths...)
+	if err != nil {
+		klog.Errorf("[%s] failed to commit change [%s]: %v", f.groupResource, message, err)
+		return
+	}
+	if obj != nil {
+		setGitCommit(obj, commit)
+	}
+}
+
+// write encodes the object into the given file and returns the MD5 of the file content.
+func (f *fileREST) write(encoder runtime.Encoder, filepath string, obj runtime.Object) (string, error) {
 	f.normalizeObjectMeta(obj, filepath)
+	if f.git != nil {
+		// The commit is only known after the file is written.
+		setGitCommit(obj, "")
+	}
 	buf := new(bytes.Buffer)
 	if err := encoder.Encode(obj, buf); err != nil {
-		return err
+		return "", err
+	}
+	content, err := f.policy.Encrypt(obj, buf.String())
+	if err != nil {
+		return "", err
 	}
+	buf = bytes.NewBufferString(content)
+	digest := calculateMd5(content)
 
 	tmpFilepath := filepath + ".tmp"
 	tmpFileWriteRetried := false
@@ -539,39 +1002,44 @@ func (f *fileREST) write(encoder runtime.Encoder, filepath string, obj runtime.O
 		}
 		if !errors.Is(writeErr, fileBeingProcessedError) {
 			klog.Errorf("failed to write temp file [%s]: %v", tmpFilepath, writeErr)
-			return writeErr
+			return "", writeErr
 		}
 		klog.Warningf("temp file already exists: %s", tmpFilepath)
 		tmpFileInfo, statErr := os.Stat(tmpFilepath)
 		if statErr != nil {
 			klog.Errorf("failed to stat temp file [%s]: %v", tmpFilepath, statErr)
-			return writeErr
+			return "", writeErr
 		}
 		if !tmpFileInfo.ModTime().Add(tmpFileTtl).Before(time.Now()) {
 			klog.Infof("temp file [%s] mod time: %v. still within TTL %dms. leave it there.", tmpFilepath,
 				tmpFileInfo.ModTime().Format(time.RFC3339), tmpFileTtl.Milliseconds())
-			return writeErr
+			return "", writeErr
 		}
 		klog.Infof("temp file [%s] mod time: %v. already beyond TTL %dms. delete it.", tmpFilepath,
 			tmpFileInfo.ModTime().Format(time.RFC3339), tmpFileTtl.Milliseconds())
 		if removeErr := os.Remove(tmpFilepath); removeErr != nil {
 			klog.Errorf("failed to remove temp file [%s]: %v", tmpFilepath, removeErr)
-			return writeErr
+			return "", writeErr
 		}
 		if tmpFileWriteRetried {
-			return writeErr
+			return "", writeErr
 		}
 		tmpFileWriteRetried = true
 	}
 	if err := os.Rename(tmpFilepath, filepath); err != nil {
 		_ = os.Remove(tmpFilepath)
-		return err
+		return "", err
 	}
-	return nil
+	return digest, nil
 }
 
 func (f *fileREST) writeTempFile(buf *bytes.Buffer, tmpFilepath string) error {
-	tmpFile, err := os.OpenFile(tmpFilepath, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0755)
+	var mode os.FileMode = 0644
+	if f.policy.Encrypts() {
+		// Keep files of sensitive resources private to the API server, whether they're encrypted or not.
+		mode = 0600
+	}
+	tmpFile, err := os.OpenFile(tmpFilepath, os.O_RDWR|os.O_CREATE|os.O_EXCL, mode)
 	if err != nil {
 		pathError := &fs.PathError{}
 		if ok := errors.As(err, &pathError); ok && errors.Is(pathError.Err, fs.ErrExist) {
@@ -588,26 +1056,31 @@ func (f *fileREST) writeTempFile(buf *bytes.Buffer, tmpFilepath string) error {
 	return err
 }
 
-func (f *fileREST) read(decoder runtime.Decoder, path string, newFunc func() runtime.Object) (runtime.Object, error) {
+// read decodes the object stored in the given file and returns it along with the MD5 of the file content.
+func (f *fileREST) read(decoder runtime.Decoder, path string, newFunc func() runtime.Object) (runtime.Object, string, error) {
 	cleanedPath := filepath.Clean(path)
 	if _, err := os.Stat(cleanedPath); err != nil {
 		if errors.Is(err, os.ErrNotExist) {
-			return nil, nil
+			return nil, "", nil
 		} else {
-			return nil, fmt.Errorf("failed to stat file [%s]: %v", path, err)
+			return nil, "", fmt.Errorf("failed to stat file [%s]: %v", path, err)
 		}
 	}
 	content, err := os.ReadFile(cleanedPath)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read file [%s]: %v", path, err)
+		return nil, "", fmt.Errorf("failed to read file [%s]: %v", path, err)
+	}
+	decryptedContent, err := f.policy.Decrypt(string(content))
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to decrypt data read from file [%s]: %v", path, err)
 	}
 	newObj := newFunc()
-	decodedObj, _, err := decoder.Decode(content, nil, newObj)
+	decodedObj, _, err := decoder.Decode([]byte(decryptedContent), nil, newObj)
 	if err != nil {
-		return nil, fmt.Errorf("failed to decode data read from file [%s]: %v\n%s", path, err, content)
+		return nil, "", fmt.Errorf("failed to decode data read from file [%s]: %v\n%s", path, err, content)
 	}
 	f.normalizeObjectMeta(decodedObj, cleanedPath)
-	return decodedObj, nil
+	return decodedObj, calculateMd5(string(content)), nil
 }
 
 func (f *fileREST) normalizeObjectMeta(obj runtime.Object, path string) {
@@ -615,7 +1088,9 @@ func (f *fileREST) normalizeObjectMeta(obj runtime.Object, path string) {
 	if err != nil {
 		return
 	}
-	if f.isNamespaced {
+	if f.namespacedLayout {
+		accessor.SetNamespace(filepath.Base(filepath.Dir(path)))
+	} else if f.isNamespaced {
 		accessor.SetNamespace(defaultNamespace)
 	} else {
 		accessor.SetNamespace("")
@@ -623,55 +1098,99 @@ func (f *fileREST) normalizeObjectMeta(obj runtime.Object, path string) {
 	accessor.SetName(strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)))
 }
 
-func (f *fileREST) visitDir(dirname string, extension string, newFunc func() runtime.Object, codec runtime.Decoder, visitFunc func(string, runtime.Object)) error {
+func (f *fileREST) visitDir(dirname string, extension string, newFunc func() runtime.Object, codec runtime.Decoder, visitFunc func(string, runtime.Object, string)) error {
+	if !utils.Exists(dirname) {
+		return nil
+	}
 	return filepath.Walk(dirname, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
 		if info.IsDir() {
+			if path != dirname && !f.isNamespaceDir(path) {
+				return filepath.SkipDir
+			}
 			return nil
 		}
 		if !strings.HasSuffix(info.Name(), extension) {
 			return nil
 		}
-		newObj, err := f.read(codec, path, newFunc)
+		if f.namespacedLayout && !f.isNamespaceDir(filepath.Dir(path)) {
+			return nil
+		}
+		newObj, digest, err := f.read(codec, path, newFunc)
 		if err != nil {
 			return fmt.Errorf("failed to load data from file [%s]: %v", path, err)
 		}
-		visitFunc(path, newObj)
+		if newObj == nil {
+			// Removed after being walked through
+			return nil
+		}
+		visitFunc(path, newObj, digest)
 		return nil
 	})
 }
 
 func (f *fileREST) Watch(ctx context.Context, options *metainternalversion.ListOptions) (watch.Interface, error) {
-	fw := &fileWatch{
-		id: uuid.New().String(),
-		f:  f,
-		ch: make(chan watch.Event, 10),
-	}
-	// On initial watch, send all the existing objects
-	list, err := f.List(ctx, options)
-	if err != nil {
-		return nil, err
+	allowBookmarks := options != nil && options.AllowWatchBookmarks
+	ns := ""
+	if f.namespacedLayout {
+		ns, _ = genericapirequest.NamespaceFrom(ctx)
 	}
+	filter := newSelectionFilter(ns, f.buildListPredicate(options))
 
-	go func() {
+	// Hold the lock until the watcher is registered, so no event is missed or duplicated.
+	f.fileChangeMutex.Lock()
+	defer f.fileChangeMutex.Unlock()
+
+	var initialEvents []watch.Event
+	if shouldSendInitialEvents(options) {
+		// On initial watch, send all the existing objects
+		list, err := f.listLocked(ctx, options)
+		if err != nil {
+			return nil, err
+		}
 		danger := reflect.ValueOf(list).Elem()
 		items := danger.FieldByName("Items")
-
 		for i := 0; i < items.Len(); i++ {
-			fw.ch <- watch.Event{
+			initialEvents = append(initialEvents, watch.Event{
 				Type:   watch.Added,
 				Object: listItemToRuntimeObject(items.Index(i)),
+			})
+		}
+		if allowBookmarks && options.SendInitialEvents != nil {
+			initialEvents = append(initialEvents, newBookmarkEvent(f.newFunc, f.revision.current(), true))
+		}
+	} else if options.ResourceVersion != "" && options.ResourceVersion != "0" {
+		// Resume from the given resource version
+		revision, err := parseResourceVersion(options.ResourceVersion)
+		if err != nil {
+			return nil, err
+		}
+		events, err := f.history.since(revision)
+		if err != nil {
+			return nil, err
+		}
+		for _, ev := range events {
+			if filtered, ok := filter(ev); ok {
+				initialEvents = append(initialEvents, filtered)
 			}
 		}
-	}()
+	}
 
-	f.fileWatchersMutex.Lock()
-	f.fileWatchers[fw.id] = fw
-	f.fileWatchersMutex.Unlock()
+	return f.watchers.watch(ctx, initialEvents, filter, allowBookmarks), nil
+}
 
-	return fw, nil
+func (f *fileREST) buildListPredicate(options *metainternalversion.ListOptions) storage.SelectionPredicate {
+	label := labels.Everything()
+	if options != nil && options.LabelSelector != nil {
+		label = options.LabelSelector
+	}
+	field := fields.Everything()
+	if options != nil && options.FieldSelector != nil {
+		field = options.FieldSelector
+	}
+	return f.predicateFunc(label, field)
 }
 
 func (f *fileREST) predicateFunc(label labels.Selector, field fields.Selector) storage.SelectionPredicate {
@@ -682,22 +1201,6 @@ func (f *fileREST) predicateFunc(label labels.Selector, field fields.Selector) s
 	}
 }
 
-type fileWatch struct {
-	f  *fileREST
-	id string
-	ch chan watch.Event
-}
-
-func (w *fileWatch) Stop() {
-	w.f.fileWatchersMutex.Lock()
-	delete(w.f.fileWatchers, w.id)
-	w.f.fileWatchersMutex.Unlock()
-}
-
-func (w *fileWatch) ResultChan() <-chan watch.Event {
-	return w.ch
-}
-
 // TODO: implement custom table printer optionally
 // func (f *fileREST) ConvertToTable(ctx context.Context, object runtime.Object, tableOptions runtime.Object) (*metav1.Table, error) {
 // 	return &metav1.Table{}, nil
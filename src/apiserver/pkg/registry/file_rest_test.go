@@ -0,0 +1,397 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metainternalversion "k8s.io/apimachinery/pkg/apis/meta/internalversion"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apiserver/pkg/registry/rest"
+
+	"github.com/alibaba/higress/api-server/pkg/encryption"
+	"github.com/alibaba/higress/api-server/pkg/options"
+)
+
+func newTestFileREST(t *testing.T, rootDir, layout string) *fileREST {
+	t.Helper()
+	return newTestFileRESTWithOptions(t, testGroupResource, &options.FileOptions{
+		RootDir: rootDir,
+		Layout:  layout,
+	}, nil)
+}
+
+func newTestFileRESTWithOptions(t *testing.T, groupResource schema.GroupResource, fileOptions *options.FileOptions, policy *encryption.Policy) *fileREST {
+	t.Helper()
+	storage, err := NewFileREST(groupResource, testCodec, fileOptions, ".yaml", true, "configmap", newTestConfigMap, newTestConfigMapList, nil, policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(storage.Destroy)
+	return storage.(*fileREST)
+}
+
+// writeTestFile writes an object into a file behind the back of the storages.
+func writeTestFile(t *testing.T, path string, obj runtime.Object) {
+	t.Helper()
+	data, err := runtime.Encode(testCodec, obj)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFileNamespacedLayout(t *testing.T) {
+	rootDir := t.TempDir()
+	resourceDir := filepath.Join(rootDir, "configmaps")
+	// The objects stored in the flat layout are moved into the dir of the default namespace.
+	writeTestFile(t, filepath.Join(resourceDir, "flat.yaml"), testConfigMap("", "flat", "v1"))
+	f := newTestFileREST(t, rootDir, options.FileLayout_Namespaced)
+	if _, err := os.Stat(filepath.Join(resourceDir, defaultNamespace, "flat.yaml")); err != nil {
+		t.Fatalf("flat object isn't migrated: %v", err)
+	}
+	if _, err := f.Get(nsContext(defaultNamespace), "flat", &metav1.GetOptions{}); err != nil {
+		t.Fatalf("failed to get migrated object: %v", err)
+	}
+
+	// Objects of the same name are kept apart by their namespaces.
+	mustCreate(t, f, "ns1", "a", "v1")
+	mustCreate(t, f, "ns2", "a", "v2")
+	for _, path := range []string{filepath.Join(resourceDir, "ns1", "a.yaml"), filepath.Join(resourceDir, "ns2", "a.yaml")} {
+		if _, err := os.Stat(path); err != nil {
+			t.Fatalf("object isn't stored at %s: %v", path, err)
+		}
+	}
+	if got := mustList(t, f, "ns1", nil); len(got.Items) != 1 || got.Items[0].Data["key"] != "v1" {
+		t.Fatalf("listed %v in ns1", got.Items)
+	}
+	if got := mustList(t, f, "", nil); len(got.Items) != 3 {
+		t.Fatalf("listed %d objects in all the namespaces, want 3", len(got.Items))
+	}
+	obj, err := f.Get(nsContext("ns2"), "a", &metav1.GetOptions{})
+	if err != nil || obj.(*corev1.ConfigMap).Data["key"] != "v2" {
+		t.Fatalf("got %v, %v from ns2", obj, err)
+	}
+	mustDelete(t, f, "ns1", "a")
+	if _, err := f.Get(nsContext("ns2"), "a", &metav1.GetOptions{}); err != nil {
+		t.Fatalf("object of ns2 is gone with the one of ns1: %v", err)
+	}
+}
+
+func TestFileRevisionsAndWatch(t *testing.T) {
+	rootDir := t.TempDir()
+	f := newTestFileREST(t, rootDir, options.FileLayout_Namespaced)
+	a1 := mustCreate(t, f, "ns", "a", "v1")
+	w := mustWatch(t, f, "ns", a1.ResourceVersion)
+	b := mustCreate(t, f, "ns", "b", "v1")
+	mustUpdate(t, f, a1, "v2")
+	mustDelete(t, f, "ns", "b")
+	expectEvents(t, w, "ADDED b=v1", "MODIFIED a=v2", "DELETED b=v1")
+
+	// A watch resumed from a revision gets all the events after it.
+	resumed := mustWatch(t, f, "ns", b.ResourceVersion)
+	expectEvents(t, resumed, "MODIFIED a=v2", "DELETED b=v1")
+
+	// The revisions are shared by all the resources stored in the root dir, and persisted there.
+	other := newTestFileRESTWithOptions(t, corev1.Resource("others"), &options.FileOptions{
+		RootDir: rootDir,
+		Layout:  options.FileLayout_Namespaced,
+	}, nil)
+	c := mustCreate(t, other, "ns", "c", "v1")
+	if revisionOf(t, c) <= revisionOf(t, b) {
+		t.Fatalf("object of another resource is created at revision %s, not after %s", c.ResourceVersion, b.ResourceVersion)
+	}
+	if data, err := os.ReadFile(filepath.Join(rootDir, revisionFileName)); err != nil || string(data) != c.ResourceVersion {
+		t.Fatalf("persisted revision is %q, %v, want %s", data, err, c.ResourceVersion)
+	}
+
+	// A file changed behind the back of the storage gets a new revision, whatever it says.
+	changed := testConfigMap("ns", "a", "v3")
+	changed.ResourceVersion = a1.ResourceVersion
+	writeTestFile(t, filepath.Join(rootDir, "configmaps", "ns", "a.yaml"), changed)
+	ev := expectEvents(t, w, "MODIFIED a=v3")[0]
+	if revisionOf(t, ev.Object) <= revisionOf(t, c) {
+		t.Fatalf("changed file is at revision %d, not after %s", revisionOf(t, ev.Object), c.ResourceVersion)
+	}
+	obj, err := f.Get(nsContext("ns"), "a", &metav1.GetOptions{})
+	if err != nil || revisionOf(t, obj) != revisionOf(t, ev.Object) {
+		t.Fatalf("got %v, %v after the file is changed, want it at the revision of the event", obj, err)
+	}
+}
+
+func TestFileServeFromCache(t *testing.T) {
+	rootDir := t.TempDir()
+	f := newTestFileRESTWithOptions(t, testGroupResource, &options.FileOptions{
+		RootDir:        rootDir,
+		Layout:         options.FileLayout_Namespaced,
+		ServeFromCache: true,
+	}, nil)
+	for _, obj := range []*corev1.ConfigMap{
+		testConfigMap("ns1", "a", "v1"),
+		testConfigMap("ns1", "b", "v1"),
+		testConfigMap("ns2", "c", "v1"),
+	} {
+		if obj.Name != "b" {
+			obj.Labels = map[string]string{"app": "x"}
+		}
+		if _, err := f.Create(nsContext(obj.Namespace), obj, nil, &metav1.CreateOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		ns      string
+		options *metainternalversion.ListOptions
+		want    string
+	}{
+		{ns: "ns1", want: "a,b"},
+		{ns: "", want: "a,b,c"},
+		{ns: "ns1", options: &metainternalversion.ListOptions{LabelSelector: labels.SelectorFromSet(labels.Set{"app": "x"})}, want: "a"},
+		{ns: "", options: &metainternalversion.ListOptions{LabelSelector: labels.SelectorFromSet(labels.Set{"app": "x"})}, want: "a,c"},
+		{ns: "", options: &metainternalversion.ListOptions{FieldSelector: fields.OneTermEqualSelector("metadata.name", "b")}, want: "b"},
+		{ns: "", options: &metainternalversion.ListOptions{FieldSelector: fields.OneTermEqualSelector("metadata.namespace", "ns2")}, want: "c"},
+	}
+	for _, tt := range tests {
+		if got := listedNames(mustList(t, f, tt.ns, tt.options)); got != tt.want {
+			t.Errorf("listed %q in %q with %v, want %q", got, tt.ns, tt.options, tt.want)
+		}
+	}
+
+	// The objects served are copies of the cached ones.
+	obj, err := f.Get(nsContext("ns1"), "a", &metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	obj.(*corev1.ConfigMap).Data["key"] = "changed"
+	if obj, err = f.Get(nsContext("ns1"), "a", &metav1.GetOptions{}); err != nil || obj.(*corev1.ConfigMap).Data["key"] != "v1" {
+		t.Fatalf("got %v, %v after changing the object returned", obj, err)
+	}
+
+	// A file changed behind the back of the storage is served once the change is observed.
+	w := mustWatch(t, f, "ns2", "")
+	receiveEvents(t, w, 1)
+	writeTestFile(t, filepath.Join(rootDir, "configmaps", "ns2", "c.yaml"), testConfigMap("ns2", "c", "v2"))
+	expectEvents(t, w, "MODIFIED c=v2")
+	if obj, err = f.Get(nsContext("ns2"), "c", &metav1.GetOptions{}); err != nil || obj.(*corev1.ConfigMap).Data["key"] != "v2" {
+		t.Fatalf("got %v, %v after the file is changed", obj, err)
+	}
+	if got := mustList(t, f, "", &metainternalversion.ListOptions{LabelSelector: labels.SelectorFromSet(labels.Set{"app": "x"})}); listedNames(got) != "a" {
+		t.Fatalf("listed %q with the label removed from c", listedNames(got))
+	}
+}
+
+func TestFileWatchSelectors(t *testing.T) {
+	f := newTestFileREST(t, t.TempDir(), options.FileLayout_Namespaced)
+	setLabel := func(obj *corev1.ConfigMap, value string) *corev1.ConfigMap {
+		t.Helper()
+		obj = obj.DeepCopy()
+		obj.Labels = map[string]string{"app": value}
+		updated, _, err := f.Update(nsContext(obj.Namespace), obj.Name, rest.DefaultUpdatedObjectInfo(obj), nil, nil, false, &metav1.UpdateOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return updated.(*corev1.ConfigMap)
+	}
+	a := setLabel(mustCreate(t, f, "ns1", "a", "v1"), "x")
+
+	byLabel, err := f.Watch(nsContext("ns1"), &metainternalversion.ListOptions{
+		LabelSelector:   labels.SelectorFromSet(labels.Set{"app": "x"}),
+		ResourceVersion: a.ResourceVersion,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(byLabel.Stop)
+	byName, err := f.Watch(nsContext(""), &metainternalversion.ListOptions{
+		FieldSelector:   fields.OneTermEqualSelector("metadata.name", "c"),
+		ResourceVersion: a.ResourceVersion,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(byName.Stop)
+
+	setLabel(mustCreate(t, f, "ns2", "b", "v1"), "x")
+	c := mustCreate(t, f, "ns1", "c", "v1")
+	setLabel(a, "y")
+	c = setLabel(c, "x")
+	mustUpdate(t, f, c, "v2")
+	mustDelete(t, f, "ns1", "c")
+	// Objects starting to match are added, and the ones stopping to match are deleted.
+	expectEvents(t, byLabel, "DELETED a=v1", "ADDED c=v1", "MODIFIED c=v2", "DELETED c=v2")
+	expectEvents(t, byName, "ADDED c=v1", "MODIFIED c=v1", "MODIFIED c=v2", "DELETED c=v2")
+	expectNoEvent(t, byLabel)
+}
+
+func TestFileCreateWithoutDataDir(t *testing.T) {
+	rootDir := t.TempDir()
+	f := newTestFileREST(t, rootDir, options.FileLayout_Namespaced)
+	// The namespace dir can't be created where a file is.
+	if err := os.WriteFile(filepath.Join(f.objRootPath, "ns"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, err := f.Create(nsContext("ns"), testConfigMap("ns", "a", "v1"), nil, &metav1.CreateOptions{})
+	if !apierrors.IsInternalError(err) {
+		t.Fatalf("create without a data dir returned %v, want an internal error", err)
+	}
+	mustCreate(t, f, "other", "a", "v1")
+}
+
+func TestFileDeleteCollection(t *testing.T) {
+	f := newTestFileREST(t, t.TempDir(), options.FileLayout_Namespaced)
+	for _, name := range []string{"a", "b", "c"} {
+		obj := testConfigMap("ns", name, "v1")
+		if name != "c" {
+			obj.Labels = map[string]string{"app": "x"}
+		}
+		if _, err := f.Create(nsContext("ns"), obj, nil, &metav1.CreateOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	w := mustWatch(t, f, "ns", "")
+	receiveEvents(t, w, 3)
+	listOptions := &metainternalversion.ListOptions{LabelSelector: labels.SelectorFromSet(labels.Set{"app": "x"})}
+
+	// A dry run deletes nothing.
+	deleted, err := f.DeleteCollection(nsContext("ns"), nil, &metav1.DeleteOptions{DryRun: []string{metav1.DryRunAll}}, listOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(deleted.(*corev1.ConfigMapList).Items); n != 2 {
+		t.Fatalf("dry run returned %d objects, want 2", n)
+	}
+	expectNoEvent(t, w)
+
+	// The validation is applied to each object.
+	rejectB := func(ctx context.Context, obj runtime.Object) error {
+		if obj.(*corev1.ConfigMap).Name == "b" {
+			return errors.New("b can't be deleted")
+		}
+		return nil
+	}
+	if _, err := f.DeleteCollection(nsContext("ns"), rejectB, &metav1.DeleteOptions{}, listOptions); err == nil {
+		t.Fatal("deleting an object rejected by the validation succeeded")
+	}
+	expectEvents(t, w, "DELETED a=v1")
+
+	deleted, err = f.DeleteCollection(nsContext("ns"), nil, &metav1.DeleteOptions{}, listOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(deleted.(*corev1.ConfigMapList).Items); n != 1 {
+		t.Fatalf("deleted %d objects, want 1", n)
+	}
+	expectEvents(t, w, "DELETED b=v1")
+	if got := mustList(t, f, "ns", nil); len(got.Items) != 1 || got.Items[0].Name != "c" {
+		t.Fatalf("listed %v after deleting the objects selected", got.Items)
+	}
+}
+
+func TestFileEncryption(t *testing.T) {
+	rootDir := t.TempDir()
+	fileOptions := &options.FileOptions{RootDir: rootDir, Layout: options.FileLayout_Namespaced}
+	selector, err := labels.Parse("higress.io/sensitive=true")
+	if err != nil {
+		t.Fatal(err)
+	}
+	newPolicy := func(keyIds ...string) *encryption.Policy {
+		return encryption.NewPolicy(newTestKeyring(t, keyIds...), &encryption.ResourceRule{GroupResource: testGroupResource, Selector: selector})
+	}
+	f := newTestFileRESTWithOptions(t, testGroupResource, fileOptions, newPolicy("key-1"))
+
+	// Only the objects selected by the labels are encrypted, while the files of the resource are all private.
+	sensitive := testConfigMap("ns", "a", "secret-1")
+	sensitive.Labels = map[string]string{"higress.io/sensitive": "true"}
+	obj, err := f.Create(nsContext("ns"), sensitive, nil, &metav1.CreateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := obj.(*corev1.ConfigMap)
+	mustCreate(t, f, "ns", "b", "plain-1")
+	pathA, pathB := filepath.Join(rootDir, "configmaps", "ns", "a.yaml"), filepath.Join(rootDir, "configmaps", "ns", "b.yaml")
+	expectFileContent(t, pathA, "secret-1", false)
+	expectFileContent(t, pathB, "plain-1", true)
+	for _, path := range []string{pathA, pathB} {
+		if fileInfo, err := os.Stat(path); err != nil || fileInfo.Mode().Perm() != 0600 {
+			t.Fatalf("%s has mode %v, %v, want 0600", path, fileInfo.Mode().Perm(), err)
+		}
+	}
+	list := mustList(t, f, "ns", nil)
+	if listedNames(list) != "a,b" || list.Items[0].Data["key"] != "secret-1" {
+		t.Fatalf("listed %v", list.Items)
+	}
+
+	// The updates are encrypted too, and decrypted for the watchers.
+	w := mustWatch(t, f, "ns", list.ResourceVersion)
+	updated := mustUpdate(t, f, a, "secret-2")
+	expectEvents(t, w, "MODIFIED a=secret-2")
+	expectFileContent(t, pathA, "secret-2", false)
+
+	// The objects encrypted with an old key are re-encrypted with the newest one.
+	rotatedDir := t.TempDir()
+	rotated := newTestFileRESTWithOptions(t, testGroupResource, &options.FileOptions{RootDir: rotatedDir, Layout: options.FileLayout_Namespaced}, newPolicy("key-1", "key-2"))
+	content, err := os.ReadFile(pathA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(rotatedDir, "configmaps", "ns"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(rotatedDir, "configmaps", "ns", "a.yaml"), content, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if count, err := rotated.Reencrypt(context.Background()); err != nil || count != 1 {
+		t.Fatalf("Reencrypt() = %d, %v, want 1 object re-encrypted", count, err)
+	}
+	if count, err := rotated.Reencrypt(context.Background()); err != nil || count != 0 {
+		t.Fatalf("Reencrypt() = %d, %v again, want nothing re-encrypted", count, err)
+	}
+	obj, err = rotated.Get(nsContext("ns"), "a", &metav1.GetOptions{})
+	if err != nil || obj.(*corev1.ConfigMap).Data["key"] != updated.Data["key"] {
+		t.Fatalf("got %v, %v after re-encryption", obj, err)
+	}
+
+	// Encrypted files aren't readable without the keys.
+	if storage, err := NewFileREST(testGroupResource, testCodec, fileOptions, ".yaml", true, "configmap", newTestConfigMap, newTestConfigMapList, nil, nil); err == nil {
+		storage.Destroy()
+		t.Fatal("storage of encrypted files is created without the keys")
+	}
+}
+
+// expectFileContent checks whether the given text is found in the file, which is stored in plaintext or not.
+func expectFileContent(t *testing.T, path, text string, plaintext bool) {
+	t.Helper()
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(content), text) != plaintext {
+		t.Fatalf("%s is stored with plaintext %v: %s", path, !plaintext, content)
+	}
+}
+
+func TestFileDryRun(t *testing.T) {
+	testDryRun(t, newTestFileREST(t, t.TempDir(), options.FileLayout_Namespaced))
+}
+
+func TestFileListChunks(t *testing.T) {
+	testListChunks(t, newTestFileREST(t, t.TempDir(), options.FileLayout_Namespaced))
+}
+
+func TestFileServerSideApply(t *testing.T) {
+	testServerSideApply(t, newTestFileREST(t, t.TempDir(), options.FileLayout_Namespaced))
+}
@@ -0,0 +1,90 @@
+package registry
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const revisionFileName = ".revision"
+
+var (
+	fileRevisionCounters      = map[string]*fileRevisionCounter{}
+	fileRevisionCountersMutex sync.Mutex
+)
+
+// fileRevisionCounter is a monotonically increasing revision shared by all the resources stored in the same root dir.
+// The latest revision is persisted in the root dir so it keeps increasing across restarts.
+type fileRevisionCounter struct {
+	mutex    sync.Mutex
+	path     string
+	revision uint64
+}
+
+func getFileRevisionCounter(rootPath string) (*fileRevisionCounter, error) {
+	fileRevisionCountersMutex.Lock()
+	defer fileRevisionCountersMutex.Unlock()
+
+	path := filepath.Join(filepath.Clean(rootPath), revisionFileName)
+	if c, ok := fileRevisionCounters[path]; ok {
+		return c, nil
+	}
+	c := &fileRevisionCounter{path: path}
+	content, err := os.ReadFile(path)
+	if err == nil {
+		c.revision, err = strconv.ParseUint(strings.TrimSpace(string(content)), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid revision data in file [%s]: %v", path, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read revision file [%s]: %v", path, err)
+	}
+	fileRevisionCounters[path] = c
+	return c, nil
+}
+
+func (c *fileRevisionCounter) current() uint64 {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.revision
+}
+
+// next increases the revision and returns the new value.
+func (c *fileRevisionCounter) next() (uint64, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if err := c.persist(c.revision + 1); err != nil {
+		return 0, err
+	}
+	c.revision++
+	return c.revision, nil
+}
+
+// observe makes sure the counter is not behind a revision found in stored data.
+func (c *fileRevisionCounter) observe(revision uint64) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if revision <= c.revision {
+		return nil
+	}
+	if err := c.persist(revision); err != nil {
+		return err
+	}
+	c.revision = revision
+	return nil
+}
+
+func (c *fileRevisionCounter) persist(revision uint64) error {
+	tmpPath := c.path + ".tmp"
+	if err := os.WriteFile(tmpPath, []byte(strconv.FormatUint(revision, 10)), 0600); err != nil {
+		return fmt.Errorf("failed to write revision file [%s]: %v", tmpPath, err)
+	}
+	if err := os.Rename(tmpPath, c.path); err != nil {
+		_ = os.Remove(tmpPath)
+		return fmt.Errorf("failed to write revision file [%s]: %v", c.path, err)
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+package registry
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metainternalversion "k8s.io/apimachinery/pkg/apis/meta/internalversion"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/apiserver/pkg/storage"
+	storeerr "k8s.io/apiserver/pkg/storage/errors"
+)
+
+type historyEvent struct {
+	revision uint64
+	event    watchEvent
+}
+
+// eventHistory keeps a window of the most recent events of a resource, so watches can be resumed
+// from a resource version without replaying the whole list.
+type eventHistory struct {
+	mutex    sync.RWMutex
+	capacity int
+	events   []historyEvent
+	// compactedRevision is the revision since which all the events are retained.
+	compactedRevision uint64
+}
+
+func newEventHistory(capacity int, revision uint64) *eventHistory {
+	if capacity <= 0 {
+		capacity = 1
+	}
+	return &eventHistory{
+		capacity:          capacity,
+		events:            make([]historyEvent, 0, capacity),
+		compactedRevision: revision,
+	}
+}
+
+func (h *eventHistory) add(revision uint64, ev watchEvent) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if len(h.events) == h.capacity {
+		h.compactedRevision = h.events[0].revision
+		copy(h.events, h.events[1:])
+		h.events = h.events[:len(h.events)-1]
+	}
+	h.events = append(h.events, historyEvent{revision: revision, event: ev})
+}
+
+// since returns all the events happened after the given revision.
+// A "410 Gone" error is returned if some of them have already been dropped from the window.
+func (h *eventHistory) since(revision uint64) ([]watchEvent, error) {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+
+	if revision < h.compactedRevision {
+		return nil, apierrors.NewResourceExpired(
+			fmt.Sprintf("too old resource version: %d (%d)", revision, h.compactedRevision))
+	}
+	var events []watchEvent
+	for _, e := range h.events {
+		if e.revision > revision {
+			events = append(events, e.event)
+		}
+	}
+	return events, nil
+}
+
+func parseResourceVersion(resourceVersion string) (uint64, error) {
+	if resourceVersion == "" {
+		return 0, nil
+	}
+	revision, err := strconv.ParseUint(resourceVersion, 10, 64)
+	if err != nil {
+		return 0, apierrors.NewBadRequest(fmt.Sprintf("invalid resource version: %s", resourceVersion))
+	}
+	return revision, nil
+}
+
+func formatResourceVersion(revision uint64) string {
+	return strconv.FormatUint(revision, 10)
+}
+
+// checkListResourceVersion verifies whether a list served at the current revision satisfies
+// the resourceVersion and resourceVersionMatch given in the list options.
+func checkListResourceVersion(options *metainternalversion.ListOptions, currentRevision uint64, groupResource schema.GroupResource) error {
+	if options == nil || options.ResourceVersion == "" {
+		return nil
+	}
+	revision, err := parseResourceVersion(options.ResourceVersion)
+	if err != nil {
+		return err
+	}
+	switch options.ResourceVersionMatch {
+	case metav1.ResourceVersionMatchExact:
+		if revision < currentRevision {
+			return apierrors.NewResourceExpired(
+				fmt.Sprintf("too old resource version: %d (%d)", revision, currentRevision))
+		}
+	case "", metav1.ResourceVersionMatchNotOlderThan:
+	default:
+		return apierrors.NewBadRequest(fmt.Sprintf("unknown resourceVersionMatch: %s", options.ResourceVersionMatch))
+	}
+	if revision > currentRevision {
+		return storeerr.InterpretListError(storage.NewTooLargeResourceVersionError(revision, currentRevision, 1), groupResource)
+	}
+	return nil
+}
+
+// shouldSendInitialEvents tells whether a watch should start with synthetic ADDED events for all the existing objects.
+func shouldSendInitialEvents(options *metainternalversion.ListOptions) bool {
+	if options == nil {
+		return true
+	}
+	if options.SendInitialEvents != nil {
+		return *options.SendInitialEvents
+	}
+	return options.ResourceVersion == "" || options.ResourceVersion == "0"
+}
+
+func newBookmarkEvent(newFunc func() runtime.Object, revision uint64, initialEventsEnd bool) watch.Event {
+	obj := newFunc()
+	if accessor, err := meta.Accessor(obj); err == nil {
+		accessor.SetResourceVersion(formatResourceVersion(revision))
+		if initialEventsEnd {
+			accessor.SetAnnotations(map[string]string{metav1.InitialEventsAnnotationKey: "true"})
+		}
+	}
+	return watch.Event{
+		Type:   watch.Bookmark,
+		Object: obj,
+	}
+}
+
+func setListResourceVersion(list runtime.Object, revision uint64) {
+	if listAccessor, err := meta.ListAccessor(list); err == nil {
+		listAccessor.SetResourceVersion(formatResourceVersion(revision))
+	}
+}
+
+func setResourceVersion(obj runtime.Object, revision uint64) {
+	if accessor, err := meta.Accessor(obj); err == nil {
+		accessor.SetResourceVersion(formatResourceVersion(revision))
+	}
+}
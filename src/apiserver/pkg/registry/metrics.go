@@ -0,0 +1,101 @@
+package registry
+
+import (
+	"time"
+
+	"k8s.io/component-base/metrics"
+	"k8s.io/component-base/metrics/legacyregistry"
+)
+
+const (
+	readSourceCache = "cache"
+	readSourceDisk  = "disk"
+)
+
+var (
+	fileReadDuration = metrics.NewHistogramVec(
+		&metrics.HistogramOpts{
+			Namespace:      "higress",
+			Subsystem:      "file_storage",
+			Name:           "read_duration_seconds",
+			Help:           "Latency of get and list requests served by the file storage, by the source they are served from.",
+			Buckets:        []float64{0.0001, 0.0005, 0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5},
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"resource", "operation", "source"},
+	)
+	fileListScannedObjects = metrics.NewCounterVec(
+		&metrics.CounterOpts{
+			Namespace:      "higress",
+			Subsystem:      "file_storage",
+			Name:           "list_scanned_objects_total",
+			Help:           "Number of objects evaluated against selectors by list requests, by the source they are served from.",
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"resource", "source"},
+	)
+	fileListReturnedObjects = metrics.NewCounterVec(
+		&metrics.CounterOpts{
+			Namespace:      "higress",
+			Subsystem:      "file_storage",
+			Name:           "list_returned_objects_total",
+			Help:           "Number of objects returned by list requests, by the source they are served from.",
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"resource", "source"},
+	)
+	mirrorReplicationLag = metrics.NewGaugeVec(
+		&metrics.GaugeOpts{
+			Namespace:      "higress",
+			Subsystem:      "storage_mirror",
+			Name:           "replication_lag_seconds",
+			Help:           "Age of the oldest change of the primary storage not yet applied to the mirror storage.",
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"resource"},
+	)
+	mirrorPendingEvents = metrics.NewGaugeVec(
+		&metrics.GaugeOpts{
+			Namespace:      "higress",
+			Subsystem:      "storage_mirror",
+			Name:           "pending_events",
+			Help:           "Number of changes of the primary storage not yet applied to the mirror storage.",
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"resource"},
+	)
+	mirrorDegraded = metrics.NewGaugeVec(
+		&metrics.GaugeOpts{
+			Namespace:      "higress",
+			Subsystem:      "storage_mirror",
+			Name:           "degraded",
+			Help:           "Whether reads are served from the mirror storage since the primary storage is unavailable.",
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"resource"},
+	)
+)
+
+func init() {
+	legacyregistry.MustRegister(fileReadDuration, fileListScannedObjects, fileListReturnedObjects,
+		mirrorReplicationLag, mirrorPendingEvents, mirrorDegraded)
+}
+
+func observeFileRead(resource, operation, source string, start time.Time) {
+	fileReadDuration.WithLabelValues(resource, operation, source).Observe(time.Since(start).Seconds())
+}
+
+func observeFileList(resource, source string, scanned, returned int) {
+	fileListScannedObjects.WithLabelValues(resource, source).Add(float64(scanned))
+	fileListReturnedObjects.WithLabelValues(resource, source).Add(float64(returned))
+}
+
+func observeMirror(resource string, pending int, lag time.Duration, degraded bool) {
+	mirrorReplicationLag.WithLabelValues(resource).Set(lag.Seconds())
+	mirrorPendingEvents.WithLabelValues(resource).Set(float64(pending))
+	if degraded {
+		mirrorDegraded.WithLabelValues(resource).Set(1)
+	} else {
+		mirrorDegraded.WithLabelValues(resource).Set(0)
+	}
+}
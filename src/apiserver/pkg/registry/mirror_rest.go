@@ -0,0 +1,776 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metainternalversion "k8s.io/apimachinery/pkg/apis/meta/internalversion"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/watch"
+	genericapirequest "k8s.io/apiserver/pkg/endpoints/request"
+	"k8s.io/apiserver/pkg/registry/rest"
+	"k8s.io/klog/v2"
+)
+
+const (
+	mirrorRetryInterval   = time.Second
+	mirrorProbeInterval   = time.Second
+	mirrorMetricsInterval = 5 * time.Second
+)
+
+// mirrorVersionPrefix tags the resource versions and continue tokens served by the secondary backend, which mean
+// nothing to the primary one, and the other way round.
+const mirrorVersionPrefix = "m"
+
+// mirrorReconcile is the type of the queued item removing from the secondary backend the objects which don't
+// exist in the primary one any more. It's queued ahead of the listed objects every time the primary is relisted.
+const mirrorReconcile watch.EventType = "RECONCILE"
+
+// mirrorReconcileKey is the queue key of the reconciliation, which no object key is equal to.
+const mirrorReconcileKey = ""
+
+var _ rest.StandardStorage = &mirrorREST{}
+var _ rest.Scoper = &mirrorREST{}
+var _ rest.Storage = &mirrorREST{}
+var _ watch.Interface = &mirrorWatcher{}
+
+// NewMirrorREST instantiates a new REST storage which writes to the primary backend and mirrors all of its changes
+// to the secondary one asynchronously. Reads are served from the secondary backend when the primary one is unreachable,
+// in which case the resource is read-only.
+func NewMirrorREST(groupResource schema.GroupResource, primary, secondary REST) REST {
+	ctx, cancel := context.WithCancel(context.Background())
+	m := &mirrorREST{
+		REST:          primary,
+		groupResource: groupResource,
+		secondary:     secondary,
+		ctx:           ctx,
+		cancel:        cancel,
+		pending:       map[string]*mirrorItem{},
+	}
+	m.queueCond = sync.NewCond(&m.queueMutex)
+	m.startSync()
+	m.startWorker()
+	m.startProbe()
+	m.startMetricsTicker()
+	return m
+}
+
+// mirrorREST is the composite storage of two backends. All the methods not overridden here are served by the primary one.
+// Resource versions are specific to the backend serving the request, so the ones served by the secondary backend
+// are tagged. Reads from a resource version of the backend not serving reads at the moment fail with "410 Gone",
+// and the watches on the secondary backend are ended with that error once the primary one is back, so clients relist.
+type mirrorREST struct {
+	REST
+	groupResource schema.GroupResource
+	secondary     REST
+	degraded      atomic.Bool
+
+	// recovered is closed when the degraded mode is left.
+	degradedMutex sync.Mutex
+	recovered     chan struct{}
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// queue holds the keys of the objects changed in the primary backend but not yet in the secondary one, in the
+	// order they are to be applied, and pending holds the latest change of each of them. The changes of an object
+	// are coalesced, so the queue doesn't grow beyond the number of objects however long the secondary one is down.
+	queueMutex sync.Mutex
+	queueCond  *sync.Cond
+	queue      []string
+	pending    map[string]*mirrorItem
+}
+
+type mirrorItem struct {
+	key       string
+	eventType watch.EventType
+	object    runtime.Object
+	// queuedAt is the time of the oldest change of the object not yet applied.
+	queuedAt time.Time
+}
+
+func (m *mirrorREST) Destroy() {
+	m.cancel()
+	m.queueMutex.Lock()
+	m.queueCond.Broadcast()
+	m.queueMutex.Unlock()
+	m.REST.Destroy()
+	m.secondary.Destroy()
+}
+
+func (m *mirrorREST) Get(ctx context.Context, name string, options *metav1.GetOptions) (runtime.Object, error) {
+	if options == nil {
+		options = &metav1.GetOptions{}
+	}
+	secondary, resourceVersion, err := m.route(options.ResourceVersion)
+	if err != nil {
+		return nil, err
+	}
+	if !secondary {
+		obj, err := m.REST.Get(ctx, name, options)
+		if !m.checkPrimary(err) {
+			return obj, err
+		}
+		if !isAnyResourceVersion(resourceVersion) {
+			return nil, mirrorVersionExpiredError()
+		}
+	}
+	secondaryOptions := *options
+	secondaryOptions.ResourceVersion = resourceVersion
+	obj, err := m.secondary.Get(ctx, name, &secondaryOptions)
+	if err != nil {
+		return nil, err
+	}
+	return tagResourceVersions(obj)
+}
+
+func (m *mirrorREST) List(ctx context.Context, options *metainternalversion.ListOptions) (runtime.Object, error) {
+	if options == nil {
+		options = &metainternalversion.ListOptions{}
+	}
+	// The continue token tells the backend serving the following chunks.
+	version := options.ResourceVersion
+	if options.Continue != "" {
+		version = options.Continue
+	}
+	secondary, version, err := m.route(version)
+	if err != nil {
+		return nil, err
+	}
+	if !secondary {
+		list, err := m.REST.List(ctx, options)
+		if !m.checkPrimary(err) {
+			return list, err
+		}
+		if options.Continue != "" || !isAnyResourceVersion(version) {
+			return nil, mirrorVersionExpiredError()
+		}
+	}
+	secondaryOptions := *options
+	if options.Continue != "" {
+		secondaryOptions.Continue = version
+	} else {
+		secondaryOptions.ResourceVersion = version
+	}
+	list, err := m.secondary.List(ctx, &secondaryOptions)
+	if err != nil {
+		return nil, err
+	}
+	return tagResourceVersions(list)
+}
+
+func (m *mirrorREST) Watch(ctx context.Context, options *metainternalversion.ListOptions) (watch.Interface, error) {
+	if options == nil {
+		options = &metainternalversion.ListOptions{}
+	}
+	secondary, resourceVersion, err := m.route(options.ResourceVersion)
+	if err != nil {
+		return nil, err
+	}
+	if !secondary {
+		w, err := m.REST.Watch(ctx, options)
+		if !m.checkPrimary(err) {
+			return w, err
+		}
+		if !isAnyResourceVersion(resourceVersion) {
+			return nil, mirrorVersionExpiredError()
+		}
+	}
+	recovered, degraded := m.recoveredChan()
+	if !degraded {
+		return nil, mirrorVersionExpiredError()
+	}
+	secondaryOptions := *options
+	secondaryOptions.ResourceVersion = resourceVersion
+	w, err := m.secondary.Watch(ctx, &secondaryOptions)
+	if err != nil {
+		return nil, err
+	}
+	return newMirrorWatcher(w, recovered), nil
+}
+
+// route tells whether a read from the given resource version or continue token is served by the secondary backend,
+// and returns it untagged. Reads from a tagged one fail with "410 Gone" unless the mirror is in the degraded mode.
+// The other ones are tried on the primary backend first.
+func (m *mirrorREST) route(version string) (bool, string, error) {
+	untagged, tagged := strings.CutPrefix(version, mirrorVersionPrefix)
+	if !tagged {
+		return false, version, nil
+	}
+	if !m.degraded.Load() {
+		return false, "", mirrorVersionExpiredError()
+	}
+	return true, untagged, nil
+}
+
+// isAnyResourceVersion tells whether a read from the resource version can be served by any of the backends.
+func isAnyResourceVersion(resourceVersion string) bool {
+	return resourceVersion == "" || resourceVersion == "0"
+}
+
+func mirrorVersionExpiredError() error {
+	return apierrors.NewResourceExpired("the resource version is from a storage not serving reads at the moment, please relist")
+}
+
+// tagResourceVersions returns a copy of an object or a list read from the secondary backend, with the resource versions
+// and the continue token in it tagged.
+func tagResourceVersions(obj runtime.Object) (runtime.Object, error) {
+	obj = obj.DeepCopyObject()
+	if meta.IsListType(obj) {
+		listAccessor, err := meta.ListAccessor(obj)
+		if err != nil {
+			return nil, apierrors.NewInternalError(err)
+		}
+		listAccessor.SetResourceVersion(tagVersion(listAccessor.GetResourceVersion()))
+		listAccessor.SetContinue(tagVersion(listAccessor.GetContinue()))
+		if err := meta.EachListItem(obj, func(item runtime.Object) error {
+			return tagObjectResourceVersion(item)
+		}); err != nil {
+			return nil, apierrors.NewInternalError(err)
+		}
+		return obj, nil
+	}
+	if err := tagObjectResourceVersion(obj); err != nil {
+		return nil, apierrors.NewInternalError(err)
+	}
+	return obj, nil
+}
+
+func tagObjectResourceVersion(obj runtime.Object) error {
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return err
+	}
+	accessor.SetResourceVersion(tagVersion(accessor.GetResourceVersion()))
+	return nil
+}
+
+func tagVersion(version string) string {
+	if version == "" {
+		return ""
+	}
+	return mirrorVersionPrefix + version
+}
+
+func (m *mirrorREST) Create(
+	ctx context.Context,
+	obj runtime.Object,
+	createValidation rest.ValidateObjectFunc,
+	options *metav1.CreateOptions,
+) (runtime.Object, error) {
+	obj, err := m.REST.Create(ctx, obj, createValidation, options)
+	return obj, m.checkWrite(err)
+}
+
+func (m *mirrorREST) Update(
+	ctx context.Context,
+	name string,
+	objInfo rest.UpdatedObjectInfo,
+	createValidation rest.ValidateObjectFunc,
+	updateValidation rest.ValidateObjectUpdateFunc,
+	forceAllowCreate bool,
+	options *metav1.UpdateOptions,
+) (runtime.Object, bool, error) {
+	obj, created, err := m.REST.Update(ctx, name, objInfo, createValidation, updateValidation, forceAllowCreate, options)
+	return obj, created, m.checkWrite(err)
+}
+
+func (m *mirrorREST) Delete(
+	ctx context.Context,
+	name string,
+	deleteValidation rest.ValidateObjectFunc,
+	options *metav1.DeleteOptions,
+) (runtime.Object, bool, error) {
+	obj, deleted, err := m.REST.Delete(ctx, name, deleteValidation, options)
+	return obj, deleted, m.checkWrite(err)
+}
+
+func (m *mirrorREST) DeleteCollection(
+	ctx context.Context,
+	deleteValidation rest.ValidateObjectFunc,
+	options *metav1.DeleteOptions,
+	listOptions *metainternalversion.ListOptions,
+) (runtime.Object, error) {
+	list, err := m.REST.DeleteCollection(ctx, deleteValidation, options, listOptions)
+	return list, m.checkWrite(err)
+}
+
+// checkPrimary updates the degraded state by the result of a read from the primary backend,
+// and returns whether the read should be served from the secondary one instead.
+func (m *mirrorREST) checkPrimary(err error) bool {
+	unavailable := isUnavailableError(err)
+	m.setDegraded(unavailable, err)
+	return unavailable
+}
+
+// checkWrite turns the failure of a write in the degraded mode into a "503 Service Unavailable" error.
+// Failed writes don't enter the degraded mode by themselves, as some backends report invalid objects as internal errors.
+func (m *mirrorREST) checkWrite(err error) error {
+	if err == nil {
+		m.setDegraded(false, nil)
+		return nil
+	}
+	if m.degraded.Load() && isUnavailableError(err) {
+		return apierrors.NewServiceUnavailable(
+			fmt.Sprintf("%s are read-only while the primary storage is unavailable: %v", m.groupResource, err))
+	}
+	return err
+}
+
+func (m *mirrorREST) setDegraded(degraded bool, err error) {
+	if m.degraded.Load() == degraded {
+		return
+	}
+	m.degradedMutex.Lock()
+	defer m.degradedMutex.Unlock()
+	if m.degraded.Load() == degraded {
+		return
+	}
+	if degraded {
+		m.recovered = make(chan struct{})
+		m.degraded.Store(true)
+		klog.Warningf("[%s] primary storage is unavailable, serving reads from the mirror: %v", m.groupResource, err)
+	} else {
+		m.degraded.Store(false)
+		close(m.recovered)
+		klog.Infof("[%s] primary storage is available again", m.groupResource)
+	}
+}
+
+// recoveredChan returns the channel closed when the current degraded mode is left, and whether the mirror is in it.
+func (m *mirrorREST) recoveredChan() (<-chan struct{}, bool) {
+	m.degradedMutex.Lock()
+	defer m.degradedMutex.Unlock()
+	return m.recovered, m.degraded.Load()
+}
+
+// isUnavailableError tells whether an error means that the backend couldn't be reached to serve the request,
+// rather than that the request failed. Internal errors are excluded, as they are returned for objects failing
+// to decode too, and so are timeout errors, which are returned for resource versions the backend hasn't reached yet.
+func isUnavailableError(err error) bool {
+	if err == nil {
+		return false
+	}
+	var status apierrors.APIStatus
+	if !errors.As(err, &status) {
+		return isConnectionError(err)
+	}
+	return apierrors.IsServiceUnavailable(err)
+}
+
+// startProbe reads from the primary backend periodically in the degraded mode, so the mode is left once it's back
+// even if all the reads are served by the secondary backend for their resource versions.
+func (m *mirrorREST) startProbe() {
+	ticker := time.NewTicker(mirrorProbeInterval)
+	go func(m *mirrorREST) {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-m.ctx.Done():
+				return
+			case <-ticker.C:
+				if m.degraded.Load() {
+					_, err := m.REST.List(m.ctx, &metainternalversion.ListOptions{Limit: 1})
+					m.checkPrimary(err)
+				}
+			}
+		}
+	}(m)
+}
+
+// mirrorWatcher delivers the events of a watch on the secondary backend with their resource versions tagged, and ends
+// with a "410 Gone" error once the degraded mode is left, so the client relists from the primary backend.
+type mirrorWatcher struct {
+	w        watch.Interface
+	result   chan watch.Event
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+func newMirrorWatcher(w watch.Interface, recovered <-chan struct{}) *mirrorWatcher {
+	mw := &mirrorWatcher{
+		w:      w,
+		result: make(chan watch.Event),
+		done:   make(chan struct{}),
+	}
+	go mw.run(recovered)
+	return mw
+}
+
+func (w *mirrorWatcher) Stop() {
+	w.stopOnce.Do(func() {
+		close(w.done)
+	})
+}
+
+func (w *mirrorWatcher) ResultChan() <-chan watch.Event {
+	return w.result
+}
+
+func (w *mirrorWatcher) run(recovered <-chan struct{}) {
+	defer close(w.result)
+	defer w.w.Stop()
+	for {
+		select {
+		case ev, ok := <-w.w.ResultChan():
+			if !ok {
+				return
+			}
+			if ev.Type != watch.Error {
+				obj, err := tagResourceVersions(ev.Object)
+				if err != nil {
+					klog.Errorf("failed to tag resource version of %s event: %v", ev.Type, err)
+					continue
+				}
+				ev.Object = obj
+			}
+			select {
+			case w.result <- ev:
+			case <-w.done:
+				return
+			case <-recovered:
+				w.deliverExpired()
+				return
+			}
+		case <-recovered:
+			w.deliverExpired()
+			return
+		case <-w.done:
+			return
+		}
+	}
+}
+
+func (w *mirrorWatcher) deliverExpired() {
+	status := mirrorVersionExpiredError().(apierrors.APIStatus).Status()
+	timer := time.NewTimer(errorEventDeliveryTimeout)
+	defer timer.Stop()
+	select {
+	case w.result <- watch.Event{Type: watch.Error, Object: &status}:
+	case <-w.done:
+	case <-timer.C:
+	}
+}
+
+// startSync keeps watching all the objects in the primary backend, and queues the changes to be mirrored.
+// Every new watch resumes from the last change seen, unless the primary backend has compacted it, in which case
+// the objects are relisted.
+func (m *mirrorREST) startSync() {
+	go func(m *mirrorREST) {
+		resourceVersion := ""
+		for {
+			resourceVersion = m.sync(resourceVersion)
+			select {
+			case <-m.ctx.Done():
+				return
+			case <-time.After(mirrorRetryInterval):
+			}
+		}
+	}(m)
+}
+
+// sync queues the changes of the primary backend following the resource version until the watch of them ends, and
+// returns the resource version to resume from. Without a resource version, a reconciliation followed by all the
+// objects listed is queued first.
+func (m *mirrorREST) sync(resourceVersion string) string {
+	if resourceVersion == "" {
+		var err error
+		if resourceVersion, err = m.relist(); err != nil {
+			if m.ctx.Err() == nil {
+				m.setDegraded(isUnavailableError(err), err)
+				klog.Errorf("[%s] failed to list primary storage for mirroring: %v", m.groupResource, err)
+			}
+			return ""
+		}
+	}
+
+	w, err := m.REST.Watch(m.ctx, &metainternalversion.ListOptions{ResourceVersion: resourceVersion, AllowWatchBookmarks: true})
+	if err != nil {
+		if m.ctx.Err() == nil {
+			m.setDegraded(isUnavailableError(err), err)
+			klog.Errorf("[%s] failed to watch primary storage for mirroring: %v", m.groupResource, err)
+		}
+		return resumableVersion(resourceVersion, err)
+	}
+	defer w.Stop()
+
+	for {
+		select {
+		case <-m.ctx.Done():
+			return resourceVersion
+		case ev, ok := <-w.ResultChan():
+			if !ok {
+				return resourceVersion
+			}
+			if ev.Type == watch.Error {
+				err := apierrors.FromObject(ev.Object)
+				klog.Errorf("[%s] watch of primary storage for mirroring terminated: %v", m.groupResource, err)
+				return resumableVersion(resourceVersion, err)
+			}
+			if accessor, err := meta.Accessor(ev.Object); err == nil && accessor.GetResourceVersion() != "" {
+				resourceVersion = accessor.GetResourceVersion()
+			}
+			if ev.Type != watch.Bookmark {
+				m.enqueue(ev.Type, ev.Object)
+			}
+		}
+	}
+}
+
+// resumableVersion returns the resource version to resume from after a watch from it failed with the error,
+// which is empty if it's too old to resume from.
+func resumableVersion(resourceVersion string, err error) string {
+	if apierrors.IsResourceExpired(err) || apierrors.IsGone(err) {
+		return ""
+	}
+	return resourceVersion
+}
+
+// relist queues a reconciliation followed by all the objects in the primary backend, and returns the resource version
+// of the list.
+func (m *mirrorREST) relist() (string, error) {
+	list, err := m.REST.List(m.ctx, &metainternalversion.ListOptions{})
+	if err != nil {
+		return "", err
+	}
+	listAccessor, err := meta.ListAccessor(list)
+	if err != nil {
+		return "", err
+	}
+	items, err := meta.ExtractList(list)
+	if err != nil {
+		return "", err
+	}
+	m.enqueue(mirrorReconcile, nil)
+	for _, item := range items {
+		m.enqueue(watch.Added, item)
+	}
+	return listAccessor.GetResourceVersion(), nil
+}
+
+// enqueue queues a change to be applied, replacing the pending change of the same object if any.
+func (m *mirrorREST) enqueue(eventType watch.EventType, obj runtime.Object) {
+	key := mirrorReconcileKey
+	if obj != nil {
+		accessor, err := meta.Accessor(obj)
+		if err != nil {
+			klog.Errorf("[%s] dropped %s event of unknown object: %v", m.groupResource, eventType, err)
+			return
+		}
+		key = accessor.GetNamespace() + "/" + accessor.GetName()
+	}
+	m.queueMutex.Lock()
+	defer m.queueMutex.Unlock()
+	item := &mirrorItem{key: key, eventType: eventType, object: obj, queuedAt: time.Now()}
+	if pending, ok := m.pending[key]; ok {
+		item.queuedAt = pending.queuedAt
+	} else {
+		m.queue = append(m.queue, key)
+	}
+	m.pending[key] = item
+	m.queueCond.Signal()
+}
+
+// peek waits for the change at the front of the queue, which is kept there until done.
+// It returns nil once the storage is destroyed.
+func (m *mirrorREST) peek() *mirrorItem {
+	m.queueMutex.Lock()
+	defer m.queueMutex.Unlock()
+	for len(m.queue) == 0 && m.ctx.Err() == nil {
+		m.queueCond.Wait()
+	}
+	if m.ctx.Err() != nil {
+		return nil
+	}
+	return m.pending[m.queue[0]]
+}
+
+// done removes the change at the front of the queue once applied. The object is moved to the back of the queue
+// instead if its change failed, so it doesn't hold back the other ones, or has been replaced by a newer one since.
+func (m *mirrorREST) done(item *mirrorItem, applied bool) {
+	m.queueMutex.Lock()
+	defer m.queueMutex.Unlock()
+	m.queue = m.queue[1:]
+	if applied && m.pending[item.key] == item {
+		delete(m.pending, item.key)
+		return
+	}
+	m.queue = append(m.queue, item.key)
+}
+
+// startWorker applies the queued changes to the secondary backend one by one, retrying the failed ones after the others.
+func (m *mirrorREST) startWorker() {
+	go func(m *mirrorREST) {
+		for {
+			item := m.peek()
+			if item == nil {
+				return
+			}
+			err := m.apply(item)
+			if err == nil || apierrors.IsBadRequest(err) || apierrors.IsInvalid(err) {
+				if err != nil {
+					klog.Errorf("[%s] dropped %s event rejected by mirror storage: %v", m.groupResource, item.eventType, err)
+				}
+				m.done(item, true)
+				continue
+			}
+			klog.Errorf("[%s] failed to mirror %s event, will retry: %v", m.groupResource, item.eventType, err)
+			m.done(item, false)
+			select {
+			case <-m.ctx.Done():
+				return
+			case <-time.After(mirrorRetryInterval):
+			}
+		}
+	}(m)
+}
+
+func (m *mirrorREST) apply(item *mirrorItem) error {
+	switch item.eventType {
+	case mirrorReconcile:
+		return m.reconcile()
+	case watch.Added, watch.Modified:
+		return m.mirrorObject(item.object)
+	case watch.Deleted:
+		return m.mirrorDeletion(item.object)
+	default:
+		return nil
+	}
+}
+
+// reconcile deletes the objects in the secondary backend which don't exist in the primary one.
+// Objects missing or outdated in the secondary backend are taken care of by the listed objects queued after it.
+func (m *mirrorREST) reconcile() error {
+	primaryList, err := m.REST.List(m.ctx, &metainternalversion.ListOptions{})
+	if err != nil {
+		return err
+	}
+	secondaryList, err := m.secondary.List(m.ctx, &metainternalversion.ListOptions{})
+	if err != nil {
+		return err
+	}
+	primaryItems, err := meta.ExtractList(primaryList)
+	if err != nil {
+		return err
+	}
+	secondaryItems, err := meta.ExtractList(secondaryList)
+	if err != nil {
+		return err
+	}
+	keys := make(map[string]struct{}, len(primaryItems))
+	for _, item := range primaryItems {
+		accessor, err := meta.Accessor(item)
+		if err != nil {
+			return err
+		}
+		keys[accessor.GetNamespace()+"/"+accessor.GetName()] = struct{}{}
+	}
+	for _, item := range secondaryItems {
+		accessor, err := meta.Accessor(item)
+		if err != nil {
+			return err
+		}
+		if _, ok := keys[accessor.GetNamespace()+"/"+accessor.GetName()]; ok {
+			continue
+		}
+		if err := m.mirrorDeletion(item); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mirrorObject creates or updates the object in the secondary backend, unless it's already up-to-date.
+func (m *mirrorREST) mirrorObject(obj runtime.Object) error {
+	obj = obj.DeepCopyObject()
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return err
+	}
+	accessor.SetResourceVersion("")
+	setGitCommit(obj, "")
+
+	ctx := genericapirequest.WithNamespace(m.ctx, accessor.GetNamespace())
+	existing, err := m.secondary.Get(ctx, accessor.GetName(), &metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		_, err = m.secondary.Create(ctx, obj, nil, &metav1.CreateOptions{})
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	if mirroredObjectEqual(obj, existing) {
+		return nil
+	}
+	_, _, err = m.secondary.Update(ctx, accessor.GetName(), rest.DefaultUpdatedObjectInfo(obj), nil, nil, true, &metav1.UpdateOptions{})
+	return err
+}
+
+func (m *mirrorREST) mirrorDeletion(obj runtime.Object) error {
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return err
+	}
+	ctx := genericapirequest.WithNamespace(m.ctx, accessor.GetNamespace())
+	_, _, err = m.secondary.Delete(ctx, accessor.GetName(), nil, &metav1.DeleteOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// mirroredObjectEqual compares two objects ignoring the fields maintained by the backends themselves.
+func mirroredObjectEqual(a, b runtime.Object) bool {
+	a, b = normalizeMirroredObject(a), normalizeMirroredObject(b)
+	return a != nil && b != nil && apiequality.Semantic.DeepEqual(a, b)
+}
+
+func normalizeMirroredObject(obj runtime.Object) runtime.Object {
+	obj = obj.DeepCopyObject()
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return nil
+	}
+	accessor.SetResourceVersion("")
+	accessor.SetUID("")
+	accessor.SetCreationTimestamp(metav1.Time{})
+	setGitCommit(obj, "")
+	return obj
+}
+
+func (m *mirrorREST) startMetricsTicker() {
+	ticker := time.NewTicker(mirrorMetricsInterval)
+	go func(m *mirrorREST) {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-m.ctx.Done():
+				return
+			case <-ticker.C:
+				m.reportMetrics()
+			}
+		}
+	}(m)
+}
+
+// reportMetrics reports the age of the oldest change not yet mirrored as the replication lag.
+func (m *mirrorREST) reportMetrics() {
+	m.queueMutex.Lock()
+	pending := len(m.queue)
+	var lag time.Duration
+	for _, item := range m.pending {
+		lag = max(lag, time.Since(item.queuedAt))
+	}
+	m.queueMutex.Unlock()
+	observeMirror(m.groupResource.String(), pending, lag, m.degraded.Load())
+}
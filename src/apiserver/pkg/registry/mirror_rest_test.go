@@ -0,0 +1,277 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metainternalversion "k8s.io/apimachinery/pkg/apis/meta/internalversion"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+// failingREST fails the reads with the error set, like a primary backend going down.
+type failingREST struct {
+	REST
+	mutex sync.Mutex
+	err   error
+	// lists and watches count the calls of List and Watch, and watchers holds the watches not stopped by drop.
+	lists    int
+	watches  int
+	watchers []watch.Interface
+	// watchedVersion is the resource version the last watch started from.
+	watchedVersion string
+}
+
+func (f *failingREST) fail(err error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.err = err
+}
+
+func (f *failingREST) failure() error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.err
+}
+
+func (f *failingREST) Get(ctx context.Context, name string, options *metav1.GetOptions) (runtime.Object, error) {
+	if err := f.failure(); err != nil {
+		return nil, err
+	}
+	return f.REST.Get(ctx, name, options)
+}
+
+func (f *failingREST) List(ctx context.Context, options *metainternalversion.ListOptions) (runtime.Object, error) {
+	f.mutex.Lock()
+	f.lists++
+	f.mutex.Unlock()
+	if err := f.failure(); err != nil {
+		return nil, err
+	}
+	return f.REST.List(ctx, options)
+}
+
+func (f *failingREST) Watch(ctx context.Context, options *metainternalversion.ListOptions) (watch.Interface, error) {
+	if err := f.failure(); err != nil {
+		return nil, err
+	}
+	w, err := f.REST.Watch(ctx, options)
+	if err == nil {
+		f.mutex.Lock()
+		f.watches++
+		f.watchers = append(f.watchers, w)
+		f.watchedVersion = options.ResourceVersion
+		f.mutex.Unlock()
+	}
+	return w, err
+}
+
+// drop stops all the watches, like a backend dropping its connections.
+func (f *failingREST) drop() {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	for _, w := range f.watchers {
+		w.Stop()
+	}
+	f.watchers = nil
+}
+
+func (f *failingREST) counts() (lists, watches int, watchedVersion string) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.lists, f.watches, f.watchedVersion
+}
+
+func newTestMirrorREST(t *testing.T) (*mirrorREST, *failingREST, *boltREST) {
+	t.Helper()
+	dir := t.TempDir()
+	primary := &failingREST{REST: newTestBoltREST(t, filepath.Join(dir, "primary.db"), nil)}
+	secondary := newTestBoltREST(t, filepath.Join(dir, "secondary.db"), nil)
+	m := NewMirrorREST(testGroupResource, primary, secondary).(*mirrorREST)
+	t.Cleanup(m.Destroy)
+	return m, primary, secondary
+}
+
+// waitForMirrored waits for the object to be mirrored to the secondary backend.
+func waitForMirrored(t *testing.T, secondary REST, ns, name, value string) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		obj, err := secondary.Get(nsContext(ns), name, &metav1.GetOptions{})
+		if err == nil && obj.(*corev1.ConfigMap).Data["key"] == value {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%s/%s=%s isn't mirrored: %v", ns, name, value, err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func expectTagged(t *testing.T, what, resourceVersion string) {
+	t.Helper()
+	if !strings.HasPrefix(resourceVersion, mirrorVersionPrefix) {
+		t.Fatalf("%s has resource version %q served by the secondary backend untagged", what, resourceVersion)
+	}
+}
+
+func TestMirrorFailover(t *testing.T) {
+	m, primary, secondary := newTestMirrorREST(t)
+	a := mustCreate(t, m, "ns", "a", "v1")
+	waitForMirrored(t, secondary, "ns", "a", "v1")
+
+	primary.fail(apierrors.NewServiceUnavailable("primary is down"))
+	obj, err := m.Get(nsContext("ns"), "a", &metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get a from the mirror: %v", err)
+	}
+	expectTagged(t, "a", obj.(*corev1.ConfigMap).ResourceVersion)
+	list := mustList(t, m, "ns", nil)
+	if len(list.Items) != 1 {
+		t.Fatalf("listed %v from the mirror", list.Items)
+	}
+	expectTagged(t, "list", list.ResourceVersion)
+	expectTagged(t, "listed a", list.Items[0].ResourceVersion)
+
+	// The resource versions of the primary backend mean nothing to the secondary one.
+	if _, err := m.Get(nsContext("ns"), "a", &metav1.GetOptions{ResourceVersion: a.ResourceVersion}); !apierrors.IsResourceExpired(err) {
+		t.Fatalf("get from a primary resource version returned %v in the degraded mode, want 410", err)
+	}
+	if _, err := m.List(nsContext("ns"), &metainternalversion.ListOptions{ResourceVersion: a.ResourceVersion}); !apierrors.IsResourceExpired(err) {
+		t.Fatalf("list from a primary resource version returned %v in the degraded mode, want 410", err)
+	}
+	if _, err := m.Watch(nsContext("ns"), &metainternalversion.ListOptions{ResourceVersion: a.ResourceVersion}); !apierrors.IsResourceExpired(err) {
+		t.Fatalf("watch from a primary resource version returned %v in the degraded mode, want 410", err)
+	}
+
+	w := mustWatch(t, m, "ns", list.ResourceVersion)
+	mustCreate(t, secondary, "ns", "b", "v1")
+	ev := receiveEvents(t, w, 1)[0]
+	if got := testEvent(ev); got != "ADDED b=v1" {
+		t.Fatalf("watch on the mirror received %q, want \"ADDED b=v1\"", got)
+	}
+	expectTagged(t, "watched b", ev.Object.(*corev1.ConfigMap).ResourceVersion)
+
+	// The watch on the secondary backend ends once the primary one is back, so the client relists.
+	primary.fail(nil)
+	ev = receiveEvents(t, w, 1)[0]
+	if ev.Type != watch.Error || !apierrors.IsResourceExpired(apierrors.FromObject(ev.Object)) {
+		t.Fatalf("watch on the mirror received %s after the primary is back, want a 410 error", testEvent(ev))
+	}
+	if _, ok := <-w.ResultChan(); ok {
+		t.Fatal("watch on the mirror isn't closed after the error")
+	}
+	if _, err := m.List(nsContext("ns"), &metainternalversion.ListOptions{ResourceVersion: list.ResourceVersion}); !apierrors.IsResourceExpired(err) {
+		t.Fatalf("list from a mirror resource version returned %v after the primary is back, want 410", err)
+	}
+	if got := mustList(t, m, "ns", &metainternalversion.ListOptions{ResourceVersion: a.ResourceVersion}); len(got.Items) != 1 || got.ResourceVersion == list.ResourceVersion {
+		t.Fatalf("listed %v at %s from the primary", got.Items, got.ResourceVersion)
+	}
+}
+
+func TestMirrorFailoverErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		failover bool
+	}{
+		{name: "unavailable", err: apierrors.NewServiceUnavailable("primary is down"), failover: true},
+		{name: "connection refused", err: &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}, failover: true},
+		{name: "unreachable store", err: unavailableError(errors.New("no server available")), failover: true},
+		{name: "internal", err: apierrors.NewInternalError(errors.New("failed to decode")), failover: false},
+		{name: "decode", err: errors.New("failed to decode"), failover: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, primary, secondary := newTestMirrorREST(t)
+			mustCreate(t, m, "ns", "a", "v1")
+			waitForMirrored(t, secondary, "ns", "a", "v1")
+
+			primary.fail(tt.err)
+			_, err := m.Get(nsContext("ns"), "a", &metav1.GetOptions{})
+			if tt.failover && err != nil {
+				t.Fatalf("get failing with %v isn't served by the secondary backend: %v", tt.err, err)
+			}
+			if !tt.failover && err == nil {
+				t.Fatalf("get failing with %v is served by the secondary backend", tt.err)
+			}
+			if m.degraded.Load() != tt.failover {
+				t.Fatalf("degraded = %v after a read failing with %v", m.degraded.Load(), tt.err)
+			}
+		})
+	}
+}
+
+func TestMirrorQueueCoalesced(t *testing.T) {
+	dir := t.TempDir()
+	primary := newTestBoltREST(t, filepath.Join(dir, "primary.db"), nil)
+	secondary := &failingREST{REST: newTestBoltREST(t, filepath.Join(dir, "secondary.db"), nil)}
+	secondary.fail(apierrors.NewServiceUnavailable("secondary is down"))
+	m := NewMirrorREST(testGroupResource, primary, secondary).(*mirrorREST)
+	t.Cleanup(m.Destroy)
+
+	// The changes of an object are coalesced while the secondary backend is down, instead of piling up.
+	a := mustCreate(t, m, "ns", "a", "v0")
+	for i := 1; i <= 50; i++ {
+		a = mustUpdate(t, m, a, fmt.Sprintf("v%d", i))
+	}
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		m.queueMutex.Lock()
+		item := m.pending["ns/a"]
+		queued := len(m.queue)
+		m.queueMutex.Unlock()
+		if item != nil && item.object.(*corev1.ConfigMap).Data["key"] == "v50" {
+			// The reconciliation is queued too.
+			if queued != 2 {
+				t.Fatalf("%d changes are queued for an object", queued-1)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the changes to be queued")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	secondary.fail(nil)
+	waitForMirrored(t, secondary, "ns", "a", "v50")
+}
+
+func TestMirrorSyncResumes(t *testing.T) {
+	m, primary, secondary := newTestMirrorREST(t)
+	mustCreate(t, m, "ns", "a", "v1")
+	waitForMirrored(t, secondary, "ns", "a", "v1")
+	lists, watches, _ := primary.counts()
+
+	// The watch of the primary backend resumes from the last change seen once dropped, without relisting.
+	primary.drop()
+	mustCreate(t, m, "ns", "b", "v1")
+	waitForMirrored(t, secondary, "ns", "b", "v1")
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		if _, got, _ := primary.counts(); got > watches {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the watch of the primary backend isn't resumed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got, _, version := primary.counts(); got != lists || version == "" {
+		t.Fatalf("primary backend is relisted %d times, and watched from %q after the watch is dropped", got-lists, version)
+	}
+	mustCreate(t, m, "ns", "c", "v1")
+	waitForMirrored(t, secondary, "ns", "c", "v1")
+}
@@ -0,0 +1,330 @@
+package registry
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alibaba/higress/api-server/pkg/options"
+	"github.com/nacos-group/nacos-sdk-go/v2/common/constant"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/klog/v2"
+)
+
+const (
+	nacosChangeLogMaxRetries  = 10
+	nacosPendingRetryInterval = 100 * time.Millisecond
+)
+
+// nacosAborted is the type of the change log entries aborting changes that have failed to be made.
+const nacosAborted watch.EventType = "ABORTED"
+
+// nacosChange is an entry of the change log of a resource, which is a config holding the most recent changes made
+// to the configs of the resource, one per line in the form of "<seq> <type> <group>/<dataId>". Writers append to it
+// with CAS before changing a config, and every API server instance listens to it to pick up the changed configs.
+// The sequence numbers are the revisions of the resource, which are used as resource versions.
+//
+// A writer failing to change a config appends "<seq> ABORTED <group>/<dataId> <aborted seq>", so the instances
+// don't wait for the config to be written with the revision it has reserved.
+type nacosChange struct {
+	seq       uint64
+	eventType watch.EventType
+	key       string
+	// abortedSeq is the sequence number of the change aborted by an entry of type nacosAborted.
+	abortedSeq uint64
+}
+
+func parseNacosChangeLog(data string) []*nacosChange {
+	var changes []*nacosChange
+	for _, line := range strings.Split(data, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 3 && (len(fields) != 4 || fields[1] != string(nacosAborted)) {
+			continue
+		}
+		seq, err := strconv.ParseUint(fields[0], 10, 64)
+		if err != nil {
+			continue
+		}
+		change := &nacosChange{seq: seq, eventType: watch.EventType(fields[1]), key: fields[2]}
+		if change.eventType == nacosAborted {
+			if len(fields) != 4 {
+				continue
+			}
+			if change.abortedSeq, err = strconv.ParseUint(fields[3], 10, 64); err != nil {
+				continue
+			}
+		}
+		changes = append(changes, change)
+	}
+	return changes
+}
+
+func formatNacosChangeLog(changes []*nacosChange) string {
+	var b strings.Builder
+	for _, change := range changes {
+		if change.eventType == nacosAborted {
+			_, _ = fmt.Fprintf(&b, "%d %s %s %d\n", change.seq, change.eventType, change.key, change.abortedSeq)
+		} else {
+			_, _ = fmt.Fprintf(&b, "%d %s %s\n", change.seq, change.eventType, change.key)
+		}
+	}
+	return b.String()
+}
+
+// lastChangeSeq returns the sequence number of the last change in the log, or 0 if it's empty.
+func lastChangeSeq(changes []*nacosChange) uint64 {
+	if len(changes) == 0 {
+		return 0
+	}
+	return changes[len(changes)-1].seq
+}
+
+// configKey returns the key of a config in the change log and the known configs.
+func configKey(group, dataId string) string {
+	if group == "" {
+		// Configs of cluster-scoped objects are stored in the default group.
+		group = constant.DEFAULT_GROUP
+	}
+	return group + "/" + dataId
+}
+
+// reserveRevision appends a change about to be made to a config to the change log, and returns the sequence number of
+// the entry, which is the revision of the change, along with the content of the change log written.
+// The revision is reserved before the change is made, so it can be stored along with the object.
+// If the change fails to be made, the revision must be given back with abortRevision.
+func (n *nacosREST) reserveRevision(eventType watch.EventType, group, dataId string) (uint64, string, error) {
+	return n.appendChange(&nacosChange{eventType: eventType, key: configKey(group, dataId)})
+}
+
+// abortRevision appends an entry aborting the change of the given revision reserved by reserveRevision, which has
+// failed to be made, so the instances don't wait for it. If the entry fails to be appended, the instances give up
+// waiting once the cache sync timeout expires.
+func (n *nacosREST) abortRevision(revision uint64, group, dataId string) {
+	_, changeLog, err := n.appendChange(&nacosChange{eventType: nacosAborted, key: configKey(group, dataId), abortedSeq: revision})
+	if err != nil {
+		klog.Errorf("[%s] failed to abort change %d: %v", n.groupResource, revision, err)
+		return
+	}
+	n.processChangeLog(changeLog, nil)
+}
+
+// appendChange appends an entry to the change log with the next sequence number, and returns the sequence number along
+// with the content of the change log written.
+func (n *nacosREST) appendChange(change *nacosChange) (uint64, string, error) {
+	var err error
+	for i := 0; i < nacosChangeLogMaxRetries; i++ {
+		var data string
+		data, err = n.readRaw(changesGroup, n.changesDataId)
+		if err != nil {
+			continue
+		}
+		changes := parseNacosChangeLog(data)
+		change.seq = lastChangeSeq(changes) + 1
+		changes = append(changes, change)
+		if len(changes) > options.NacosChangeLogSize {
+			changes = changes[len(changes)-options.NacosChangeLogSize:]
+		}
+		casMd5 := ""
+		if data != "" {
+			casMd5 = calculateMd5(data)
+		}
+		changeLog := formatNacosChangeLog(changes)
+		if err = n.writeRaw(changesGroup, n.changesDataId, changeLog, casMd5); err == nil {
+			return change.seq, changeLog, nil
+		}
+	}
+	return 0, "", fmt.Errorf("failed to append to %s/%s: %w", changesGroup, n.changesDataId, err)
+}
+
+// syncWrittenChange reflects a change made by this instance in the known configs right away along with the changes
+// logged before it, so that the watchers are notified before the write returns.
+// content is the content written, or empty if the config is deleted.
+func (n *nacosREST) syncWrittenChange(revision uint64, changeLog, group, dataId, content string, waitForCacheSync bool) {
+	n.processChangeLog(changeLog, map[string]string{configKey(group, dataId): content})
+	if waitForCacheSync {
+		n.waitForCacheSync(revision)
+	}
+}
+
+// changedConfig is the current state of a changed config.
+type changedConfig struct {
+	// content is empty if the config doesn't exist.
+	content  string
+	revision uint64
+}
+
+// processChangeLog reflects the entries of the change log after the last processed one in the known configs, strictly
+// in the order of their sequence numbers, so the events are delivered in the order of their revisions.
+// The content of the configs known to be written is taken from written instead of being read. The other configs
+// changed are read before taking listRefreshMutex, so a slow Nacos doesn't hold back the reads served from the known
+// configs.
+//
+// As revisions are reserved before writing, a config read may not have been written with the revision of its entry yet.
+// Such an entry is kept pending along with all the entries following it, and the change log is processed again later,
+// until the config is written, the change is aborted, or the cache sync timeout expires, which means the writer is gone.
+// The entries of the changes overwritten by later ones are skipped, as the content they have written is gone.
+func (n *nacosREST) processChangeLog(data string, written map[string]string) {
+	for {
+		keys, trimmed := n.changedConfigKeys(data, written)
+		if !trimmed {
+			configs := map[string]*changedConfig{}
+			for key, content := range written {
+				configs[key] = &changedConfig{content: content, revision: n.configRevision(content)}
+			}
+			for _, key := range keys {
+				group, dataId, _ := strings.Cut(key, "/")
+				content, err := n.readRaw(group, dataId)
+				if err != nil {
+					// The entries of the config are processed again later.
+					klog.Errorf("failed to read changed config %s: %v", key, err)
+					continue
+				}
+				configs[key] = &changedConfig{content: content, revision: n.configRevision(content)}
+			}
+			n.applyChangeLog(data, configs)
+			return
+		}
+		if err := n.sweepConfigs(true); err != nil {
+			klog.Errorf("[%s] failed to sweep configs: %v", n.groupResource, err)
+			return
+		}
+		// The changes made during the sweep are picked up from the change log as usual.
+		var err error
+		if data, err = n.readRaw(changesGroup, n.changesDataId); err != nil {
+			klog.Errorf("[%s] failed to read change log: %v", n.groupResource, err)
+			return
+		}
+		written = nil
+	}
+}
+
+// unprocessedChanges returns the entries of the change log following the last processed one, merged with the change log
+// last processed, which may be newer, along with the sequence number of the last entry. It must be called with
+// listRefreshMutex held.
+func (n *nacosREST) unprocessedChanges(data string) (string, []*nacosChange, uint64) {
+	changes := parseNacosChangeLog(data)
+	if processed := parseNacosChangeLog(n.changeLog); lastChangeSeq(processed) > lastChangeSeq(changes) {
+		// The notifications of the older versions of the change log may arrive late.
+		data, changes = n.changeLog, processed
+	}
+	return data, changes, lastChangeSeq(changes)
+}
+
+// changedConfigKeys returns the keys of the configs to read for the unprocessed entries of the change log, except
+// the written ones. trimmed is set if some of the entries have been trimmed, in which case all the configs are swept.
+func (n *nacosREST) changedConfigKeys(data string, written map[string]string) (keys []string, trimmed bool) {
+	n.listRefreshMutex.Lock()
+	defer n.listRefreshMutex.Unlock()
+
+	if n.configs == nil {
+		// Not synced yet, and the initial sweep will pick up all the changes.
+		return nil, false
+	}
+	_, changes, lastSeq := n.unprocessedChanges(data)
+	if lastSeq <= n.changeSeq {
+		return nil, false
+	}
+	if changes[0].seq > n.changeSeq+1 {
+		klog.Warningf("[%s] changes %d-%d have been trimmed from the change log, sweeping all the configs",
+			n.groupResource, n.changeSeq+1, changes[0].seq-1)
+		return nil, true
+	}
+	seen := map[string]bool{}
+	for _, change := range changes {
+		if change.seq <= n.changeSeq || change.eventType == nacosAborted || seen[change.key] {
+			continue
+		}
+		if _, ok := written[change.key]; !ok {
+			keys = append(keys, change.key)
+		}
+		seen[change.key] = true
+	}
+	return keys, false
+}
+
+// applyChangeLog reflects the entries of the change log in the known configs, given the configs changed read for them.
+// Processing stops at the entries of the configs not read, which are processed again later.
+func (n *nacosREST) applyChangeLog(data string, configs map[string]*changedConfig) {
+	n.listRefreshMutex.Lock()
+	defer n.listRefreshMutex.Unlock()
+
+	if n.configs == nil {
+		return
+	}
+	data, changes, lastSeq := n.unprocessedChanges(data)
+	if lastSeq <= n.changeSeq || changes[0].seq > n.changeSeq+1 {
+		// Processed meanwhile, or to be picked up by a sweep.
+		return
+	}
+	n.changeLog = data
+
+	aborted := map[uint64]bool{}
+	for _, change := range changes {
+		if change.eventType == nacosAborted {
+			aborted[change.abortedSeq] = true
+		}
+	}
+	// The last deletion of each config, which overwrites the changes logged before it.
+	deletedSeqs := map[string]uint64{}
+	for _, change := range changes {
+		if change.eventType == watch.Deleted && !aborted[change.seq] {
+			deletedSeqs[change.key] = change.seq
+		}
+	}
+	syncedSeq := n.changeSeq
+	for _, change := range changes {
+		if change.seq <= n.changeSeq {
+			continue
+		}
+		if change.eventType != nacosAborted && !aborted[change.seq] {
+			config, ok := configs[change.key]
+			if !ok {
+				break
+			}
+			var pending bool
+			if change.eventType == watch.Deleted {
+				// Deleted once the config is gone, or created again after it.
+				pending = config.content != "" && config.revision <= change.seq
+				if !pending {
+					n.applyConfig(change.key, "", change.seq)
+				}
+			} else {
+				overwritten := config.content == "" && deletedSeqs[change.key] > change.seq
+				pending = !overwritten && (config.content == "" || config.revision < change.seq)
+				if config.revision == change.seq {
+					n.applyConfig(change.key, config.content, change.seq)
+				}
+			}
+			if pending && !n.pendingChangeExpired(change.seq) {
+				break
+			}
+		}
+		syncedSeq = change.seq
+	}
+	n.setChangeSeq(syncedSeq)
+	if syncedSeq < lastSeq && !n.pendingRetryScheduled {
+		n.pendingRetryScheduled = true
+		time.AfterFunc(nacosPendingRetryInterval, func() {
+			n.listRefreshMutex.Lock()
+			n.pendingRetryScheduled = false
+			changeLog := n.changeLog
+			n.listRefreshMutex.Unlock()
+			n.processChangeLog(changeLog, nil)
+		})
+	}
+}
+
+// pendingChangeExpired tells whether the change of the given sequence number has been waited for to be made for longer
+// than the cache sync timeout, in which case it's given up on.
+func (n *nacosREST) pendingChangeExpired(seq uint64) bool {
+	if n.pendingSeq != seq {
+		n.pendingSeq, n.pendingSince = seq, time.Now()
+		return nacosCacheSyncTimeout <= 0
+	}
+	if time.Since(n.pendingSince) < nacosCacheSyncTimeout {
+		return false
+	}
+	klog.Warningf("[%s] change %d is given up on, as it isn't made in %v", n.groupResource, seq, nacosCacheSyncTimeout)
+	return true
+}
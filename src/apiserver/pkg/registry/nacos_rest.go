@@ -4,7 +4,6 @@ import (
 	"bytes"
 	"context"
 	"crypto/md5"
-	"encoding/base64"
 	"errors"
 	"fmt"
 	"io"
@@ -15,9 +14,8 @@ import (
 	"sync/atomic"
 	"time"
 
+	"github.com/alibaba/higress/api-server/pkg/encryption"
 	"github.com/alibaba/higress/api-server/pkg/options"
-	"github.com/alibaba/higress/api-server/pkg/utils"
-	"github.com/google/uuid"
 	"github.com/nacos-group/nacos-sdk-go/v2/clients/config_client"
 	"github.com/nacos-group/nacos-sdk-go/v2/common/constant"
 	"github.com/nacos-group/nacos-sdk-go/v2/model"
@@ -32,41 +30,52 @@ import (
 	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/uuid"
 	"k8s.io/apimachinery/pkg/watch"
 	genericapirequest "k8s.io/apiserver/pkg/endpoints/request"
 	"k8s.io/apiserver/pkg/registry/rest"
 	"k8s.io/apiserver/pkg/storage"
+	"k8s.io/apiserver/pkg/util/dryrun"
 	"k8s.io/klog/v2"
 )
 
 const dataIdSeparator = "."
 const wildcardSuffix = dataIdSeparator + "*"
-const encryptionMark = "enc|"
-const namesSuffix = "__names__"
-const namesGroup = constant.DEFAULT_GROUP
-const emptyNamesPlaceholder = "EMPTY"
-const defaultNacosCacheSyncDelay time.Duration = 500 * time.Millisecond
+const changesSuffix = "__changes__"
+const changesGroup = constant.DEFAULT_GROUP
+const reservedDataIdSuffix = "__"
+const nacosListenRetryInterval = 10 * time.Second
+const defaultNacosCacheSyncTimeout time.Duration = 5 * time.Second
 
 var (
-	nacosCacheSyncDelay = defaultNacosCacheSyncDelay
+	nacosCacheSyncTimeout = defaultNacosCacheSyncTimeout
 )
 
 func init() {
-	// Read nacosCacheSyncDelay from environment variable NACOS_CACHE_SYNC_DELAY
-	if delayStr := os.Getenv("NACOS_CACHE_SYNC_DELAY"); delayStr != "" {
-		if delay, err := time.ParseDuration(delayStr); err == nil {
-			nacosCacheSyncDelay = delay
+	// Read nacosCacheSyncTimeout from environment variable NACOS_CACHE_SYNC_TIMEOUT
+	if timeoutStr := os.Getenv("NACOS_CACHE_SYNC_TIMEOUT"); timeoutStr != "" {
+		if timeout, err := time.ParseDuration(timeoutStr); err == nil {
+			nacosCacheSyncTimeout = timeout
 		} else {
-			klog.Errorf("failed to parse NACOS_CACHE_SYNC_DELAY: %v, using default value %v", err, nacosCacheSyncDelay)
+			klog.Errorf("failed to parse NACOS_CACHE_SYNC_TIMEOUT: %v, using default value %v", err, nacosCacheSyncTimeout)
 		}
 	}
-	klog.Infof("NacosCacheSyncDelay: %v", nacosCacheSyncDelay)
+	klog.Infof("NacosCacheSyncTimeout: %v", nacosCacheSyncTimeout)
 }
 
 // ErrItemAlreadyExists means the item already exists.
 var ErrItemAlreadyExists = fmt.Errorf("item already exists")
 
+// errNacosConfigChanged means a config isn't written, as it's been changed since it was read.
+var errNacosConfigChanged = errors.New("config has been changed")
+
+// nacosCasFailureMessage is in the lower-cased message of the failures of CAS writes, e.g.
+// "Cas publish fail,server md5 may have changed."
+const nacosCasFailureMessage = "cas publish fail"
+
 var _ rest.StandardStorage = &nacosREST{}
+var _ Reencrypter = &nacosREST{}
 var _ rest.Scoper = &nacosREST{}
 var _ rest.Storage = &nacosREST{}
 
@@ -80,7 +89,7 @@ func NewNacosREST(
 	newFunc func() runtime.Object,
 	newListFunc func() runtime.Object,
 	attrFunc storage.AttrFunc,
-	dataEncryptionKey []byte,
+	policy *encryption.Policy,
 ) REST {
 	if attrFunc == nil {
 		if isNamespaced {
@@ -90,20 +99,22 @@ func NewNacosREST(
 		}
 	}
 	n := &nacosREST{
-		TableConvertor: rest.NewDefaultTableConvertor(groupResource),
-		groupResource:  groupResource,
-		codec:          codec,
-		configClient:   configClient,
-		isNamespaced:   isNamespaced,
-		singularName:   singularName,
-		dataIdPrefix:   strings.ToLower(groupResource.Resource),
-		newFunc:        newFunc,
-		newListFunc:    newListFunc,
-		attrFunc:       attrFunc,
-		watchers:       make(map[string]*nacosWatch, 10),
-		encryptionKey:  dataEncryptionKey,
-	}
-	n.namesDataId = n.dataIdPrefix + dataIdSeparator + namesSuffix
+		TableConvertor:  rest.NewDefaultTableConvertor(groupResource),
+		groupResource:   groupResource,
+		codec:           codec,
+		configClient:    configClient,
+		isNamespaced:    isNamespaced,
+		singularName:    singularName,
+		dataIdPrefix:    strings.ToLower(groupResource.Resource),
+		newFunc:         newFunc,
+		newListFunc:     newListFunc,
+		attrFunc:        attrFunc,
+		watchers:        newWatchBroadcaster(),
+		policy:          policy,
+		changeSeqSynced: make(chan struct{}),
+		configErrors:    map[string]error{},
+	}
+	n.changesDataId = n.dataIdPrefix + dataIdSeparator + changesSuffix
 	n.startBackgroundWatcher()
 	return n
 }
@@ -117,26 +128,51 @@ type nacosREST struct {
 	singularName  string
 	dataIdPrefix  string
 
-	listRefreshMutex   sync.Mutex
-	listRefreshTicker  *time.Ticker
-	listConfigListened int32
-	watchersMutex      sync.RWMutex
-	watchers           map[string]*nacosWatch
+	// listRefreshMutex serializes the sweeps of configs and the processing of the change log.
+	listRefreshMutex  sync.Mutex
+	listRefreshTicker *time.Ticker
+	changesListened   int32
+	watchers          *watchBroadcaster
 
 	newFunc     func() runtime.Object
 	newListFunc func() runtime.Object
 	attrFunc    storage.AttrFunc
 
-	encryptionKey []byte
+	// policy encrypts the configs of the objects selected, and is nil if encryption isn't enabled.
+	policy *encryption.Policy
+
+	changesDataId string
+	// configs holds all the known configs keyed by "<group>/<dataId>", which is nil until the first sweep.
+	configs map[string]*nacosConfig
+	// configErrors holds the errors of the configs failing to be decoded, e.g. without the key encrypting them,
+	// which are kept out of configs.
+	configErrors map[string]error
+	// history holds the most recent events for resuming watches, which is nil until the first sweep.
+	history *eventHistory
+	// changeSeq is the sequence number of the last change log entry reflected in configs.
+	changeSeq uint64
+	// changeSeqSynced is closed and replaced whenever changeSeq is advanced.
+	changeSeqSynced chan struct{}
+	// changeLog is the content of the change log last processed.
+	changeLog string
+	// pendingSeq is the sequence number of the change log entry waited for to be made since pendingSince.
+	pendingSeq            uint64
+	pendingSince          time.Time
+	pendingRetryScheduled bool
+}
 
-	namesDataId string
-	configItems map[string]*model.ConfigItem
+// nacosConfig is a known config along with the object decoded from it.
+type nacosConfig struct {
+	content string
+	object  runtime.Object
 }
 
 func (n *nacosREST) GetSingularName() string {
 	return n.singularName
 }
 
+// startBackgroundWatcher starts listening to the change log, and sweeps all the configs once it's listened to
+// and periodically after that, in case of missing changes, including those made outside the API servers.
 func (n *nacosREST) startBackgroundWatcher() {
 	if n.listRefreshTicker != nil {
 		return
@@ -149,33 +185,36 @@ func (n *nacosREST) startBackgroundWatcher() {
 		return
 	}
 
-	n.listRefreshTicker = time.NewTicker(time.Duration(options.NacosListRefreshIntervalSecs) * time.Second)
-	go func(n *nacosREST) {
+	n.listRefreshTicker = time.NewTicker(nacosListenRetryInterval)
+	go func(n *nacosREST, ticker *time.Ticker) {
+		sweepInterval := time.Duration(options.NacosConsistencySweepIntervalSecs) * time.Second
+		var lastSweep time.Time
 		for {
-			<-n.listRefreshTicker.C
-			n.listRefreshTickerFunc()
-		}
-	}(n)
-}
-
-func (n *nacosREST) listRefreshTickerFunc() {
-	if atomic.LoadInt32(&n.listConfigListened) == 0 {
-		if err := n.watchNamesConfig(); err == nil {
-			atomic.StoreInt32(&n.listConfigListened, 1)
-		} else {
-			klog.Errorf("failed to watch names config: %v", err)
+			if atomic.LoadInt32(&n.changesListened) == 0 {
+				if err := n.watchChangeLog(); err == nil {
+					atomic.StoreInt32(&n.changesListened, 1)
+				} else {
+					klog.Errorf("failed to watch change log: %v", err)
+				}
+			}
+			if time.Since(lastSweep) >= sweepInterval {
+				if err := n.refreshConfigList(); err == nil {
+					lastSweep = time.Now()
+				} else {
+					klog.Errorf("[%s] failed to sweep configs: %v", n.groupResource, err)
+				}
+			}
+			<-ticker.C
 		}
-	}
-
-	n.refreshConfigList()
+	}(n, n.listRefreshTicker)
 }
 
-func (n *nacosREST) watchNamesConfig() error {
+func (n *nacosREST) watchChangeLog() error {
 	return n.configClient.ListenConfig(vo.ConfigParam{
-		DataId: n.namesDataId,
-		Group:  namesGroup,
+		DataId: n.changesDataId,
+		Group:  changesGroup,
 		OnChange: func(namespace, group, dataId, data string) {
-			n.refreshConfigList()
+			n.processChangeLog(data, nil)
 		},
 	})
 }
@@ -189,14 +228,18 @@ func (n *nacosREST) Destroy() {
 	}
 }
 
-func (n *nacosREST) notifyWatchers(ev watch.Event) {
-	n.watchersMutex.RLock()
-	defer n.watchersMutex.RUnlock()
-	accessor, _ := meta.Accessor(ev.Object)
-	klog.Infof("event %s %s %s/%s count(watcher)=%d", ev.Type, ev.Object.GetObjectKind(), accessor.GetNamespace(), accessor.GetName(), len(n.watchers))
-	for _, w := range n.watchers {
-		w.SendEvent(ev, false)
+// notifyWatchers records an event into the history and sends it to all the watchers.
+// prevObj is the state of the object before a modification, if known.
+// It must be called with listRefreshMutex held, so events are delivered in the order they are observed.
+func (n *nacosREST) notifyWatchers(revision uint64, ev watch.Event, prevObj runtime.Object) {
+	wev := watchEvent{Event: ev, prevObject: prevObj}
+	if n.history != nil {
+		n.history.add(revision, wev)
 	}
+
+	accessor, _ := meta.Accessor(ev.Object)
+	klog.Infof("event %s %s %s/%s rv=%d count(watcher)=%d", ev.Type, ev.Object.GetObjectKind(), accessor.GetNamespace(), accessor.GetName(), revision, n.watchers.count())
+	n.watchers.broadcast(wev)
 }
 
 func (n *nacosREST) New() runtime.Object {
@@ -217,7 +260,11 @@ func (n *nacosREST) Get(
 	options *metav1.GetOptions,
 ) (runtime.Object, error) {
 	ns, _ := genericapirequest.NamespaceFrom(ctx)
-	obj, _, err := n.read(n.codec, ns, n.objectDataId(ctx, name), n.newFunc)
+	dataId := n.objectDataId(ctx, name)
+	obj, synced, err := n.getKnown(ns, dataId)
+	if !synced {
+		obj, _, err = n.read(n.codec, ns, dataId, n.newFunc)
+	}
 	if obj == nil && err == nil {
 		requestInfo, ok := genericapirequest.RequestInfoFrom(ctx)
 		var groupResource = schema.GroupResource{}
@@ -232,13 +279,37 @@ func (n *nacosREST) Get(
 	if err == nil {
 		return obj, nil
 	}
-	return obj, apierrors.NewInternalError(err)
+	return obj, toAPIError(err)
+}
+
+// getKnown returns the object of a known config, which reflects all the writes made by this instance, as they don't
+// return until then, while the config read from Nacos may not, as its cache lags behind the writes.
+// synced is false if the configs aren't known yet.
+func (n *nacosREST) getKnown(group, dataId string) (obj runtime.Object, synced bool, err error) {
+	n.listRefreshMutex.Lock()
+	defer n.listRefreshMutex.Unlock()
+	if n.configs == nil {
+		return nil, false, nil
+	}
+	key := configKey(group, dataId)
+	if err := n.configErrors[key]; err != nil {
+		return nil, true, err
+	}
+	if config, ok := n.configs[key]; ok {
+		return config.object.DeepCopyObject(), true, nil
+	}
+	return nil, true, nil
 }
 
 func (n *nacosREST) List(
 	ctx context.Context,
 	options *metainternalversion.ListOptions,
 ) (runtime.Object, error) {
+	token, err := decodeContinue(options)
+	if err != nil {
+		return nil, err
+	}
+
 	newListObj := n.NewList()
 	v, err := getListPrt(newListObj)
 	if err != nil {
@@ -246,13 +317,51 @@ func (n *nacosREST) List(
 	}
 
 	ns, _ := genericapirequest.NamespaceFrom(ctx)
+	predicate := n.buildListPredicate(options)
+
+	// Like Get, the objects are listed from the known configs once synced.
+	n.listRefreshMutex.Lock()
+	if n.configs != nil {
+		defer n.listRefreshMutex.Unlock()
+		revision := n.changeSeq
+		if token != nil {
+			if err := n.checkContinueLocked(ns, token); err != nil {
+				return nil, err
+			}
+			revision = token.Revision
+		}
+		count := 0
+		for key, config := range n.configs {
+			if group, _, _ := strings.Cut(key, "/"); ns != "" && group != ns || n.configErrors[key] != nil {
+				continue
+			}
+			if ok, err := predicate.Matches(config.object); err == nil && ok {
+				appendItem(v, config.object.DeepCopyObject())
+				count++
+			}
+		}
+		setListResourceVersion(newListObj, revision)
+		klog.Infof("[%s] %s list count=%d rv=%d", n.groupResource, ns, count, revision)
+		return paginate(newListObj, options, token)
+	}
+	n.listRefreshMutex.Unlock()
+
+	// Not synced yet, so the objects are listed from Nacos, and the changes synced before the search are all reflected
+	// in the result. The search results aren't ordered by key, so a chunk can't be mapped onto their pages, and the
+	// limit is ignored instead, which the clients take as the whole list. The tokens can only come from other
+	// replicas then, and as no event history is known to tell the changes since their first chunks, they have expired.
+	if token != nil {
+		return nil, apierrors.NewResourceExpired(continueExpiredMessage)
+	}
+	n.listRefreshMutex.Lock()
+	revision := n.changeSeq
+	n.listRefreshMutex.Unlock()
 
 	searchConfigParam := vo.SearchConfigParam{
 		Search: "blur",
 		DataId: n.dataIdPrefix + wildcardSuffix,
 		Group:  ns,
 	}
-	predicate := n.buildListPredicate(options)
 	count := 0
 	err = n.enumerateConfigs(&searchConfigParam, func(item *model.ConfigItem) {
 		obj, err := n.decodeConfig(n.codec, item.Content, n.newFunc)
@@ -266,13 +375,28 @@ func (n *nacosREST) List(
 		}
 	})
 	if err != nil {
-		return nil, apierrors.NewInternalError(err)
+		return nil, toAPIError(err)
 	}
 
-	klog.Infof("[%s] %s list count=%d", n.groupResource, ns, count)
+	setListResourceVersion(newListObj, revision)
+	klog.Infof("[%s] %s list count=%d rv=%d", n.groupResource, ns, count, revision)
 	return newListObj, nil
 }
 
+// checkContinueLocked returns a "410 Gone" error if the objects of the chunks following the token have been changed
+// since its first chunk, or the changes can't be told as the event history doesn't go back that far. It must be
+// called with listRefreshMutex held.
+func (n *nacosREST) checkContinueLocked(ns string, token *continueToken) error {
+	if n.history == nil {
+		return apierrors.NewResourceExpired(continueExpiredMessage)
+	}
+	events, err := n.history.since(token.Revision)
+	if err != nil {
+		return err
+	}
+	return token.checkEvents(ns, events)
+}
+
 func (n *nacosREST) Create(
 	ctx context.Context,
 	obj runtime.Object,
@@ -281,13 +405,13 @@ func (n *nacosREST) Create(
 ) (runtime.Object, error) {
 	if createValidation != nil {
 		if err := createValidation(ctx, obj); err != nil {
-			return nil, apierrors.NewInternalError(err)
+			return nil, toAPIError(err)
 		}
 	}
 
 	accessor, err := meta.Accessor(obj)
 	if err != nil {
-		return nil, apierrors.NewInternalError(err)
+		return nil, toAPIError(err)
 	}
 
 	ns, _ := genericapirequest.NamespaceFrom(ctx)
@@ -301,24 +425,32 @@ func (n *nacosREST) Create(
 		return nil, apierrors.NewConflict(n.groupResource, name, ErrItemAlreadyExists)
 	}
 
+	accessor.SetUID(uuid.NewUUID())
 	accessor.SetCreationTimestamp(metav1.NewTime(time.Now()))
-	if err := n.write(n.codec, ns, dataId, "", obj); err != nil {
-		return nil, apierrors.NewInternalError(err)
+	if dryrun.IsDryRun(options.DryRun) {
+		return obj, nil
 	}
 
-	nameKey := ns + "/" + name
-	namesData, err := n.readRaw(namesGroup, n.namesDataId)
+	revision, changeLog, err := n.reserveRevision(watch.Added, ns, dataId)
 	if err != nil {
-		klog.Errorf("failed to read %s/%s: %v", namesGroup, n.namesDataId, err)
-	} else {
-		newNamesData := namesData + nameKey + "\n"
-		err := n.writeRaw(namesGroup, n.namesDataId, newNamesData, calculateMd5(namesData))
+		return nil, toAPIError(err)
+	}
+	// Configs can't be created with CAS, so the config is checked again once the revision is reserved,
+	// which catches the creations racing with this one unless they are checked at the same time.
+	if currentConfig, err := n.readRaw(ns, dataId); err != nil || currentConfig != "" {
+		n.abortRevision(revision, ns, dataId)
 		if err != nil {
-			klog.Errorf("failed to update %s/%s: %v", namesGroup, n.namesDataId, err)
+			return nil, toAPIError(err)
 		}
+		return nil, apierrors.NewConflict(n.groupResource, name, ErrItemAlreadyExists)
+	}
+	content, err := n.write(n.codec, ns, dataId, "", revision, obj)
+	if err != nil {
+		n.abortRevision(revision, ns, dataId)
+		return nil, n.writeError(name, err)
 	}
 
-	n.waitForCacheSync()
+	n.syncWrittenChange(revision, changeLog, ns, dataId, content, true)
 
 	return obj, nil
 }
@@ -338,7 +470,7 @@ func (n *nacosREST) Update(
 	isCreate := false
 	oldObj, oldConfig, err := n.read(n.codec, ns, dataId, n.newFunc)
 	if err != nil {
-		return nil, false, apierrors.NewInternalError(err)
+		return nil, false, toAPIError(err)
 	}
 	if oldConfig == "" {
 		if !forceAllowCreate {
@@ -349,39 +481,52 @@ func (n *nacosREST) Update(
 
 	updatedObj, err := objInfo.UpdatedObject(ctx, oldObj)
 	if err != nil {
-		return nil, false, apierrors.NewInternalError(err)
+		return nil, false, toAPIError(err)
+	}
+
+	if isCreate {
+		obj, err := n.Create(ctx, updatedObj, createValidation, updateToCreateOptions(options))
+		if err != nil {
+			return nil, false, toAPIError(err)
+		}
+		return obj, true, nil
 	}
 
 	oldAccessor, err := meta.Accessor(oldObj)
 	if err != nil {
-		return nil, false, apierrors.NewInternalError(err)
+		return nil, false, toAPIError(err)
 	}
 
 	updatedAccessor, err := meta.Accessor(updatedObj)
 	if err != nil {
-		return nil, false, apierrors.NewInternalError(err)
-	}
-
-	if isCreate {
-		obj, err := n.Create(ctx, updatedObj, createValidation, nil)
-		return obj, err == nil, apierrors.NewInternalError(err)
+		return nil, false, toAPIError(err)
 	}
 
 	if updateValidation != nil {
 		if err := updateValidation(ctx, updatedObj, oldObj); err != nil {
-			return nil, false, apierrors.NewInternalError(err)
+			return nil, false, toAPIError(err)
 		}
 	}
 
 	if updatedAccessor.GetResourceVersion() != "" && updatedAccessor.GetResourceVersion() != oldAccessor.GetResourceVersion() {
-		return nil, false, apierrors.NewConflict(n.groupResource, name, nil)
+		return nil, false, apierrors.NewConflict(n.groupResource, name, errors.New(optimisticLockErrorMsg))
+	}
+	updatedAccessor.SetUID(oldAccessor.GetUID())
+	if dryrun.IsDryRun(options.DryRun) {
+		return updatedObj, false, nil
 	}
 
-	if err := n.write(n.codec, ns, dataId, oldAccessor.GetResourceVersion(), updatedObj); err != nil {
-		return nil, false, apierrors.NewInternalError(err)
+	revision, changeLog, err := n.reserveRevision(watch.Modified, ns, dataId)
+	if err != nil {
+		return nil, false, toAPIError(err)
+	}
+	content, err := n.write(n.codec, ns, dataId, calculateMd5(oldConfig), revision, updatedObj)
+	if err != nil {
+		n.abortRevision(revision, ns, dataId)
+		return nil, false, n.writeError(name, err)
 	}
 
-	n.waitForCacheSync()
+	n.syncWrittenChange(revision, changeLog, ns, dataId, content, true)
 
 	return updatedObj, false, nil
 }
@@ -394,47 +539,51 @@ func (n *nacosREST) doDelete(
 	waitForCacheSync bool) (runtime.Object, bool, error) {
 	dataId := n.objectDataId(ctx, name)
 
-	oldObj, err := n.Get(ctx, name, nil)
+	ns, _ := genericapirequest.NamespaceFrom(ctx)
+	oldObj, oldConfig, err := n.read(n.codec, ns, dataId, n.newFunc)
 	if err != nil {
-		return nil, false, err
+		return nil, false, toAPIError(err)
+	}
+	if oldConfig == "" {
+		return nil, false, apierrors.NewNotFound(n.groupResource, name)
 	}
 	if deleteValidation != nil {
 		if err := deleteValidation(ctx, oldObj); err != nil {
 			return nil, false, apierrors.NewBadRequest(err.Error())
 		}
 	}
+	if dryrun.IsDryRun(options.DryRun) {
+		return oldObj, true, nil
+	}
 
-	ns, _ := genericapirequest.NamespaceFrom(ctx)
+	revision, changeLog, err := n.reserveRevision(watch.Deleted, ns, dataId)
+	if err != nil {
+		return nil, false, toAPIError(err)
+	}
+	// Configs can't be deleted with CAS, so the config is checked again once the revision is reserved,
+	// which catches the updates racing with this deletion unless they are made at the same time.
+	if currentConfig, err := n.readRaw(ns, dataId); err != nil || currentConfig != oldConfig {
+		n.abortRevision(revision, ns, dataId)
+		if err != nil {
+			return nil, false, toAPIError(err)
+		}
+		return nil, false, n.writeError(name, errNacosConfigChanged)
+	}
 	deleted, err := n.configClient.DeleteConfig(vo.ConfigParam{
 		DataId: dataId,
 		Group:  ns,
 	})
 	if err != nil {
-		return nil, false, apierrors.NewInternalError(err)
-	}
-	if !deleted {
-		return nil, false, errors.New("delete config failed: " + dataId)
+		err = unavailableError(err)
+	} else if !deleted {
+		err = errors.New("delete config failed: " + dataId)
 	}
-
-	nameKey := ns + "/" + name
-	namesData, err := n.readRaw(namesGroup, n.namesDataId)
 	if err != nil {
-		klog.Errorf("failed to read %s/%s: %v", namesGroup, n.namesDataId, err)
-	} else {
-		newNamesData := strings.Replace(namesData, nameKey+"\n", "", -1)
-		if newNamesData == "" {
-			// Use a placeholder since Nacos does not support empty content
-			newNamesData = emptyNamesPlaceholder
-		}
-		err := n.writeRaw(namesGroup, n.namesDataId, newNamesData, calculateMd5(namesData))
-		if err != nil {
-			klog.Errorf("failed to update %s/%s: %v", namesGroup, n.namesDataId, err)
-		}
+		n.abortRevision(revision, ns, dataId)
+		return nil, false, toAPIError(err)
 	}
 
-	if waitForCacheSync {
-		n.waitForCacheSync()
-	}
+	n.syncWrittenChange(revision, changeLog, ns, dataId, "", waitForCacheSync)
 
 	return oldObj, true, nil
 }
@@ -461,7 +610,7 @@ func (n *nacosREST) DeleteCollection(
 	deletedItems := n.NewList()
 	v, err := getListPrt(deletedItems)
 	if err != nil {
-		return nil, apierrors.NewInternalError(err)
+		return nil, toAPIError(err)
 	}
 
 	for _, obj := range list.(*unstructured.UnstructuredList).Items {
@@ -472,46 +621,81 @@ func (n *nacosREST) DeleteCollection(
 	return deletedItems, nil
 }
 
+// writeError returns the API error of a failure to write the config of an object, which is a conflict if the config
+// has been changed since it was read, so the clients retry.
+func (n *nacosREST) writeError(name string, err error) error {
+	if errors.Is(err, errNacosConfigChanged) {
+		return apierrors.NewConflict(n.groupResource, name, errors.New(optimisticLockErrorMsg))
+	}
+	return toAPIError(err)
+}
+
 func (n *nacosREST) objectDataId(ctx context.Context, name string) string {
 	return strings.Join([]string{n.dataIdPrefix, name}, dataIdSeparator)
 }
 
 func (n *nacosREST) Watch(ctx context.Context, options *metainternalversion.ListOptions) (watch.Interface, error) {
 	ns, _ := genericapirequest.NamespaceFrom(ctx)
-	predicate := n.buildListPredicate(options)
-	nw := &nacosWatch{
-		id:        uuid.New().String(),
-		f:         n,
-		ch:        make(chan watch.Event, 1024),
-		ns:        ns,
-		predicate: &predicate,
-	}
+	filter := newSelectionFilter(ns, n.buildListPredicate(options))
 
 	n.startBackgroundWatcher()
 
+	if !shouldSendInitialEvents(options) {
+		// Resume from the given resource version
+		revision, err := parseResourceVersion(options.ResourceVersion)
+		if err != nil {
+			return nil, err
+		}
+		// Hold the lock until the watcher is registered, so no event is missed or duplicated.
+		n.listRefreshMutex.Lock()
+		defer n.listRefreshMutex.Unlock()
+		if n.history == nil {
+			return nil, apierrors.NewResourceExpired(fmt.Sprintf("too old resource version: %d", revision))
+		}
+		events, err := n.history.since(revision)
+		if err != nil {
+			return nil, err
+		}
+		var initialEvents []watch.Event
+		for _, ev := range events {
+			if filtered, ok := filter(ev); ok {
+				initialEvents = append(initialEvents, filtered)
+			}
+		}
+		return n.watchers.watch(ctx, initialEvents, filter, false), nil
+	}
+
 	// On initial watch, send all the existing objects
+	n.listRefreshMutex.Lock()
+	if n.configs != nil {
+		defer n.listRefreshMutex.Unlock()
+		var initialEvents []watch.Event
+		for _, config := range n.configs {
+			if ev, ok := filter(watchEvent{Event: watch.Event{Type: watch.Added, Object: config.object.DeepCopyObject()}}); ok {
+				initialEvents = append(initialEvents, ev)
+			}
+		}
+		return n.watchers.watch(ctx, initialEvents, filter, false), nil
+	}
+	n.listRefreshMutex.Unlock()
+
+	// Not synced yet, so the objects are listed from Nacos.
 	list, err := n.List(ctx, options)
 	if err != nil {
 		return nil, err
 	}
 
-	go func() {
-		danger := reflect.ValueOf(list).Elem()
-		items := danger.FieldByName("Items")
-
-		for i := 0; i < items.Len(); i++ {
-			nw.SendEvent(watch.Event{
-				Type:   watch.Added,
-				Object: listItemToRuntimeObject(items.Index(i)),
-			}, true)
-		}
-	}()
-
-	n.watchersMutex.Lock()
-	defer n.watchersMutex.Unlock()
-	n.watchers[nw.id] = nw
+	danger := reflect.ValueOf(list).Elem()
+	items := danger.FieldByName("Items")
+	initialEvents := make([]watch.Event, 0, items.Len())
+	for i := 0; i < items.Len(); i++ {
+		initialEvents = append(initialEvents, watch.Event{
+			Type:   watch.Added,
+			Object: listItemToRuntimeObject(items.Index(i)),
+		})
+	}
 
-	return nw, nil
+	return n.watchers.watch(ctx, initialEvents, filter, false), nil
 }
 
 func (n *nacosREST) buildListPredicate(options *metainternalversion.ListOptions) storage.SelectionPredicate {
@@ -541,7 +725,7 @@ func (n *nacosREST) enumerateConfigs(param *vo.SearchConfigParam, action func(*m
 	for {
 		page, err := n.configClient.SearchConfig(searchConfigParam)
 		if err != nil {
-			return err
+			return unavailableError(err)
 		}
 
 		if page.PagesAvailable == 0 {
@@ -549,7 +733,7 @@ func (n *nacosREST) enumerateConfigs(param *vo.SearchConfigParam, action func(*m
 		}
 
 		for _, item := range page.PageItems {
-			if item.Group == namesGroup && item.DataId == n.namesDataId {
+			if isReservedConfig(&item) {
 				continue
 			}
 			localItem := *(&item)
@@ -565,6 +749,11 @@ func (n *nacosREST) enumerateConfigs(param *vo.SearchConfigParam, action func(*m
 	return nil
 }
 
+// isReservedConfig tells whether a config is the change log, or the name list used by previous versions.
+func isReservedConfig(item *model.ConfigItem) bool {
+	return item.Group == changesGroup && strings.HasSuffix(item.DataId, reservedDataIdSuffix)
+}
+
 func (n *nacosREST) read(decoder runtime.Decoder, group, dataId string, newFunc func() runtime.Object) (runtime.Object, string, error) {
 	config, err := n.readRaw(group, dataId)
 	if err != nil {
@@ -582,14 +771,18 @@ func (n *nacosREST) read(decoder runtime.Decoder, group, dataId string, newFunc
 }
 
 func (n *nacosREST) readRaw(group, dataId string) (string, error) {
-	return n.configClient.GetConfig(vo.ConfigParam{
+	config, err := n.configClient.GetConfig(vo.ConfigParam{
 		DataId: dataId,
 		Group:  group,
 	})
+	if err != nil {
+		return "", unavailableError(err)
+	}
+	return config, nil
 }
 
 func (n *nacosREST) decodeConfig(decoder runtime.Decoder, config string, newFunc func() runtime.Object) (runtime.Object, error) {
-	decryptedConfig, err := n.decryptConfig(config)
+	decryptedConfig, err := n.policy.Decrypt(config)
 	if err != nil {
 		klog.Infof("failed to decoded config #1: %v\n%s", err, config)
 		return nil, err
@@ -599,39 +792,55 @@ func (n *nacosREST) decodeConfig(decoder runtime.Decoder, config string, newFunc
 		klog.Infof("failed to decoded config #2: %v\n%s", err, config)
 		return nil, err
 	}
+	return obj, nil
+}
+
+// configRevision returns the revision stored in a config, which is 0 if it can't be decoded,
+// or it's written by a previous version not storing revisions.
+func (n *nacosREST) configRevision(config string) uint64 {
+	if config == "" {
+		return 0
+	}
+	obj, err := n.decodeConfig(n.codec, config, n.newFunc)
+	if err != nil {
+		return 0
+	}
 	accessor, err := meta.Accessor(obj)
-	if err == nil {
-		accessor.SetResourceVersion(calculateMd5(config))
+	if err != nil {
+		return 0
 	}
-	return obj, nil
+	revision, _ := parseResourceVersion(accessor.GetResourceVersion())
+	return revision
 }
 
-func (n *nacosREST) write(encoder runtime.Encoder, group, dataId, oldMd5 string, obj runtime.Object) error {
+// write stores the object into the config with the given revision as its resource version, and returns the content
+// written. The config is only written if its current content matches oldMd5, unless it's empty.
+func (n *nacosREST) write(encoder runtime.Encoder, group, dataId, oldMd5 string, revision uint64, obj runtime.Object) (string, error) {
 	accessor, err := meta.Accessor(obj)
 	if err != nil {
-		return err
+		return "", err
 	}
 	oldResourceVersion := accessor.GetResourceVersion()
-	// No resource version saved into nacos
-	accessor.SetResourceVersion("")
+	accessor.SetResourceVersion(formatResourceVersion(revision))
 
 	buf := new(bytes.Buffer)
 	if err := encoder.Encode(obj, buf); err != nil {
-		return err
+		return "", err
 	}
-	content, err := n.encryptConfig(buf.String())
+	content, err := n.policy.Encrypt(obj, buf.String())
 	if err != nil {
-		return err
+		return "", err
 	}
 	err = n.writeRaw(group, dataId, content, oldMd5)
-	if err == nil {
-		accessor.SetResourceVersion(calculateMd5(content))
-	} else {
+	if err != nil {
 		accessor.SetResourceVersion(oldResourceVersion)
+		return "", err
 	}
-	return err
+	return content, nil
 }
 
+// writeRaw writes the content of a config. The config is only written if its current content matches oldMd5,
+// unless it's empty, and errNacosConfigChanged is returned if it doesn't.
 func (n *nacosREST) writeRaw(group, dataId, content, oldMd5 string) error {
 	published, err := n.configClient.PublishConfig(vo.ConfigParam{
 		DataId:  dataId,
@@ -640,142 +849,227 @@ func (n *nacosREST) writeRaw(group, dataId, content, oldMd5 string) error {
 		CasMd5:  oldMd5,
 	})
 	if err != nil {
-		return err
+		if oldMd5 != "" && strings.Contains(strings.ToLower(err.Error()), nacosCasFailureMessage) {
+			return fmt.Errorf("%w: %v", errNacosConfigChanged, err)
+		}
+		return unavailableError(err)
 	} else if !published {
 		return fmt.Errorf("failed to publish config %s", dataId)
 	}
 	return nil
 }
 
-func (n *nacosREST) refreshConfigList() {
-	n.listRefreshMutex.Lock()
-	defer n.listRefreshMutex.Unlock()
+// refreshConfigList sweeps all the configs, and notifies watchers of the changes missed.
+func (n *nacosREST) refreshConfigList() error {
+	if err := n.sweepConfigs(false); err != nil {
+		return err
+	}
+	// The changes made during the sweep are picked up from the change log as usual.
+	changeLog, err := n.readRaw(changesGroup, n.changesDataId)
+	if err != nil {
+		return err
+	}
+	n.processChangeLog(changeLog, nil)
+	return nil
+}
 
-	configItems := map[string]*model.ConfigItem{}
-	var allConfigKeys, newConfigKeys []string
-	err := n.enumerateConfigs(&vo.SearchConfigParam{
+// sweepConfigs compares all the configs with the known ones. The event history is reset if resetHistory is set,
+// as the events of the changes missed can't be recorded at their revisions. The configs are read before taking
+// listRefreshMutex, so the changes processed meanwhile are newer than the ones swept, and the configs they have
+// changed are left alone.
+func (n *nacosREST) sweepConfigs(resetHistory bool) error {
+	// The change log is read first, so the changes made during the sweep are processed again later.
+	changeLog, err := n.readRaw(changesGroup, n.changesDataId)
+	if err != nil {
+		return err
+	}
+	configs := map[string]string{}
+	err = n.enumerateConfigs(&vo.SearchConfigParam{
 		Search: "blur",
 		DataId: n.dataIdPrefix + wildcardSuffix,
 	}, func(item *model.ConfigItem) {
-		key := item.Group + "/" + item.DataId
-		allConfigKeys = append(allConfigKeys, key)
-		if _, ok := n.configItems[key]; !ok {
-			newConfigKeys = append(newConfigKeys, key)
-		}
-		configItems[key] = item
+		configs[item.Group+"/"+item.DataId] = item.Content
 	})
 	if err != nil {
-		return
+		return err
 	}
+	seq := lastChangeSeq(parseNacosChangeLog(changeLog))
 
-	namesData, err := n.readRaw(namesGroup, n.namesDataId)
-	if err != nil {
-		klog.Errorf("failed to read %s/%s: %v", namesGroup, n.namesDataId, err)
-	} else if len(allConfigKeys) > 0 {
-		newNamesData := strings.Join(allConfigKeys, "\n") + "\n"
-		if namesData != newNamesData {
-			err := n.writeRaw(namesGroup, n.namesDataId, newNamesData, calculateMd5(namesData))
-			if err != nil {
-				klog.Errorf("failed to update %s/%s: %v", namesGroup, n.namesDataId, err)
+	n.listRefreshMutex.Lock()
+	defer n.listRefreshMutex.Unlock()
+
+	processedKeys := map[string]bool{}
+	if n.configs != nil && n.changeSeq > seq {
+		processed := parseNacosChangeLog(n.changeLog)
+		if len(processed) == 0 || processed[0].seq > seq+1 {
+			return fmt.Errorf("changes %d-%d processed during the sweep aren't known", seq+1, n.changeSeq)
+		}
+		for _, change := range processed {
+			if change.seq > seq && change.seq <= n.changeSeq {
+				processedKeys[change.key] = true
 			}
 		}
 	}
-
-	var removedConfigKeys []string
-	for key, _ := range n.configItems {
-		if _, ok := configItems[key]; !ok {
-			removedConfigKeys = append(removedConfigKeys, key)
-		}
+	if n.configs == nil {
+		n.configs = make(map[string]*nacosConfig, len(configs))
 	}
-
-	for _, key := range newConfigKeys {
-		configItem := configItems[key]
-		obj, err := n.decodeConfig(n.codec, configItem.Content, n.newFunc)
-		if err != nil {
-			klog.Errorf("failed to decode config #5 %s: %v", configItem.DataId, err)
-			delete(configItems, key)
-			continue
+	// The changes missed are taken as made at the revision swept, or the last one processed if it's later.
+	revision := max(seq, n.changeSeq)
+	if n.history == nil || resetHistory {
+		n.history = newEventHistory(options.WatchHistorySize, revision)
+	}
+	for key, content := range configs {
+		if !processedKeys[key] {
+			n.applyConfig(key, content, revision)
 		}
-		klog.Infof("%s/%s is added", configItem.Group, configItem.DataId)
-		n.notifyWatchers(watch.Event{
-			Type:   watch.Added,
-			Object: obj,
-		})
-		err = n.configClient.ListenConfig(vo.ConfigParam{
-			DataId: configItem.DataId,
-			Group:  configItem.Group,
-			OnChange: func(namespace, group, dataId, data string) {
-				obj, err := n.decodeConfig(n.codec, data, n.newFunc)
-				if err != nil {
-					klog.Errorf("failed to decode config #6 %s: %v", dataId, err)
-					return
-				}
-				klog.Infof("%s/%s is changed", group, dataId)
-				n.notifyWatchers(watch.Event{
-					Type:   watch.Modified,
-					Object: obj,
-				})
-			},
-		})
-		if err != nil {
-			klog.Errorf("failed to listen config %s: %v", key, err)
+	}
+	for key := range n.configs {
+		if _, ok := configs[key]; !ok && !processedKeys[key] {
+			n.applyConfig(key, "", revision)
 		}
 	}
-	for _, key := range removedConfigKeys {
-		configItem := n.configItems[key]
-		obj, err := n.decodeConfig(n.codec, configItem.Content, n.newFunc)
-		if err != nil {
-			klog.Errorf("failed to decode config #7 %s: %v", configItem.DataId, err)
-			continue
+	for key := range n.configErrors {
+		if _, ok := configs[key]; !ok && !processedKeys[key] {
+			delete(n.configErrors, key)
 		}
-		_ = n.configClient.CancelListenConfig(vo.ConfigParam{
-			DataId: configItem.DataId,
-			Group:  configItem.Group,
-		})
-		klog.Infof("%s/%s is deleted", configItem.Group, configItem.DataId)
-		n.notifyWatchers(watch.Event{
-			Type:   watch.Deleted,
-			Object: obj,
-		})
 	}
-	n.configItems = configItems
+	if seq > n.changeSeq {
+		n.changeLog = changeLog
+		n.pendingSeq = 0
+		n.setChangeSeq(seq)
+	}
+	return nil
 }
 
-func (n *nacosREST) encryptConfig(config string) (string, error) {
-	if n.encryptionKey == nil {
-		return config, nil
+// applyConfig updates the known content of a config, which is empty if the config doesn't exist,
+// and notifies watchers if it's changed. The revision of a deletion is given by changeRevision, and so is the one of
+// a config changed outside the API servers, which isn't stored with a revision newer than the known one.
+func (n *nacosREST) applyConfig(key, content string, changeRevision uint64) {
+	delete(n.configErrors, key)
+	oldConfig, existed := n.configs[key]
+	if existed && content == oldConfig.content || !existed && content == "" {
+		return
 	}
-
-	encryptedKeyData, err := utils.AesEncrypt([]byte(config), n.encryptionKey)
+	if content == "" {
+		delete(n.configs, key)
+		klog.Infof("%s is deleted", key)
+		obj := oldConfig.object.DeepCopyObject()
+		setResourceVersion(obj, changeRevision)
+		n.notifyWatchers(changeRevision, watch.Event{
+			Type:   watch.Deleted,
+			Object: obj,
+		}, nil)
+		return
+	}
+	obj, err := n.decodeConfig(n.codec, content, n.newFunc)
 	if err != nil {
-		return "", err
+		klog.Errorf("failed to decode config #5 %s: %v", key, err)
+		n.configErrors[key] = err
+		return
+	}
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return
+	}
+	revision, _ := parseResourceVersion(accessor.GetResourceVersion())
+	if existed && revision <= n.configRevision(oldConfig.content) || revision == 0 {
+		revision = changeRevision
+		setResourceVersion(obj, revision)
+	}
+	n.configs[key] = &nacosConfig{content: content, object: obj}
+	if existed {
+		klog.Infof("%s is changed", key)
+		n.notifyWatchers(revision, watch.Event{
+			Type:   watch.Modified,
+			Object: obj.DeepCopyObject(),
+		}, oldConfig.object)
+	} else {
+		klog.Infof("%s is added", key)
+		n.notifyWatchers(revision, watch.Event{
+			Type:   watch.Added,
+			Object: obj.DeepCopyObject(),
+		}, nil)
 	}
-	return encryptionMark + base64.URLEncoding.EncodeToString(encryptedKeyData), nil
 }
 
-func (n *nacosREST) decryptConfig(config string) (string, error) {
-	if !strings.HasPrefix(config, encryptionMark) {
-		return config, nil
+// Reencrypt rewrites the configs not encrypted as the policy requires, e.g. not with the newest key, through the change log
+// like updates, so all the instances pick up the new content.
+func (n *nacosREST) Reencrypt(ctx context.Context) (int, error) {
+	if n.policy == nil {
+		return 0, nil
 	}
-	if n.encryptionKey == nil {
-		return "", errors.New("config data is encrypted, but no data encryption key is provided")
+	if err := n.refreshConfigList(); err != nil {
+		return 0, err
 	}
-	encryptedData, err := base64.URLEncoding.DecodeString(strings.TrimPrefix(config, encryptionMark))
-	if err != nil {
-		return "", err
+
+	n.listRefreshMutex.Lock()
+	staleConfigs := map[string]*nacosConfig{}
+	for key, config := range n.configs {
+		if n.policy.IsStale(config.object, config.content) {
+			staleConfigs[key] = config
+		}
 	}
-	decryptedData, err := utils.AesDecrypt(encryptedData, n.encryptionKey)
-	if err != nil {
-		return "", err
+	n.listRefreshMutex.Unlock()
+
+	count := 0
+	var errs []error
+	for key, config := range staleConfigs {
+		if err := ctx.Err(); err != nil {
+			return count, err
+		}
+		group, dataId, _ := strings.Cut(key, "/")
+		revision, changeLog, err := n.reserveRevision(watch.Modified, group, dataId)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to re-encrypt %s: %v", key, err))
+			continue
+		}
+		content, err := n.write(n.codec, group, dataId, calculateMd5(config.content), revision, config.object.DeepCopyObject())
+		if err != nil {
+			n.abortRevision(revision, group, dataId)
+			// The config may have been changed meanwhile, which is fine as long as it's encrypted with the newest key.
+			errs = append(errs, fmt.Errorf("failed to re-encrypt %s: %v", key, err))
+			continue
+		}
+		n.syncWrittenChange(revision, changeLog, group, dataId, content, true)
+		klog.Infof("[%s] %s is re-encrypted with key %s", n.groupResource, key, n.policy.NewestKeyId())
+		count++
+	}
+	return count, utilerrors.NewAggregate(errs)
+}
+
+// waitForCacheSync waits until the known configs reflect the change log entry of the given sequence number,
+// or the timeout expires.
+func (n *nacosREST) waitForCacheSync(seq uint64) {
+	if nacosCacheSyncTimeout <= 0 {
+		return
+	}
+	timer := time.NewTimer(nacosCacheSyncTimeout)
+	defer timer.Stop()
+	for {
+		n.listRefreshMutex.Lock()
+		synced, changeSeqSynced := n.changeSeq >= seq, n.changeSeqSynced
+		n.listRefreshMutex.Unlock()
+		if synced {
+			return
+		}
+		select {
+		case <-changeSeqSynced:
+		case <-timer.C:
+			klog.Warningf("[%s] timed out waiting for change %d to be synced", n.groupResource, seq)
+			return
+		}
 	}
-	return string(decryptedData), nil
 }
 
-func (n *nacosREST) waitForCacheSync() {
-	if nacosCacheSyncDelay <= 0 {
+// setChangeSeq updates the sequence number of the last change log entry reflected in configs, and wakes up the writers
+// waiting for it. It must be called with listRefreshMutex held.
+func (n *nacosREST) setChangeSeq(seq uint64) {
+	if seq == n.changeSeq {
 		return
 	}
-	time.Sleep(nacosCacheSyncDelay)
+	n.changeSeq = seq
+	close(n.changeSeqSynced)
+	n.changeSeqSynced = make(chan struct{})
 }
 
 func calculateMd5(str string) string {
@@ -784,47 +1078,6 @@ func calculateMd5(str string) string {
 	return fmt.Sprintf("%x", w.Sum(nil))
 }
 
-type nacosWatch struct {
-	f         *nacosREST
-	id        string
-	ch        chan watch.Event
-	ns        string
-	predicate *storage.SelectionPredicate
-}
-
-func (w *nacosWatch) Stop() {
-	w.f.watchersMutex.Lock()
-	defer w.f.watchersMutex.Unlock()
-	delete(w.f.watchers, w.id)
-}
-
-func (w *nacosWatch) ResultChan() <-chan watch.Event {
-	return w.ch
-}
-
-func (w *nacosWatch) SendEvent(ev watch.Event, force bool) bool {
-	if !force {
-		if w.predicate != nil {
-			match, err := w.predicate.Matches(ev.Object)
-			if err == nil && !match {
-				// If something went wrong, we assume it's a match
-				return false
-			}
-		}
-		if ns := w.ns; ns != "" {
-			accessor, err := meta.Accessor(ev.Object)
-			if err != nil {
-				return false
-			}
-			if accessor.GetNamespace() != ns {
-				return false
-			}
-		}
-	}
-	w.ch <- ev
-	return true
-}
-
 // TODO: implement custom table printer optionally
 // func (n *nacosREST) ConvertToTable(ctx context.Context, object runtime.Object, tableOptions runtime.Object) (*metav1.Table, error) {
 // 	return &metav1.Table{}, nil
@@ -0,0 +1,781 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nacos-group/nacos-sdk-go/v2/clients/config_client"
+	"github.com/nacos-group/nacos-sdk-go/v2/common/constant"
+	"github.com/nacos-group/nacos-sdk-go/v2/model"
+	"github.com/nacos-group/nacos-sdk-go/v2/vo"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metainternalversion "k8s.io/apimachinery/pkg/apis/meta/internalversion"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/apiserver/pkg/registry/rest"
+
+	"github.com/alibaba/higress/api-server/pkg/encryption"
+	"github.com/alibaba/higress/api-server/pkg/options"
+)
+
+// fakeConfigClient is an in-memory Nacos config client, failing CAS writes like Nacos does.
+type fakeConfigClient struct {
+	mutex     sync.Mutex
+	configs   map[string]string
+	listeners map[string][]func(namespace, group, dataId, data string)
+	// beforePublish is called before a config is published, which may change the configs or fail the write.
+	beforePublish func(param vo.ConfigParam) error
+	// beforeGet is called before a config is read, which may hold the read back like a slow Nacos.
+	beforeGet func(param vo.ConfigParam)
+	// searches counts the calls of SearchConfig.
+	searches int
+	// muted drops the notifications of the listeners, like a lagging Nacos client.
+	muted bool
+	// lagging holds the configs read and searched instead of the current ones, except the change logs, like the cache
+	// of a Nacos node lagging behind the writes, unless it's nil.
+	lagging map[string]string
+}
+
+var _ config_client.IConfigClient = &fakeConfigClient{}
+
+func newFakeConfigClient() *fakeConfigClient {
+	return &fakeConfigClient{
+		configs:   map[string]string{},
+		listeners: map[string][]func(namespace, group, dataId, data string){},
+	}
+}
+
+func fakeConfigKey(group, dataId string) string {
+	if group == "" {
+		group = constant.DEFAULT_GROUP
+	}
+	return group + "/" + dataId
+}
+
+func (c *fakeConfigClient) GetConfig(param vo.ConfigParam) (string, error) {
+	c.mutex.Lock()
+	beforeGet := c.beforeGet
+	c.mutex.Unlock()
+	if beforeGet != nil {
+		beforeGet(param)
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.lagging != nil && !strings.HasSuffix(param.DataId, changesSuffix) {
+		return c.lagging[fakeConfigKey(param.Group, param.DataId)], nil
+	}
+	return c.configs[fakeConfigKey(param.Group, param.DataId)], nil
+}
+
+func (c *fakeConfigClient) PublishConfig(param vo.ConfigParam) (bool, error) {
+	c.mutex.Lock()
+	beforePublish := c.beforePublish
+	c.mutex.Unlock()
+	if beforePublish != nil {
+		if err := beforePublish(param); err != nil {
+			return false, err
+		}
+	}
+	c.mutex.Lock()
+	key := fakeConfigKey(param.Group, param.DataId)
+	current, exists := c.configs[key]
+	if param.CasMd5 != "" && exists && calculateMd5(current) != param.CasMd5 {
+		c.mutex.Unlock()
+		return false, errors.New("Cas publish fail,server md5 may have changed.")
+	}
+	c.configs[key] = param.Content
+	listeners := c.listeners[key]
+	if c.muted {
+		listeners = nil
+	}
+	c.mutex.Unlock()
+	for _, listener := range listeners {
+		go listener("", param.Group, param.DataId, param.Content)
+	}
+	return true, nil
+}
+
+// set changes a config behind the back of the API servers.
+func (c *fakeConfigClient) set(group, dataId, content string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if content == "" {
+		delete(c.configs, fakeConfigKey(group, dataId))
+	} else {
+		c.configs[fakeConfigKey(group, dataId)] = content
+	}
+}
+
+func (c *fakeConfigClient) get(group, dataId string) string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.configs[fakeConfigKey(group, dataId)]
+}
+
+func (c *fakeConfigClient) DeleteConfig(param vo.ConfigParam) (bool, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	delete(c.configs, fakeConfigKey(param.Group, param.DataId))
+	return true, nil
+}
+
+func (c *fakeConfigClient) ListenConfig(param vo.ConfigParam) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	key := fakeConfigKey(param.Group, param.DataId)
+	c.listeners[key] = append(c.listeners[key], param.OnChange)
+	return nil
+}
+
+func (c *fakeConfigClient) CancelListenConfig(param vo.ConfigParam) error {
+	return nil
+}
+
+// SearchConfig supports the blur searches of data IDs ending with "*", ordering the results by group and data ID.
+func (c *fakeConfigClient) SearchConfig(param vo.SearchConfigParam) (*model.ConfigPage, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.searches++
+	configs := c.configs
+	if c.lagging != nil {
+		configs = c.lagging
+	}
+	var items []model.ConfigItem
+	for key, content := range configs {
+		group, dataId, _ := strings.Cut(key, "/")
+		if param.Group != "" && group != param.Group || !strings.HasPrefix(dataId, strings.TrimSuffix(param.DataId, "*")) {
+			continue
+		}
+		items = append(items, model.ConfigItem{Group: group, DataId: dataId, Content: content})
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return fakeConfigKey(items[i].Group, items[i].DataId) < fakeConfigKey(items[j].Group, items[j].DataId)
+	})
+	page := &model.ConfigPage{TotalCount: len(items), PageNumber: param.PageNo}
+	if param.PageSize > 0 {
+		page.PagesAvailable = (len(items) + param.PageSize - 1) / param.PageSize
+		start := min((param.PageNo-1)*param.PageSize, len(items))
+		end := min(start+param.PageSize, len(items))
+		items = items[start:end]
+	}
+	page.PageItems = items
+	return page, nil
+}
+
+func (c *fakeConfigClient) CloseClient() {
+}
+
+func (c *fakeConfigClient) searchCount() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.searches
+}
+
+func (c *fakeConfigClient) setMuted(muted bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.muted = muted
+}
+
+// setLagging makes the reads and searches return the configs as they are now until it's unset.
+func (c *fakeConfigClient) setLagging(lagging bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.lagging = nil
+	if lagging {
+		c.lagging = make(map[string]string, len(c.configs))
+		for key, content := range c.configs {
+			c.lagging[key] = content
+		}
+	}
+}
+
+func (c *fakeConfigClient) setBeforeGet(beforeGet func(param vo.ConfigParam)) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.beforeGet = beforeGet
+}
+
+func (c *fakeConfigClient) setBeforePublish(beforePublish func(param vo.ConfigParam) error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.beforePublish = beforePublish
+}
+
+func newTestNacosREST(t *testing.T, client *fakeConfigClient) *nacosREST {
+	t.Helper()
+	return newTestNacosRESTWithPolicy(t, client, nil)
+}
+
+func newTestNacosRESTWithPolicy(t *testing.T, client *fakeConfigClient, policy *encryption.Policy) *nacosREST {
+	t.Helper()
+	n := NewNacosREST(testGroupResource, testCodec, client, true, "configmap", newTestConfigMap, newTestConfigMapList, nil, policy).(*nacosREST)
+	t.Cleanup(n.Destroy)
+	// Wait for the initial sweep.
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		n.listRefreshMutex.Lock()
+		synced := n.configs != nil
+		n.listRefreshMutex.Unlock()
+		if synced {
+			return n
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the initial sweep")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func setNacosCacheSyncTimeout(t *testing.T, timeout time.Duration) {
+	old := nacosCacheSyncTimeout
+	nacosCacheSyncTimeout = timeout
+	t.Cleanup(func() {
+		nacosCacheSyncTimeout = old
+	})
+}
+
+func TestNacosChangeLogFormat(t *testing.T) {
+	changes := []*nacosChange{
+		{seq: 1, eventType: watch.Added, key: "ns/configmaps.a"},
+		{seq: 2, eventType: watch.Modified, key: "ns/configmaps.a"},
+		{seq: 3, eventType: nacosAborted, key: "ns/configmaps.a", abortedSeq: 2},
+	}
+	data := formatNacosChangeLog(changes)
+	if want := "1 ADDED ns/configmaps.a\n2 MODIFIED ns/configmaps.a\n3 ABORTED ns/configmaps.a 2\n"; data != want {
+		t.Fatalf("change log = %q, want %q", data, want)
+	}
+	parsed := parseNacosChangeLog(data + "garbage\n4 ABORTED ns/configmaps.a\n")
+	if len(parsed) != len(changes) {
+		t.Fatalf("parsed %d changes, want %d", len(parsed), len(changes))
+	}
+	for i := range changes {
+		if *parsed[i] != *changes[i] {
+			t.Errorf("change %d = %+v, want %+v", i, *parsed[i], *changes[i])
+		}
+	}
+}
+
+func TestNacosRevisionsAndWatch(t *testing.T) {
+	client := newFakeConfigClient()
+	n := newTestNacosREST(t, client)
+
+	a1 := mustCreate(t, n, "ns", "a", "v1")
+	w := mustWatch(t, n, "ns", a1.ResourceVersion)
+	b := mustCreate(t, n, "ns", "b", "v1")
+	a2 := mustUpdate(t, n, a1, "v2")
+	mustDelete(t, n, "ns", "b")
+	if !(revisionOf(t, a1) < revisionOf(t, b) && revisionOf(t, b) < revisionOf(t, a2)) {
+		t.Fatalf("revisions aren't increasing: %s, %s, %s", a1.ResourceVersion, b.ResourceVersion, a2.ResourceVersion)
+	}
+	expectEvents(t, w, "ADDED b=v1", "MODIFIED a=v2", "DELETED b=v1")
+
+	// A watch resumed from a revision gets all the events after it.
+	resumed := mustWatch(t, n, "ns", b.ResourceVersion)
+	expectEvents(t, resumed, "MODIFIED a=v2", "DELETED b=v1")
+}
+
+func TestNacosListChunks(t *testing.T) {
+	testListChunks(t, newTestNacosREST(t, newFakeConfigClient()))
+}
+
+func TestNacosListChunksWithoutSearches(t *testing.T) {
+	client := newFakeConfigClient()
+	n := newTestNacosREST(t, client)
+	for _, name := range []string{"a", "b", "c", "d", "e"} {
+		mustCreate(t, n, "ns", name, "v1")
+	}
+	searches := client.searchCount()
+
+	// The chunks are cut out of the known configs, without searching Nacos for each of them.
+	var names []string
+	options := &metainternalversion.ListOptions{Limit: 2}
+	for {
+		list := mustList(t, n, "ns", options)
+		names = append(names, listedNames(list))
+		if list.Continue == "" {
+			break
+		}
+		options = &metainternalversion.ListOptions{Limit: 2, Continue: list.Continue}
+	}
+	if got := strings.Join(names, ","); got != "a,b,c,d,e" {
+		t.Fatalf("got chunks of %s", got)
+	}
+	if got := client.searchCount(); got != searches {
+		t.Fatalf("configs are searched %d times for the chunks", got-searches)
+	}
+}
+
+func TestNacosListChunksBeforeSync(t *testing.T) {
+	client := newFakeConfigClient()
+	replica := newTestNacosREST(t, client)
+	for _, name := range []string{"a", "b", "c"} {
+		mustCreate(t, replica, "ns", name, "v1")
+	}
+	// The initial sweep is held back at reading the change log.
+	unblock := make(chan struct{})
+	client.setBeforeGet(func(param vo.ConfigParam) {
+		if strings.HasSuffix(param.DataId, changesSuffix) {
+			<-unblock
+		}
+	})
+	n := NewNacosREST(testGroupResource, testCodec, client, true, "configmap", newTestConfigMap, newTestConfigMapList, nil, nil).(*nacosREST)
+	t.Cleanup(n.Destroy)
+	t.Cleanup(func() {
+		close(unblock)
+	})
+
+	// The limit is ignored, and the whole list is returned at once.
+	list := mustList(t, n, "ns", &metainternalversion.ListOptions{Limit: 2})
+	if got := listedNames(list); got != "a,b,c" || list.Continue != "" {
+		t.Fatalf("got %s with continue %q before the sync", got, list.Continue)
+	}
+
+	// The tokens of the other replicas have expired, without searching Nacos.
+	searches := client.searchCount()
+	token := (&continueToken{Revision: 1, Start: "ns/a"}).encode()
+	_, err := n.List(nsContext("ns"), &metainternalversion.ListOptions{Limit: 2, Continue: token})
+	if !apierrors.IsResourceExpired(err) {
+		t.Fatalf("got %v for a continue before the sync, want 410", err)
+	}
+	if got := client.searchCount(); got != searches {
+		t.Fatalf("configs are searched %d times for an expired continue", got-searches)
+	}
+}
+
+func TestNacosUpdateConflict(t *testing.T) {
+	setNacosCacheSyncTimeout(t, 3*time.Second)
+	client := newFakeConfigClient()
+	n := newTestNacosREST(t, client)
+	a := mustCreate(t, n, "ns", "a", "v1")
+	w := mustWatch(t, n, "ns", a.ResourceVersion)
+
+	// Another writer changes the config between the read and the write.
+	dataId := n.objectDataId(context.Background(), "a")
+	client.setBeforePublish(func(param vo.ConfigParam) error {
+		if param.DataId == dataId {
+			client.setBeforePublish(nil)
+			client.set("ns", dataId, strings.Replace(client.get("ns", dataId), `"key":"v1"`, `"key":"other"`, 1))
+		}
+		return nil
+	})
+	updated := a.DeepCopy()
+	updated.Data["key"] = "v2"
+	start := time.Now()
+	_, _, err := n.Update(nsContext("ns"), "a", rest.DefaultUpdatedObjectInfo(updated), nil, nil, false, &metav1.UpdateOptions{})
+	if !apierrors.IsConflict(err) {
+		t.Fatalf("update with a CAS mismatch returned %v, want a conflict", err)
+	}
+	if !strings.Contains(client.get(changesGroup, n.changesDataId), string(nacosAborted)) {
+		t.Fatalf("the failed change isn't aborted in the change log:\n%s", client.get(changesGroup, n.changesDataId))
+	}
+
+	// The aborted change doesn't hold back the following writes.
+	mustCreate(t, n, "ns", "b", "v1")
+	if elapsed := time.Since(start); elapsed >= nacosCacheSyncTimeout {
+		t.Fatalf("the write following the failed one took %v", elapsed)
+	}
+	expectEvents(t, w, "ADDED b=v1")
+	if got := mustList(t, n, "ns", nil); len(got.Items) != 2 {
+		t.Fatalf("listed %d objects, want 2", len(got.Items))
+	}
+}
+
+func TestNacosWriteFailureIsAborted(t *testing.T) {
+	setNacosCacheSyncTimeout(t, 3*time.Second)
+	client := newFakeConfigClient()
+	n := newTestNacosREST(t, client)
+	a := mustCreate(t, n, "ns", "a", "v1")
+	w := mustWatch(t, n, "ns", a.ResourceVersion)
+
+	dataId := n.objectDataId(context.Background(), "b")
+	client.setBeforePublish(func(param vo.ConfigParam) error {
+		if param.DataId == dataId {
+			return errors.New("connection refused")
+		}
+		return nil
+	})
+	_, err := n.Create(nsContext("ns"), testConfigMap("ns", "b", "v1"), nil, &metav1.CreateOptions{})
+	if !apierrors.IsServiceUnavailable(err) {
+		t.Fatalf("failed create returned %v, want a service unavailable error", err)
+	}
+	client.setBeforePublish(nil)
+
+	start := time.Now()
+	mustCreate(t, n, "ns", "c", "v1")
+	if elapsed := time.Since(start); elapsed >= nacosCacheSyncTimeout {
+		t.Fatalf("the write following the failed one took %v", elapsed)
+	}
+	expectEvents(t, w, "ADDED c=v1")
+}
+
+func TestNacosCreateConflict(t *testing.T) {
+	client := newFakeConfigClient()
+	n := newTestNacosREST(t, client)
+	mustCreate(t, n, "ns", "a", "v1")
+	_, err := n.Create(nsContext("ns"), testConfigMap("ns", "a", "v2"), nil, &metav1.CreateOptions{})
+	if !apierrors.IsConflict(err) {
+		t.Fatalf("creating an existing object returned %v, want a conflict", err)
+	}
+
+	// The config is created by another instance once the revision is reserved.
+	dataId := n.objectDataId(context.Background(), "b")
+	client.setBeforePublish(func(param vo.ConfigParam) error {
+		if param.DataId == n.changesDataId {
+			client.setBeforePublish(nil)
+			client.set("ns", dataId, "created: elsewhere\n")
+		}
+		return nil
+	})
+	_, err = n.Create(nsContext("ns"), testConfigMap("ns", "b", "v1"), nil, &metav1.CreateOptions{})
+	if !apierrors.IsConflict(err) {
+		t.Fatalf("racing create returned %v, want a conflict", err)
+	}
+	if got := client.get("ns", dataId); got != "created: elsewhere\n" {
+		t.Fatalf("the config created by the other instance is overwritten with %q", got)
+	}
+}
+
+func TestNacosDeleteConflict(t *testing.T) {
+	client := newFakeConfigClient()
+	n := newTestNacosREST(t, client)
+	mustCreate(t, n, "ns", "a", "v1")
+
+	// The config is updated by another instance once the revision of the deletion is reserved.
+	dataId := n.objectDataId(context.Background(), "a")
+	client.setBeforePublish(func(param vo.ConfigParam) error {
+		if param.DataId == n.changesDataId {
+			client.setBeforePublish(nil)
+			client.set("ns", dataId, strings.Replace(client.get("ns", dataId), `"key":"v1"`, `"key":"v2"`, 1))
+		}
+		return nil
+	})
+	_, _, err := n.Delete(nsContext("ns"), "a", nil, &metav1.DeleteOptions{})
+	if !apierrors.IsConflict(err) {
+		t.Fatalf("racing delete returned %v, want a conflict", err)
+	}
+	if client.get("ns", dataId) == "" {
+		t.Fatal("the config updated by the other instance is deleted")
+	}
+	if _, _, err := n.Delete(nsContext("ns"), "missing", nil, &metav1.DeleteOptions{}); !apierrors.IsNotFound(err) {
+		t.Fatalf("deleting a missing object returned %v, want not found", err)
+	}
+}
+
+// TestNacosEventsInRevisionOrder checks a change waited for holds back the events of the changes following it,
+// which are then delivered in the order of their revisions.
+func TestNacosEventsInRevisionOrder(t *testing.T) {
+	setNacosCacheSyncTimeout(t, 5*time.Second)
+	client := newFakeConfigClient()
+	n := newTestNacosREST(t, client)
+	a := mustCreate(t, n, "ns", "a", "v1")
+	w := mustWatch(t, n, "ns", a.ResourceVersion)
+
+	// A slow writer reserves a revision for a, and b is created meanwhile.
+	dataIdA := n.objectDataId(context.Background(), "a")
+	revision, _, err := n.reserveRevision(watch.Modified, "ns", dataIdA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	created := make(chan struct{})
+	go func() {
+		defer close(created)
+		mustCreate(t, n, "ns", "b", "v1")
+	}()
+	expectNoEvent(t, w)
+
+	updated := a.DeepCopy()
+	updated.Data["key"] = "v2"
+	content, err := n.write(n.codec, "ns", dataIdA, calculateMd5(client.get("ns", dataIdA)), revision, updated)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.syncWrittenChange(revision, client.get(changesGroup, n.changesDataId), "ns", dataIdA, content, true)
+	<-created
+	events := expectEvents(t, w, "MODIFIED a=v2", "ADDED b=v1")
+
+	// A watch resumed from the first event gets the second.
+	resumed := mustWatch(t, n, "ns", events[0].Object.(metav1.Object).GetResourceVersion())
+	expectEvents(t, resumed, "ADDED b=v1")
+}
+
+func TestNacosPendingChangeExpires(t *testing.T) {
+	setNacosCacheSyncTimeout(t, 300*time.Millisecond)
+	client := newFakeConfigClient()
+	n := newTestNacosREST(t, client)
+	a := mustCreate(t, n, "ns", "a", "v1")
+	w := mustWatch(t, n, "ns", a.ResourceVersion)
+
+	// The writer is gone after reserving a revision.
+	if _, _, err := n.reserveRevision(watch.Modified, "ns", n.objectDataId(context.Background(), "a")); err != nil {
+		t.Fatal(err)
+	}
+	mustCreate(t, n, "ns", "b", "v1")
+	expectEvents(t, w, "ADDED b=v1")
+}
+
+func TestNacosReplicaChanges(t *testing.T) {
+	client := newFakeConfigClient()
+	n := newTestNacosREST(t, client)
+	replica := newTestNacosREST(t, client)
+	w := mustWatch(t, n, "ns", "")
+	searches := client.searchCount()
+
+	// The changes made through another instance are picked up from the change log, without enumerating the configs.
+	a := mustCreate(t, replica, "ns", "a", "v1")
+	ev := expectEvents(t, w, "ADDED a=v1")[0]
+	if rv := ev.Object.(metav1.Object).GetResourceVersion(); rv != a.ResourceVersion {
+		t.Fatalf("change of another instance is watched at %s, want %s", rv, a.ResourceVersion)
+	}
+	mustUpdate(t, replica, a, "v2")
+	expectEvents(t, w, "MODIFIED a=v2")
+	if got := client.searchCount(); got != searches {
+		t.Fatalf("configs are searched %d times for the changes logged", got-searches)
+	}
+
+	// Changes overwritten before being seen are skipped, without holding back the ones following them.
+	client.setMuted(true)
+	mustCreate(t, replica, "ns", "b", "v1")
+	mustDelete(t, replica, "ns", "b")
+	client.setMuted(false)
+	start := time.Now()
+	mustCreate(t, replica, "ns", "c", "v1")
+	expectEvents(t, w, "ADDED c=v1")
+	if elapsed := time.Since(start); elapsed > nacosCacheSyncTimeout/2 {
+		t.Fatalf("changes following the ones of b are held back for %s", elapsed)
+	}
+}
+
+func TestNacosConsistencySweep(t *testing.T) {
+	client := newFakeConfigClient()
+	n := newTestNacosREST(t, client)
+	a := mustCreate(t, n, "ns", "a", "v1")
+	w := mustWatch(t, n, "ns", a.ResourceVersion)
+
+	// A config changed outside the API servers isn't logged, and is only picked up by the sweep.
+	b := testConfigMap("ns", "b", "v1")
+	data, err := runtime.Encode(testCodec, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.set("ns", n.objectDataId(context.Background(), "b"), string(data))
+	expectNoEvent(t, w)
+	if err := n.refreshConfigList(); err != nil {
+		t.Fatal(err)
+	}
+	expectEvents(t, w, "ADDED b=v1")
+}
+
+func TestNacosTrimmedChangeLog(t *testing.T) {
+	oldSize := options.NacosChangeLogSize
+	options.NacosChangeLogSize = 2
+	t.Cleanup(func() {
+		options.NacosChangeLogSize = oldSize
+	})
+	client := newFakeConfigClient()
+	n := newTestNacosREST(t, client)
+	replica := newTestNacosREST(t, client)
+
+	// The changes trimmed from the change log before being seen are picked up by a sweep of all the configs.
+	client.setMuted(true)
+	for _, name := range []string{"a", "b", "c"} {
+		mustCreate(t, replica, "ns", name, "v1")
+	}
+	n.processChangeLog(client.get(changesGroup, n.changesDataId), nil)
+	n.listRefreshMutex.Lock()
+	known := len(n.configs)
+	n.listRefreshMutex.Unlock()
+	if known != 3 {
+		t.Fatalf("%d configs are known after the change log is trimmed, want 3", known)
+	}
+}
+
+func TestNacosReadYourWrites(t *testing.T) {
+	setNacosCacheSyncTimeout(t, 5*time.Second)
+	client := newFakeConfigClient()
+	n := newTestNacosREST(t, client)
+	// The writes don't wait for the notifications of the change log to arrive.
+	client.setMuted(true)
+
+	start := time.Now()
+	var last *corev1.ConfigMap
+	for i := 0; i < 100; i++ {
+		last = mustCreate(t, n, "ns", fmt.Sprintf("cm-%03d", i), "v1")
+	}
+	if elapsed := time.Since(start); elapsed > nacosCacheSyncTimeout {
+		t.Fatalf("100 creations took %s", elapsed)
+	}
+	list := mustList(t, n, "ns", nil)
+	if len(list.Items) != 100 {
+		t.Fatalf("listed %d objects after creating 100", len(list.Items))
+	}
+	if revision, err := parseResourceVersion(list.ResourceVersion); err != nil || revision < revisionOf(t, last) {
+		t.Fatalf("list is at %s before the last write at %s", list.ResourceVersion, last.ResourceVersion)
+	}
+	if got, err := n.Get(nsContext("ns"), "cm-099", &metav1.GetOptions{}); err != nil || got.(metav1.Object).GetResourceVersion() != last.ResourceVersion {
+		t.Fatalf("got %v, %v after the write", got, err)
+	}
+
+	// A watch from the list gets the following writes only.
+	w := mustWatch(t, n, "ns", list.ResourceVersion)
+	mustUpdate(t, n, last, "v2")
+	expectEvents(t, w, "MODIFIED cm-099=v2")
+}
+
+func TestNacosReadYourWritesWithLaggingNacos(t *testing.T) {
+	client := newFakeConfigClient()
+	n := newTestNacosREST(t, client)
+	a := mustCreate(t, n, "ns", "a", "v1")
+	// Nacos still serves the configs as they are before the following writes.
+	client.setLagging(true)
+	client.setMuted(true)
+	b := mustCreate(t, n, "ns", "b", "v1")
+	a = mustUpdate(t, n, a, "v2")
+
+	for _, want := range []*corev1.ConfigMap{a, b} {
+		got, err := n.Get(nsContext("ns"), want.Name, &metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("get of %s after writing it returned %v", want.Name, err)
+		}
+		if cm := got.(*corev1.ConfigMap); cm.ResourceVersion != want.ResourceVersion || cm.Data["key"] != want.Data["key"] {
+			t.Fatalf("got %s=%s at %s after writing it at %s", cm.Name, cm.Data["key"], cm.ResourceVersion, want.ResourceVersion)
+		}
+	}
+	list := mustList(t, n, "ns", nil)
+	if got := listedNames(list); got != "a,b" && got != "b,a" {
+		t.Fatalf("listed %q after the writes", got)
+	}
+	for _, item := range list.Items {
+		if item.Name == "a" && item.Data["key"] != "v2" {
+			t.Fatalf("listed a=%s after updating it to v2", item.Data["key"])
+		}
+	}
+}
+
+func TestNacosSlowReadsDontBlockLists(t *testing.T) {
+	client := newFakeConfigClient()
+	n := newTestNacosREST(t, client)
+	replica := newTestNacosREST(t, client)
+	mustCreate(t, n, "ns", "a", "v1")
+	w := mustWatch(t, replica, "ns", "")
+	expectEvents(t, w, "ADDED a=v1")
+
+	client.setMuted(true)
+	mustCreate(t, n, "ns", "b", "v1")
+	dataId := n.objectDataId(context.Background(), "b")
+	unblock := make(chan struct{})
+	client.setBeforeGet(func(param vo.ConfigParam) {
+		if param.DataId == dataId {
+			<-unblock
+		}
+	})
+	processed := make(chan struct{})
+	go func() {
+		replica.processChangeLog(client.get(changesGroup, n.changesDataId), nil)
+		close(processed)
+	}()
+
+	// The replica keeps serving what it knows while the changed config is read.
+	listed := make(chan *corev1.ConfigMapList)
+	go func() {
+		listed <- mustList(t, replica, "ns", nil)
+	}()
+	select {
+	case list := <-listed:
+		if got := listedNames(list); got != "a" {
+			t.Fatalf("listed %q while b is read", got)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("list is blocked by the read of a changed config")
+	}
+	close(unblock)
+	<-processed
+	expectEvents(t, w, "ADDED b=v1")
+}
+
+func TestNacosMonotonicRevisions(t *testing.T) {
+	oldSize := options.WatchHistorySize
+	options.WatchHistorySize = 2
+	t.Cleanup(func() {
+		options.WatchHistorySize = oldSize
+	})
+	client := newFakeConfigClient()
+	n := newTestNacosREST(t, client)
+	a1 := mustCreate(t, n, "ns", "a", "v1")
+
+	// Writing the same content again still moves the object to a later revision, which is stored along with it.
+	a2 := mustUpdate(t, n, a1, "v1")
+	if revisionOf(t, a2) <= revisionOf(t, a1) {
+		t.Fatalf("same content is written at %s after %s", a2.ResourceVersion, a1.ResourceVersion)
+	}
+	if got := n.configRevision(client.get("ns", n.objectDataId(context.Background(), "a"))); got != revisionOf(t, a2) {
+		t.Fatalf("config is stored at revision %d, want %s", got, a2.ResourceVersion)
+	}
+	a3 := mustUpdate(t, n, a2, "v2")
+	mustUpdate(t, n, a3, "v3")
+
+	// The revisions dropped from the event history can't be watched from any more, while the ones kept can.
+	if _, err := n.Watch(nsContext("ns"), &metainternalversion.ListOptions{ResourceVersion: a1.ResourceVersion}); !apierrors.IsResourceExpired(err) {
+		t.Fatalf("watch from a revision out of the history returned %v, want 410", err)
+	}
+	w := mustWatch(t, n, "ns", a2.ResourceVersion)
+	expectEvents(t, w, "MODIFIED a=v2", "MODIFIED a=v3")
+}
+
+func TestNacosFieldEncryption(t *testing.T) {
+	fields, err := encryption.ParseFieldPath("{.data.key}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy := encryption.NewPolicy(newTestKeyring(t, "key-1"), &encryption.ResourceRule{
+		GroupResource: testGroupResource,
+		Selector:      labels.Everything(),
+		Fields:        []*encryption.FieldPath{fields},
+	})
+	client := newFakeConfigClient()
+	n := newTestNacosRESTWithPolicy(t, client, policy)
+	a := mustCreate(t, n, "ns", "a", "secret-1")
+	a.Data["other"] = "plain"
+	obj, _, err := n.Update(nsContext("ns"), "a", rest.DefaultUpdatedObjectInfo(a), nil, nil, false, &metav1.UpdateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := obj.(*corev1.ConfigMap).Data; got["key"] != "secret-1" || got["other"] != "plain" {
+		t.Fatalf("updated data = %v", got)
+	}
+
+	// Only the field selected is encrypted in the config, while the rest of the object stays readable.
+	content := client.get("ns", n.objectDataId(context.Background(), "a"))
+	if strings.Contains(content, "secret-1") || encryption.IsEncrypted(content) {
+		t.Fatalf("field isn't encrypted in the config: %s", content)
+	}
+	if !strings.Contains(content, "plain") || !strings.Contains(content, `"name":"a"`) {
+		t.Fatalf("rest of the object isn't readable in the config: %s", content)
+	}
+	if got, err := n.Get(nsContext("ns"), "a", &metav1.GetOptions{}); err != nil || got.(*corev1.ConfigMap).Data["key"] != "secret-1" {
+		t.Fatalf("got %v, %v", got, err)
+	}
+
+	// The config can't be read without the key.
+	replica := newTestNacosREST(t, client)
+	if got, err := replica.Get(nsContext("ns"), "a", &metav1.GetOptions{}); err == nil {
+		t.Fatalf("encrypted field is read without the key: %v", got)
+	}
+}
+
+func TestNacosDryRun(t *testing.T) {
+	testDryRun(t, newTestNacosREST(t, newFakeConfigClient()))
+}
+
+func TestNacosServerSideApply(t *testing.T) {
+	testServerSideApply(t, newTestNacosREST(t, newFakeConfigClient()))
+}
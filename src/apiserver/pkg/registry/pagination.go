@@ -0,0 +1,133 @@
+package registry
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metainternalversion "k8s.io/apimachinery/pkg/apis/meta/internalversion"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+const continueExpiredMessage = "The provided continue parameter is too old to display a consistent list result. " +
+	"You can start a new list without the continue parameter."
+
+// continueToken is the opaque continue parameter of a chunked list, which tells where its next chunk starts.
+type continueToken struct {
+	// Revision is the one the first chunk was listed at, which all the chunks are consistent with.
+	Revision uint64 `json:"rv"`
+	// Start is the key of the last object returned, i.e. "<namespace>/<name>", which the next chunk starts after.
+	Start string `json:"start"`
+}
+
+// decodeContinue returns the continue token given in the list options, or nil if the list isn't continued.
+func decodeContinue(options *metainternalversion.ListOptions) (*continueToken, error) {
+	if options == nil || options.Continue == "" {
+		return nil, nil
+	}
+	data, err := base64.RawURLEncoding.DecodeString(options.Continue)
+	if err != nil {
+		return nil, apierrors.NewBadRequest("continue key is not valid: " + err.Error())
+	}
+	token := &continueToken{}
+	if err := json.Unmarshal(data, token); err != nil {
+		return nil, apierrors.NewBadRequest("continue key is not valid: " + err.Error())
+	}
+	if token.Start == "" {
+		return nil, apierrors.NewBadRequest("continue key is not valid: no start key")
+	}
+	return token, nil
+}
+
+func (t *continueToken) encode() string {
+	data, _ := json.Marshal(t)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// checkChanges returns a "410 Gone" error if any of the objects changed since the first chunk would be in the
+// chunks following the token of namespace ns, as they would no longer be consistent with it.
+func (t *continueToken) checkChanges(ns string, changed []runtime.Object) error {
+	for _, obj := range changed {
+		accessor, err := meta.Accessor(obj)
+		if err != nil {
+			continue
+		}
+		if ns != "" && accessor.GetNamespace() != ns {
+			continue
+		}
+		if objectKeyAfter(accessor.GetNamespace(), accessor.GetName(), t.Start) {
+			return apierrors.NewResourceExpired(continueExpiredMessage)
+		}
+	}
+	return nil
+}
+
+// checkEvents is checkChanges on the objects of events.
+func (t *continueToken) checkEvents(ns string, events []watchEvent) error {
+	changed := make([]runtime.Object, 0, len(events))
+	for _, ev := range events {
+		changed = append(changed, ev.Object)
+	}
+	return t.checkChanges(ns, changed)
+}
+
+// paginate turns a list of all the objects into the chunk requested by the limit and continue token in the list
+// options, in the order of their keys. The continue token of the next chunk is set in the list if there are more.
+// A continued list is at the revision of its first chunk.
+func paginate(list runtime.Object, options *metainternalversion.ListOptions, token *continueToken) (runtime.Object, error) {
+	if token == nil && (options == nil || options.Limit <= 0) {
+		return list, nil
+	}
+	listAccessor, err := meta.ListAccessor(list)
+	if err != nil {
+		return nil, apierrors.NewInternalError(err)
+	}
+	items, err := meta.ExtractList(list)
+	if err != nil {
+		return nil, apierrors.NewInternalError(err)
+	}
+	sortObjects(items)
+
+	revision, err := parseResourceVersion(listAccessor.GetResourceVersion())
+	if err != nil {
+		return nil, apierrors.NewInternalError(err)
+	}
+	if token != nil {
+		revision = token.Revision
+		setListResourceVersion(list, revision)
+		first := len(items)
+		for i, item := range items {
+			if accessor, err := meta.Accessor(item); err == nil && objectKeyAfter(accessor.GetNamespace(), accessor.GetName(), token.Start) {
+				first = i
+				break
+			}
+		}
+		items = items[first:]
+	}
+	if limit := int(options.Limit); limit > 0 && len(items) > limit {
+		remaining := int64(len(items) - limit)
+		items = items[:limit]
+		last, err := meta.Accessor(items[limit-1])
+		if err != nil {
+			return nil, apierrors.NewInternalError(err)
+		}
+		next := &continueToken{Revision: revision, Start: last.GetNamespace() + "/" + last.GetName()}
+		listAccessor.SetContinue(next.encode())
+		listAccessor.SetRemainingItemCount(&remaining)
+	}
+	if err := meta.SetList(list, items); err != nil {
+		return nil, apierrors.NewInternalError(err)
+	}
+	return list, nil
+}
+
+// objectKeyAfter tells whether the object of namespace and name comes after the given key in the order of sortObjects.
+func objectKeyAfter(namespace, name, key string) bool {
+	startNamespace, startName, _ := strings.Cut(key, "/")
+	if namespace != startNamespace {
+		return namespace > startNamespace
+	}
+	return name > startName
+}
@@ -0,0 +1,309 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/klog/v2"
+
+	"github.com/alibaba/higress/api-server/pkg/options"
+)
+
+const (
+	redisReadBlockTimeout = 5 * time.Second
+	redisReadBatchSize    = 500
+	redisRetryInterval    = time.Second
+)
+
+var (
+	redisDatabases      = map[string]*redisDatabase{}
+	redisDatabasesMutex sync.Mutex
+)
+
+// redisDatabase is a Redis database shared by all the resources stored in the redis backend, possibly by several
+// API server instances as well. The objects of each resource are kept in a hash whose fields are "<namespace>/<name>",
+// the revision is a counter, and every change is appended to a stream with the revision as its entry ID.
+// All of them are updated in a single MULTI/EXEC transaction, which is retried if any of the keys read before
+// is modified concurrently. A reader follows the stream and dispatches the events to the watchers of each resource.
+type redisDatabase struct {
+	client        *redis.Client
+	keyPrefix     string
+	changeLogSize int
+
+	// dispatchMutex is held while dispatching events, so watchers can be registered between two batches.
+	dispatchMutex      sync.Mutex
+	dispatchedRevision uint64
+	handlers           map[string]func(revision uint64, change *redisChange)
+}
+
+// redisChange is an entry in the event stream.
+type redisChange struct {
+	revision   uint64
+	resource   string
+	key        string
+	eventType  watch.EventType
+	object     []byte
+	prevObject []byte
+}
+
+func getRedisDatabase(redisOptions *options.RedisOptions) (*redisDatabase, error) {
+	redisDatabasesMutex.Lock()
+	defer redisDatabasesMutex.Unlock()
+
+	key := fmt.Sprintf("%s/%d/%s", redisOptions.Address, redisOptions.DB, redisOptions.KeyPrefix)
+	if d, ok := redisDatabases[key]; ok {
+		return d, nil
+	}
+	client := redis.NewClient(&redis.Options{
+		Addr:     redisOptions.Address,
+		Username: redisOptions.Username,
+		Password: redisOptions.Password,
+		DB:       redisOptions.DB,
+	})
+	d := &redisDatabase{
+		client:        client,
+		keyPrefix:     redisOptions.KeyPrefix,
+		changeLogSize: redisOptions.ChangeLogSize,
+		handlers:      make(map[string]func(uint64, *redisChange)),
+	}
+	revision, err := d.currentRevision(context.Background(), client)
+	if err != nil {
+		_ = client.Close()
+		return nil, fmt.Errorf("failed to read revision from redis [%s]: %v", redisOptions.Address, err)
+	}
+	d.dispatchedRevision = revision
+	d.startReader()
+	redisDatabases[key] = d
+	return d, nil
+}
+
+func (d *redisDatabase) revisionKey() string {
+	return d.keyPrefix + ":revision"
+}
+
+func (d *redisDatabase) eventsKey() string {
+	return d.keyPrefix + ":events"
+}
+
+func (d *redisDatabase) objectsKey(resource string) string {
+	return d.keyPrefix + ":objects:" + resource
+}
+
+func (d *redisDatabase) currentRevision(ctx context.Context, c redis.Cmdable) (uint64, error) {
+	revision, err := c.Get(ctx, d.revisionKey()).Uint64()
+	if errors.Is(err, redis.Nil) {
+		return 0, nil
+	}
+	return revision, err
+}
+
+// snapshot reads the current revision and all the objects of a resource atomically.
+func (d *redisDatabase) snapshot(ctx context.Context, resource string) (uint64, map[string]string, error) {
+	var revisionCmd *redis.StringCmd
+	var objectsCmd *redis.MapStringStringCmd
+	if _, err := d.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		revisionCmd = pipe.Get(ctx, d.revisionKey())
+		objectsCmd = pipe.HGetAll(ctx, d.objectsKey(resource))
+		return nil
+	}); err != nil && !errors.Is(err, redis.Nil) {
+		return 0, nil, err
+	}
+	var revision uint64
+	if revisionCmd.Err() == nil {
+		var err error
+		if revision, err = revisionCmd.Uint64(); err != nil {
+			return 0, nil, err
+		}
+	}
+	objects, err := objectsCmd.Result()
+	if err != nil {
+		return 0, nil, err
+	}
+	return revision, objects, nil
+}
+
+// getObject returns the stored value of an object, or nil if it doesn't exist.
+func (d *redisDatabase) getObject(ctx context.Context, c redis.Cmdable, resource, key string) ([]byte, error) {
+	value, err := c.HGet(ctx, d.objectsKey(resource), key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	return value, err
+}
+
+// redisUpdateFunc prepares the changes of a transaction, reading the current objects with tx and allocating
+// revisions with nextRevision. The changes of a DELETED type remove the objects, and the other ones overwrite them.
+type redisUpdateFunc func(tx *redis.Tx, nextRevision func() uint64) ([]*redisChange, error)
+
+// update commits the changes of a resource prepared by updateFunc, which is called again if any object of the
+// resource or the revision is modified concurrently.
+func (d *redisDatabase) update(ctx context.Context, resource string, updateFunc redisUpdateFunc) error {
+	for {
+		err := d.client.Watch(ctx, func(tx *redis.Tx) error {
+			revision, err := d.currentRevision(ctx, tx)
+			if err != nil {
+				return err
+			}
+			nextRevision := revision
+			changes, err := updateFunc(tx, func() uint64 {
+				nextRevision++
+				return nextRevision
+			})
+			if err != nil || len(changes) == 0 {
+				return err
+			}
+			_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+				pipe.Set(ctx, d.revisionKey(), nextRevision, 0)
+				for _, change := range changes {
+					if change.eventType == watch.Deleted {
+						pipe.HDel(ctx, d.objectsKey(resource), change.key)
+					} else {
+						pipe.HSet(ctx, d.objectsKey(resource), change.key, change.object)
+					}
+					values := map[string]interface{}{
+						"resource": resource,
+						"key":      change.key,
+						"type":     string(change.eventType),
+						"object":   change.object,
+					}
+					if change.prevObject != nil {
+						values["prevObject"] = change.prevObject
+					}
+					pipe.XAdd(ctx, &redis.XAddArgs{
+						Stream: d.eventsKey(),
+						MaxLen: int64(d.changeLogSize),
+						Approx: true,
+						ID:     formatRedisStreamID(change.revision),
+						Values: values,
+					})
+				}
+				return nil
+			})
+			return err
+		}, d.revisionKey(), d.objectsKey(resource))
+		if errors.Is(err, redis.TxFailedErr) {
+			continue
+		}
+		return err
+	}
+}
+
+// changesSince returns the changes of a resource happened after the given revision and not after the upper bound.
+// A "410 Gone" error is returned if some of them have already been trimmed from the stream.
+func (d *redisDatabase) changesSince(ctx context.Context, resource string, revision, upperBound uint64) ([]*redisChange, error) {
+	first, err := d.client.XRangeN(ctx, d.eventsKey(), "-", "+", 1).Result()
+	if err != nil {
+		return nil, err
+	}
+	if revision < upperBound && (len(first) == 0 || parseRedisStreamID(first[0].ID) > revision+1) {
+		oldest := upperBound
+		if len(first) != 0 {
+			oldest = parseRedisStreamID(first[0].ID) - 1
+		}
+		return nil, apierrors.NewResourceExpired(
+			fmt.Sprintf("too old resource version: %d (%d)", revision, oldest))
+	}
+	if revision >= upperBound {
+		return nil, nil
+	}
+	messages, err := d.client.XRange(ctx, d.eventsKey(), formatRedisStreamID(revision+1), formatRedisStreamID(upperBound)).Result()
+	if err != nil {
+		return nil, err
+	}
+	var changes []*redisChange
+	for _, message := range messages {
+		change := parseRedisChange(message)
+		if change.resource == resource {
+			changes = append(changes, change)
+		}
+	}
+	return changes, nil
+}
+
+// registerHandler sets the function receiving the changes of a resource in revision order.
+func (d *redisDatabase) registerHandler(resource string, handler func(uint64, *redisChange)) {
+	d.dispatchMutex.Lock()
+	defer d.dispatchMutex.Unlock()
+	d.handlers[resource] = handler
+}
+
+func (d *redisDatabase) startReader() {
+	go func(d *redisDatabase) {
+		for {
+			if err := d.read(); err != nil {
+				klog.Errorf("failed to read redis events: %v", err)
+				time.Sleep(redisRetryInterval)
+			}
+		}
+	}(d)
+}
+
+// read waits for the changes following the dispatched revision, and dispatches them.
+func (d *redisDatabase) read() error {
+	d.dispatchMutex.Lock()
+	lastID := formatRedisStreamID(d.dispatchedRevision)
+	d.dispatchMutex.Unlock()
+
+	streams, err := d.client.XRead(context.Background(), &redis.XReadArgs{
+		Streams: []string{d.eventsKey(), lastID},
+		Count:   redisReadBatchSize,
+		Block:   redisReadBlockTimeout,
+	}).Result()
+	if errors.Is(err, redis.Nil) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	d.dispatchMutex.Lock()
+	defer d.dispatchMutex.Unlock()
+	for _, stream := range streams {
+		for _, message := range stream.Messages {
+			change := parseRedisChange(message)
+			if change.revision <= d.dispatchedRevision {
+				continue
+			}
+			if change.revision != d.dispatchedRevision+1 {
+				klog.Warningf("skipping redis revisions %d-%d trimmed from the stream", d.dispatchedRevision+1, change.revision-1)
+			}
+			if handler, ok := d.handlers[change.resource]; ok {
+				handler(change.revision, change)
+			}
+			d.dispatchedRevision = change.revision
+		}
+	}
+	return nil
+}
+
+func parseRedisChange(message redis.XMessage) *redisChange {
+	change := &redisChange{revision: parseRedisStreamID(message.ID)}
+	change.resource, _ = message.Values["resource"].(string)
+	change.key, _ = message.Values["key"].(string)
+	eventType, _ := message.Values["type"].(string)
+	change.eventType = watch.EventType(eventType)
+	if object, ok := message.Values["object"].(string); ok {
+		change.object = []byte(object)
+	}
+	if prevObject, ok := message.Values["prevObject"].(string); ok {
+		change.prevObject = []byte(prevObject)
+	}
+	return change
+}
+
+func formatRedisStreamID(revision uint64) string {
+	return strconv.FormatUint(revision, 10) + "-0"
+}
+
+func parseRedisStreamID(id string) uint64 {
+	revision, _ := strconv.ParseUint(strings.SplitN(id, "-", 2)[0], 10, 64)
+	return revision
+}
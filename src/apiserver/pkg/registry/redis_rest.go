@@ -0,0 +1,672 @@
+package registry
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metainternalversion "k8s.io/apimachinery/pkg/apis/meta/internalversion"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/uuid"
+	"k8s.io/apimachinery/pkg/watch"
+	genericapirequest "k8s.io/apiserver/pkg/endpoints/request"
+	"k8s.io/apiserver/pkg/registry/rest"
+	"k8s.io/apiserver/pkg/storage"
+	storeerr "k8s.io/apiserver/pkg/storage/errors"
+	"k8s.io/apiserver/pkg/util/dryrun"
+	"k8s.io/klog/v2"
+
+	"github.com/alibaba/higress/api-server/pkg/encryption"
+	"github.com/alibaba/higress/api-server/pkg/options"
+)
+
+var _ rest.StandardStorage = &redisREST{}
+var _ Reencrypter = &redisREST{}
+var _ rest.Scoper = &redisREST{}
+var _ rest.Storage = &redisREST{}
+
+// NewRedisREST instantiates a new REST storage backed by Redis.
+func NewRedisREST(
+	groupResource schema.GroupResource,
+	codec runtime.Codec,
+	redisOptions *options.RedisOptions,
+	isNamespaced bool,
+	singularName string,
+	newFunc func() runtime.Object,
+	newListFunc func() runtime.Object,
+	attrFunc storage.AttrFunc,
+	policy *encryption.Policy,
+) (REST, error) {
+	if attrFunc == nil {
+		if isNamespaced {
+			attrFunc = storage.DefaultNamespaceScopedAttr
+		} else {
+			attrFunc = storage.DefaultClusterScopedAttr
+		}
+	}
+	db, err := getRedisDatabase(redisOptions)
+	if err != nil {
+		return nil, err
+	}
+	r := &redisREST{
+		TableConvertor: rest.NewDefaultTableConvertor(groupResource),
+		groupResource:  groupResource,
+		codec:          codec,
+		db:             db,
+		resource:       groupResource.String(),
+		isNamespaced:   isNamespaced,
+		singularName:   singularName,
+		newFunc:        newFunc,
+		newListFunc:    newListFunc,
+		attrFunc:       attrFunc,
+		policy:         policy,
+		watchers:       newWatchBroadcaster(),
+	}
+	db.registerHandler(r.resource, r.handleChange)
+	r.startBookmarkTicker()
+	return r, nil
+}
+
+type redisREST struct {
+	rest.TableConvertor
+	groupResource schema.GroupResource
+	codec         runtime.Codec
+	db            *redisDatabase
+	// resource is the name of the hash holding the objects without the key prefix, and the resource recorded in the stream.
+	resource     string
+	isNamespaced bool
+	singularName string
+
+	watchers *watchBroadcaster
+
+	newFunc     func() runtime.Object
+	newListFunc func() runtime.Object
+	attrFunc    storage.AttrFunc
+	// policy encrypts the objects selected, along with the change log entries holding them, and is nil if
+	// encryption isn't enabled.
+	policy *encryption.Policy
+}
+
+func (r *redisREST) GetSingularName() string {
+	return r.singularName
+}
+
+func (r *redisREST) Destroy() {
+	// The client is shared by all the resources, so it's left open.
+}
+
+func (r *redisREST) New() runtime.Object {
+	return r.newFunc()
+}
+
+func (r *redisREST) NewList() runtime.Object {
+	return r.newListFunc()
+}
+
+func (r *redisREST) NamespaceScoped() bool {
+	return r.isNamespaced
+}
+
+func (r *redisREST) startBookmarkTicker() {
+	if options.WatchBookmarkIntervalSecs <= 0 {
+		return
+	}
+	ticker := time.NewTicker(time.Duration(options.WatchBookmarkIntervalSecs) * time.Second)
+	go func(r *redisREST) {
+		for {
+			<-ticker.C
+			r.sendBookmarks()
+		}
+	}(r)
+}
+
+func (r *redisREST) sendBookmarks() {
+	r.db.dispatchMutex.Lock()
+	defer r.db.dispatchMutex.Unlock()
+	r.watchers.broadcastBookmark(r.newFunc, r.db.dispatchedRevision)
+}
+
+// handleChange is called by the reader of the database for each change of this resource, in revision order.
+func (r *redisREST) handleChange(revision uint64, change *redisChange) {
+	ev, err := r.decodeChange(change)
+	if err != nil {
+		klog.Errorf("[%s] failed to decode change at revision %d: %v", r.groupResource, revision, err)
+		return
+	}
+	klog.Infof("event %s %s %s rv=%d count(watcher)=%d", ev.Type, ev.Object.GetObjectKind(), change.key, revision, r.watchers.count())
+	r.watchers.broadcast(ev)
+}
+
+func (r *redisREST) Get(
+	ctx context.Context,
+	name string,
+	options *metav1.GetOptions,
+) (runtime.Object, error) {
+	data, err := r.db.getObject(ctx, r.db.client, r.resource, r.objectKey(ctx, name))
+	if err != nil {
+		return nil, toAPIError(err)
+	}
+	if data == nil {
+		return nil, apierrors.NewNotFound(r.groupResource, name)
+	}
+	obj, err := r.decode(data)
+	if err != nil {
+		return nil, apierrors.NewInternalError(err)
+	}
+	klog.Infof("[%s] %s got", r.groupResource, name)
+	return obj, nil
+}
+
+func (r *redisREST) List(
+	ctx context.Context,
+	options *metainternalversion.ListOptions,
+) (runtime.Object, error) {
+	token, err := decodeContinue(options)
+	if err != nil {
+		return nil, err
+	}
+	revision, objects, err := r.db.snapshot(ctx, r.resource)
+	if err != nil {
+		return nil, toAPIError(err)
+	}
+	if err := checkListResourceVersion(options, revision, r.groupResource); err != nil {
+		return nil, err
+	}
+	if token != nil {
+		if err := r.checkContinue(ctx, token, revision); err != nil {
+			return nil, toAPIError(err)
+		}
+	}
+
+	predicate := r.buildListPredicate(options)
+
+	newListObj := r.NewList()
+	v, err := getListPrt(newListObj)
+	if err != nil {
+		return nil, apierrors.NewInternalError(err)
+	}
+
+	count := 0
+	if err := r.visitObjects(objects, r.listKeyPrefix(ctx), func(_ string, obj runtime.Object) {
+		if ok, err := predicate.Matches(obj); err == nil && ok {
+			count++
+			appendItem(v, obj)
+		}
+	}); err != nil {
+		return nil, toAPIError(err)
+	}
+	setListResourceVersion(newListObj, revision)
+
+	klog.Infof("[%s] list count=%d rv=%d", r.groupResource, count, revision)
+	return paginate(newListObj, options, token)
+}
+
+// checkContinue verifies that the chunks following the continue token are still consistent with the first one,
+// given the list is at the snapshot of the given revision.
+func (r *redisREST) checkContinue(ctx context.Context, token *continueToken, revision uint64) error {
+	changes, err := r.db.changesSince(ctx, r.resource, token.Revision, revision)
+	if err != nil {
+		return err
+	}
+	events := make([]watchEvent, 0, len(changes))
+	for _, change := range changes {
+		ev, err := r.decodeChange(change)
+		if err != nil {
+			return err
+		}
+		events = append(events, ev)
+	}
+	ns, _ := genericapirequest.NamespaceFrom(ctx)
+	return token.checkEvents(ns, events)
+}
+
+func (r *redisREST) Create(
+	ctx context.Context,
+	obj runtime.Object,
+	createValidation rest.ValidateObjectFunc,
+	options *metav1.CreateOptions,
+) (runtime.Object, error) {
+	if createValidation != nil {
+		if err := createValidation(ctx, obj); err != nil {
+			return nil, toAPIError(err)
+		}
+	}
+
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return nil, apierrors.NewInternalError(err)
+	}
+	name := accessor.GetName()
+	key := r.objectKey(ctx, name)
+
+	err = r.db.update(ctx, r.resource, func(tx *redis.Tx, nextRevision func() uint64) ([]*redisChange, error) {
+		current, err := r.db.getObject(ctx, tx, r.resource, key)
+		if err != nil {
+			return nil, err
+		}
+		if current != nil {
+			return nil, apierrors.NewAlreadyExists(r.groupResource, name)
+		}
+		r.prepareObjectMeta(ctx, accessor)
+		accessor.SetUID(uuid.NewUUID())
+		accessor.SetCreationTimestamp(metav1.NewTime(time.Now()))
+		if dryrun.IsDryRun(options.DryRun) {
+			return nil, nil
+		}
+		revision := nextRevision()
+		accessor.SetResourceVersion(formatResourceVersion(revision))
+		data, err := r.encode(obj)
+		if err != nil {
+			return nil, err
+		}
+		return []*redisChange{{revision: revision, key: key, eventType: watch.Added, object: data}}, nil
+	})
+	if err != nil {
+		return nil, toAPIError(err)
+	}
+	return obj, nil
+}
+
+func (r *redisREST) Update(
+	ctx context.Context,
+	name string,
+	objInfo rest.UpdatedObjectInfo,
+	createValidation rest.ValidateObjectFunc,
+	updateValidation rest.ValidateObjectUpdateFunc,
+	forceAllowCreate bool,
+	options *metav1.UpdateOptions,
+) (runtime.Object, bool, error) {
+	key := r.objectKey(ctx, name)
+
+	var updatedObj runtime.Object
+	isCreate := false
+	err := r.db.update(ctx, r.resource, func(tx *redis.Tx, nextRevision func() uint64) ([]*redisChange, error) {
+		current, err := r.db.getObject(ctx, tx, r.resource, key)
+		if err != nil {
+			return nil, err
+		}
+		var oldObj runtime.Object
+		isCreate = false
+		if current == nil {
+			if !forceAllowCreate {
+				return nil, apierrors.NewNotFound(r.groupResource, name)
+			}
+			isCreate = true
+		} else if oldObj, err = r.decode(current); err != nil {
+			return nil, err
+		}
+
+		updatedObj, err = objInfo.UpdatedObject(ctx, oldObj)
+		if err != nil {
+			return nil, err
+		}
+		updatedAccessor, err := meta.Accessor(updatedObj)
+		if err != nil {
+			return nil, err
+		}
+
+		change := &redisChange{key: key}
+		if isCreate {
+			if createValidation != nil {
+				if err := createValidation(ctx, updatedObj); err != nil {
+					return nil, err
+				}
+			}
+			updatedAccessor.SetUID(uuid.NewUUID())
+			updatedAccessor.SetCreationTimestamp(metav1.NewTime(time.Now()))
+			change.eventType = watch.Added
+		} else {
+			if updateValidation != nil {
+				if err := updateValidation(ctx, updatedObj, oldObj); err != nil {
+					return nil, err
+				}
+			}
+			oldAccessor, err := meta.Accessor(oldObj)
+			if err != nil {
+				return nil, err
+			}
+			if updatedAccessor.GetResourceVersion() != "" && updatedAccessor.GetResourceVersion() != oldAccessor.GetResourceVersion() {
+				return nil, apierrors.NewConflict(r.groupResource, name, errors.New(optimisticLockErrorMsg))
+			}
+			updatedAccessor.SetUID(oldAccessor.GetUID())
+			change.eventType = watch.Modified
+			change.prevObject = current
+		}
+
+		r.prepareObjectMeta(ctx, updatedAccessor)
+		if dryrun.IsDryRun(options.DryRun) {
+			return nil, nil
+		}
+		change.revision = nextRevision()
+		updatedAccessor.SetResourceVersion(formatResourceVersion(change.revision))
+		if change.object, err = r.encode(updatedObj); err != nil {
+			return nil, err
+		}
+		return []*redisChange{change}, nil
+	})
+	if err != nil {
+		return nil, false, toAPIError(err)
+	}
+	return updatedObj, isCreate, nil
+}
+
+func (r *redisREST) Delete(
+	ctx context.Context,
+	name string,
+	deleteValidation rest.ValidateObjectFunc,
+	options *metav1.DeleteOptions) (runtime.Object, bool, error) {
+	key := r.objectKey(ctx, name)
+
+	var oldObj runtime.Object
+	err := r.db.update(ctx, r.resource, func(tx *redis.Tx, nextRevision func() uint64) ([]*redisChange, error) {
+		current, err := r.db.getObject(ctx, tx, r.resource, key)
+		if err != nil {
+			return nil, err
+		}
+		if current == nil {
+			return nil, apierrors.NewNotFound(r.groupResource, name)
+		}
+		if oldObj, err = r.decode(current); err != nil {
+			return nil, err
+		}
+		if deleteValidation != nil {
+			if err := deleteValidation(ctx, oldObj); err != nil {
+				return nil, err
+			}
+		}
+		if dryrun.IsDryRun(options.DryRun) {
+			return nil, nil
+		}
+		change, err := r.deletion(key, oldObj, nextRevision())
+		if err != nil {
+			return nil, err
+		}
+		return []*redisChange{change}, nil
+	})
+	if err != nil {
+		return nil, false, toAPIError(err)
+	}
+	return oldObj, true, nil
+}
+
+func (r *redisREST) DeleteCollection(
+	ctx context.Context,
+	deleteValidation rest.ValidateObjectFunc,
+	options *metav1.DeleteOptions,
+	listOptions *metainternalversion.ListOptions,
+) (runtime.Object, error) {
+	predicate := r.buildListPredicate(listOptions)
+
+	var deletedObjs []runtime.Object
+	err := r.db.update(ctx, r.resource, func(tx *redis.Tx, nextRevision func() uint64) ([]*redisChange, error) {
+		objects, err := tx.HGetAll(ctx, r.db.objectsKey(r.resource)).Result()
+		if err != nil {
+			return nil, err
+		}
+		deletedObjs = nil
+		var changes []*redisChange
+		var visitErr error
+		if err := r.visitObjects(objects, r.listKeyPrefix(ctx), func(key string, obj runtime.Object) {
+			if visitErr != nil {
+				return
+			}
+			if ok, err := predicate.Matches(obj); err != nil || !ok {
+				return
+			}
+			if deleteValidation != nil {
+				if visitErr = deleteValidation(ctx, obj); visitErr != nil {
+					return
+				}
+			}
+			deletedObjs = append(deletedObjs, obj)
+			if dryrun.IsDryRun(options.DryRun) {
+				return
+			}
+			var change *redisChange
+			if change, visitErr = r.deletion(key, obj, nextRevision()); visitErr != nil {
+				return
+			}
+			changes = append(changes, change)
+		}); err != nil {
+			return nil, err
+		}
+		return changes, visitErr
+	})
+	if err != nil {
+		return nil, toAPIError(err)
+	}
+
+	newListObj := r.NewList()
+	v, err := getListPrt(newListObj)
+	if err != nil {
+		return nil, apierrors.NewInternalError(err)
+	}
+	for _, obj := range deletedObjs {
+		appendItem(v, obj)
+	}
+	return newListObj, nil
+}
+
+func (r *redisREST) Watch(ctx context.Context, options *metainternalversion.ListOptions) (watch.Interface, error) {
+	allowBookmarks := options != nil && options.AllowWatchBookmarks
+	ns := ""
+	if r.isNamespaced {
+		ns, _ = genericapirequest.NamespaceFrom(ctx)
+	}
+	filter := newSelectionFilter(ns, r.buildListPredicate(options))
+
+	// Hold the lock until the watcher is registered, so no event is missed.
+	r.db.dispatchMutex.Lock()
+	defer r.db.dispatchMutex.Unlock()
+
+	dispatchedRevision := r.db.dispatchedRevision
+	var initialEvents []watch.Event
+	if shouldSendInitialEvents(options) {
+		// On initial watch, send all the existing objects
+		list, err := r.List(ctx, options)
+		if err != nil {
+			return nil, err
+		}
+		items, err := meta.ExtractList(list)
+		if err != nil {
+			return nil, apierrors.NewInternalError(err)
+		}
+		for _, item := range items {
+			initialEvents = append(initialEvents, watch.Event{
+				Type:   watch.Added,
+				Object: item,
+			})
+		}
+		listAccessor, err := meta.ListAccessor(list)
+		if err != nil {
+			return nil, apierrors.NewInternalError(err)
+		}
+		revision, _ := parseResourceVersion(listAccessor.GetResourceVersion())
+		if allowBookmarks && options.SendInitialEvents != nil {
+			initialEvents = append(initialEvents, newBookmarkEvent(r.newFunc, revision, true))
+		}
+		if revision > dispatchedRevision {
+			// Events up to the list revision are yet to be dispatched, but are already reflected in the list.
+			filter = skipEventsUntil(revision, filter)
+		}
+	} else if options.ResourceVersion != "" && options.ResourceVersion != "0" {
+		// Resume from the given resource version
+		revision, err := parseResourceVersion(options.ResourceVersion)
+		if err != nil {
+			return nil, err
+		}
+		current, err := r.db.currentRevision(ctx, r.db.client)
+		if err != nil {
+			return nil, toAPIError(err)
+		}
+		if revision > current {
+			return nil, storeerr.InterpretListError(storage.NewTooLargeResourceVersionError(revision, current, 1), r.groupResource)
+		}
+		changes, err := r.db.changesSince(ctx, r.resource, revision, dispatchedRevision)
+		if err != nil {
+			return nil, toAPIError(err)
+		}
+		for _, change := range changes {
+			ev, err := r.decodeChange(change)
+			if err != nil {
+				return nil, apierrors.NewInternalError(err)
+			}
+			if filtered, ok := filter(ev); ok {
+				initialEvents = append(initialEvents, filtered)
+			}
+		}
+		if revision > dispatchedRevision {
+			// Events up to the given revision are yet to be dispatched, but have been observed by the client.
+			filter = skipEventsUntil(revision, filter)
+		}
+	}
+
+	return r.watchers.watch(ctx, initialEvents, filter, allowBookmarks), nil
+}
+
+func (r *redisREST) buildListPredicate(options *metainternalversion.ListOptions) storage.SelectionPredicate {
+	label := labels.Everything()
+	field := fields.Everything()
+	if options != nil {
+		if options.LabelSelector != nil {
+			label = options.LabelSelector
+		}
+		if options.FieldSelector != nil {
+			field = options.FieldSelector
+		}
+	}
+	return storage.SelectionPredicate{
+		Label:    label,
+		Field:    field,
+		GetAttrs: r.attrFunc,
+	}
+}
+
+// objectKey returns the field of an object in the resource hash, which is "<namespace>/<name>" for namespaced
+// resources and "<name>" for cluster-scoped ones.
+func (r *redisREST) objectKey(ctx context.Context, name string) string {
+	if r.isNamespaced {
+		ns, _ := genericapirequest.NamespaceFrom(ctx)
+		return ns + "/" + name
+	}
+	return name
+}
+
+// listKeyPrefix returns the prefix of the fields of all the objects visible to a list request.
+func (r *redisREST) listKeyPrefix(ctx context.Context) string {
+	if r.isNamespaced {
+		if ns, ok := genericapirequest.NamespaceFrom(ctx); ok && ns != "" {
+			return ns + "/"
+		}
+	}
+	return ""
+}
+
+// prepareObjectMeta makes the namespace of an object consistent with the request it's written by.
+func (r *redisREST) prepareObjectMeta(ctx context.Context, accessor metav1.Object) {
+	if r.isNamespaced {
+		ns, _ := genericapirequest.NamespaceFrom(ctx)
+		accessor.SetNamespace(ns)
+	} else {
+		accessor.SetNamespace("")
+	}
+}
+
+// deletion builds the change removing an object with the given revision.
+func (r *redisREST) deletion(key string, obj runtime.Object, revision uint64) (*redisChange, error) {
+	deletedObj := obj.DeepCopyObject()
+	setResourceVersion(deletedObj, revision)
+	data, err := r.encode(deletedObj)
+	if err != nil {
+		return nil, err
+	}
+	return &redisChange{revision: revision, key: key, eventType: watch.Deleted, object: data}, nil
+}
+
+// visitObjects decodes all the objects whose fields start with the given prefix, in field order.
+func (r *redisREST) visitObjects(objects map[string]string, prefix string, visitFunc func(string, runtime.Object)) error {
+	keys := make([]string, 0, len(objects))
+	for key := range objects {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		obj, err := r.decode([]byte(objects[key]))
+		if err != nil {
+			return fmt.Errorf("failed to decode object [%s]: %v", key, err)
+		}
+		visitFunc(key, obj)
+	}
+	return nil
+}
+
+func (r *redisREST) encode(obj runtime.Object) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	if err := r.codec.Encode(obj, buf); err != nil {
+		return nil, err
+	}
+	data, err := r.policy.Encrypt(obj, buf.String())
+	if err != nil {
+		return nil, err
+	}
+	return []byte(data), nil
+}
+
+func (r *redisREST) decode(data []byte) (runtime.Object, error) {
+	decrypted, err := r.policy.Decrypt(string(data))
+	if err != nil {
+		return nil, err
+	}
+	obj, _, err := r.codec.Decode([]byte(decrypted), nil, r.newFunc())
+	return obj, err
+}
+
+// Reencrypt rewrites the objects not encrypted as the policy requires, e.g. not with the newest key, like updates.
+func (r *redisREST) Reencrypt(ctx context.Context) (int, error) {
+	if r.policy == nil {
+		return 0, nil
+	}
+	_, objects, err := r.db.snapshot(ctx, r.resource)
+	if err != nil {
+		return 0, err
+	}
+	var staleObjs []runtime.Object
+	for key, value := range objects {
+		obj, err := r.decode([]byte(value))
+		if err != nil {
+			return 0, fmt.Errorf("failed to decode object [%s]: %v", key, err)
+		}
+		if r.policy.IsStale(obj, value) {
+			staleObjs = append(staleObjs, obj)
+		}
+	}
+	sortObjects(staleObjs)
+	return reencryptObjects(ctx, r, r.groupResource, r.policy, staleObjs)
+}
+
+func (r *redisREST) decodeChange(change *redisChange) (watchEvent, error) {
+	obj, err := r.decode(change.object)
+	if err != nil {
+		return watchEvent{}, err
+	}
+	ev := watchEvent{Event: watch.Event{Type: change.eventType, Object: obj}}
+	if len(change.prevObject) != 0 {
+		if ev.prevObject, err = r.decode(change.prevObject); err != nil {
+			return watchEvent{}, err
+		}
+	}
+	return ev, nil
+}
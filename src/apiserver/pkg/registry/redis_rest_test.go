@@ -0,0 +1,182 @@
+package registry
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metainternalversion "k8s.io/apimachinery/pkg/apis/meta/internalversion"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apiserver/pkg/registry/rest"
+
+	"github.com/alibaba/higress/api-server/pkg/encryption"
+	"github.com/alibaba/higress/api-server/pkg/options"
+)
+
+func newTestRedisREST(t *testing.T, redisOptions *options.RedisOptions, policy *encryption.Policy) *redisREST {
+	t.Helper()
+	storage, err := NewRedisREST(testGroupResource, testCodec, redisOptions, true, "configmap",
+		newTestConfigMap, newTestConfigMapList, nil, policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return storage.(*redisREST)
+}
+
+func newTestRedisOptions(t *testing.T, changeLogSize int) (*miniredis.Miniredis, *options.RedisOptions) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	return mr, &options.RedisOptions{
+		Address:       mr.Addr(),
+		KeyPrefix:     "higress",
+		ChangeLogSize: changeLogSize,
+	}
+}
+
+// newTestRedisReplica creates a storage with its own database connection, like another API server instance would.
+func newTestRedisReplica(t *testing.T, redisOptions *options.RedisOptions) *redisREST {
+	t.Helper()
+	redisDatabasesMutex.Lock()
+	delete(redisDatabases, redisOptions.Address+"/0/"+redisOptions.KeyPrefix)
+	redisDatabasesMutex.Unlock()
+	return newTestRedisREST(t, redisOptions, nil)
+}
+
+func TestRedisRevisionsAndWatch(t *testing.T) {
+	mr, redisOptions := newTestRedisOptions(t, 1000)
+	r := newTestRedisREST(t, redisOptions, nil)
+	a1 := mustCreate(t, r, "ns", "a", "v1")
+	w := mustWatch(t, r, "ns", a1.ResourceVersion)
+	b1 := mustCreate(t, r, "ns", "b", "v1")
+	mustUpdate(t, r, a1, "v2")
+	mustDelete(t, r, "ns", "b")
+	expectEvents(t, w, "ADDED b=v1", "MODIFIED a=v2", "DELETED b=v1")
+
+	// A watch resumed from a revision gets all the events after it.
+	resumed := mustWatch(t, r, "ns", b1.ResourceVersion)
+	expectEvents(t, resumed, "MODIFIED a=v2", "DELETED b=v1")
+
+	// The revision is a counter in redis, following the last change.
+	if revision, err := mr.Get("higress:revision"); err != nil || revision != "4" {
+		t.Fatalf("revision in redis is %q, %v, want 4", revision, err)
+	}
+	if _, err := r.Watch(nsContext("ns"), &metainternalversion.ListOptions{ResourceVersion: "5"}); !apierrors.IsTimeout(err) {
+		t.Fatalf("watch from a future revision returned %v, want a timeout", err)
+	}
+}
+
+func TestRedisReplicas(t *testing.T) {
+	_, redisOptions := newTestRedisOptions(t, 1000)
+	r := newTestRedisREST(t, redisOptions, nil)
+	replica := newTestRedisReplica(t, redisOptions)
+	if r.db == replica.db {
+		t.Fatal("replica shares the database connection")
+	}
+
+	// The changes made through one instance are watched and read through the other one.
+	w := mustWatch(t, r, "ns", "")
+	a := mustCreate(t, replica, "ns", "a", "v1")
+	a = mustUpdate(t, replica, a, "v2")
+	expectEvents(t, w, "ADDED a=v1", "MODIFIED a=v2")
+	if got := mustList(t, r, "ns", nil); listedNames(got) != "a" || got.Items[0].Data["key"] != "v2" {
+		t.Fatalf("listed %v from the other instance", got.Items)
+	}
+
+	// Updates from both instances are compared and swapped against the same revision.
+	mustUpdate(t, r, a, "v3")
+	_, _, err := r.Update(nsContext("ns"), "a", rest.DefaultUpdatedObjectInfo(a), nil, nil, false, &metav1.UpdateOptions{})
+	if !apierrors.IsConflict(err) {
+		t.Fatalf("update with a resource version overwritten by the other instance returned %v, want a conflict", err)
+	}
+}
+
+func TestRedisConflicts(t *testing.T) {
+	_, redisOptions := newTestRedisOptions(t, 1000)
+	r := newTestRedisREST(t, redisOptions, nil)
+	a := mustCreate(t, r, "ns", "a", "v1")
+	if _, err := r.Create(nsContext("ns"), testConfigMap("ns", "a", "v2"), nil, &metav1.CreateOptions{}); !apierrors.IsAlreadyExists(err) {
+		t.Fatalf("creating an existing object returned %v, want already exists", err)
+	}
+	mustUpdate(t, r, a, "v2")
+	stale := a.DeepCopy()
+	stale.Data["key"] = "v3"
+	_, _, err := r.Update(nsContext("ns"), "a", rest.DefaultUpdatedObjectInfo(stale), nil, nil, false, &metav1.UpdateOptions{})
+	if !apierrors.IsConflict(err) {
+		t.Fatalf("update with a stale resource version returned %v, want a conflict", err)
+	}
+	if _, _, err := r.Delete(nsContext("ns"), "b", nil, &metav1.DeleteOptions{}); !apierrors.IsNotFound(err) {
+		t.Fatalf("deleting a missing object returned %v, want not found", err)
+	}
+}
+
+func TestRedisStreamTrimming(t *testing.T) {
+	_, redisOptions := newTestRedisOptions(t, 2)
+	r := newTestRedisREST(t, redisOptions, nil)
+	a := mustCreate(t, r, "ns", "a", "v1")
+	for _, value := range []string{"v2", "v3", "v4"} {
+		a = mustUpdate(t, r, a, value)
+	}
+	// The changes trimmed from the stream can't be watched any more, while the ones kept can.
+	if _, err := r.Watch(nsContext("ns"), &metainternalversion.ListOptions{ResourceVersion: "1"}); !apierrors.IsResourceExpired(err) {
+		t.Fatalf("watch from a trimmed revision returned %v, want 410", err)
+	}
+	w := mustWatch(t, r, "ns", formatResourceVersion(revisionOf(t, a)-2))
+	expectEvents(t, w, "MODIFIED a=v3", "MODIFIED a=v4")
+}
+
+func TestRedisEncryption(t *testing.T) {
+	mr, redisOptions := newTestRedisOptions(t, 1000)
+	r := newTestRedisREST(t, redisOptions, newTestPolicy(t, "key-1"))
+	a := mustCreate(t, r, "ns", "a", "secret-1")
+	w := mustWatch(t, r, "ns", a.ResourceVersion)
+	mustUpdate(t, r, a, "secret-2")
+	mustDelete(t, r, "ns", "a")
+	mustCreate(t, r, "ns", "b", "secret-3")
+	expectEvents(t, w, "MODIFIED a=secret-2", "DELETED a=secret-2", "ADDED b=secret-3")
+	if got := mustList(t, r, "ns", nil); listedNames(got) != "b" || got.Items[0].Data["key"] != "secret-3" {
+		t.Fatalf("listed %v", got.Items)
+	}
+	expectNoRedisPlaintext(t, mr, testGroupResource.String(), "secret")
+}
+
+// expectNoRedisPlaintext checks the given text isn't found in the objects or the event stream stored in redis.
+func expectNoRedisPlaintext(t *testing.T, mr *miniredis.Miniredis, resource, text string) {
+	t.Helper()
+	objectsKey := "higress:objects:" + resource
+	fields, err := mr.HKeys(objectsKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, field := range fields {
+		if value := mr.HGet(objectsKey, field); strings.Contains(value, text) {
+			t.Errorf("%s/%s is stored in plaintext: %s", objectsKey, field, value)
+		}
+	}
+	entries, err := mr.Stream("higress:events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		for _, value := range entry.Values {
+			if strings.Contains(value, text) {
+				t.Errorf("event %s is stored in plaintext: %s", entry.ID, value)
+			}
+		}
+	}
+}
+
+func TestRedisDryRun(t *testing.T) {
+	_, redisOptions := newTestRedisOptions(t, 1000)
+	testDryRun(t, newTestRedisREST(t, redisOptions, nil))
+}
+
+func TestRedisListChunks(t *testing.T) {
+	_, redisOptions := newTestRedisOptions(t, 1000)
+	testListChunks(t, newTestRedisREST(t, redisOptions, nil))
+}
+
+func TestRedisServerSideApply(t *testing.T) {
+	_, redisOptions := newTestRedisOptions(t, 1000)
+	testServerSideApply(t, newTestRedisREST(t, redisOptions, nil))
+}
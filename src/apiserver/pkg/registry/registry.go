@@ -17,6 +17,7 @@ limitations under the License.
 package registry
 
 import (
+	"context"
 	"fmt"
 	"k8s.io/apiserver/pkg/registry/rest"
 )
@@ -30,6 +31,12 @@ type REST interface {
 	rest.TableConvertor
 }
 
+// Reencrypter is implemented by storages encrypting sensitive data at rest.
+type Reencrypter interface {
+	// Reencrypt rewrites the stored objects not encrypted with the newest key, and returns the number of them.
+	Reencrypt(ctx context.Context) (int, error)
+}
+
 // RESTInPeace is just a simple function that panics on error.
 // Otherwise, returns the given storage object. It is meant to be
 // a wrapper for Higress registries.
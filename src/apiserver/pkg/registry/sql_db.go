@@ -0,0 +1,444 @@
+package registry
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/klog/v2"
+
+	"github.com/alibaba/higress/api-server/pkg/options"
+	"github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+const (
+	sqlPollBatchSize        = 500
+	sqlCompactInterval      = time.Minute
+	sqlCompactedRevisionKey = "compacted_revision"
+	// sqlRevisionKey is the name of the counter the revisions are allocated from.
+	sqlRevisionKey = "revision"
+)
+
+// sqlDialect holds the statements differing among database drivers.
+type sqlDialect struct {
+	// driverName is the name the driver is registered with in database/sql.
+	driverName string
+	schema     []string
+	// initMeta inserts a meta value if it doesn't exist yet.
+	initMeta string
+	// numberedPlaceholders tells whether placeholders are written as $1, $2... instead of ?.
+	numberedPlaceholders bool
+}
+
+var sqlDialects = map[string]*sqlDialect{
+	options.SqlDriver_Sqlite: {
+		// The pure Go driver, so the API server is still built without cgo.
+		driverName: "sqlite",
+		schema: []string{
+			`CREATE TABLE IF NOT EXISTS higress_events (
+				revision INTEGER PRIMARY KEY,
+				resource TEXT NOT NULL,
+				namespace TEXT NOT NULL,
+				name TEXT NOT NULL,
+				type TEXT NOT NULL,
+				value BLOB,
+				prev_value BLOB
+			)`,
+			`CREATE TABLE IF NOT EXISTS higress_objects (
+				resource TEXT NOT NULL,
+				namespace TEXT NOT NULL,
+				name TEXT NOT NULL,
+				revision INTEGER NOT NULL,
+				value BLOB NOT NULL,
+				PRIMARY KEY (resource, namespace, name)
+			)`,
+			`CREATE TABLE IF NOT EXISTS higress_meta (
+				name TEXT PRIMARY KEY,
+				value INTEGER NOT NULL
+			)`,
+		},
+		initMeta: `INSERT OR IGNORE INTO higress_meta (name, value) VALUES (?, ?)`,
+	},
+	options.SqlDriver_Mysql: {
+		driverName: "mysql",
+		schema: []string{
+			`CREATE TABLE IF NOT EXISTS higress_events (
+				revision BIGINT UNSIGNED NOT NULL PRIMARY KEY,
+				resource VARCHAR(255) NOT NULL,
+				namespace VARCHAR(255) NOT NULL,
+				name VARCHAR(255) NOT NULL,
+				type VARCHAR(16) NOT NULL,
+				value MEDIUMBLOB,
+				prev_value MEDIUMBLOB
+			)`,
+			`CREATE TABLE IF NOT EXISTS higress_objects (
+				resource VARCHAR(255) NOT NULL,
+				namespace VARCHAR(255) NOT NULL,
+				name VARCHAR(255) NOT NULL,
+				revision BIGINT UNSIGNED NOT NULL,
+				value MEDIUMBLOB NOT NULL,
+				PRIMARY KEY (resource, namespace, name)
+			)`,
+			`CREATE TABLE IF NOT EXISTS higress_meta (
+				name VARCHAR(64) NOT NULL PRIMARY KEY,
+				value BIGINT UNSIGNED NOT NULL
+			)`,
+		},
+		initMeta: `INSERT IGNORE INTO higress_meta (name, value) VALUES (?, ?)`,
+	},
+	options.SqlDriver_Postgres: {
+		driverName: "postgres",
+		schema: []string{
+			`CREATE TABLE IF NOT EXISTS higress_events (
+				revision BIGINT PRIMARY KEY,
+				resource VARCHAR(255) NOT NULL,
+				namespace VARCHAR(255) NOT NULL,
+				name VARCHAR(255) NOT NULL,
+				type VARCHAR(16) NOT NULL,
+				value BYTEA,
+				prev_value BYTEA
+			)`,
+			`CREATE TABLE IF NOT EXISTS higress_objects (
+				resource VARCHAR(255) NOT NULL,
+				namespace VARCHAR(255) NOT NULL,
+				name VARCHAR(255) NOT NULL,
+				revision BIGINT NOT NULL,
+				value BYTEA NOT NULL,
+				PRIMARY KEY (resource, namespace, name)
+			)`,
+			`CREATE TABLE IF NOT EXISTS higress_meta (
+				name VARCHAR(64) NOT NULL PRIMARY KEY,
+				value BIGINT NOT NULL
+			)`,
+		},
+		initMeta:             `INSERT INTO higress_meta (name, value) VALUES (?, ?) ON CONFLICT DO NOTHING`,
+		numberedPlaceholders: true,
+	},
+}
+
+var (
+	sqlDatabases      = map[string]*sqlDatabase{}
+	sqlDatabasesMutex sync.Mutex
+)
+
+// sqlDatabase is a database shared by all the resources stored in the sql backend, possibly by several
+// API server instances as well. Every change is recorded as a row of the event table keyed by its revision, and
+// the current state of each object is kept in the object table. Revisions are allocated from a counter in the meta
+// table, which stays locked until the transaction ends, so they are committed in order and never skipped.
+// A poller reads new events in revision order and dispatches them to the watchers of each resource.
+type sqlDatabase struct {
+	db            *sql.DB
+	dialect       *sqlDialect
+	changeLogSize int
+
+	// dispatchMutex is held while dispatching events, so watchers can be registered between two batches.
+	dispatchMutex      sync.Mutex
+	dispatchedRevision uint64
+	handlers           map[string]func(revision uint64, event *sqlEvent)
+	pollNotifyCh       chan struct{}
+}
+
+// sqlEvent is a row in the event table.
+type sqlEvent struct {
+	revision  uint64
+	resource  string
+	namespace string
+	name      string
+	eventType watch.EventType
+	value     []byte
+	prevValue []byte
+}
+
+func getSqlDatabase(sqlOptions *options.SqlOptions) (*sqlDatabase, error) {
+	sqlDatabasesMutex.Lock()
+	defer sqlDatabasesMutex.Unlock()
+
+	key := sqlOptions.Driver + "|" + sqlOptions.DataSource
+	if d, ok := sqlDatabases[key]; ok {
+		return d, nil
+	}
+	dialect, ok := sqlDialects[sqlOptions.Driver]
+	if !ok {
+		return nil, fmt.Errorf("unsupported sql driver: %s", sqlOptions.Driver)
+	}
+	db, err := sql.Open(dialect.driverName, sqlOptions.DataSource)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s database: %v", sqlOptions.Driver, err)
+	}
+	if sqlOptions.Driver == options.SqlDriver_Sqlite {
+		// SQLite allows a single writer only.
+		db.SetMaxOpenConns(1)
+	} else {
+		db.SetMaxOpenConns(sqlOptions.MaxOpenConns)
+	}
+	d := &sqlDatabase{
+		db:            db,
+		dialect:       dialect,
+		changeLogSize: sqlOptions.ChangeLogSize,
+		handlers:      make(map[string]func(uint64, *sqlEvent)),
+		pollNotifyCh:  make(chan struct{}, 1),
+	}
+	if err := d.initSchema(); err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("failed to initialize %s database: %v", sqlOptions.Driver, err)
+	}
+	if d.dispatchedRevision, err = d.currentRevision(context.Background(), db); err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("failed to read revision from %s database: %v", sqlOptions.Driver, err)
+	}
+	d.startPoller(sqlOptions.PollInterval)
+	d.startCompactor()
+	sqlDatabases[key] = d
+	return d, nil
+}
+
+func (d *sqlDatabase) initSchema() error {
+	for _, stmt := range d.dialect.schema {
+		if _, err := d.db.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	for _, name := range []string{sqlCompactedRevisionKey, sqlRevisionKey} {
+		if _, err := d.db.Exec(d.rebind(d.dialect.initMeta), name, 0); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rebind rewrites ? placeholders into the form used by the driver.
+func (d *sqlDatabase) rebind(query string) string {
+	if !d.dialect.numberedPlaceholders {
+		return query
+	}
+	var sb strings.Builder
+	n := 0
+	for _, c := range query {
+		if c == '?' {
+			n++
+			sb.WriteString("$" + strconv.Itoa(n))
+		} else {
+			sb.WriteRune(c)
+		}
+	}
+	return sb.String()
+}
+
+// sqlQuerier is implemented by both *sql.DB and *sql.Tx.
+type sqlQuerier interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+func (d *sqlDatabase) currentRevision(ctx context.Context, q sqlQuerier) (uint64, error) {
+	var revision sql.NullInt64
+	if err := q.QueryRowContext(ctx, "SELECT MAX(revision) FROM higress_events").Scan(&revision); err != nil {
+		return 0, err
+	}
+	if revision.Valid {
+		return uint64(revision.Int64), nil
+	}
+	// All the events have been compacted, or nothing has ever been written.
+	return d.compactedRevision(ctx, q)
+}
+
+func (d *sqlDatabase) compactedRevision(ctx context.Context, q sqlQuerier) (uint64, error) {
+	var revision int64
+	if err := q.QueryRowContext(ctx, d.rebind("SELECT value FROM higress_meta WHERE name = ?"), sqlCompactedRevisionKey).Scan(&revision); err != nil {
+		return 0, err
+	}
+	return uint64(revision), nil
+}
+
+// nextRevision allocates the revision of a change made in the transaction. The counter is locked until
+// the transaction ends, and is rolled back along with it.
+func (d *sqlDatabase) nextRevision(ctx context.Context, tx *sql.Tx) (uint64, error) {
+	if _, err := tx.ExecContext(ctx, d.rebind("UPDATE higress_meta SET value = value + 1 WHERE name = ?"), sqlRevisionKey); err != nil {
+		return 0, err
+	}
+	var revision int64
+	if err := tx.QueryRowContext(ctx, d.rebind("SELECT value FROM higress_meta WHERE name = ?"), sqlRevisionKey).Scan(&revision); err != nil {
+		return 0, err
+	}
+	return uint64(revision), nil
+}
+
+// insertEvent records a change with the revision allocated to it by nextRevision.
+func (d *sqlDatabase) insertEvent(ctx context.Context, tx *sql.Tx, revision uint64, event *sqlEvent) error {
+	_, err := tx.ExecContext(ctx, d.rebind("INSERT INTO higress_events (revision, resource, namespace, name, type, value, prev_value) VALUES (?, ?, ?, ?, ?, ?, ?)"),
+		revision, event.resource, event.namespace, event.name, string(event.eventType), event.value, event.prevValue)
+	return err
+}
+
+// isUniqueViolation tells whether an error is returned by the driver for a row violating a unique constraint.
+func isUniqueViolation(err error) bool {
+	var sqliteErr *sqlite.Error
+	if errors.As(err, &sqliteErr) {
+		return sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_PRIMARYKEY || sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_UNIQUE
+	}
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		// ER_DUP_ENTRY
+		return mysqlErr.Number == 1062
+	}
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return pqErr.Code == "23505"
+	}
+	return false
+}
+
+// eventsSince returns the events of a resource happened after the given revision and not after the upper bound.
+// A "410 Gone" error is returned if some of them have already been compacted.
+func (d *sqlDatabase) eventsSince(ctx context.Context, resource string, revision, upperBound uint64) ([]*sqlEvent, error) {
+	compactedRevision, err := d.compactedRevision(ctx, d.db)
+	if err != nil {
+		return nil, err
+	}
+	if revision < compactedRevision {
+		return nil, apierrors.NewResourceExpired(
+			fmt.Sprintf("too old resource version: %d (%d)", revision, compactedRevision))
+	}
+	rows, err := d.db.QueryContext(ctx, d.rebind(
+		"SELECT revision, resource, namespace, name, type, value, prev_value FROM higress_events "+
+			"WHERE resource = ? AND revision > ? AND revision <= ? ORDER BY revision"), resource, revision, upperBound)
+	if err != nil {
+		return nil, err
+	}
+	return scanSqlEvents(rows)
+}
+
+func scanSqlEvents(rows *sql.Rows) ([]*sqlEvent, error) {
+	defer func() {
+		_ = rows.Close()
+	}()
+	var events []*sqlEvent
+	for rows.Next() {
+		event := &sqlEvent{}
+		var revision int64
+		var eventType string
+		if err := rows.Scan(&revision, &event.resource, &event.namespace, &event.name, &eventType, &event.value, &event.prevValue); err != nil {
+			return nil, err
+		}
+		event.revision = uint64(revision)
+		event.eventType = watch.EventType(eventType)
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
+
+// registerHandler sets the function receiving the events of a resource in revision order.
+func (d *sqlDatabase) registerHandler(resource string, handler func(uint64, *sqlEvent)) {
+	d.dispatchMutex.Lock()
+	defer d.dispatchMutex.Unlock()
+	d.handlers[resource] = handler
+}
+
+// notifyPoller asks the poller to look for new events right away, e.g. after a local write.
+func (d *sqlDatabase) notifyPoller() {
+	select {
+	case d.pollNotifyCh <- struct{}{}:
+	default:
+	}
+}
+
+func (d *sqlDatabase) startPoller(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	go func(d *sqlDatabase) {
+		for {
+			select {
+			case <-ticker.C:
+			case <-d.pollNotifyCh:
+			}
+			if err := d.poll(); err != nil {
+				klog.Errorf("failed to poll sql events: %v", err)
+			}
+		}
+	}(d)
+}
+
+func (d *sqlDatabase) poll() error {
+	d.dispatchMutex.Lock()
+	defer d.dispatchMutex.Unlock()
+
+	for {
+		rows, err := d.db.Query(d.rebind(
+			"SELECT revision, resource, namespace, name, type, value, prev_value FROM higress_events "+
+				"WHERE revision > ? ORDER BY revision LIMIT ?"), d.dispatchedRevision, sqlPollBatchSize)
+		if err != nil {
+			return err
+		}
+		events, err := scanSqlEvents(rows)
+		if err != nil {
+			return err
+		}
+		for _, event := range events {
+			if event.revision != d.dispatchedRevision+1 {
+				// Revisions are committed in order without gaps, so the missing ones have been compacted before
+				// they are polled.
+				klog.Warningf("skipping missing sql revisions %d-%d", d.dispatchedRevision+1, event.revision-1)
+			}
+			if handler, ok := d.handlers[event.resource]; ok {
+				handler(event.revision, event)
+			}
+			d.dispatchedRevision = event.revision
+		}
+		if len(events) < sqlPollBatchSize {
+			return nil
+		}
+	}
+}
+
+func (d *sqlDatabase) startCompactor() {
+	ticker := time.NewTicker(sqlCompactInterval)
+	go func(d *sqlDatabase) {
+		for {
+			<-ticker.C
+			if err := d.compact(); err != nil {
+				klog.Errorf("failed to compact sql events: %v", err)
+			}
+		}
+	}(d)
+}
+
+// compact drops the oldest events beyond the size limit of the change log.
+func (d *sqlDatabase) compact() error {
+	ctx := context.Background()
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+	revision, err := d.currentRevision(ctx, tx)
+	if err != nil {
+		return err
+	}
+	if revision <= uint64(d.changeLogSize) {
+		return nil
+	}
+	threshold := revision - uint64(d.changeLogSize)
+	compactedRevision, err := d.compactedRevision(ctx, tx)
+	if err != nil || threshold <= compactedRevision {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, d.rebind("DELETE FROM higress_events WHERE revision <= ?"), threshold); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, d.rebind("UPDATE higress_meta SET value = ? WHERE name = ?"), threshold, sqlCompactedRevisionKey); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
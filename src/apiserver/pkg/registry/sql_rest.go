@@ -0,0 +1,766 @@
+package registry
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metainternalversion "k8s.io/apimachinery/pkg/apis/meta/internalversion"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/uuid"
+	"k8s.io/apimachinery/pkg/watch"
+	genericapirequest "k8s.io/apiserver/pkg/endpoints/request"
+	"k8s.io/apiserver/pkg/registry/rest"
+	"k8s.io/apiserver/pkg/storage"
+	storeerr "k8s.io/apiserver/pkg/storage/errors"
+	"k8s.io/apiserver/pkg/util/dryrun"
+	"k8s.io/klog/v2"
+
+	"github.com/alibaba/higress/api-server/pkg/encryption"
+	"github.com/alibaba/higress/api-server/pkg/options"
+)
+
+var _ rest.StandardStorage = &sqlREST{}
+var _ Reencrypter = &sqlREST{}
+var _ rest.Scoper = &sqlREST{}
+var _ rest.Storage = &sqlREST{}
+
+// NewSqlREST instantiates a new REST storage backed by a SQL database.
+func NewSqlREST(
+	groupResource schema.GroupResource,
+	codec runtime.Codec,
+	sqlOptions *options.SqlOptions,
+	isNamespaced bool,
+	singularName string,
+	newFunc func() runtime.Object,
+	newListFunc func() runtime.Object,
+	attrFunc storage.AttrFunc,
+	policy *encryption.Policy,
+) (REST, error) {
+	if attrFunc == nil {
+		if isNamespaced {
+			attrFunc = storage.DefaultNamespaceScopedAttr
+		} else {
+			attrFunc = storage.DefaultClusterScopedAttr
+		}
+	}
+	db, err := getSqlDatabase(sqlOptions)
+	if err != nil {
+		return nil, err
+	}
+	s := &sqlREST{
+		TableConvertor: rest.NewDefaultTableConvertor(groupResource),
+		groupResource:  groupResource,
+		codec:          codec,
+		db:             db,
+		resource:       groupResource.String(),
+		isNamespaced:   isNamespaced,
+		singularName:   singularName,
+		newFunc:        newFunc,
+		newListFunc:    newListFunc,
+		attrFunc:       attrFunc,
+		policy:         policy,
+		watchers:       newWatchBroadcaster(),
+	}
+	db.registerHandler(s.resource, s.handleEvent)
+	s.startBookmarkTicker()
+	return s, nil
+}
+
+type sqlREST struct {
+	rest.TableConvertor
+	groupResource schema.GroupResource
+	codec         runtime.Codec
+	db            *sqlDatabase
+	// resource is the value of the resource column of the rows of this resource.
+	resource     string
+	isNamespaced bool
+	singularName string
+
+	watchers *watchBroadcaster
+
+	newFunc     func() runtime.Object
+	newListFunc func() runtime.Object
+	attrFunc    storage.AttrFunc
+	// policy encrypts the objects selected, along with the change log entries holding them, and is nil if
+	// encryption isn't enabled.
+	policy *encryption.Policy
+}
+
+func (s *sqlREST) GetSingularName() string {
+	return s.singularName
+}
+
+func (s *sqlREST) Destroy() {
+	// The database is shared by all the resources, so it's left open.
+}
+
+func (s *sqlREST) New() runtime.Object {
+	return s.newFunc()
+}
+
+func (s *sqlREST) NewList() runtime.Object {
+	return s.newListFunc()
+}
+
+func (s *sqlREST) NamespaceScoped() bool {
+	return s.isNamespaced
+}
+
+func (s *sqlREST) startBookmarkTicker() {
+	if options.WatchBookmarkIntervalSecs <= 0 {
+		return
+	}
+	ticker := time.NewTicker(time.Duration(options.WatchBookmarkIntervalSecs) * time.Second)
+	go func(s *sqlREST) {
+		for {
+			<-ticker.C
+			s.sendBookmarks()
+		}
+	}(s)
+}
+
+func (s *sqlREST) sendBookmarks() {
+	s.db.dispatchMutex.Lock()
+	defer s.db.dispatchMutex.Unlock()
+	s.watchers.broadcastBookmark(s.newFunc, s.db.dispatchedRevision)
+}
+
+// handleEvent is called by the poller of the database for each event of this resource, in revision order.
+func (s *sqlREST) handleEvent(revision uint64, event *sqlEvent) {
+	ev, err := s.decodeEvent(event)
+	if err != nil {
+		klog.Errorf("[%s] failed to decode event at revision %d: %v", s.groupResource, revision, err)
+		return
+	}
+	klog.Infof("event %s %s %s/%s rv=%d count(watcher)=%d", ev.Type, ev.Object.GetObjectKind(), event.namespace, event.name, revision, s.watchers.count())
+	s.watchers.broadcast(ev)
+}
+
+func (s *sqlREST) Get(
+	ctx context.Context,
+	name string,
+	options *metav1.GetOptions,
+) (runtime.Object, error) {
+	ns := s.objectNamespace(ctx)
+	obj, _, err := s.getInTx(ctx, s.db.db, ns, name)
+	if err != nil {
+		return nil, toAPIError(err)
+	}
+	if obj == nil {
+		return nil, apierrors.NewNotFound(s.groupResource, name)
+	}
+	klog.Infof("[%s] %s/%s got", s.groupResource, ns, name)
+	return obj, nil
+}
+
+func (s *sqlREST) List(
+	ctx context.Context,
+	options *metainternalversion.ListOptions,
+) (runtime.Object, error) {
+	token, err := decodeContinue(options)
+	if err != nil {
+		return nil, err
+	}
+	tx, err := s.db.db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return nil, toAPIError(err)
+	}
+	list, err := s.listInTx(ctx, tx, options)
+	// Released before reading the events, as there is a single connection to sqlite.
+	_ = tx.Rollback()
+	if err != nil {
+		return nil, toAPIError(err)
+	}
+	if token != nil {
+		if err := s.checkContinue(ctx, token, list); err != nil {
+			return nil, toAPIError(err)
+		}
+	}
+	return paginate(list, options, token)
+}
+
+// checkContinue verifies that the chunks following the continue token are still consistent with the first one,
+// given the list of all the objects read for them.
+func (s *sqlREST) checkContinue(ctx context.Context, token *continueToken, list runtime.Object) error {
+	listAccessor, err := meta.ListAccessor(list)
+	if err != nil {
+		return err
+	}
+	revision, err := parseResourceVersion(listAccessor.GetResourceVersion())
+	if err != nil {
+		return err
+	}
+	sqlEvents, err := s.db.eventsSince(ctx, s.resource, token.Revision, revision)
+	if err != nil {
+		return err
+	}
+	events := make([]watchEvent, 0, len(sqlEvents))
+	for _, event := range sqlEvents {
+		ev, err := s.decodeEvent(event)
+		if err != nil {
+			return err
+		}
+		events = append(events, ev)
+	}
+	return token.checkEvents(s.objectNamespace(ctx), events)
+}
+
+func (s *sqlREST) listInTx(
+	ctx context.Context,
+	tx *sql.Tx,
+	options *metainternalversion.ListOptions,
+) (runtime.Object, error) {
+	revision, err := s.db.currentRevision(ctx, tx)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkListResourceVersion(options, revision, s.groupResource); err != nil {
+		return nil, err
+	}
+
+	predicate := s.buildListPredicate(options)
+
+	newListObj := s.NewList()
+	v, err := getListPrt(newListObj)
+	if err != nil {
+		return nil, err
+	}
+
+	count := 0
+	if err := s.visitObjects(ctx, tx, s.objectNamespace(ctx), func(obj runtime.Object) {
+		if ok, err := predicate.Matches(obj); err == nil && ok {
+			count++
+			appendItem(v, obj)
+		}
+	}); err != nil {
+		return nil, err
+	}
+	setListResourceVersion(newListObj, revision)
+
+	klog.Infof("[%s] list count=%d rv=%d", s.groupResource, count, revision)
+	return newListObj, nil
+}
+
+func (s *sqlREST) Create(
+	ctx context.Context,
+	obj runtime.Object,
+	createValidation rest.ValidateObjectFunc,
+	options *metav1.CreateOptions,
+) (runtime.Object, error) {
+	if createValidation != nil {
+		if err := createValidation(ctx, obj); err != nil {
+			return nil, toAPIError(err)
+		}
+	}
+
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return nil, apierrors.NewInternalError(err)
+	}
+	ns := s.objectNamespace(ctx)
+	name := accessor.GetName()
+
+	accessor.SetNamespace(ns)
+	accessor.SetUID(uuid.NewUUID())
+	accessor.SetCreationTimestamp(metav1.NewTime(time.Now()))
+	var revision uint64
+	err = s.inTx(ctx, func(tx *sql.Tx) error {
+		if current, _, err := s.getInTx(ctx, tx, ns, name); err != nil {
+			return err
+		} else if current != nil {
+			return apierrors.NewAlreadyExists(s.groupResource, name)
+		}
+		if dryrun.IsDryRun(options.DryRun) {
+			return errDryRun
+		}
+		value, err := s.encode(obj)
+		if err != nil {
+			return err
+		}
+		if revision, err = s.db.nextRevision(ctx, tx); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, s.db.rebind("INSERT INTO higress_objects (resource, namespace, name, revision, value) VALUES (?, ?, ?, ?, ?)"),
+			s.resource, ns, name, revision, value); err != nil {
+			if isUniqueViolation(err) {
+				// The object has been created concurrently after the check above.
+				return apierrors.NewAlreadyExists(s.groupResource, name)
+			}
+			return err
+		}
+		return s.db.insertEvent(ctx, tx, revision, &sqlEvent{resource: s.resource, namespace: ns, name: name, eventType: watch.Added, value: value})
+	})
+	if errors.Is(err, errDryRun) {
+		return obj, nil
+	}
+	if err != nil {
+		return nil, toAPIError(err)
+	}
+	accessor.SetResourceVersion(formatResourceVersion(revision))
+	s.db.notifyPoller()
+	return obj, nil
+}
+
+func (s *sqlREST) Update(
+	ctx context.Context,
+	name string,
+	objInfo rest.UpdatedObjectInfo,
+	createValidation rest.ValidateObjectFunc,
+	updateValidation rest.ValidateObjectUpdateFunc,
+	forceAllowCreate bool,
+	options *metav1.UpdateOptions,
+) (runtime.Object, bool, error) {
+	ns := s.objectNamespace(ctx)
+
+	oldObj, oldValue, err := s.getInTx(ctx, s.db.db, ns, name)
+	if err != nil {
+		return nil, false, toAPIError(err)
+	}
+	if oldObj == nil {
+		if !forceAllowCreate {
+			return nil, false, apierrors.NewNotFound(s.groupResource, name)
+		}
+		updatedObj, err := objInfo.UpdatedObject(ctx, nil)
+		if err != nil {
+			return nil, false, toAPIError(err)
+		}
+		obj, err := s.Create(ctx, updatedObj, createValidation, updateToCreateOptions(options))
+		return obj, err == nil, err
+	}
+
+	updatedObj, err := objInfo.UpdatedObject(ctx, oldObj)
+	if err != nil {
+		return nil, false, toAPIError(err)
+	}
+	if updateValidation != nil {
+		if err := updateValidation(ctx, updatedObj, oldObj); err != nil {
+			return nil, false, toAPIError(err)
+		}
+	}
+
+	oldAccessor, err := meta.Accessor(oldObj)
+	if err != nil {
+		return nil, false, apierrors.NewInternalError(err)
+	}
+	updatedAccessor, err := meta.Accessor(updatedObj)
+	if err != nil {
+		return nil, false, apierrors.NewInternalError(err)
+	}
+	if updatedAccessor.GetResourceVersion() != "" && updatedAccessor.GetResourceVersion() != oldAccessor.GetResourceVersion() {
+		return nil, false, apierrors.NewConflict(s.groupResource, name, errors.New(optimisticLockErrorMsg))
+	}
+	oldRevision, err := parseResourceVersion(oldAccessor.GetResourceVersion())
+	if err != nil {
+		return nil, false, apierrors.NewInternalError(err)
+	}
+
+	updatedAccessor.SetNamespace(ns)
+	updatedAccessor.SetUID(oldAccessor.GetUID())
+	if dryrun.IsDryRun(options.DryRun) {
+		return updatedObj, false, nil
+	}
+	var revision uint64
+	err = s.inTx(ctx, func(tx *sql.Tx) error {
+		value, err := s.encode(updatedObj)
+		if err != nil {
+			return err
+		}
+		if revision, err = s.db.nextRevision(ctx, tx); err != nil {
+			return err
+		}
+		// Compare and swap on the revision the update is based on.
+		result, err := tx.ExecContext(ctx, s.db.rebind("UPDATE higress_objects SET revision = ?, value = ? WHERE resource = ? AND namespace = ? AND name = ? AND revision = ?"),
+			revision, value, s.resource, ns, name, oldRevision)
+		if err != nil {
+			return err
+		}
+		if affected, err := result.RowsAffected(); err != nil {
+			return err
+		} else if affected == 0 {
+			return apierrors.NewConflict(s.groupResource, name, errors.New(optimisticLockErrorMsg))
+		}
+		return s.db.insertEvent(ctx, tx, revision, &sqlEvent{resource: s.resource, namespace: ns, name: name, eventType: watch.Modified, value: value, prevValue: oldValue})
+	})
+	if err != nil {
+		return nil, false, toAPIError(err)
+	}
+	updatedAccessor.SetResourceVersion(formatResourceVersion(revision))
+	s.db.notifyPoller()
+	return updatedObj, false, nil
+}
+
+func (s *sqlREST) Delete(
+	ctx context.Context,
+	name string,
+	deleteValidation rest.ValidateObjectFunc,
+	options *metav1.DeleteOptions) (runtime.Object, bool, error) {
+	ns := s.objectNamespace(ctx)
+
+	oldObj, oldValue, err := s.getInTx(ctx, s.db.db, ns, name)
+	if err != nil {
+		return nil, false, toAPIError(err)
+	}
+	if oldObj == nil {
+		return nil, false, apierrors.NewNotFound(s.groupResource, name)
+	}
+	if deleteValidation != nil {
+		if err := deleteValidation(ctx, oldObj); err != nil {
+			return nil, false, toAPIError(err)
+		}
+	}
+	if dryrun.IsDryRun(options.DryRun) {
+		return oldObj, true, nil
+	}
+	if err := s.inTx(ctx, func(tx *sql.Tx) error {
+		return s.deleteInTx(ctx, tx, oldObj, oldValue)
+	}); err != nil {
+		return nil, false, toAPIError(err)
+	}
+	s.db.notifyPoller()
+	return oldObj, true, nil
+}
+
+func (s *sqlREST) DeleteCollection(
+	ctx context.Context,
+	deleteValidation rest.ValidateObjectFunc,
+	options *metav1.DeleteOptions,
+	listOptions *metainternalversion.ListOptions,
+) (runtime.Object, error) {
+	predicate := s.buildListPredicate(listOptions)
+
+	newListObj := s.NewList()
+	v, err := getListPrt(newListObj)
+	if err != nil {
+		return nil, apierrors.NewInternalError(err)
+	}
+
+	err = s.inTx(ctx, func(tx *sql.Tx) error {
+		var objs []runtime.Object
+		if err := s.visitObjects(ctx, tx, s.objectNamespace(ctx), func(obj runtime.Object) {
+			if ok, err := predicate.Matches(obj); err == nil && ok {
+				objs = append(objs, obj)
+			}
+		}); err != nil {
+			return err
+		}
+		for _, obj := range objs {
+			if deleteValidation != nil {
+				if err := deleteValidation(ctx, obj); err != nil {
+					return err
+				}
+			}
+			appendItem(v, obj)
+			if dryrun.IsDryRun(options.DryRun) {
+				continue
+			}
+			value, err := s.encode(obj)
+			if err != nil {
+				return err
+			}
+			if err := s.deleteInTx(ctx, tx, obj, value); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, toAPIError(err)
+	}
+	s.db.notifyPoller()
+	return newListObj, nil
+}
+
+func (s *sqlREST) Watch(ctx context.Context, options *metainternalversion.ListOptions) (watch.Interface, error) {
+	allowBookmarks := options != nil && options.AllowWatchBookmarks
+	ns := ""
+	if s.isNamespaced {
+		ns, _ = genericapirequest.NamespaceFrom(ctx)
+	}
+	filter := newSelectionFilter(ns, s.buildListPredicate(options))
+
+	// Hold the lock until the watcher is registered, so no event is missed.
+	s.db.dispatchMutex.Lock()
+	defer s.db.dispatchMutex.Unlock()
+
+	dispatchedRevision := s.db.dispatchedRevision
+	var initialEvents []watch.Event
+	if shouldSendInitialEvents(options) {
+		// On initial watch, send all the existing objects
+		list, err := s.List(ctx, options)
+		if err != nil {
+			return nil, err
+		}
+		items, err := meta.ExtractList(list)
+		if err != nil {
+			return nil, apierrors.NewInternalError(err)
+		}
+		for _, item := range items {
+			initialEvents = append(initialEvents, watch.Event{
+				Type:   watch.Added,
+				Object: item,
+			})
+		}
+		listAccessor, err := meta.ListAccessor(list)
+		if err != nil {
+			return nil, apierrors.NewInternalError(err)
+		}
+		revision, _ := parseResourceVersion(listAccessor.GetResourceVersion())
+		if allowBookmarks && options.SendInitialEvents != nil {
+			initialEvents = append(initialEvents, newBookmarkEvent(s.newFunc, revision, true))
+		}
+		if revision > dispatchedRevision {
+			// Events up to the list revision are yet to be dispatched, but are already reflected in the list.
+			filter = skipEventsUntil(revision, filter)
+		}
+	} else if options.ResourceVersion != "" && options.ResourceVersion != "0" {
+		// Resume from the given resource version
+		revision, err := parseResourceVersion(options.ResourceVersion)
+		if err != nil {
+			return nil, err
+		}
+		current, err := s.db.currentRevision(ctx, s.db.db)
+		if err != nil {
+			return nil, toAPIError(err)
+		}
+		if revision > current {
+			return nil, storeerr.InterpretListError(storage.NewTooLargeResourceVersionError(revision, current, 1), s.groupResource)
+		}
+		events, err := s.db.eventsSince(ctx, s.resource, revision, dispatchedRevision)
+		if err != nil {
+			return nil, toAPIError(err)
+		}
+		for _, event := range events {
+			ev, err := s.decodeEvent(event)
+			if err != nil {
+				return nil, apierrors.NewInternalError(err)
+			}
+			if filtered, ok := filter(ev); ok {
+				initialEvents = append(initialEvents, filtered)
+			}
+		}
+		if revision > dispatchedRevision {
+			// Events up to the given revision are yet to be dispatched, but have been observed by the client.
+			filter = skipEventsUntil(revision, filter)
+		}
+	}
+
+	return s.watchers.watch(ctx, initialEvents, filter, allowBookmarks), nil
+}
+
+func (s *sqlREST) buildListPredicate(options *metainternalversion.ListOptions) storage.SelectionPredicate {
+	label := labels.Everything()
+	field := fields.Everything()
+	if options != nil {
+		if options.LabelSelector != nil {
+			label = options.LabelSelector
+		}
+		if options.FieldSelector != nil {
+			field = options.FieldSelector
+		}
+	}
+	return storage.SelectionPredicate{
+		Label:    label,
+		Field:    field,
+		GetAttrs: s.attrFunc,
+	}
+}
+
+// objectNamespace returns the namespace of the objects a request works on, which is always empty for cluster-scoped
+// resources. An empty namespace in a list request stands for all the namespaces.
+func (s *sqlREST) objectNamespace(ctx context.Context) string {
+	if !s.isNamespaced {
+		return ""
+	}
+	ns, _ := genericapirequest.NamespaceFrom(ctx)
+	return ns
+}
+
+func (s *sqlREST) inTx(ctx context.Context, f func(tx *sql.Tx) error) error {
+	tx, err := s.db.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := f(tx); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// getInTx returns an object along with its stored value, or nil if it doesn't exist.
+func (s *sqlREST) getInTx(ctx context.Context, q sqlQuerier, ns, name string) (runtime.Object, []byte, error) {
+	var revision int64
+	var value []byte
+	err := q.QueryRowContext(ctx, s.db.rebind("SELECT revision, value FROM higress_objects WHERE resource = ? AND namespace = ? AND name = ?"),
+		s.resource, ns, name).Scan(&revision, &value)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	obj, err := s.decode(value, uint64(revision))
+	if err != nil {
+		return nil, nil, err
+	}
+	return obj, value, nil
+}
+
+// deleteInTx removes an object and records its removal.
+func (s *sqlREST) deleteInTx(ctx context.Context, tx *sql.Tx, obj runtime.Object, value []byte) error {
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return err
+	}
+	ns, name := accessor.GetNamespace(), accessor.GetName()
+	revision, err := parseResourceVersion(accessor.GetResourceVersion())
+	if err != nil {
+		return err
+	}
+	result, err := tx.ExecContext(ctx, s.db.rebind("DELETE FROM higress_objects WHERE resource = ? AND namespace = ? AND name = ? AND revision = ?"),
+		s.resource, ns, name, revision)
+	if err != nil {
+		return err
+	}
+	if affected, err := result.RowsAffected(); err != nil {
+		return err
+	} else if affected == 0 {
+		return apierrors.NewConflict(s.groupResource, name, errors.New(optimisticLockErrorMsg))
+	}
+	deletedRevision, err := s.db.nextRevision(ctx, tx)
+	if err != nil {
+		return err
+	}
+	return s.db.insertEvent(ctx, tx, deletedRevision, &sqlEvent{resource: s.resource, namespace: ns, name: name, eventType: watch.Deleted, value: value})
+}
+
+// visitObjects decodes all the objects in the given namespace, or all of them if it's empty, ordered by namespace and name.
+func (s *sqlREST) visitObjects(ctx context.Context, q sqlQuerier, ns string, visitFunc func(runtime.Object)) error {
+	query := "SELECT revision, value FROM higress_objects WHERE resource = ?"
+	args := []any{s.resource}
+	if ns != "" {
+		query += " AND namespace = ?"
+		args = append(args, ns)
+	}
+	rows, err := q.QueryContext(ctx, s.db.rebind(query+" ORDER BY namespace, name"), args...)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+	for rows.Next() {
+		var revision int64
+		var value []byte
+		if err := rows.Scan(&revision, &value); err != nil {
+			return err
+		}
+		obj, err := s.decode(value, uint64(revision))
+		if err != nil {
+			return err
+		}
+		visitFunc(obj)
+	}
+	return rows.Err()
+}
+
+// encode serializes an object without its resource version, which is kept in the revision column instead.
+func (s *sqlREST) encode(obj runtime.Object) ([]byte, error) {
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return nil, err
+	}
+	resourceVersion := accessor.GetResourceVersion()
+	accessor.SetResourceVersion("")
+	defer accessor.SetResourceVersion(resourceVersion)
+	buf := new(bytes.Buffer)
+	if err := s.codec.Encode(obj, buf); err != nil {
+		return nil, err
+	}
+	value, err := s.policy.Encrypt(obj, buf.String())
+	if err != nil {
+		return nil, err
+	}
+	return []byte(value), nil
+}
+
+func (s *sqlREST) decode(value []byte, revision uint64) (runtime.Object, error) {
+	obj, err := s.decodeValue(value)
+	if err != nil {
+		return nil, err
+	}
+	setResourceVersion(obj, revision)
+	return obj, nil
+}
+
+// decodeValue decodes a stored value, which doesn't hold a resource version.
+func (s *sqlREST) decodeValue(value []byte) (runtime.Object, error) {
+	decrypted, err := s.policy.Decrypt(string(value))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt object: %v", err)
+	}
+	obj, _, err := s.codec.Decode([]byte(decrypted), nil, s.newFunc())
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode object: %v", err)
+	}
+	return obj, nil
+}
+
+func (s *sqlREST) decodeEvent(event *sqlEvent) (watchEvent, error) {
+	obj, err := s.decode(event.value, event.revision)
+	if err != nil {
+		return watchEvent{}, err
+	}
+	ev := watchEvent{Event: watch.Event{Type: event.eventType, Object: obj}}
+	if len(event.prevValue) != 0 {
+		if ev.prevObject, err = s.decodeValue(event.prevValue); err != nil {
+			return watchEvent{}, err
+		}
+	}
+	return ev, nil
+}
+
+// Reencrypt rewrites the objects not encrypted as the policy requires, e.g. not with the newest key, like updates.
+func (s *sqlREST) Reencrypt(ctx context.Context) (int, error) {
+	if s.policy == nil {
+		return 0, nil
+	}
+	rows, err := s.db.db.QueryContext(ctx, s.db.rebind("SELECT revision, value FROM higress_objects WHERE resource = ? ORDER BY namespace, name"), s.resource)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+	var staleObjs []runtime.Object
+	for rows.Next() {
+		var revision int64
+		var value []byte
+		if err := rows.Scan(&revision, &value); err != nil {
+			return 0, err
+		}
+		obj, err := s.decode(value, uint64(revision))
+		if err != nil {
+			return 0, err
+		}
+		if s.policy.IsStale(obj, string(value)) {
+			staleObjs = append(staleObjs, obj)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	// The rows are closed before updating, as sqlite allows a single connection.
+	_ = rows.Close()
+	return reencryptObjects(ctx, s, s.groupResource, s.policy, staleObjs)
+}
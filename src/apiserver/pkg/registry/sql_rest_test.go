@@ -0,0 +1,197 @@
+package registry
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apiserver/pkg/registry/rest"
+
+	"github.com/alibaba/higress/api-server/pkg/encryption"
+	"github.com/alibaba/higress/api-server/pkg/options"
+)
+
+func newTestSqlREST(t *testing.T) *sqlREST {
+	t.Helper()
+	return newTestSqlRESTWithPolicy(t, filepath.Join(t.TempDir(), "higress.sqlite"), nil)
+}
+
+func newTestSqlRESTWithPolicy(t *testing.T, path string, policy *encryption.Policy) *sqlREST {
+	t.Helper()
+	storage, err := NewSqlREST(testGroupResource, testCodec, &options.SqlOptions{
+		// The query string tells the storages sharing a database with different policies apart.
+		Driver:        options.SqlDriver_Sqlite,
+		DataSource:    path + "?_pragma=busy_timeout(10000)&_policy=" + policy.NewestKeyId(),
+		ChangeLogSize: 1000,
+		PollInterval:  time.Second,
+	}, true, "configmap", newTestConfigMap, newTestConfigMapList, nil, policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return storage.(*sqlREST)
+}
+
+func TestSqlRevisionsAndWatch(t *testing.T) {
+	s := newTestSqlREST(t)
+	a1 := mustCreate(t, s, "ns", "a", "v1")
+	w := mustWatch(t, s, "ns", a1.ResourceVersion)
+	b := mustCreate(t, s, "ns", "b", "v1")
+	mustUpdate(t, s, a1, "v2")
+	mustDelete(t, s, "ns", "b")
+	expectEvents(t, w, "ADDED b=v1", "MODIFIED a=v2", "DELETED b=v1")
+
+	// A watch resumed from a revision gets all the events after it.
+	resumed := mustWatch(t, s, "ns", b.ResourceVersion)
+	expectEvents(t, resumed, "MODIFIED a=v2", "DELETED b=v1")
+}
+
+// TestSqlFailedWritesLeaveNoGap checks the revisions of the writes rolled back are reused, so the poller never waits
+// for them.
+func TestSqlFailedWritesLeaveNoGap(t *testing.T) {
+	s := newTestSqlREST(t)
+	a := mustCreate(t, s, "ns", "a", "v1")
+	w := mustWatch(t, s, "ns", a.ResourceVersion)
+
+	stale := a.DeepCopy()
+	stale.ResourceVersion = "100"
+	stale.Data["key"] = "v2"
+	if _, _, err := s.Update(nsContext("ns"), "a", rest.DefaultUpdatedObjectInfo(stale), nil, nil, false, &metav1.UpdateOptions{}); !apierrors.IsConflict(err) {
+		t.Fatalf("update with a stale resource version returned %v, want a conflict", err)
+	}
+	if _, err := s.Create(nsContext("ns"), testConfigMap("ns", "a", "v3"), nil, &metav1.CreateOptions{}); !apierrors.IsAlreadyExists(err) {
+		t.Fatalf("creating an existing object returned %v, want already exists", err)
+	}
+
+	b := mustCreate(t, s, "ns", "b", "v1")
+	if revisionOf(t, b) != revisionOf(t, a)+1 {
+		t.Fatalf("b is created at revision %s after a at %s", b.ResourceVersion, a.ResourceVersion)
+	}
+	expectEvents(t, w, "ADDED b=v1")
+}
+
+// TestSqlCreateFailure checks an insert failing for another reason than a conflict is an internal error, and
+// the transaction rolled back leaves no gap in the revisions.
+func TestSqlCreateFailure(t *testing.T) {
+	s := newTestSqlREST(t)
+	a := mustCreate(t, s, "ns", "a", "v1")
+	w := mustWatch(t, s, "ns", a.ResourceVersion)
+	if _, err := s.db.db.Exec(`CREATE TRIGGER fail_insert BEFORE INSERT ON higress_objects BEGIN SELECT RAISE(ABORT, 'disk I/O error'); END`); err != nil {
+		t.Fatal(err)
+	}
+	_, err := s.Create(nsContext("ns"), testConfigMap("ns", "b", "v1"), nil, &metav1.CreateOptions{})
+	if !apierrors.IsInternalError(err) {
+		t.Fatalf("create failing to insert returned %v, want an internal error", err)
+	}
+	if _, err := s.db.db.Exec(`DROP TRIGGER fail_insert`); err != nil {
+		t.Fatal(err)
+	}
+
+	c := mustCreate(t, s, "ns", "c", "v1")
+	if revisionOf(t, c) != revisionOf(t, a)+1 {
+		t.Fatalf("c is created at revision %s after a at %s", c.ResourceVersion, a.ResourceVersion)
+	}
+	expectEvents(t, w, "ADDED c=v1")
+}
+
+func TestIsUniqueViolation(t *testing.T) {
+	db, err := sql.Open(sqlDialects[options.SqlDriver_Sqlite].driverName, filepath.Join(t.TempDir(), "test.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = db.Close()
+	}()
+	ctx := context.Background()
+	if _, err := db.ExecContext(ctx, "CREATE TABLE t (id INTEGER PRIMARY KEY, name TEXT NOT NULL UNIQUE)"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.ExecContext(ctx, "INSERT INTO t (id, name) VALUES (1, 'a')"); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		stmt string
+		want bool
+	}{
+		{stmt: "INSERT INTO t (id, name) VALUES (1, 'b')", want: true},
+		{stmt: "INSERT INTO t (id, name) VALUES (2, 'a')", want: true},
+		{stmt: "INSERT INTO t (id, name) VALUES (3, NULL)", want: false},
+		{stmt: "INSERT INTO missing (id) VALUES (1)", want: false},
+	}
+	for _, tt := range tests {
+		_, err := db.ExecContext(ctx, tt.stmt)
+		if err == nil {
+			t.Fatalf("%s succeeded", tt.stmt)
+		}
+		if got := isUniqueViolation(err); got != tt.want {
+			t.Errorf("isUniqueViolation(%v) = %v, want %v", err, got, tt.want)
+		}
+	}
+}
+
+func TestSqlEncryption(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "higress.sqlite")
+	s := newTestSqlRESTWithPolicy(t, path, newTestPolicy(t, "key-1"))
+	a := mustCreate(t, s, "ns", "a", "secret-1")
+	w := mustWatch(t, s, "ns", a.ResourceVersion)
+	mustUpdate(t, s, a, "secret-2")
+	mustDelete(t, s, "ns", "a")
+	mustCreate(t, s, "ns", "b", "secret-3")
+	expectEvents(t, w, "MODIFIED a=secret-2", "DELETED a=secret-2", "ADDED b=secret-3")
+	if got := mustList(t, s, "ns", nil); len(got.Items) != 1 || got.Items[0].Data["key"] != "secret-3" {
+		t.Fatalf("listed %v", got.Items)
+	}
+	expectNoPlaintext(t, s.db.db, "secret")
+
+	// The objects encrypted with an old key are re-encrypted with the newest one.
+	rotated := newTestSqlRESTWithPolicy(t, path, newTestPolicy(t, "key-1", "key-2"))
+	count, err := rotated.Reencrypt(context.Background())
+	if err != nil || count != 1 {
+		t.Fatalf("Reencrypt() = %d, %v, want 1 object re-encrypted", count, err)
+	}
+	if count, err := rotated.Reencrypt(context.Background()); err != nil || count != 0 {
+		t.Fatalf("Reencrypt() = %d, %v again, want nothing re-encrypted", count, err)
+	}
+	expectNoPlaintext(t, s.db.db, "secret")
+}
+
+// expectNoPlaintext checks the given text isn't found in the objects or the events stored in the database.
+func expectNoPlaintext(t *testing.T, db *sql.DB, text string) {
+	t.Helper()
+	for _, query := range []string{
+		"SELECT value FROM higress_objects",
+		"SELECT value FROM higress_events",
+		"SELECT prev_value FROM higress_events WHERE prev_value IS NOT NULL",
+	} {
+		rows, err := db.Query(query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for rows.Next() {
+			var value []byte
+			if err := rows.Scan(&value); err != nil {
+				t.Fatal(err)
+			}
+			if strings.Contains(string(value), text) {
+				t.Errorf("%s returned plaintext: %s", query, value)
+			}
+		}
+		_ = rows.Close()
+	}
+}
+
+func TestSqlDryRun(t *testing.T) {
+	testDryRun(t, newTestSqlREST(t))
+}
+
+func TestSqlListChunks(t *testing.T) {
+	testListChunks(t, newTestSqlREST(t))
+}
+
+func TestSqlServerSideApply(t *testing.T) {
+	testServerSideApply(t, newTestSqlREST(t))
+}
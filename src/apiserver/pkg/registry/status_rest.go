@@ -0,0 +1,190 @@
+package registry
+
+import (
+	"context"
+	"reflect"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apiserver/pkg/registry/rest"
+	"sigs.k8s.io/structured-merge-diff/v4/fieldpath"
+)
+
+var _ rest.ResetFieldsStrategy = &specREST{}
+var _ rest.ShortNamesProvider = &specREST{}
+var _ rest.CategoriesProvider = &specREST{}
+var _ Reencrypter = &specREST{}
+var _ rest.Patcher = &statusREST{}
+var _ rest.ResetFieldsStrategy = &statusREST{}
+
+// NewStatusREST splits the updates of a resource with a status subresource like the registries of Kubernetes do:
+// the status is dropped when an object is created and kept when it's updated, and only the status is updated
+// through the subresource. It returns the storages of the resource and of the subresource, which share the backend.
+// versions are all the ones the resource is served in.
+func NewStatusREST(storage REST, versions []schema.GroupVersion) (REST, rest.Storage) {
+	specResetFields := map[fieldpath.APIVersion]*fieldpath.Set{}
+	statusResetFields := map[fieldpath.APIVersion]*fieldpath.Set{}
+	for _, version := range versions {
+		specResetFields[fieldpath.APIVersion(version.String())] = fieldpath.NewSet(fieldpath.MakePathOrDie("status"))
+		statusResetFields[fieldpath.APIVersion(version.String())] = fieldpath.NewSet(fieldpath.MakePathOrDie("spec"))
+	}
+	return &specREST{REST: storage, resetFields: specResetFields}, &statusREST{store: storage, resetFields: statusResetFields}
+}
+
+// specREST is the storage of a resource with a status subresource. All the methods not overridden here are served
+// by the backend. The optional interfaces aren't promoted from the backend, so the ones it may implement are
+// forwarded below.
+type specREST struct {
+	REST
+	resetFields map[fieldpath.APIVersion]*fieldpath.Set
+}
+
+func (s *specREST) Create(
+	ctx context.Context,
+	obj runtime.Object,
+	createValidation rest.ValidateObjectFunc,
+	options *metav1.CreateOptions,
+) (runtime.Object, error) {
+	copyStatus(obj, nil)
+	return s.REST.Create(ctx, obj, createValidation, options)
+}
+
+func (s *specREST) Update(
+	ctx context.Context,
+	name string,
+	objInfo rest.UpdatedObjectInfo,
+	createValidation rest.ValidateObjectFunc,
+	updateValidation rest.ValidateObjectUpdateFunc,
+	forceAllowCreate bool,
+	options *metav1.UpdateOptions,
+) (runtime.Object, bool, error) {
+	return s.REST.Update(ctx, name, &specUpdatedObjectInfo{objInfo}, createValidation, updateValidation, forceAllowCreate, options)
+}
+
+func (s *specREST) Reencrypt(ctx context.Context) (int, error) {
+	if reencrypter, ok := s.REST.(Reencrypter); ok {
+		return reencrypter.Reencrypt(ctx)
+	}
+	return 0, nil
+}
+
+func (s *specREST) ShortNames() []string {
+	if provider, ok := s.REST.(rest.ShortNamesProvider); ok {
+		return provider.ShortNames()
+	}
+	return nil
+}
+
+func (s *specREST) Categories() []string {
+	if provider, ok := s.REST.(rest.CategoriesProvider); ok {
+		return provider.Categories()
+	}
+	return nil
+}
+
+// GetResetFields returns the status, which isn't owned by the managers applying the resource.
+func (s *specREST) GetResetFields() map[fieldpath.APIVersion]*fieldpath.Set {
+	return s.resetFields
+}
+
+// specUpdatedObjectInfo keeps the status of the object updated, or drops it if the object is created on update.
+type specUpdatedObjectInfo struct {
+	rest.UpdatedObjectInfo
+}
+
+func (i *specUpdatedObjectInfo) UpdatedObject(ctx context.Context, oldObj runtime.Object) (runtime.Object, error) {
+	newObj, err := i.UpdatedObjectInfo.UpdatedObject(ctx, oldObj)
+	if err != nil {
+		return nil, err
+	}
+	copyStatus(newObj, oldObj)
+	return newObj, nil
+}
+
+// statusREST is the storage of the status subresource, which updates nothing but the status of the objects.
+type statusREST struct {
+	store       REST
+	resetFields map[fieldpath.APIVersion]*fieldpath.Set
+}
+
+func (s *statusREST) New() runtime.Object {
+	return s.store.New()
+}
+
+// Destroy does nothing, as the backend is destroyed along with the resource.
+func (s *statusREST) Destroy() {
+}
+
+func (s *statusREST) Get(ctx context.Context, name string, options *metav1.GetOptions) (runtime.Object, error) {
+	return s.store.Get(ctx, name, options)
+}
+
+func (s *statusREST) Update(
+	ctx context.Context,
+	name string,
+	objInfo rest.UpdatedObjectInfo,
+	createValidation rest.ValidateObjectFunc,
+	updateValidation rest.ValidateObjectUpdateFunc,
+	forceAllowCreate bool,
+	options *metav1.UpdateOptions,
+) (runtime.Object, bool, error) {
+	// Objects are never created through the subresource.
+	return s.store.Update(ctx, name, &statusUpdatedObjectInfo{objInfo}, createValidation, updateValidation, false, options)
+}
+
+// GetResetFields returns the spec, which isn't owned by the managers applying the status.
+func (s *statusREST) GetResetFields() map[fieldpath.APIVersion]*fieldpath.Set {
+	return s.resetFields
+}
+
+func (s *statusREST) ConvertToTable(ctx context.Context, object runtime.Object, tableOptions runtime.Object) (*metav1.Table, error) {
+	return s.store.ConvertToTable(ctx, object, tableOptions)
+}
+
+// statusUpdatedObjectInfo takes nothing but the status from the object updated, along with the resource version
+// it's based on and its managed fields.
+type statusUpdatedObjectInfo struct {
+	rest.UpdatedObjectInfo
+}
+
+func (i *statusUpdatedObjectInfo) UpdatedObject(ctx context.Context, oldObj runtime.Object) (runtime.Object, error) {
+	newObj, err := i.UpdatedObjectInfo.UpdatedObject(ctx, oldObj)
+	if err != nil || oldObj == nil {
+		return newObj, err
+	}
+	newAccessor, err := meta.Accessor(newObj)
+	if err != nil {
+		return nil, err
+	}
+	updatedObj := oldObj.DeepCopyObject()
+	updatedAccessor, err := meta.Accessor(updatedObj)
+	if err != nil {
+		return nil, err
+	}
+	copyStatus(updatedObj, newObj)
+	updatedAccessor.SetResourceVersion(newAccessor.GetResourceVersion())
+	updatedAccessor.SetManagedFields(newAccessor.GetManagedFields())
+	return updatedObj, nil
+}
+
+// copyStatus sets the Status field of dst to a copy of the one of src, or resets it if src is nil.
+func copyStatus(dst, src runtime.Object) {
+	dstValue := reflect.ValueOf(dst)
+	if dstValue.Kind() != reflect.Pointer || dstValue.IsNil() || dstValue.Elem().Kind() != reflect.Struct {
+		return
+	}
+	dstStatus := dstValue.Elem().FieldByName("Status")
+	if !dstStatus.IsValid() {
+		return
+	}
+	if src == nil || reflect.ValueOf(src).IsNil() {
+		dstStatus.Set(reflect.Zero(dstStatus.Type()))
+		return
+	}
+	srcStatus := reflect.ValueOf(src.DeepCopyObject()).Elem().FieldByName("Status")
+	if srcStatus.IsValid() && srcStatus.Type() == dstStatus.Type() {
+		dstStatus.Set(srcStatus)
+	}
+}
@@ -0,0 +1,195 @@
+package registry
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apiserver/pkg/registry/rest"
+	gwapiv1beta1 "sigs.k8s.io/gateway-api/apis/v1beta1"
+	"sigs.k8s.io/structured-merge-diff/v4/fieldpath"
+
+	gwapiv1 "github.com/alibaba/higress/api-server/pkg/apis/gatewayapi/v1"
+	"github.com/alibaba/higress/api-server/pkg/options"
+)
+
+func newTestStatusREST(t *testing.T) (REST, rest.Storage) {
+	t.Helper()
+	storage, err := NewBoltREST(corev1.Resource("services"), testCodec, &options.BoltOptions{
+		Path:          filepath.Join(t.TempDir(), "higress.db"),
+		Timeout:       time.Second,
+		ChangeLogSize: 1000,
+	}, true, "service",
+		func() runtime.Object { return &corev1.Service{} },
+		func() runtime.Object { return &corev1.ServiceList{} },
+		nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewStatusREST(storage, []schema.GroupVersion{corev1.SchemeGroupVersion})
+}
+
+func testService(name, selector, ip string) *corev1.Service {
+	return &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: name},
+		Spec:       corev1.ServiceSpec{Selector: map[string]string{"app": selector}},
+		Status: corev1.ServiceStatus{LoadBalancer: corev1.LoadBalancerStatus{
+			Ingress: []corev1.LoadBalancerIngress{{IP: ip}},
+		}},
+	}
+}
+
+func serviceIP(svc *corev1.Service) string {
+	if len(svc.Status.LoadBalancer.Ingress) == 0 {
+		return ""
+	}
+	return svc.Status.LoadBalancer.Ingress[0].IP
+}
+
+func TestStatusSubresource(t *testing.T) {
+	specStorage, statusStorage := newTestStatusREST(t)
+	obj, err := specStorage.Create(nsContext("ns"), testService("a", "v1", "10.0.0.1"), nil, &metav1.CreateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	created := obj.(*corev1.Service)
+	if ip := serviceIP(created); ip != "" {
+		t.Fatalf("created service has status %q, want it dropped", ip)
+	}
+
+	// The status is only updated through the subresource.
+	updated := testService("a", "v2", "10.0.0.2")
+	updated.ResourceVersion = created.ResourceVersion
+	obj, _, err = statusStorage.(rest.Updater).Update(nsContext("ns"), "a", rest.DefaultUpdatedObjectInfo(updated), nil, nil, false, &metav1.UpdateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	statusUpdated := obj.(*corev1.Service)
+	if statusUpdated.Spec.Selector["app"] != "v1" || serviceIP(statusUpdated) != "10.0.0.2" {
+		t.Fatalf("status update left spec %v and status %q", statusUpdated.Spec.Selector, serviceIP(statusUpdated))
+	}
+
+	// The spec is updated through the resource, leaving the status alone.
+	updated = testService("a", "v3", "10.0.0.3")
+	updated.ResourceVersion = statusUpdated.ResourceVersion
+	obj, _, err = specStorage.Update(nsContext("ns"), "a", rest.DefaultUpdatedObjectInfo(updated), nil, nil, false, &metav1.UpdateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	specUpdated := obj.(*corev1.Service)
+	if specUpdated.Spec.Selector["app"] != "v3" || serviceIP(specUpdated) != "10.0.0.2" {
+		t.Fatalf("spec update left spec %v and status %q", specUpdated.Spec.Selector, serviceIP(specUpdated))
+	}
+
+	// Nothing but the status is taken from the subresource, which still checks the resource version.
+	updated = testService("a", "v4", "10.0.0.4")
+	updated.Labels = map[string]string{"app": "a"}
+	updated.ResourceVersion = statusUpdated.ResourceVersion
+	_, _, err = statusStorage.(rest.Updater).Update(nsContext("ns"), "a", rest.DefaultUpdatedObjectInfo(updated), nil, nil, false, &metav1.UpdateOptions{})
+	if !apierrors.IsConflict(err) {
+		t.Fatalf("status update with a stale resource version returned %v, want a conflict", err)
+	}
+	updated.ResourceVersion = specUpdated.ResourceVersion
+	if _, _, err := statusStorage.(rest.Updater).Update(nsContext("ns"), "a", rest.DefaultUpdatedObjectInfo(updated), nil, nil, false, &metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	obj, err = statusStorage.(rest.Getter).Get(nsContext("ns"), "a", &metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := obj.(*corev1.Service); got.Spec.Selector["app"] != "v3" || got.Labels != nil || serviceIP(got) != "10.0.0.4" {
+		t.Fatalf("got spec %v, labels %v and status %q after the status update", got.Spec.Selector, got.Labels, serviceIP(got))
+	}
+
+	// Objects are never created through the subresource.
+	created = testService("b", "v1", "10.0.0.4")
+	if _, _, err := statusStorage.(rest.Updater).Update(nsContext("ns"), "b", rest.DefaultUpdatedObjectInfo(created), nil, nil, true, &metav1.UpdateOptions{}); err == nil {
+		t.Fatal("status update created an object")
+	}
+}
+
+// shortNamesREST is a backend implementing an optional interface of the storages.
+type shortNamesREST struct {
+	REST
+}
+
+func (s *shortNamesREST) ShortNames() []string {
+	return []string{"svc"}
+}
+
+func (s *shortNamesREST) Reencrypt(ctx context.Context) (int, error) {
+	return 1, nil
+}
+
+func TestStatusResetFields(t *testing.T) {
+	versions := []schema.GroupVersion{gwapiv1.SchemeGroupVersion, gwapiv1beta1.SchemeGroupVersion}
+	specStorage, statusStorage := NewStatusREST(&shortNamesREST{}, versions)
+	for _, version := range versions {
+		apiVersion := fieldpath.APIVersion(version.String())
+		specFields := specStorage.(rest.ResetFieldsStrategy).GetResetFields()[apiVersion]
+		if specFields == nil || !specFields.Has(fieldpath.MakePathOrDie("status")) || specFields.Has(fieldpath.MakePathOrDie("spec")) {
+			t.Errorf("fields reset by the resource in %s are %v, want the status", version, specFields)
+		}
+		statusFields := statusStorage.(rest.ResetFieldsStrategy).GetResetFields()[apiVersion]
+		if statusFields == nil || !statusFields.Has(fieldpath.MakePathOrDie("spec")) || statusFields.Has(fieldpath.MakePathOrDie("status")) {
+			t.Errorf("fields reset by the subresource in %s are %v, want the spec", version, statusFields)
+		}
+	}
+}
+
+func TestStatusRESTForwardsOptionalInterfaces(t *testing.T) {
+	specStorage, _ := NewStatusREST(&shortNamesREST{}, nil)
+	if got := specStorage.(rest.ShortNamesProvider).ShortNames(); !reflect.DeepEqual(got, []string{"svc"}) {
+		t.Fatalf("ShortNames() = %v, want the ones of the backend", got)
+	}
+	if got := specStorage.(rest.CategoriesProvider).Categories(); got != nil {
+		t.Fatalf("Categories() = %v, want none as the backend has none", got)
+	}
+	if count, err := specStorage.(Reencrypter).Reencrypt(context.Background()); count != 1 || err != nil {
+		t.Fatalf("Reencrypt() = %d, %v, want the result of the backend", count, err)
+	}
+}
+
+func TestCopyStatus(t *testing.T) {
+	dst := testService("a", "v1", "10.0.0.1")
+	copyStatus(dst, (*corev1.Service)(nil))
+	if ip := serviceIP(dst); ip != "" {
+		t.Fatalf("status copied from a nil object is %q, want it reset", ip)
+	}
+
+	src := testService("a", "v2", "10.0.0.2")
+	copyStatus(dst, src)
+	src.Status.LoadBalancer.Ingress[0].IP = "10.0.0.3"
+	if ip := serviceIP(dst); ip != "10.0.0.2" {
+		t.Fatalf("copied status is %q, want a copy of the source", ip)
+	}
+
+	// Objects without a status, or with a different one, are left alone.
+	cm := testConfigMap("ns", "a", "v1")
+	copyStatus(cm, src)
+	copyStatus(dst, cm)
+	if ip := serviceIP(dst); ip != "10.0.0.2" {
+		t.Fatalf("status copied from an object without one is %q", ip)
+	}
+}
+
+func TestCopyGatewayStatus(t *testing.T) {
+	condition := metav1.Condition{Type: "Programmed", Status: metav1.ConditionTrue, Reason: "Programmed"}
+	src := &gwapiv1.Gateway{Status: gwapiv1beta1.GatewayStatus{Conditions: []metav1.Condition{condition}}}
+	dst := &gwapiv1.Gateway{Spec: gwapiv1beta1.GatewaySpec{GatewayClassName: "higress"}}
+	copyStatus(dst, src)
+	if len(dst.Status.Conditions) != 1 || dst.Status.Conditions[0] != condition || dst.Spec.GatewayClassName != "higress" {
+		t.Fatalf("gateway after copying the status = %+v", dst)
+	}
+	src.Status.Conditions[0].Status = metav1.ConditionFalse
+	if dst.Status.Conditions[0].Status != metav1.ConditionTrue {
+		t.Fatal("copied conditions are shared with the source")
+	}
+}
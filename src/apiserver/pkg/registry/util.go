@@ -1,13 +1,56 @@
 package registry
 
 import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
 	"fmt"
+	"io"
 	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/conversion"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	genericapirequest "k8s.io/apiserver/pkg/endpoints/request"
+	"k8s.io/apiserver/pkg/registry/rest"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+	"net"
 	"reflect"
+	"sort"
+	"syscall"
+
+	"github.com/alibaba/higress/api-server/pkg/encryption"
+	"github.com/go-sql-driver/mysql"
 )
 
+// errDryRun rolls back the transaction of a dry-run write once all its checks have passed.
+var errDryRun = errors.New("dry run")
+
+// errStorageUnavailable marks the failures to reach the store behind a backend, which are reported as
+// "503 Service Unavailable" errors rather than internal ones.
+var errStorageUnavailable = errors.New("storage is unavailable")
+
+func unavailableError(err error) error {
+	return fmt.Errorf("%w: %w", errStorageUnavailable, err)
+}
+
+// isConnectionError tells whether an error means the store behind a backend can't be reached,
+// rather than that it failed to handle the request.
+func isConnectionError(err error) bool {
+	var netErr net.Error
+	return errors.Is(err, errStorageUnavailable) ||
+		errors.As(err, &netErr) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, driver.ErrBadConn) ||
+		errors.Is(err, sql.ErrConnDone) ||
+		errors.Is(err, mysql.ErrInvalidConn)
+}
+
 func appendItem(v reflect.Value, obj runtime.Object) {
 	value := reflect.ValueOf(obj)
 	if v.Type().Elem().Kind() != reflect.Ptr {
@@ -37,3 +80,54 @@ func listItemToRuntimeObject(item reflect.Value) runtime.Object {
 	}
 	return item.Addr().Interface().(runtime.Object)
 }
+
+// sortObjects sorts objects by namespace and name, which is the order items are returned in a list.
+func sortObjects(objs []runtime.Object) {
+	sort.Slice(objs, func(i, j int) bool {
+		a, _ := meta.Accessor(objs[i])
+		b, _ := meta.Accessor(objs[j])
+		if a == nil || b == nil {
+			return false
+		}
+		if a.GetNamespace() != b.GetNamespace() {
+			return a.GetNamespace() < b.GetNamespace()
+		}
+		return a.GetName() < b.GetName()
+	})
+}
+
+// updateToCreateOptions converts the options of an update creating the object into the ones of the create.
+func updateToCreateOptions(options *metav1.UpdateOptions) *metav1.CreateOptions {
+	return &metav1.CreateOptions{
+		DryRun:          options.DryRun,
+		FieldManager:    options.FieldManager,
+		FieldValidation: options.FieldValidation,
+	}
+}
+
+// reencryptObjects rewrites the given objects, which aren't encrypted as the policy requires, through the storage
+// like updates, and returns the number of them rewritten.
+func reencryptObjects(ctx context.Context, storage rest.Updater, groupResource schema.GroupResource, policy *encryption.Policy, objs []runtime.Object) (int, error) {
+	count := 0
+	var errs []error
+	for _, obj := range objs {
+		if err := ctx.Err(); err != nil {
+			return count, err
+		}
+		accessor, err := meta.Accessor(obj)
+		if err != nil {
+			return count, err
+		}
+		key, _ := cache.MetaNamespaceKeyFunc(obj)
+		// The resource version read makes the update fail if the object is changed meanwhile, which is fine
+		// as it's written with the newest key then.
+		objCtx := genericapirequest.WithNamespace(ctx, accessor.GetNamespace())
+		if _, _, err := storage.Update(objCtx, accessor.GetName(), rest.DefaultUpdatedObjectInfo(obj), nil, nil, false, &metav1.UpdateOptions{}); err != nil {
+			errs = append(errs, fmt.Errorf("failed to re-encrypt %s: %v", key, err))
+			continue
+		}
+		klog.Infof("[%s] %s is re-encrypted with key %s", groupResource, key, policy.NewestKeyId())
+		count++
+	}
+	return count, utilerrors.NewAggregate(errs)
+}
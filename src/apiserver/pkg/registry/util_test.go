@@ -0,0 +1,384 @@
+package registry
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metainternalversion "k8s.io/apimachinery/pkg/apis/meta/internalversion"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/managedfields"
+	"k8s.io/apimachinery/pkg/watch"
+	genericapirequest "k8s.io/apiserver/pkg/endpoints/request"
+	"k8s.io/apiserver/pkg/registry/rest"
+	"k8s.io/client-go/kubernetes/scheme"
+
+	"github.com/alibaba/higress/api-server/pkg/encryption"
+)
+
+var (
+	testGroupResource = corev1.Resource("configmaps")
+	testCodec         = scheme.Codecs.LegacyCodec(corev1.SchemeGroupVersion)
+)
+
+func newTestConfigMap() runtime.Object {
+	return &corev1.ConfigMap{}
+}
+
+func newTestConfigMapList() runtime.Object {
+	return &corev1.ConfigMapList{}
+}
+
+func testConfigMap(ns, name, value string) *corev1.ConfigMap {
+	return &corev1.ConfigMap{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "ConfigMap"},
+		ObjectMeta: metav1.ObjectMeta{Namespace: ns, Name: name},
+		Data:       map[string]string{"key": value},
+	}
+}
+
+// newTestPolicy returns a policy encrypting all the objects with a keyring holding the given keys, the last one of
+// which is the newest.
+func newTestPolicy(t *testing.T, keyIds ...string) *encryption.Policy {
+	t.Helper()
+	return encryption.NewPolicy(newTestKeyring(t, keyIds...), &encryption.ResourceRule{GroupResource: testGroupResource, Selector: labels.Everything()})
+}
+
+func newTestKeyring(t *testing.T, keyIds ...string) *encryption.Keyring {
+	t.Helper()
+	dir := t.TempDir()
+	for _, keyId := range keyIds {
+		if err := os.WriteFile(filepath.Join(dir, keyId), []byte(strings.Repeat(keyId[len(keyId)-1:], 32)), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	keyring, err := encryption.LoadKeyring(dir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keyring
+}
+
+func nsContext(ns string) context.Context {
+	return genericapirequest.WithNamespace(context.Background(), ns)
+}
+
+func mustCreate(t *testing.T, storage rest.Creater, ns, name, value string) *corev1.ConfigMap {
+	t.Helper()
+	obj, err := storage.Create(nsContext(ns), testConfigMap(ns, name, value), nil, &metav1.CreateOptions{})
+	if err != nil {
+		t.Fatalf("failed to create %s/%s: %v", ns, name, err)
+	}
+	return obj.(*corev1.ConfigMap)
+}
+
+func mustUpdate(t *testing.T, storage rest.Updater, obj *corev1.ConfigMap, value string) *corev1.ConfigMap {
+	t.Helper()
+	obj = obj.DeepCopy()
+	obj.Data = map[string]string{"key": value}
+	updated, _, err := storage.Update(nsContext(obj.Namespace), obj.Name, rest.DefaultUpdatedObjectInfo(obj), nil, nil, false, &metav1.UpdateOptions{})
+	if err != nil {
+		t.Fatalf("failed to update %s/%s: %v", obj.Namespace, obj.Name, err)
+	}
+	return updated.(*corev1.ConfigMap)
+}
+
+func mustDelete(t *testing.T, storage rest.GracefulDeleter, ns, name string) {
+	t.Helper()
+	if _, _, err := storage.Delete(nsContext(ns), name, nil, &metav1.DeleteOptions{}); err != nil {
+		t.Fatalf("failed to delete %s/%s: %v", ns, name, err)
+	}
+}
+
+func mustList(t *testing.T, storage rest.Lister, ns string, options *metainternalversion.ListOptions) *corev1.ConfigMapList {
+	t.Helper()
+	list, err := storage.List(nsContext(ns), options)
+	if err != nil {
+		t.Fatalf("failed to list %s: %v", ns, err)
+	}
+	return list.(*corev1.ConfigMapList)
+}
+
+func mustWatch(t *testing.T, storage rest.Watcher, ns string, resourceVersion string) watch.Interface {
+	t.Helper()
+	w, err := storage.Watch(nsContext(ns), &metainternalversion.ListOptions{ResourceVersion: resourceVersion})
+	if err != nil {
+		t.Fatalf("failed to watch %s from %q: %v", ns, resourceVersion, err)
+	}
+	t.Cleanup(w.Stop)
+	return w
+}
+
+func revisionOf(t *testing.T, obj runtime.Object) uint64 {
+	t.Helper()
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		t.Fatal(err)
+	}
+	revision, err := parseResourceVersion(accessor.GetResourceVersion())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return revision
+}
+
+// testEvent is an event in short, e.g. "ADDED a=v1".
+func testEvent(ev watch.Event) string {
+	if cm, ok := ev.Object.(*corev1.ConfigMap); ok {
+		return string(ev.Type) + " " + cm.Name + "=" + cm.Data["key"]
+	}
+	return string(ev.Type)
+}
+
+// receiveEvents receives n events from w, failing if they don't arrive in time.
+func receiveEvents(t *testing.T, w watch.Interface, n int) []watch.Event {
+	t.Helper()
+	var events []watch.Event
+	timeout := time.After(10 * time.Second)
+	for len(events) < n {
+		select {
+		case ev, ok := <-w.ResultChan():
+			if !ok {
+				t.Fatalf("watch is closed after events %v", events)
+			}
+			events = append(events, ev)
+		case <-timeout:
+			t.Fatalf("timed out waiting for %d events, got %d", n, len(events))
+		}
+	}
+	return events
+}
+
+// expectEvents receives the given events in short from w in order, and checks their revisions are increasing.
+func expectEvents(t *testing.T, w watch.Interface, want ...string) []watch.Event {
+	t.Helper()
+	events := receiveEvents(t, w, len(want))
+	var lastRevision uint64
+	for i, ev := range events {
+		if got := testEvent(ev); got != want[i] {
+			t.Fatalf("event %d is %q, want %q", i, got, want[i])
+		}
+		revision := revisionOf(t, ev.Object)
+		if revision <= lastRevision {
+			t.Fatalf("event %d %q is at revision %d, not after %d", i, testEvent(ev), revision, lastRevision)
+		}
+		lastRevision = revision
+	}
+	return events
+}
+
+// expectNoEvent checks nothing is received from w for a while.
+func expectNoEvent(t *testing.T, w watch.Interface) {
+	t.Helper()
+	select {
+	case ev, ok := <-w.ResultChan():
+		if ok {
+			t.Fatalf("unexpected event %s", testEvent(ev))
+		}
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+// testDryRun checks the dry-run writes to storage go through all the checks of the real ones, and return the objects
+// they would write, without storing anything, notifying the watchers or using up a revision.
+func testDryRun(t *testing.T, storage REST) {
+	t.Helper()
+	ctx := nsContext("ns")
+	dryRun := []string{metav1.DryRunAll}
+	w := mustWatch(t, storage, "ns", "")
+
+	obj, err := storage.Create(ctx, testConfigMap("ns", "a", "v1"), nil, &metav1.CreateOptions{DryRun: dryRun})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := obj.(*corev1.ConfigMap); got.Name != "a" || got.Data["key"] != "v1" || got.CreationTimestamp.IsZero() {
+		t.Fatalf("dry-run create returned %+v", got)
+	}
+	if _, err := storage.Get(ctx, "a", &metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+		t.Fatalf("get after a dry-run create returned %v, want not found", err)
+	}
+
+	a := mustCreate(t, storage, "ns", "a", "v1")
+	// The file and nacos storages report existing objects as conflicts.
+	_, err = storage.Create(ctx, testConfigMap("ns", "a", "v2"), nil, &metav1.CreateOptions{DryRun: dryRun})
+	if !apierrors.IsAlreadyExists(err) && !apierrors.IsConflict(err) {
+		t.Fatalf("dry-run create of an existing object returned %v, want 409", err)
+	}
+
+	updated := a.DeepCopy()
+	updated.Data["key"] = "v2"
+	obj, _, err = storage.Update(ctx, "a", rest.DefaultUpdatedObjectInfo(updated), nil, nil, false, &metav1.UpdateOptions{DryRun: dryRun})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := obj.(*corev1.ConfigMap); got.Data["key"] != "v2" {
+		t.Fatalf("dry-run update returned %+v", got)
+	}
+	stale := updated.DeepCopy()
+	stale.ResourceVersion = formatResourceVersion(revisionOf(t, a) - 1)
+	_, _, err = storage.Update(ctx, "a", rest.DefaultUpdatedObjectInfo(stale), nil, nil, false, &metav1.UpdateOptions{DryRun: dryRun})
+	if !apierrors.IsConflict(err) {
+		t.Fatalf("dry-run update with a stale resource version returned %v, want a conflict", err)
+	}
+	invalid := func(ctx context.Context, obj, old runtime.Object) error {
+		return apierrors.NewBadRequest("invalid")
+	}
+	_, _, err = storage.Update(ctx, "a", rest.DefaultUpdatedObjectInfo(updated), nil, invalid, false, &metav1.UpdateOptions{DryRun: dryRun})
+	if !apierrors.IsBadRequest(err) {
+		t.Fatalf("dry-run update failing validation returned %v", err)
+	}
+
+	if _, _, err := storage.Delete(ctx, "a", nil, &metav1.DeleteOptions{DryRun: dryRun}); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := storage.Delete(ctx, "b", nil, &metav1.DeleteOptions{DryRun: dryRun}); !apierrors.IsNotFound(err) {
+		t.Fatalf("dry-run delete of a missing object returned %v, want not found", err)
+	}
+
+	// Nothing is changed by the dry runs, so the object read is the one created, and it's updated with its revision.
+	got, err := storage.Get(ctx, "a", &metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("get after a dry-run delete returned %v", err)
+	}
+	if cm := got.(*corev1.ConfigMap); cm.Data["key"] != "v1" || cm.ResourceVersion != a.ResourceVersion {
+		t.Fatalf("got %+v after the dry runs, want the object created", cm)
+	}
+	mustUpdate(t, storage, a, "v3")
+	expectEvents(t, w, "ADDED a=v1", "MODIFIED a=v3")
+	expectNoEvent(t, w)
+}
+
+// testListChunks checks the lists of storage are served in chunks in the order of the object keys, which are
+// consistent with the first one, or expired once an object of the following chunks is changed.
+func testListChunks(t *testing.T, storage REST) {
+	t.Helper()
+	for _, name := range []string{"e", "c", "a", "d", "b"} {
+		mustCreate(t, storage, "ns", name, "v1")
+	}
+	first := mustList(t, storage, "ns", &metainternalversion.ListOptions{Limit: 2})
+	if got := listedNames(first); got != "a,b" || first.Continue == "" {
+		t.Fatalf("first chunk is %q with continue %q", got, first.Continue)
+	}
+	if first.RemainingItemCount == nil || *first.RemainingItemCount != 3 {
+		t.Fatalf("first chunk has %v items remaining, want 3", first.RemainingItemCount)
+	}
+
+	// The objects before the chunk, or of other namespaces, are changed without affecting the following chunks.
+	mustUpdate(t, storage, &first.Items[0], "v2")
+	mustCreate(t, storage, "ns", "a0", "v1")
+	mustCreate(t, storage, "other", "z", "v1")
+	second := mustList(t, storage, "ns", &metainternalversion.ListOptions{Limit: 2, Continue: first.Continue})
+	if got := listedNames(second); got != "c,d" || second.Continue == "" {
+		t.Fatalf("second chunk is %q with continue %q", got, second.Continue)
+	}
+	if second.ResourceVersion != first.ResourceVersion {
+		t.Fatalf("second chunk is at %s, not at %s of the first one", second.ResourceVersion, first.ResourceVersion)
+	}
+	last := mustList(t, storage, "ns", &metainternalversion.ListOptions{Limit: 2, Continue: second.Continue})
+	if got := listedNames(last); got != "e" || last.Continue != "" || last.RemainingItemCount != nil {
+		t.Fatalf("last chunk is %q with continue %q", got, last.Continue)
+	}
+
+	// The chunk following a changed object can't be consistent with the previous ones.
+	mustDelete(t, storage, "ns", "e")
+	_, err := storage.List(nsContext("ns"), &metainternalversion.ListOptions{Limit: 2, Continue: second.Continue})
+	if !apierrors.IsResourceExpired(err) {
+		t.Fatalf("continuing a list after a change of its following chunk returned %v, want 410", err)
+	}
+	_, err = storage.List(nsContext("ns"), &metainternalversion.ListOptions{Limit: 2, Continue: "garbage"})
+	if !apierrors.IsBadRequest(err) {
+		t.Fatalf("continuing a list with an invalid token returned %v, want a bad request", err)
+	}
+	if list := mustList(t, storage, "ns", &metainternalversion.ListOptions{}); list.Continue != "" || len(list.Items) != 5 {
+		t.Fatalf("list without a limit returned %q with continue %q", listedNames(list), list.Continue)
+	}
+}
+
+// listedNames returns the names of the listed objects joined by commas.
+func listedNames(list *corev1.ConfigMapList) string {
+	names := make([]string, 0, len(list.Items))
+	for _, item := range list.Items {
+		names = append(names, item.Name)
+	}
+	return strings.Join(names, ",")
+}
+
+// applyInfo applies a configuration to the object stored with a field manager, like the handler of server-side apply.
+type applyInfo struct {
+	fieldManager *managedfields.FieldManager
+	patch        *unstructured.Unstructured
+	manager      string
+}
+
+func (a *applyInfo) Preconditions() *metav1.Preconditions {
+	return nil
+}
+
+func (a *applyInfo) UpdatedObject(ctx context.Context, oldObj runtime.Object) (runtime.Object, error) {
+	// An object without a UID is taken as a new one.
+	if accessor, err := meta.Accessor(oldObj); oldObj == nil || err == nil && accessor.GetUID() == "" {
+		oldObj = &corev1.ConfigMap{}
+	}
+	return a.fieldManager.Apply(oldObj, a.patch, a.manager, false)
+}
+
+// testServerSideApply checks objects are created and updated in storage by server-side apply, i.e. by updates
+// allowed to create them, along with their managed fields.
+func testServerSideApply(t *testing.T, storage REST) {
+	t.Helper()
+	gvk := corev1.SchemeGroupVersion.WithKind("ConfigMap")
+	fieldManager, err := managedfields.NewDefaultFieldManager(managedfields.NewDeducedTypeConverter(),
+		scheme.Scheme, scheme.Scheme, scheme.Scheme, gvk, gvk.GroupVersion(), "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	apply := func(manager string, data map[string]interface{}) (*corev1.ConfigMap, bool, error) {
+		t.Helper()
+		patch := &unstructured.Unstructured{Object: map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "ConfigMap",
+			"metadata":   map[string]interface{}{"name": "a", "namespace": "ns"},
+			"data":       data,
+		}}
+		obj, created, err := storage.Update(nsContext("ns"), "a", &applyInfo{fieldManager: fieldManager, patch: patch, manager: manager},
+			nil, nil, true, &metav1.UpdateOptions{})
+		if err != nil {
+			return nil, false, err
+		}
+		return obj.(*corev1.ConfigMap), created, nil
+	}
+
+	a, created, err := apply("console", map[string]interface{}{"key": "v1"})
+	if err != nil {
+		t.Fatalf("apply of a new object returned %v", err)
+	}
+	if !created || a.UID == "" || a.Data["key"] != "v1" || len(a.ManagedFields) != 1 || a.ManagedFields[0].Manager != "console" {
+		t.Fatalf("apply of a new object = %+v, created %v", a, created)
+	}
+	if _, _, err := apply("gitops", map[string]interface{}{"key": "v2"}); !apierrors.IsConflict(err) {
+		t.Fatalf("conflicting apply returned %v, want a conflict", err)
+	}
+	updated, created, err := apply("gitops", map[string]interface{}{"other": "v1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created || updated.UID != a.UID || updated.Data["key"] != "v1" || updated.Data["other"] != "v1" || len(updated.ManagedFields) != 2 {
+		t.Fatalf("apply of an existing object = %+v, created %v", updated, created)
+	}
+	stored, err := storage.Get(nsContext("ns"), "a", &metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cm := stored.(*corev1.ConfigMap); cm.ResourceVersion != updated.ResourceVersion || len(cm.ManagedFields) != 2 {
+		t.Fatalf("stored object = %+v, want the one applied", cm)
+	}
+}
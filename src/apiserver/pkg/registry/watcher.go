@@ -0,0 +1,279 @@
+package registry
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/alibaba/higress/api-server/pkg/options"
+	"github.com/google/uuid"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/apiserver/pkg/storage"
+	"k8s.io/klog/v2"
+)
+
+// errorEventDeliveryTimeout is how long a terminated watcher waits for its consumer to receive the error event.
+const errorEventDeliveryTimeout = 5 * time.Second
+
+var _ watch.Interface = &bufferedWatcher{}
+
+// watchEvent is an event along with the state of the object before the change, if known.
+// The previous state is needed to tell whether an object starts or stops matching the selectors of a watch.
+type watchEvent struct {
+	watch.Event
+	prevObject runtime.Object
+}
+
+// watchFilter decides whether an event is delivered to a watcher and returns the event to deliver.
+type watchFilter func(ev watchEvent) (watch.Event, bool)
+
+// watchBroadcaster fans events out to a set of watchers without ever blocking on any of them.
+type watchBroadcaster struct {
+	mutex    sync.RWMutex
+	watchers map[string]*bufferedWatcher
+}
+
+func newWatchBroadcaster() *watchBroadcaster {
+	return &watchBroadcaster{
+		watchers: make(map[string]*bufferedWatcher, 10),
+	}
+}
+
+// watch creates a watcher which delivers the initial events first and then the broadcast ones accepted by the filter.
+// The watcher is stopped when the given context is done.
+//
+// The broadcast events are buffered while the initial ones are delivered, so the buffer has room for as many more
+// events as there are initial ones, and a client receiving a large initial list isn't deemed too slow.
+func (b *watchBroadcaster) watch(ctx context.Context, initialEvents []watch.Event, filter watchFilter, allowBookmarks bool) *bufferedWatcher {
+	w := &bufferedWatcher{
+		id:             uuid.New().String(),
+		filter:         filter,
+		allowBookmarks: allowBookmarks,
+		input:          make(chan watch.Event, options.WatchBufferSize+len(initialEvents)),
+		result:         make(chan watch.Event),
+		done:           make(chan struct{}),
+	}
+
+	b.mutex.Lock()
+	b.watchers[w.id] = w
+	b.mutex.Unlock()
+
+	go func() {
+		defer func() {
+			b.mutex.Lock()
+			delete(b.watchers, w.id)
+			b.mutex.Unlock()
+		}()
+		w.run(ctx, initialEvents)
+	}()
+	return w
+}
+
+// broadcast sends the event to all the watchers accepting it. Watchers whose buffer is full are terminated.
+func (b *watchBroadcaster) broadcast(ev watchEvent) {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+	for _, w := range b.watchers {
+		if w.filter == nil {
+			w.send(ev.Event)
+		} else if filtered, ok := w.filter(ev); ok {
+			w.send(filtered)
+		}
+	}
+}
+
+// broadcastBookmark sends a bookmark of the given revision to all the watchers asking for bookmarks.
+// Bookmarks are dropped for watchers whose buffer is full.
+func (b *watchBroadcaster) broadcastBookmark(newFunc func() runtime.Object, revision uint64) {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+	for _, w := range b.watchers {
+		if w.allowBookmarks {
+			w.trySend(newBookmarkEvent(newFunc, revision, false))
+		}
+	}
+}
+
+func (b *watchBroadcaster) count() int {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+	return len(b.watchers)
+}
+
+// bufferedWatcher delivers events to a watch client through its own bounded buffer and goroutine,
+// so a slow client never blocks event producers or other clients.
+type bufferedWatcher struct {
+	id             string
+	filter         watchFilter
+	allowBookmarks bool
+
+	input  chan watch.Event
+	result chan watch.Event
+
+	done       chan struct{}
+	stopOnce   sync.Once
+	errorEvent *watch.Event
+}
+
+func (w *bufferedWatcher) Stop() {
+	w.stopOnce.Do(func() {
+		close(w.done)
+	})
+}
+
+func (w *bufferedWatcher) ResultChan() <-chan watch.Event {
+	return w.result
+}
+
+func (w *bufferedWatcher) send(ev watch.Event) {
+	select {
+	case <-w.done:
+		return
+	default:
+	}
+	select {
+	case w.input <- ev:
+	default:
+		klog.Warningf("watcher %s is too slow to consume events, terminating it", w.id)
+		w.stopOnce.Do(func() {
+			status := apierrors.NewTooManyRequests("the watch consumer is too slow, please resume the watch", 1).Status()
+			w.errorEvent = &watch.Event{
+				Type:   watch.Error,
+				Object: &status,
+			}
+			close(w.done)
+		})
+	}
+}
+
+func (w *bufferedWatcher) trySend(ev watch.Event) {
+	select {
+	case <-w.done:
+	case w.input <- ev:
+	default:
+	}
+}
+
+func (w *bufferedWatcher) run(ctx context.Context, initialEvents []watch.Event) {
+	defer close(w.result)
+
+	deliver := func(ev watch.Event) bool {
+		select {
+		case w.result <- ev:
+			return true
+		case <-w.done:
+			return false
+		case <-ctx.Done():
+			w.Stop()
+			return false
+		}
+	}
+
+	for _, ev := range initialEvents {
+		if !deliver(ev) {
+			w.deliverErrorEvent(ctx)
+			return
+		}
+	}
+	for {
+		select {
+		case ev := <-w.input:
+			if !deliver(ev) {
+				w.deliverErrorEvent(ctx)
+				return
+			}
+		case <-w.done:
+			w.deliverErrorEvent(ctx)
+			return
+		case <-ctx.Done():
+			w.Stop()
+			return
+		}
+	}
+}
+
+func (w *bufferedWatcher) deliverErrorEvent(ctx context.Context) {
+	// errorEvent is only set before done gets closed.
+	if w.errorEvent == nil {
+		return
+	}
+	timer := time.NewTimer(errorEventDeliveryTimeout)
+	defer timer.Stop()
+	select {
+	case w.result <- *w.errorEvent:
+	case <-ctx.Done():
+	case <-timer.C:
+	}
+}
+
+// newSelectionFilter creates a filter accepting events of objects in the given namespace and matching the predicate.
+// An object starting to match is delivered as ADDED, and one stopping to match is delivered as DELETED,
+// so the watcher sees the same set of objects as a list with the same selectors would return.
+func newSelectionFilter(ns string, predicate storage.SelectionPredicate) watchFilter {
+	matches := func(obj runtime.Object) bool {
+		if obj == nil {
+			return false
+		}
+		if ns != "" {
+			accessor, err := meta.Accessor(obj)
+			if err != nil || accessor.GetNamespace() != ns {
+				return false
+			}
+		}
+		if predicate.Empty() {
+			return true
+		}
+		match, err := predicate.Matches(obj)
+		// If something went wrong, we assume it's a match
+		return err != nil || match
+	}
+	return func(ev watchEvent) (watch.Event, bool) {
+		switch ev.Type {
+		case watch.Added, watch.Modified:
+			curMatches := matches(ev.Object)
+			prevMatches := false
+			if ev.Type == watch.Modified {
+				if ev.prevObject != nil {
+					prevMatches = matches(ev.prevObject)
+				} else {
+					// Nothing is known about the previous state, so only the current one counts.
+					prevMatches = curMatches
+				}
+			}
+			switch {
+			case curMatches && prevMatches:
+				return ev.Event, true
+			case curMatches:
+				return watch.Event{Type: watch.Added, Object: ev.Object}, true
+			case prevMatches:
+				obj := ev.prevObject.DeepCopyObject()
+				if accessor, err := meta.Accessor(ev.Object); err == nil {
+					if revision, err := parseResourceVersion(accessor.GetResourceVersion()); err == nil {
+						setResourceVersion(obj, revision)
+					}
+				}
+				return watch.Event{Type: watch.Deleted, Object: obj}, true
+			}
+			return watch.Event{}, false
+		case watch.Deleted:
+			return ev.Event, matches(ev.Object)
+		default:
+			return ev.Event, true
+		}
+	}
+}
+
+// skipEventsUntil wraps a filter to drop events not after the given revision.
+func skipEventsUntil(revision uint64, filter watchFilter) watchFilter {
+	return func(ev watchEvent) (watch.Event, bool) {
+		if accessor, err := meta.Accessor(ev.Object); err == nil {
+			if rv, err := parseResourceVersion(accessor.GetResourceVersion()); err == nil && rv <= revision {
+				return watch.Event{}, false
+			}
+		}
+		return filter(ev)
+	}
+}
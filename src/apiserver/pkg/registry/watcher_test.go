@@ -0,0 +1,67 @@
+package registry
+
+import (
+	"context"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/watch"
+
+	"github.com/alibaba/higress/api-server/pkg/options"
+)
+
+func setWatchBufferSize(t *testing.T, size int) {
+	old := options.WatchBufferSize
+	options.WatchBufferSize = size
+	t.Cleanup(func() {
+		options.WatchBufferSize = old
+	})
+}
+
+func testEvents(eventType watch.EventType, names ...string) []watch.Event {
+	var events []watch.Event
+	for i, name := range names {
+		cm := testConfigMap("ns", name, "v1")
+		cm.ResourceVersion = formatResourceVersion(uint64(i + 1))
+		events = append(events, watch.Event{Type: eventType, Object: cm})
+	}
+	return events
+}
+
+// TestWatchLargeInitialEvents checks the events broadcast while a large list of initial events is delivered
+// don't make the watcher too slow.
+func TestWatchLargeInitialEvents(t *testing.T) {
+	setWatchBufferSize(t, 2)
+	b := newWatchBroadcaster()
+	w := b.watch(context.Background(), testEvents(watch.Added, "a", "b", "c", "d", "e"), nil, false)
+	defer w.Stop()
+	for _, ev := range testEvents(watch.Modified, "a", "b", "c", "d", "e") {
+		b.broadcast(watchEvent{Event: ev})
+	}
+	events := receiveEvents(t, w, 10)
+	for i, ev := range events {
+		want := watch.Added
+		if i >= 5 {
+			want = watch.Modified
+		}
+		if ev.Type != want {
+			t.Fatalf("event %d is %s, want %s", i, testEvent(ev), want)
+		}
+	}
+}
+
+func TestWatchSlowConsumer(t *testing.T) {
+	setWatchBufferSize(t, 2)
+	b := newWatchBroadcaster()
+	w := b.watch(context.Background(), nil, nil, false)
+	defer w.Stop()
+	for _, ev := range testEvents(watch.Modified, "a", "b", "c", "d", "e") {
+		b.broadcast(watchEvent{Event: ev})
+	}
+	var last watch.Event
+	for ev := range w.ResultChan() {
+		last = ev
+	}
+	if last.Type != watch.Error {
+		t.Fatalf("the last event of a slow watcher is %s, want an error", testEvent(last))
+	}
+}
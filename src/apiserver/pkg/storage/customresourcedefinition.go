@@ -3,6 +3,7 @@ package storage
 import (
 	"context"
 	"embed"
+	"io/fs"
 	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metainternalversion "k8s.io/apimachinery/pkg/apis/meta/internalversion"
@@ -19,6 +20,12 @@ var (
 	groupResource = apiextensionsv1.Resource("customresourcedefinitions")
 )
 
+// CustomResourceDefinitionFiles returns the files of the custom resource definitions listed by the storage.
+func CustomResourceDefinitionFiles() fs.FS {
+	files, _ := fs.Sub(res, "crds")
+	return files
+}
+
 func CreateCustomResourceDefinitionStorage(codec runtime.Codec) (rest.Storage, error) {
 	crds := make([]apiextensionsv1.CustomResourceDefinition, 0)
 	files, err := res.ReadDir("crds")
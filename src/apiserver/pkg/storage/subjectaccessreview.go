@@ -2,13 +2,26 @@ package storage
 
 import (
 	"context"
+	"fmt"
+
 	authzv1 "k8s.io/api/authorization/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apiserver/pkg/authentication/user"
+	"k8s.io/apiserver/pkg/authorization/authorizer"
 	"k8s.io/apiserver/pkg/registry/rest"
 )
 
 type SubjectAccessReviewStorage struct {
+	// authorizer answers the reviews, which is nil if authorization is disabled.
+	authorizer authorizer.Authorizer
+}
+
+// NewSubjectAccessReviewStorage creates a storage answering SubjectAccessReviews with the given authorizer,
+// which allows everything if it's nil, as the API server does when authorization is disabled.
+func NewSubjectAccessReviewStorage(authorizer authorizer.Authorizer) *SubjectAccessReviewStorage {
+	return &SubjectAccessReviewStorage{authorizer: authorizer}
 }
 
 func (s *SubjectAccessReviewStorage) NamespaceScoped() bool {
@@ -31,17 +44,67 @@ func (s *SubjectAccessReviewStorage) Create(ctx context.Context,
 	createValidation rest.ValidateObjectFunc,
 	options *metav1.CreateOptions,
 ) (runtime.Object, error) {
+	review, ok := obj.(*authzv1.SubjectAccessReview)
+	if !ok {
+		return nil, apierrors.NewBadRequest(fmt.Sprintf("not a SubjectAccessReview: %#v", obj))
+	}
+	if (review.Spec.ResourceAttributes == nil) == (review.Spec.NonResourceAttributes == nil) {
+		return nil, apierrors.NewBadRequest("exactly one of resourceAttributes and nonResourceAttributes must be set")
+	}
+	if review.Spec.User == "" && len(review.Spec.Groups) == 0 {
+		return nil, apierrors.NewBadRequest("at least one of user or groups must be set")
+	}
 	if createValidation != nil {
 		if err := createValidation(ctx, obj); err != nil {
 			return nil, err
 		}
 	}
 
-	// Always allow
-	result := &authzv1.SubjectAccessReview{
-		Status: authzv1.SubjectAccessReviewStatus{
+	if s.authorizer == nil {
+		review.Status = authzv1.SubjectAccessReviewStatus{
 			Allowed: true,
+			Reason:  "authorization is disabled",
+		}
+		return review, nil
+	}
+
+	decision, reason, err := s.authorizer.Authorize(ctx, authorizationAttributesFrom(review.Spec))
+	review.Status = authzv1.SubjectAccessReviewStatus{
+		Allowed: decision == authorizer.DecisionAllow,
+		Denied:  decision == authorizer.DecisionDeny,
+		Reason:  reason,
+	}
+	if err != nil {
+		review.Status.EvaluationError = err.Error()
+	}
+	return review, nil
+}
+
+func authorizationAttributesFrom(spec authzv1.SubjectAccessReviewSpec) authorizer.AttributesRecord {
+	extra := map[string][]string{}
+	for key, value := range spec.Extra {
+		extra[key] = value
+	}
+	attrs := authorizer.AttributesRecord{
+		User: &user.DefaultInfo{
+			Name:   spec.User,
+			UID:    spec.UID,
+			Groups: spec.Groups,
+			Extra:  extra,
 		},
 	}
-	return result, nil
+	if resourceAttributes := spec.ResourceAttributes; resourceAttributes != nil {
+		attrs.ResourceRequest = true
+		attrs.Verb = resourceAttributes.Verb
+		attrs.Namespace = resourceAttributes.Namespace
+		attrs.APIGroup = resourceAttributes.Group
+		attrs.APIVersion = resourceAttributes.Version
+		attrs.Resource = resourceAttributes.Resource
+		attrs.Subresource = resourceAttributes.Subresource
+		attrs.Name = resourceAttributes.Name
+	} else {
+		attrs.Path = spec.NonResourceAttributes.Path
+		attrs.Verb = spec.NonResourceAttributes.Verb
+	}
+	return attrs
 }
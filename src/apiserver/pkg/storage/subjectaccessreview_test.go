@@ -0,0 +1,104 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	authzv1 "k8s.io/api/authorization/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apiserver/pkg/authorization/authorizer"
+)
+
+func createReview(t *testing.T, s *SubjectAccessReviewStorage, spec authzv1.SubjectAccessReviewSpec) authzv1.SubjectAccessReviewStatus {
+	t.Helper()
+	obj, err := s.Create(context.Background(), &authzv1.SubjectAccessReview{Spec: spec}, nil, &metav1.CreateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return obj.(*authzv1.SubjectAccessReview).Status
+}
+
+func TestSubjectAccessReview(t *testing.T) {
+	var reviewed authorizer.Attributes
+	s := NewSubjectAccessReviewStorage(authorizer.AuthorizerFunc(func(ctx context.Context, attrs authorizer.Attributes) (authorizer.Decision, string, error) {
+		reviewed = attrs
+		switch {
+		case attrs.GetUser().GetName() == "admin":
+			return authorizer.DecisionAllow, "admin", nil
+		case attrs.GetVerb() == "delete":
+			return authorizer.DecisionDeny, "no deletion", nil
+		case attrs.GetUser().GetName() == "broken":
+			return authorizer.DecisionNoOpinion, "", errors.New("role not found")
+		}
+		return authorizer.DecisionNoOpinion, "", nil
+	}))
+
+	// The review is answered by the authorizer with the attributes of the request reviewed.
+	status := createReview(t, s, authzv1.SubjectAccessReviewSpec{
+		User:   "admin",
+		Groups: []string{"higress:console"},
+		ResourceAttributes: &authzv1.ResourceAttributes{
+			Verb: "update", Namespace: "ns", Group: "networking.k8s.io", Version: "v1", Resource: "ingresses", Subresource: "status", Name: "a",
+		},
+	})
+	if !status.Allowed || status.Denied || status.Reason != "admin" {
+		t.Fatalf("review status = %+v, want allowed", status)
+	}
+	if !reviewed.IsResourceRequest() || reviewed.GetVerb() != "update" || reviewed.GetNamespace() != "ns" ||
+		reviewed.GetAPIGroup() != "networking.k8s.io" || reviewed.GetResource() != "ingresses" ||
+		reviewed.GetSubresource() != "status" || reviewed.GetName() != "a" || reviewed.GetUser().GetGroups()[0] != "higress:console" {
+		t.Fatalf("reviewed attributes = %+v", reviewed)
+	}
+
+	status = createReview(t, s, authzv1.SubjectAccessReviewSpec{
+		User:                  "alice",
+		NonResourceAttributes: &authzv1.NonResourceAttributes{Verb: "get", Path: "/healthz"},
+	})
+	if status.Allowed || status.Denied || reviewed.IsResourceRequest() || reviewed.GetPath() != "/healthz" {
+		t.Fatalf("review status = %+v of %+v, want no opinion", status, reviewed)
+	}
+	status = createReview(t, s, authzv1.SubjectAccessReviewSpec{
+		User:               "alice",
+		ResourceAttributes: &authzv1.ResourceAttributes{Verb: "delete", Resource: "secrets"},
+	})
+	if status.Allowed || !status.Denied || status.Reason != "no deletion" {
+		t.Fatalf("review status = %+v, want denied", status)
+	}
+	status = createReview(t, s, authzv1.SubjectAccessReviewSpec{
+		User:               "broken",
+		ResourceAttributes: &authzv1.ResourceAttributes{Verb: "get", Resource: "secrets"},
+	})
+	if status.Allowed || status.EvaluationError != "role not found" {
+		t.Fatalf("review status = %+v, want an evaluation error", status)
+	}
+
+	// Everything is allowed when authorization is disabled.
+	status = createReview(t, NewSubjectAccessReviewStorage(nil), authzv1.SubjectAccessReviewSpec{
+		User:               "alice",
+		ResourceAttributes: &authzv1.ResourceAttributes{Verb: "delete", Resource: "secrets"},
+	})
+	if !status.Allowed {
+		t.Fatalf("review status = %+v with authorization disabled", status)
+	}
+}
+
+func TestSubjectAccessReviewValidation(t *testing.T) {
+	s := NewSubjectAccessReviewStorage(nil)
+	tests := map[string]authzv1.SubjectAccessReviewSpec{
+		"no attributes": {User: "alice"},
+		"both attributes": {
+			User:                  "alice",
+			ResourceAttributes:    &authzv1.ResourceAttributes{Verb: "get", Resource: "secrets"},
+			NonResourceAttributes: &authzv1.NonResourceAttributes{Verb: "get", Path: "/healthz"},
+		},
+		"no subject": {ResourceAttributes: &authzv1.ResourceAttributes{Verb: "get", Resource: "secrets"}},
+	}
+	for name, spec := range tests {
+		_, err := s.Create(context.Background(), &authzv1.SubjectAccessReview{Spec: spec}, nil, &metav1.CreateOptions{})
+		if !apierrors.IsBadRequest(err) {
+			t.Errorf("[%s] review returned %v, want a bad request", name, err)
+		}
+	}
+}
@@ -7,8 +7,11 @@ import (
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/sha256"
+	"errors"
 )
 
+var errInvalidPadding = errors.New("invalid padding")
+
 func AesEncrypt(data, key []byte) ([]byte, error) {
 	block, err := aes.NewCipher(key)
 	if err != nil {
@@ -32,12 +35,49 @@ func AesDecrypt(encryptedData, key []byte) ([]byte, error) {
 	}
 
 	blockSize := block.BlockSize()
+	if len(encryptedData) == 0 || len(encryptedData)%blockSize != 0 {
+		return nil, errors.New("encrypted data is not a multiple of the block size")
+	}
 	blockMode := cipher.NewCBCDecrypter(block, key[:blockSize])
 	origData := make([]byte, len(encryptedData))
 
 	blockMode.CryptBlocks(origData, encryptedData)
-	origData = pkcs5Unpadding(origData)
-	return origData, nil
+	return pkcs5Unpadding(origData, blockSize)
+}
+
+// AesGcmEncrypt encrypts and authenticates data along with additionalData, which is authenticated only.
+// A random nonce is generated for each call and prepended to the result.
+func AesGcmEncrypt(data, additionalData, key []byte) ([]byte, error) {
+	aead, err := newAesGcm(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(data)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, data, additionalData), nil
+}
+
+// AesGcmDecrypt decrypts data encrypted by AesGcmEncrypt, and fails if it or additionalData has been tampered with.
+func AesGcmDecrypt(encryptedData, additionalData, key []byte) ([]byte, error) {
+	aead, err := newAesGcm(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(encryptedData) < aead.NonceSize() {
+		return nil, errors.New("encrypted data is too short")
+	}
+	nonce, ciphertext := encryptedData[:aead.NonceSize()], encryptedData[aead.NonceSize():]
+	return aead.Open(nil, nonce, ciphertext, additionalData)
+}
+
+func newAesGcm(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
 }
 
 func RsaEncrypt(data, label []byte, publicKey *rsa.PublicKey) ([]byte, error) {
@@ -62,8 +102,19 @@ func pkcs5Padding(data []byte, blockSize int) []byte {
 	return append(data, paddedData...)
 }
 
-func pkcs5Unpadding(data []byte) []byte {
+func pkcs5Unpadding(data []byte, blockSize int) ([]byte, error) {
 	length := len(data)
+	if length == 0 {
+		return nil, errInvalidPadding
+	}
 	paddingLength := int(data[length-1])
-	return data[:(length - paddingLength)]
+	if paddingLength == 0 || paddingLength > blockSize || paddingLength > length {
+		return nil, errInvalidPadding
+	}
+	for _, b := range data[length-paddingLength:] {
+		if int(b) != paddingLength {
+			return nil, errInvalidPadding
+		}
+	}
+	return data[:(length - paddingLength)], nil
 }